@@ -0,0 +1,54 @@
+package ast
+
+import (
+	"rafiki/token"
+	"strings"
+)
+
+// A // line or /* block */ comment. Comments aren't part of the tree proper;
+// the Program keeps all of them so tools like a formatter can put them back.
+type Comment struct {
+	Token    token.Token // token.COMMENT, the literal includes the markers
+	Trailing bool        // Follows code on the same line, like `x; // note`
+}
+
+func (c *Comment) Pos() token.Position { return c.Token.Span.Start }
+func (c *Comment) End() token.Position { return c.Token.Span.End }
+
+// The comment without its // or /* */ markers
+func (c *Comment) Text() string {
+	literal := c.Token.Literal
+
+	if strings.HasPrefix(literal, "//") {
+		return strings.TrimSpace(strings.TrimPrefix(literal, "//"))
+	}
+
+	literal = strings.TrimPrefix(literal, "/*")
+	literal = strings.TrimSuffix(literal, "*/")
+
+	lines := strings.Split(literal, "\n")
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		lines[i] = strings.TrimSpace(strings.TrimPrefix(line, "*"))
+	}
+
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// Comments on consecutive lines with nothing in between, e.g. a doc comment
+type CommentGroup struct {
+	List []*Comment
+}
+
+func (g *CommentGroup) Text() string {
+	if g == nil {
+		return ""
+	}
+
+	lines := []string{}
+	for _, c := range g.List {
+		lines = append(lines, c.Text())
+	}
+
+	return strings.Join(lines, "\n")
+}
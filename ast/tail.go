@@ -0,0 +1,55 @@
+package ast
+
+/*
+TailCalls finds the calls in a function body whose result the function
+returns as it is, so the callee can take over the caller's frame. A call is
+in tail position when it's the value of a return, or what the body's last
+expression statement evaluates to, looking into the last statement of each
+branch of an if:
+
+	fn(n, acc) {
+		if (n == 0) { return acc; }
+		if (n % 2 == 0) { loop(n - 1, acc) } else { return loop(n - 1, acc + n); }
+	}
+
+Both calls to loop are tail calls. Calls inside a try aren't, since its
+handler belongs to the caller.
+*/
+func TailCalls(body *BlockStatement) map[*CallExpression]bool {
+	calls := map[*CallExpression]bool{}
+
+	markTailBlock(body, calls)
+
+	Inspect(body, func(node Node) bool {
+		switch node := node.(type) {
+		case *FunctionLiteral, *TryExpression:
+			return false
+		case *ReturnStatement:
+			markTailExpression(node.ReturnValue, calls)
+		}
+
+		return true
+	})
+
+	return calls
+}
+
+func markTailBlock(block *BlockStatement, calls map[*CallExpression]bool) {
+	if block == nil || len(block.Statements) == 0 {
+		return
+	}
+
+	if last, ok := block.Statements[len(block.Statements)-1].(*ExpressionStatement); ok {
+		markTailExpression(last.Expression, calls)
+	}
+}
+
+func markTailExpression(expr Expression, calls map[*CallExpression]bool) {
+	switch expr := expr.(type) {
+	case *CallExpression:
+		calls[expr] = true
+	case *IfExpression:
+		markTailBlock(expr.Consequence, calls)
+		markTailBlock(expr.Alternative, calls)
+	}
+}
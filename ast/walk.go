@@ -0,0 +1,130 @@
+package ast
+
+/*
+Inspect calls f for node and then, if f returns true, for each of its
+children in source order, depth first. Unlike Modify it visits every node,
+including names being bound, like a let's Name or a function's Parameters,
+and leaves the tree alone.
+*/
+func Inspect(node Node, f func(Node) bool) {
+	if isNil(node) || !f(node) {
+		return
+	}
+
+	for _, child := range Children(node) {
+		Inspect(child, f)
+	}
+}
+
+// The nodes directly under node, in source order
+func Children(node Node) []Node {
+	if isNil(node) {
+		return nil
+	}
+
+	children := []Node{}
+	add := func(nodes ...Node) {
+		for _, n := range nodes {
+			if !isNil(n) {
+				children = append(children, n)
+			}
+		}
+	}
+
+	switch node := node.(type) {
+
+	case *Program:
+		for _, s := range node.Statements {
+			add(s)
+		}
+
+	case *LetStatement:
+		add(node.Name, node.Value)
+
+	case *ReturnStatement:
+		add(node.ReturnValue)
+
+	case *ExpressionStatement:
+		add(node.Expression)
+
+	case *ThrowStatement:
+		add(node.Value)
+
+	case *BlockStatement:
+		for _, s := range node.Statements {
+			add(s)
+		}
+
+	case *PrefixExpression:
+		add(node.Right)
+
+	case *InfixExpression:
+		add(node.Left, node.Right)
+
+	case *AssignExpression:
+		add(node.Target, node.Value)
+
+	case *IfExpression:
+		add(node.Condition, node.Consequence, node.Alternative)
+
+	case *WhileStatement:
+		add(node.Condition, node.Body)
+
+	case *ForStatement:
+		add(node.Init, node.Condition, node.Post, node.Body)
+
+	case *TryExpression:
+		add(node.Body, node.Param, node.Handler)
+
+	case *FunctionLiteral:
+		for _, p := range node.Parameters {
+			add(p)
+		}
+		add(node.Body)
+
+	case *MacroLiteral:
+		for _, p := range node.Parameters {
+			add(p)
+		}
+		add(node.Body)
+
+	case *CallExpression:
+		add(node.Function)
+		for _, a := range node.Arguments {
+			add(a)
+		}
+
+	case *ArrayLiteral:
+		for _, e := range node.Elements {
+			add(e)
+		}
+
+	case *IndexExpression:
+		add(node.Left, node.Index)
+
+	case *ImportExpression:
+		add(node.Path)
+
+	case *HashLiteral:
+		for _, key := range node.Keys() {
+			add(key, node.Pairs[key])
+		}
+	}
+
+	return children
+}
+
+// Optional children are typed nil pointers inside the interface, e.g. an if
+// without an else
+func isNil(node Node) bool {
+	switch node := node.(type) {
+	case nil:
+		return true
+	case *BlockStatement:
+		return node == nil
+	case *Identifier:
+		return node == nil
+	}
+
+	return false
+}
@@ -0,0 +1,54 @@
+package ast
+
+import (
+	"rafiki/token"
+	"testing"
+)
+
+func ident(name string) *Identifier {
+	return &Identifier{Token: token.Token{Type: token.IDENT, Literal: name}, Value: name}
+}
+
+func TestInspect(t *testing.T) {
+	// let f = fn(a) { if (a) { b } }
+	program := &Program{
+		Statements: []Statement{
+			&LetStatement{
+				Name: ident("f"),
+				Value: &FunctionLiteral{
+					Parameters: []*Identifier{ident("a")},
+					Body: &BlockStatement{Statements: []Statement{
+						&ExpressionStatement{Expression: &IfExpression{
+							Condition:   ident("a"),
+							Consequence: &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: ident("b")}}},
+						}},
+					}},
+				},
+			},
+		},
+	}
+
+	names := ""
+	Inspect(program, func(node Node) bool {
+		if id, ok := node.(*Identifier); ok {
+			names += id.Value
+		}
+		return true
+	})
+
+	if names != "faab" {
+		t.Errorf("wrong identifiers visited. want=%q, got=%q", "faab", names)
+	}
+
+	// Returning false skips the children
+	count := 0
+	Inspect(program, func(node Node) bool {
+		count++
+		_, isFn := node.(*FunctionLiteral)
+		return !isFn
+	})
+
+	if count != 4 {
+		t.Errorf("wrong number of nodes visited. want=4, got=%d", count)
+	}
+}
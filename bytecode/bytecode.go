@@ -0,0 +1,543 @@
+package bytecode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"os"
+	"rafiki/code"
+	"rafiki/compiler"
+	"rafiki/object"
+	"rafiki/token"
+)
+
+/*
+A .rkc file is a fixed header followed by the payload:
+
+	magic     4 bytes   "RKC\x00"
+	version   uint16    Version
+	checksum  uint32    CRC-32 (IEEE) of the payload
+	length    uint32    payload length in bytes
+
+The payload holds the main instructions, their source map, the names of the
+global slots, the names of the builtins in the order the code was compiled
+against, and the constant pool. Integers in it are varints, and strings
+and byte slices are prefixed with their length. Every constant starts with a
+one byte tag saying which object type follows. Header fields are big endian,
+like instruction operands.
+*/
+const Version = 7
+
+const Extension = ".rkc"
+
+var magic = []byte("RKC\x00")
+
+const headerSize = 14
+
+// Constant pool tags
+const (
+	tagInteger          byte = 1
+	tagFloat            byte = 2
+	tagString           byte = 3
+	tagCompiledFunction byte = 4
+)
+
+var (
+	ErrNotBytecode = errors.New("bytecode: not a compiled Rafiki file")
+	ErrVersion     = errors.New("bytecode: unsupported version")
+	ErrChecksum    = errors.New("bytecode: checksum mismatch")
+	ErrCorrupt     = errors.New("bytecode: corrupt file")
+)
+
+func Marshal(bc *compiler.Bytecode) ([]byte, error) {
+	e := &encoder{files: map[string]int{}}
+
+	e.bytes(bc.Instructions)
+	e.sourceMap(bc.SourceMap)
+	e.strings(bc.GlobalNames)
+	e.strings(bc.Builtins)
+
+	e.uvarint(uint64(len(bc.Constants)))
+	for _, constant := range bc.Constants {
+		if err := e.constant(constant); err != nil {
+			return nil, err
+		}
+	}
+
+	payload := e.buf.Bytes()
+
+	out := make([]byte, headerSize, headerSize+len(payload))
+	copy(out, magic)
+	binary.BigEndian.PutUint16(out[4:], Version)
+	binary.BigEndian.PutUint32(out[6:], crc32.ChecksumIEEE(payload))
+	binary.BigEndian.PutUint32(out[10:], uint32(len(payload)))
+
+	return append(out, payload...), nil
+}
+
+func Unmarshal(data []byte) (*compiler.Bytecode, error) {
+	if len(data) < headerSize || !bytes.Equal(data[:4], magic) {
+		return nil, ErrNotBytecode
+	}
+
+	if version := binary.BigEndian.Uint16(data[4:]); version != Version {
+		return nil, fmt.Errorf("%w %d, want %d", ErrVersion, version, Version)
+	}
+
+	payload := data[headerSize:]
+	if int(binary.BigEndian.Uint32(data[10:])) != len(payload) {
+		return nil, fmt.Errorf("%w: payload length doesn't match header", ErrCorrupt)
+	}
+
+	if binary.BigEndian.Uint32(data[6:]) != crc32.ChecksumIEEE(payload) {
+		return nil, ErrChecksum
+	}
+
+	d := &decoder{data: payload}
+
+	bc := &compiler.Bytecode{}
+	bc.Instructions = d.bytes()
+	bc.SourceMap = d.sourceMap()
+	bc.GlobalNames = d.strings()
+	if builtins := d.strings(); len(builtins) > 0 {
+		bc.Builtins = builtins
+	}
+
+	count := d.length()
+	bc.Constants = make([]object.Object, 0, count)
+	for i := 0; i < count && d.err == nil; i++ {
+		bc.Constants = append(bc.Constants, d.constant())
+	}
+
+	if d.err == nil && d.pos != len(d.data) {
+		d.fail("trailing bytes after the constant pool")
+	}
+
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	if err := validate(bc); err != nil {
+		return nil, err
+	}
+
+	return bc, nil
+}
+
+/*
+validate checks the code can't take the VM outside the program, since the VM
+trusts what the compiler gives it. Every instruction has to decode, jump to
+the start of an instruction, and refer only to constants, globals, builtins,
+locals and free variables that exist.
+*/
+func validate(bc *compiler.Bytecode) error {
+	main := &object.CompiledFunction{Instructions: bc.Instructions}
+	if err := validateFunction(bc, main, "the main program"); err != nil {
+		return err
+	}
+
+	for i, constant := range bc.Constants {
+		fn, ok := constant.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+
+		if err := validateFunction(bc, fn, fmt.Sprintf("function constant %d", i)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func validateFunction(bc *compiler.Bytecode, fn *object.CompiledFunction, name string) error {
+	fail := func(offset int, format string, a ...interface{}) error {
+		return fmt.Errorf("%w: %s, offset %d: %s", ErrCorrupt, name, offset, fmt.Sprintf(format, a...))
+	}
+
+	if fn.NumParameters > fn.NumLocals {
+		return fail(0, "%d parameters but %d locals", fn.NumParameters, fn.NumLocals)
+	}
+
+	ins := fn.Instructions
+	starts := map[int]bool{len(ins): true}
+	jumps := map[int]int{} // Targets by the offset of the jump
+
+	for i := 0; i < len(ins); {
+		instruction, err := code.Decode(ins[i:])
+		if err != nil {
+			return fail(i, "%s", err)
+		}
+
+		starts[i] = true
+		operands := instruction.Operands
+
+		switch instruction.Op {
+		case code.OpConstant:
+			if operands[0] >= len(bc.Constants) {
+				return fail(i, "constant %d of %d", operands[0], len(bc.Constants))
+			}
+
+		case code.OpClosure:
+			function, ok := constantFunction(bc, operands[0])
+			if !ok {
+				return fail(i, "constant %d isn't a function", operands[0])
+			}
+			if operands[1] != len(function.FreeNames) {
+				return fail(i, "%d free variables for a function with %d", operands[1], len(function.FreeNames))
+			}
+
+		case code.OpImport:
+			if operands[0] >= len(bc.GlobalNames) {
+				return fail(i, "global %d of %d", operands[0], len(bc.GlobalNames))
+			}
+			if function, ok := constantFunction(bc, operands[1]); !ok || len(function.FreeNames) != 0 {
+				return fail(i, "constant %d isn't a module", operands[1])
+			}
+
+		case code.OpModule:
+			if operands[0] >= len(bc.Constants) || bc.Constants[operands[0]].Type() != object.STRING_OBJ {
+				return fail(i, "constant %d isn't a module name", operands[0])
+			}
+
+		case code.OpGetGlobal, code.OpSetGlobal:
+			if operands[0] >= len(bc.GlobalNames) {
+				return fail(i, "global %d of %d", operands[0], len(bc.GlobalNames))
+			}
+
+		case code.OpGetBuiltin:
+			if operands[0] >= len(bc.Builtins) {
+				return fail(i, "builtin %d of %d", operands[0], len(bc.Builtins))
+			}
+
+		case code.OpGetLocal, code.OpSetLocal, code.OpGetLocalCell:
+			if operands[0] >= fn.NumLocals {
+				return fail(i, "local %d of %d", operands[0], fn.NumLocals)
+			}
+
+		case code.OpGetFree, code.OpSetFree, code.OpGetFreeCell:
+			if operands[0] >= len(fn.FreeNames) {
+				return fail(i, "free variable %d of %d", operands[0], len(fn.FreeNames))
+			}
+
+		case code.OpJump, code.OpJumpNotTruthy, code.OpTry:
+			jumps[i] = operands[0]
+		}
+
+		i += instruction.Length
+	}
+
+	for offset, target := range jumps {
+		if !starts[target] {
+			return fail(offset, "jump to %d, which isn't an instruction", target)
+		}
+	}
+
+	return nil
+}
+
+// The function constant at index, if there is one
+func constantFunction(bc *compiler.Bytecode, index int) (*object.CompiledFunction, bool) {
+	if index >= len(bc.Constants) {
+		return nil, false
+	}
+
+	fn, ok := bc.Constants[index].(*object.CompiledFunction)
+	return fn, ok
+}
+
+func WriteFile(filename string, bc *compiler.Bytecode) error {
+	data, err := Marshal(bc)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filename, data, 0644)
+}
+
+func ReadFile(filename string) (*compiler.Bytecode, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	return Unmarshal(data)
+}
+
+type encoder struct {
+	buf   bytes.Buffer
+	files map[string]int // Filenames already written, by index
+}
+
+func (e *encoder) uvarint(v uint64) {
+	e.buf.Write(binary.AppendUvarint(nil, v))
+}
+
+func (e *encoder) varint(v int64) {
+	e.buf.Write(binary.AppendVarint(nil, v))
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.uvarint(uint64(len(b)))
+	e.buf.Write(b)
+}
+
+func (e *encoder) string(s string) {
+	e.bytes([]byte(s))
+}
+
+func (e *encoder) strings(list []string) {
+	e.uvarint(uint64(len(list)))
+
+	for _, s := range list {
+		e.string(s)
+	}
+}
+
+// Filenames repeat in every entry, so each is written once and referred to by
+// index after that. An index one past the known names introduces a new one.
+func (e *encoder) position(pos token.Position) {
+	index, ok := e.files[pos.Filename]
+	if !ok {
+		index = len(e.files)
+		e.files[pos.Filename] = index
+	}
+
+	e.uvarint(uint64(index))
+	if !ok {
+		e.string(pos.Filename)
+	}
+
+	e.uvarint(uint64(pos.Offset))
+	e.uvarint(uint64(pos.Line))
+	e.uvarint(uint64(pos.Column))
+}
+
+func (e *encoder) sourceMap(sm code.SourceMap) {
+	e.uvarint(uint64(len(sm)))
+
+	for _, entry := range sm {
+		e.uvarint(uint64(entry.Offset))
+		e.position(entry.Pos)
+	}
+}
+
+func (e *encoder) constant(obj object.Object) error {
+	switch obj := obj.(type) {
+
+	case *object.Integer:
+		e.buf.WriteByte(tagInteger)
+		e.varint(obj.Value)
+
+	case *object.Float:
+		e.buf.WriteByte(tagFloat)
+		e.buf.Write(binary.BigEndian.AppendUint64(nil, math.Float64bits(obj.Value)))
+
+	case *object.String:
+		e.buf.WriteByte(tagString)
+		e.string(obj.Value)
+
+	case *object.CompiledFunction:
+		e.buf.WriteByte(tagCompiledFunction)
+		e.bytes(obj.Instructions)
+		e.uvarint(uint64(obj.NumLocals))
+		e.uvarint(uint64(obj.NumParameters))
+		e.string(obj.Name)
+		e.sourceMap(obj.SourceMap)
+		e.strings(obj.LocalNames)
+		e.strings(obj.FreeNames)
+
+	default:
+		return fmt.Errorf("bytecode: cannot encode constant of type %s", obj.Type())
+	}
+
+	return nil
+}
+
+// Reads the payload back. The first problem is kept in err, and every read
+// after it returns a zero value, so callers only need to check at the end.
+type decoder struct {
+	data  []byte
+	pos   int
+	files []string
+	err   error
+}
+
+func (d *decoder) fail(format string, a ...interface{}) {
+	if d.err == nil {
+		d.err = fmt.Errorf("%w: %s", ErrCorrupt, fmt.Sprintf(format, a...))
+	}
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+
+	v, n := binary.Uvarint(d.data[d.pos:])
+	if n <= 0 {
+		d.fail("bad integer at byte %d", d.pos)
+		return 0
+	}
+
+	d.pos += n
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+
+	v, n := binary.Varint(d.data[d.pos:])
+	if n <= 0 {
+		d.fail("bad integer at byte %d", d.pos)
+		return 0
+	}
+
+	d.pos += n
+	return v
+}
+
+// A count or size, which can never be more than the bytes left
+func (d *decoder) length() int {
+	n := d.uvarint()
+	if n > uint64(len(d.data)-d.pos) {
+		d.fail("length %d at byte %d runs past the end", n, d.pos)
+		return 0
+	}
+
+	return int(n)
+}
+
+func (d *decoder) int() int {
+	v := d.uvarint()
+	if v > math.MaxInt32 {
+		d.fail("value %d out of range", v)
+		return 0
+	}
+
+	return int(v)
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.length()
+	if d.err != nil {
+		return nil
+	}
+
+	b := make([]byte, n)
+	copy(b, d.data[d.pos:])
+	d.pos += n
+
+	return b
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+func (d *decoder) strings() []string {
+	count := d.length()
+
+	list := make([]string, 0, count)
+	for i := 0; i < count && d.err == nil; i++ {
+		list = append(list, d.string())
+	}
+
+	return list
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+
+	if d.pos >= len(d.data) {
+		d.fail("unexpected end of data")
+		return 0
+	}
+
+	b := d.data[d.pos]
+	d.pos++
+
+	return b
+}
+
+func (d *decoder) position() token.Position {
+	index := d.int()
+
+	switch {
+	case d.err != nil:
+		return token.Position{}
+	case index == len(d.files):
+		d.files = append(d.files, d.string())
+	case index > len(d.files):
+		d.fail("unknown filename %d", index)
+		return token.Position{}
+	}
+
+	return token.Position{
+		Filename: d.files[index],
+		Offset:   d.int(),
+		Line:     d.int(),
+		Column:   d.int(),
+	}
+}
+
+func (d *decoder) sourceMap() code.SourceMap {
+	count := d.length()
+	if count == 0 {
+		return nil
+	}
+
+	sm := make(code.SourceMap, 0, count)
+	for i := 0; i < count && d.err == nil; i++ {
+		offset := d.int()
+		sm = append(sm, code.SourceMapEntry{Offset: offset, Pos: d.position()})
+	}
+
+	return sm
+}
+
+func (d *decoder) constant() object.Object {
+	tag := d.byte()
+
+	switch tag {
+
+	case tagInteger:
+		return &object.Integer{Value: d.varint()}
+
+	case tagFloat:
+		if len(d.data)-d.pos < 8 {
+			d.fail("unexpected end of data")
+			return nil
+		}
+
+		bits := binary.BigEndian.Uint64(d.data[d.pos:])
+		d.pos += 8
+
+		return &object.Float{Value: math.Float64frombits(bits)}
+
+	case tagString:
+		return &object.String{Value: d.string()}
+
+	case tagCompiledFunction:
+		return &object.CompiledFunction{
+			Instructions:  d.bytes(),
+			NumLocals:     d.int(),
+			NumParameters: d.int(),
+			Name:          d.string(),
+			SourceMap:     d.sourceMap(),
+			LocalNames:    d.strings(),
+			FreeNames:     d.strings(),
+		}
+
+	default:
+		d.fail("unknown constant tag %d", tag)
+		return nil
+	}
+}
@@ -0,0 +1,240 @@
+package bytecode
+
+import (
+	"errors"
+	"path/filepath"
+	"rafiki/code"
+	"rafiki/compiler"
+	"rafiki/lexer"
+	"rafiki/object"
+	"rafiki/parser"
+	"rafiki/vm"
+	"reflect"
+	"testing"
+)
+
+func compile(t *testing.T, input string) *compiler.Bytecode {
+	t.Helper()
+
+	l := lexer.NewLexerWithFilename(input, "main.rk")
+	p := parser.NewParser(l)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+
+	comp := compiler.NewCompiler()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	return comp.Bytecode()
+}
+
+const program = `
+let greeting = "hello";
+let half = 0.5;
+let newAdder = fn(a) { fn(b) { a + b } };
+let addTwo = newAdder(2);
+let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } };
+[greeting, addTwo(-3), fib(10) * half]
+`
+
+func TestRoundTrip(t *testing.T) {
+	original := compile(t, program)
+
+	data, err := Marshal(original)
+	if err != nil {
+		t.Fatalf("marshal failed: %s", err)
+	}
+
+	decoded, err := Unmarshal(data)
+	if err != nil {
+		t.Fatalf("unmarshal failed: %s", err)
+	}
+
+	if !reflect.DeepEqual(original, decoded) {
+		t.Fatalf("decoded bytecode differs.\nwant=%+v\ngot=%+v", original, decoded)
+	}
+
+	machine := vm.NewVm(decoded)
+	if err := machine.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	result := machine.LastPoppedStackElem().Inspect()
+	if result != "[hello, -1, 27.5]" {
+		t.Errorf("wrong result. want=%q, got=%q", "[hello, -1, 27.5]", result)
+	}
+}
+
+func TestErrorPositionsSurvive(t *testing.T) {
+	data, err := Marshal(compile(t, "let f = fn() {\n  1 / 0\n};\nf()"))
+	if err != nil {
+		t.Fatalf("marshal failed: %s", err)
+	}
+
+	decoded, err := Unmarshal(data)
+	if err != nil {
+		t.Fatalf("unmarshal failed: %s", err)
+	}
+
+	err = vm.NewVm(decoded).Run()
+	if err == nil {
+		t.Fatalf("expected VM error but resulted in none.")
+	}
+
+	expected := "main.rk:2:5: division by zero"
+	if err.Error() != expected {
+		t.Errorf("wrong error. want=%q, got=%q", expected, err.Error())
+	}
+}
+
+func TestFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "main"+Extension)
+	original := compile(t, program)
+
+	if err := WriteFile(path, original); err != nil {
+		t.Fatalf("write failed: %s", err)
+	}
+
+	decoded, err := ReadFile(path)
+	if err != nil {
+		t.Fatalf("read failed: %s", err)
+	}
+
+	if !reflect.DeepEqual(original, decoded) {
+		t.Errorf("decoded bytecode differs")
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	valid, err := Marshal(compile(t, program))
+	if err != nil {
+		t.Fatalf("marshal failed: %s", err)
+	}
+
+	corrupt := func(change func(data []byte) []byte) []byte {
+		data := append([]byte{}, valid...)
+		return change(data)
+	}
+
+	tests := []struct {
+		name     string
+		data     []byte
+		expected error
+	}{
+		{"empty", []byte{}, ErrNotBytecode},
+		{"source file", []byte("let x = 1;\nlet y = 2;"), ErrNotBytecode},
+		{"version", corrupt(func(d []byte) []byte { d[5] = 99; return d }), ErrVersion},
+		{"flipped byte", corrupt(func(d []byte) []byte { d[len(d)-1] ^= 0xff; return d }), ErrChecksum},
+		{"truncated", corrupt(func(d []byte) []byte { return d[:len(d)-4] }), ErrCorrupt},
+	}
+
+	for _, tt := range tests {
+		_, err := Unmarshal(tt.data)
+		if !errors.Is(err, tt.expected) {
+			t.Errorf("%s: wrong error. want=%v, got=%v", tt.name, tt.expected, err)
+		}
+	}
+}
+
+func TestCorruptInstructions(t *testing.T) {
+	function := func(ins []byte, numLocals int, free ...string) *object.CompiledFunction {
+		return &object.CompiledFunction{Instructions: ins, NumLocals: numLocals, FreeNames: free}
+	}
+
+	tests := []struct {
+		name string
+		bc   *compiler.Bytecode
+	}{
+		{"unknown opcode", &compiler.Bytecode{Instructions: []byte{255}}},
+		{"bare OpWide", &compiler.Bytecode{Instructions: []byte{byte(code.OpWide)}}},
+		{"truncated operand", &compiler.Bytecode{Instructions: []byte{byte(code.OpConstant), 0}}},
+		{"constant", &compiler.Bytecode{
+			Instructions: code.Make(code.OpConstant, 1),
+			Constants:    []object.Object{&object.Integer{Value: 1}},
+		}},
+		{"global", &compiler.Bytecode{
+			Instructions: code.Make(code.OpGetGlobal, 1),
+			GlobalNames:  []string{"x"},
+		}},
+		{"builtin", &compiler.Bytecode{
+			Instructions: code.Make(code.OpGetBuiltin, 1),
+			Builtins:     []string{"len"},
+		}},
+		{"jump past the end", &compiler.Bytecode{Instructions: code.Make(code.OpJump, 4)}},
+		{"jump into an operand", &compiler.Bytecode{
+			Instructions: append(code.Make(code.OpJump, 4), code.Make(code.OpConstant, 0)...),
+			Constants:    []object.Object{&object.Integer{Value: 1}},
+		}},
+		{"closure of a non-function", &compiler.Bytecode{
+			Instructions: code.Make(code.OpClosure, 0, 0),
+			Constants:    []object.Object{&object.Integer{Value: 1}},
+		}},
+		{"closure missing free variables", &compiler.Bytecode{
+			Instructions: code.Make(code.OpClosure, 0, 0),
+			Constants:    []object.Object{function(code.Make(code.OpGetFree, 0), 0, "a")},
+		}},
+		{"local in the main program", &compiler.Bytecode{Instructions: code.Make(code.OpGetLocal, 0)}},
+		{"local", &compiler.Bytecode{
+			Constants: []object.Object{function(code.Make(code.OpSetLocal, 2), 2)},
+		}},
+		{"free variable", &compiler.Bytecode{
+			Constants: []object.Object{function(code.Make(code.OpGetFree, 1), 0, "a")},
+		}},
+		{"bad instruction in a function", &compiler.Bytecode{
+			Constants: []object.Object{function([]byte{byte(code.OpCall)}, 0)},
+		}},
+	}
+
+	for _, tt := range tests {
+		data, err := Marshal(tt.bc)
+		if err != nil {
+			t.Fatalf("%s: marshal failed: %s", tt.name, err)
+		}
+
+		if _, err := Unmarshal(data); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("%s: wrong error. want=%v, got=%v", tt.name, ErrCorrupt, err)
+		}
+	}
+}
+
+func TestMarshalUnsupportedConstant(t *testing.T) {
+	bc := &compiler.Bytecode{Constants: []object.Object{&object.Boolean{Value: true}}}
+
+	if _, err := Marshal(bc); err == nil {
+		t.Errorf("expected an error for a BOOLEAN constant")
+	}
+}
+
+func TestBuiltinsByName(t *testing.T) {
+	data, err := Marshal(compile(t, `len(rest([1, 2, 3]))`))
+	if err != nil {
+		t.Fatalf("marshal failed: %s", err)
+	}
+
+	decoded, err := Unmarshal(data)
+	if err != nil {
+		t.Fatalf("unmarshal failed: %s", err)
+	}
+
+	// A registry with a different layout still gives the code the builtins
+	// it was compiled against
+	builtins := object.NewBuiltinRegistry()
+	defaults := object.DefaultBuiltins()
+	for _, name := range []string{"rest", "len"} {
+		builtin, _ := defaults.Lookup(name)
+		builtins.Register(name, builtin.Fn)
+	}
+
+	machine := vm.NewVm(decoded)
+	machine.SetBuiltins(builtins)
+	if err := machine.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	if result := machine.LastPoppedStackElem().Inspect(); result != "2" {
+		t.Errorf("wrong result. want=2, got=%s", result)
+	}
+}
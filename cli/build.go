@@ -0,0 +1,94 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"rafiki/bytecode"
+	"rafiki/compiler"
+	"strings"
+)
+
+// rafiki build [-o <file.rkc>] [-O0|-O1] <file.rk>
+func buildCommand(args []string, s Streams) int {
+	fs := newFlagSet("build", s)
+	output := fs.String("o", "", "output file (default: the source file with a .rkc extension)")
+	optimization := addOptimizationFlags(fs)
+
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+
+	if fs.NArg() != 1 {
+		fmt.Fprintln(s.Err, "rafiki build: expected exactly one source file")
+		return ExitUsage
+	}
+
+	filename := fs.Arg(0)
+	src, err := os.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintf(s.Err, "rafiki build: %s\n", err)
+		return ExitError
+	}
+
+	code, ok := compileSource(filename, string(src), *optimization, s)
+	if !ok {
+		return ExitError
+	}
+
+	out := *output
+	if out == "" {
+		out = strings.TrimSuffix(filename, filepath.Ext(filename)) + bytecode.Extension
+	}
+
+	if err := bytecode.WriteFile(out, code); err != nil {
+		fmt.Fprintf(s.Err, "rafiki build: %s\n", err)
+		return ExitError
+	}
+
+	return ExitOK
+}
+
+// rafiki exec [limits] <file.rkc>
+func execCommand(args []string, s Streams) int {
+	fs := newFlagSet("exec", s)
+	limits := addLimitFlags(fs)
+
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+
+	if fs.NArg() != 1 {
+		fmt.Fprintln(s.Err, "rafiki exec: expected exactly one compiled file")
+		return ExitUsage
+	}
+
+	filename := fs.Arg(0)
+	code, err := bytecode.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintf(s.Err, "rafiki exec: %s: %s\n", filename, err)
+		return ExitError
+	}
+
+	_, ok := runBytecode(filename, originalSource(code), code, limits, s)
+	if !ok {
+		return ExitError
+	}
+
+	return ExitOK
+}
+
+// The source the bytecode was built from, if it's still where it was, so
+// errors can show the line they happened on
+func originalSource(code *compiler.Bytecode) string {
+	if len(code.SourceMap) == 0 {
+		return ""
+	}
+
+	src, err := os.ReadFile(code.SourceMap[0].Pos.Filename)
+	if err != nil {
+		return ""
+	}
+
+	return string(src)
+}
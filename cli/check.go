@@ -0,0 +1,75 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"rafiki/diagnostic"
+	"rafiki/lexer"
+	"rafiki/parser"
+)
+
+// One entry per file in `rafiki check --format=json` output
+type checkResult struct {
+	File        string                   `json:"file"`
+	Diagnostics []*diagnostic.Diagnostic `json:"diagnostics"`
+}
+
+// rafiki check [--format=text|json] <file.rk>...
+func checkCommand(args []string, s Streams) int {
+	fs := newFlagSet("check", s)
+	format := fs.String("format", "text", "output format: 'text' or 'json'")
+
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+
+	if fs.NArg() == 0 {
+		fmt.Fprintln(s.Err, "rafiki check: expected at least one source file")
+		return ExitUsage
+	}
+
+	if *format != "text" && *format != "json" {
+		fmt.Fprintf(s.Err, "rafiki check: unknown format %q\n", *format)
+		return ExitUsage
+	}
+
+	results := []checkResult{}
+	exitCode := ExitOK
+
+	for _, filename := range fs.Args() {
+		src, err := os.ReadFile(filename)
+		if err != nil {
+			fmt.Fprintf(s.Err, "rafiki check: %s\n", err)
+			return ExitError
+		}
+
+		p := parser.NewParser(lexer.NewLexerWithFilename(string(src), filename))
+		p.ParseProgram()
+
+		diagnostics := p.Diagnostics()
+		if len(diagnostics) > 0 {
+			exitCode = ExitError
+		}
+
+		if *format == "json" {
+			results = append(results, checkResult{File: filename, Diagnostics: diagnostics})
+			continue
+		}
+
+		for _, d := range diagnostics {
+			fmt.Fprint(s.Out, diagnostic.Render(string(src), d))
+		}
+	}
+
+	if *format == "json" {
+		encoder := json.NewEncoder(s.Out)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(results); err != nil {
+			fmt.Fprintf(s.Err, "rafiki check: %s\n", err)
+			return ExitError
+		}
+	}
+
+	return exitCode
+}
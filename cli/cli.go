@@ -0,0 +1,192 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"rafiki/repl"
+)
+
+// Exit codes returned by Run
+const (
+	ExitOK    = 0 // Program ran to completion
+	ExitError = 1 // Parse, compile or runtime error
+	ExitUsage = 2 // Bad command line
+)
+
+const usage = `Usage: rafiki <command> [arguments]
+
+Commands:
+  run  [--engine=vm|eval] <file.rk>    execute a Rafiki source file
+  eval [--engine=vm|eval] <source>     execute a snippet and print its value
+  repl [--engine=vm|eval|both]         start the interactive REPL
+  check [--format=text|json] <file.rk>...
+                                       report syntax errors without running
+  lint [--format=text|json] [--disable=<rule>,...] <file.rk>...
+                                       report likely mistakes without running
+  build [-o <file.rkc>] <file.rk>      compile a source file to bytecode
+  exec <file.rkc>                      run a compiled bytecode file
+  disasm <file.rk|file.rkc>            list the bytecode of every function
+  fmt [-w|-check] <file.rk>...         reformat source files in canonical style
+  debug <file.rk>                      step through a program on the VM
+  dap                                  serve the Debug Adapter Protocol on stdio
+  lsp                                  serve the Language Server Protocol on stdio
+
+run, eval and exec also take --timeout, --max-steps, --max-alloc and
+--max-depth to stop a program that runs too long or uses too much.
+
+run, eval, build and disasm optimize the bytecode they compile; -O0 turns
+that off, and -O1 (the default) turns it on.
+
+Running rafiki without a command starts the REPL.
+`
+
+// Streams bundles the readers and writers a command talks to, so tests can
+// swap them out for buffers.
+type Streams struct {
+	In  io.Reader
+	Out io.Writer
+	Err io.Writer
+}
+
+type command struct {
+	name string
+	run  func(args []string, s Streams) int
+}
+
+var commands = []command{
+	{"run", runCommand},
+	{"eval", evalCommand},
+	{"repl", replCommand},
+	{"check", checkCommand},
+	{"lint", lintCommand},
+	{"build", buildCommand},
+	{"exec", execCommand},
+	{"disasm", disasmCommand},
+	{"fmt", fmtCommand},
+	{"debug", debugCommand},
+	{"dap", dapCommand},
+	{"lsp", lspCommand},
+}
+
+// Main is the entrypoint used by main.go
+func Main() {
+	os.Exit(Run(os.Args[1:], Streams{In: os.Stdin, Out: os.Stdout, Err: os.Stderr}))
+}
+
+// Run dispatches args to a subcommand and returns the process exit code
+func Run(args []string, s Streams) int {
+	if len(args) == 0 {
+		return replCommand(args, s)
+	}
+
+	name := args[0]
+	if name == "help" || name == "-h" || name == "--help" {
+		fmt.Fprint(s.Out, usage)
+		return ExitOK
+	}
+
+	for _, c := range commands {
+		if c.name == name {
+			return c.run(args[1:], s)
+		}
+	}
+
+	fmt.Fprintf(s.Err, "rafiki: unknown command %q\n\n", name)
+	fmt.Fprint(s.Err, usage)
+	return ExitUsage
+}
+
+func newFlagSet(name string, s Streams) *flag.FlagSet {
+	fs := flag.NewFlagSet("rafiki "+name, flag.ContinueOnError)
+	fs.SetOutput(s.Err)
+	return fs
+}
+
+// rafiki run [--engine=vm|eval] [-O0|-O1] [limits] <file.rk>
+func runCommand(args []string, s Streams) int {
+	fs := newFlagSet("run", s)
+	engine := fs.String("engine", EngineVM, "execution engine: 'vm' or 'eval'")
+	optimization := addOptimizationFlags(fs)
+	limits := addLimitFlags(fs)
+
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+
+	if fs.NArg() != 1 {
+		fmt.Fprintln(s.Err, "rafiki run: expected exactly one source file")
+		return ExitUsage
+	}
+
+	if !isValidEngine(*engine) {
+		fmt.Fprintf(s.Err, "rafiki run: unknown engine %q\n", *engine)
+		return ExitUsage
+	}
+
+	filename := fs.Arg(0)
+	src, err := os.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintf(s.Err, "rafiki run: %s\n", err)
+		return ExitError
+	}
+
+	_, ok := execute(filename, string(src), *engine, *optimization, limits, s)
+	if !ok {
+		return ExitError
+	}
+
+	return ExitOK
+}
+
+// rafiki eval [--engine=vm|eval] [-O0|-O1] [limits] <source>
+func evalCommand(args []string, s Streams) int {
+	fs := newFlagSet("eval", s)
+	engine := fs.String("engine", EngineVM, "execution engine: 'vm' or 'eval'")
+	optimization := addOptimizationFlags(fs)
+	limits := addLimitFlags(fs)
+
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+
+	if fs.NArg() != 1 {
+		fmt.Fprintln(s.Err, "rafiki eval: expected exactly one source argument")
+		return ExitUsage
+	}
+
+	if !isValidEngine(*engine) {
+		fmt.Fprintf(s.Err, "rafiki eval: unknown engine %q\n", *engine)
+		return ExitUsage
+	}
+
+	result, ok := execute("<eval>", fs.Arg(0), *engine, *optimization, limits, s)
+	if !ok {
+		return ExitError
+	}
+
+	if result != nil {
+		fmt.Fprintln(s.Out, result.Inspect())
+	}
+
+	return ExitOK
+}
+
+// rafiki repl
+func replCommand(args []string, s Streams) int {
+	fs := newFlagSet("repl", s)
+	engine := fs.String("engine", repl.EngineVM, "execution engine: 'vm', 'eval' or 'both'")
+
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+
+	if !isValidEngine(*engine) && *engine != repl.EngineBoth {
+		fmt.Fprintf(s.Err, "rafiki repl: unknown engine %q\n", *engine)
+		return ExitUsage
+	}
+
+	repl.StartWithEngine(s.In, s.Out, *engine)
+	return ExitOK
+}
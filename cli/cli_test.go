@@ -0,0 +1,623 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"rafiki/eval"
+	"rafiki/vm"
+	"strings"
+	"testing"
+)
+
+func runCLI(args ...string) (int, string, string) {
+	var out, errOut bytes.Buffer
+
+	code := Run(args, Streams{In: strings.NewReader(""), Out: &out, Err: &errOut})
+
+	return code, out.String(), errOut.String()
+}
+
+func writeSource(t *testing.T, src string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "main.rk")
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatalf("could not write source file: %s", err)
+	}
+
+	return path
+}
+
+func TestRunCommand(t *testing.T) {
+	src := `
+let fibonacci = fn(x) {
+  if (x < 2) {
+    x
+  } else {
+    fibonacci(x - 1) + fibonacci(x - 2);
+  }
+};
+
+let result = fibonacci(10);
+`
+	path := writeSource(t, src)
+
+	for _, engine := range []string{EngineVM, EngineEval} {
+		code, _, errOut := runCLI("run", "--engine="+engine, path)
+		if code != ExitOK {
+			t.Errorf("engine=%s: wrong exit code. want=%d, got=%d (%s)",
+				engine, ExitOK, code, errOut)
+		}
+	}
+}
+
+func TestRunCommandErrors(t *testing.T) {
+	tests := []struct {
+		src         string
+		engine      string
+		expectedErr string
+	}{
+		{"let = 5;", EngineVM, "parse failed"},
+		{"let = 5;", EngineEval, "parse failed"},
+		{"foobar;", EngineVM, "compilation failed"},
+		{"foobar;", EngineEval, "runtime error"},
+		{"5 + true;", EngineVM, "runtime error"},
+		{"5 + true;", EngineEval, "runtime error"},
+	}
+
+	for _, tt := range tests {
+		path := writeSource(t, tt.src)
+
+		code, _, errOut := runCLI("run", "--engine="+tt.engine, path)
+		if code != ExitError {
+			t.Errorf("%q (%s): wrong exit code. want=%d, got=%d",
+				tt.src, tt.engine, ExitError, code)
+		}
+
+		if !strings.Contains(errOut, tt.expectedErr) {
+			t.Errorf("%q (%s): stderr does not mention %q. got=%q",
+				tt.src, tt.engine, tt.expectedErr, errOut)
+		}
+	}
+}
+
+func TestErrorsPointAtSource(t *testing.T) {
+	src := "let a = 1;\nlet b = a + c;\n"
+	path := writeSource(t, src)
+
+	expected := path + ":2:13: undefined variable c\n" +
+		"    let b = a + c;\n" +
+		"                ^\n"
+
+	_, _, errOut := runCLI("run", path)
+	if !strings.Contains(errOut, expected) {
+		t.Errorf("error does not point at the source.\nwant=%q\ngot=%q", expected, errOut)
+	}
+
+	src = "let f = fn(x) {\n  x + true\n};\nf(1);\n"
+	path = writeSource(t, src)
+
+	trace := "  at f (" + path + ":2:5)\n" +
+		"  at <main> (" + path + ":4:2)\n"
+
+	for _, engine := range []string{EngineVM, EngineEval} {
+		_, _, errOut := runCLI("run", "--engine="+engine, path)
+		if !strings.Contains(errOut, path+":2:5: ") || !strings.Contains(errOut, "      x + true\n        ^\n") {
+			t.Errorf("engine=%s: runtime error does not point at the source. got=%q",
+				engine, errOut)
+		}
+
+		if !strings.Contains(errOut, trace) {
+			t.Errorf("engine=%s: missing stack trace.\nwant=%q\ngot=%q", engine, trace, errOut)
+		}
+	}
+}
+
+func TestImports(t *testing.T) {
+	path := writeSource(t, "let util = import \"lib/util.rk\";\nlet x = util.double(21);\nutil.fail();\n")
+	lib := filepath.Join(filepath.Dir(path), "lib", "util.rk")
+	os.Mkdir(filepath.Dir(lib), 0755)
+	if err := os.WriteFile(lib, []byte("let double = fn(x) { x * 2 };\nlet fail = fn() {\n  1 + true\n};\n"), 0644); err != nil {
+		t.Fatalf("could not write source file: %s", err)
+	}
+
+	for _, engine := range []string{EngineVM, EngineEval} {
+		code, _, errOut := runCLI("run", "--engine="+engine, path)
+		if code != ExitError {
+			t.Errorf("engine=%s: wrong exit code. want=%d, got=%d", engine, ExitError, code)
+		}
+
+		// The error is in the imported file, so that's the line to show
+		if !strings.Contains(errOut, ":3:5: ") || !strings.Contains(errOut, "      1 + true\n        ^\n") {
+			t.Errorf("engine=%s: runtime error does not point at the imported file. got=%q", engine, errOut)
+		}
+	}
+}
+
+func TestLimitFlags(t *testing.T) {
+	path := writeSource(t, "let f = fn(n) { 1 + f(n + 1) };\nf(0);\n")
+	loop := writeSource(t, "while (true) {}\n")
+
+	for _, engine := range []string{EngineVM, EngineEval} {
+		tests := []struct {
+			args     []string
+			expected string
+		}{
+			{[]string{"--max-depth=10", path}, "recursion depth limit of 10 exceeded"},
+			{[]string{"--max-steps=500", loop}, "step limit of 500 exceeded"},
+			{[]string{"--timeout=20ms", loop}, "execution canceled: context deadline exceeded"},
+		}
+
+		for _, tt := range tests {
+			args := append([]string{"run", "--engine=" + engine}, tt.args...)
+			code, _, errOut := runCLI(args...)
+
+			if code != ExitError || !strings.Contains(errOut, tt.expected) {
+				t.Errorf("engine=%s %v: want %q, got %d %q", engine, tt.args, tt.expected, code, errOut)
+			}
+		}
+	}
+}
+
+func TestEvalCommand(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"eval", "1 + 2"}, "3\n"},
+		{[]string{"eval", "--engine=eval", "1 + 2"}, "3\n"},
+		{[]string{"eval", `let a = [1, 2]; push(a, 3)`}, "[1, 2, 3]\n"},
+	}
+
+	for _, tt := range tests {
+		code, out, errOut := runCLI(tt.args...)
+		if code != ExitOK {
+			t.Fatalf("%v: wrong exit code. want=%d, got=%d (%s)",
+				tt.args, ExitOK, code, errOut)
+		}
+
+		if out != tt.expected {
+			t.Errorf("%v: wrong output. want=%q, got=%q", tt.args, tt.expected, out)
+		}
+	}
+}
+
+func TestAssigningFunctionNames(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string // The printed value, or the error both engines report
+	}{
+		{"let f = fn() { f = 1; f }; f()", "cannot assign to f inside its own body"},
+		{"let f = fn() { let g = fn() { f = 2 }; g(); 0 }; f(); f", "cannot assign to f inside its own body"},
+		{"let f = fn() { f += 1 }; f()", "cannot assign to f inside its own body"},
+		// Bindings of the same name inside the function are its own
+		{"let f = fn(f) { f = 1; f }; f(5)", "1\n"},
+		{"let f = fn() { let g = fn(f) { f = 2; f }; g(0) }; f()", "2\n"},
+		{"let f = fn() { 1 }; f = 2; f", "2\n"},
+	}
+
+	for _, tt := range tests {
+		for _, engine := range []string{EngineVM, EngineEval} {
+			_, out, errOut := runCLI("eval", "--engine="+engine, tt.input)
+
+			if out != tt.expected && !strings.Contains(errOut, tt.expected) {
+				t.Errorf("engine=%s %q: want %q, got %q %q", engine, tt.input, tt.expected, out, errOut)
+			}
+		}
+	}
+}
+
+func TestComparisonOperandOrder(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let l = []; let f = fn(v) { l = push(l, v); v }; f(1) < f(2); l", "[1, 2]\n"},
+		{"let l = []; let f = fn(v) { l = push(l, v); v }; f(1) <= f(2); l", "[1, 2]\n"},
+		{"let l = []; let f = fn(v) { l = push(l, v); v }; f(1) > f(2); l", "[1, 2]\n"},
+		{"let x = 1; (x += 1) < (x *= 10)", "true\n"},
+		{"let x = 1; (x += 1) <= (x *= 10)", "true\n"},
+		{"let x = 1; (x *= 10) >= (x += 1)", "false\n"},
+	}
+
+	for _, tt := range tests {
+		for _, engine := range []string{EngineVM, EngineEval} {
+			_, out, errOut := runCLI("eval", "--engine="+engine, tt.input)
+			if out != tt.expected {
+				t.Errorf("engine=%s %q: want %q, got %q %q", engine, tt.input, tt.expected, out, errOut)
+			}
+		}
+	}
+}
+
+func TestCallDepthBoundary(t *testing.T) {
+	depth := func(n int) string {
+		return fmt.Sprintf("let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(%d)", n)
+	}
+
+	for _, engine := range []string{EngineVM, EngineEval} {
+		// f(n) makes n + 1 calls, so this nests exactly as deep as allowed
+		_, out, errOut := runCLI("eval", "--engine="+engine, depth(eval.MaxCallDepth-1))
+		if out != fmt.Sprintf("%d\n", eval.MaxCallDepth-1) {
+			t.Errorf("engine=%s: want %d calls to run, got %q %q", engine, eval.MaxCallDepth, out, errOut)
+		}
+
+		_, _, errOut = runCLI("eval", "--engine="+engine, depth(eval.MaxCallDepth))
+		if !strings.Contains(errOut, "stack overflow") {
+			t.Errorf("engine=%s: want a stack overflow past %d calls, got %q", engine, eval.MaxCallDepth, errOut)
+		}
+	}
+
+	if vm.MaxCallDepth != eval.MaxCallDepth {
+		t.Errorf("engines nest calls differently deep: vm=%d, eval=%d", vm.MaxCallDepth, eval.MaxCallDepth)
+	}
+}
+
+func TestLoopValue(t *testing.T) {
+	tests := []string{
+		"let x = 0; while (x < 3) { x += 1; }",
+		"let x = 0; while (true) { x += 1; if (x == 3) { break; } }",
+		"for (let i = 0; i < 3; i += 1) { i; }",
+		"for (;;) { break; }",
+		"let f = fn() { let i = 0; while (i < 2) { i += 1; } }; f()",
+		"if (true) { while (false) { 1 } }",
+	}
+
+	for _, input := range tests {
+		for _, args := range [][]string{{"--engine=vm"}, {"--engine=vm", "-O0"}, {"--engine=eval"}} {
+			_, out, errOut := runCLI(append(append([]string{"eval"}, args...), input)...)
+			if out != "null\n" {
+				t.Errorf("%v %q: want the loop's value to be null, got %q %q", args, input, out, errOut)
+			}
+		}
+	}
+}
+
+func TestProgramValue(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x = 5;", "null\n"},
+		{"let x = 5; let y = x + 1;", "null\n"},
+		{"let x = 5; x", "5\n"},
+		{"let x = 1; x = 3;", "3\n"},
+		{"let f = fn() { 2 }; f();", "2\n"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		for _, engine := range []string{EngineVM, EngineEval} {
+			_, out, errOut := runCLI("eval", "--engine="+engine, tt.input)
+			if out != tt.expected {
+				t.Errorf("engine=%s %q: want %q, got %q %q", engine, tt.input, tt.expected, out, errOut)
+			}
+		}
+	}
+}
+
+func TestOperatorErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let e = try { throw "x" } catch (e) { e }; e + 1`, "type mismatch: ERROR + INTEGER"},
+		{"true + 1", "type mismatch: BOOLEAN + INTEGER"},
+		{"[1] * 2", "type mismatch: ARRAY * INTEGER"},
+		{"true + false", "unknown operator: BOOLEAN + BOOLEAN"},
+		{`"a" - "b"`, "unknown operator: STRING - STRING"},
+		{`"a" < "b"`, "unknown operator: STRING < STRING"},
+		{"1 <= true", "type mismatch: INTEGER <= BOOLEAN"},
+		{"true > false", "unknown operator: BOOLEAN > BOOLEAN"},
+	}
+
+	for _, tt := range tests {
+		for _, engine := range []string{EngineVM, EngineEval} {
+			_, _, errOut := runCLI("eval", "--engine="+engine, tt.input)
+			if !strings.Contains(errOut, tt.expected) {
+				t.Errorf("engine=%s %q: want %q, got %q", engine, tt.input, tt.expected, errOut)
+			}
+		}
+	}
+}
+
+func TestCaughtErrorIsAValue(t *testing.T) {
+	for _, engine := range []string{EngineVM, EngineEval} {
+		code, out, errOut := runCLI("eval", "--engine="+engine, `try { throw "x" } catch (e) { e }`)
+		if code != ExitOK || out != "ERROR: x\n" {
+			t.Errorf("engine=%s: want the caught error as the value, got %d %q %q", engine, code, out, errOut)
+		}
+
+		code, _, errOut = runCLI("eval", "--engine="+engine, `throw "x"`)
+		if code != ExitError || !strings.Contains(errOut, "runtime error") {
+			t.Errorf("engine=%s: want an uncaught throw to fail, got %d %q", engine, code, errOut)
+		}
+	}
+}
+
+func TestUsageErrors(t *testing.T) {
+	tests := [][]string{
+		{"frobnicate"},
+		{"run"},
+		{"run", "--engine=jit", "main.rk"},
+		{"eval"},
+		{"build"},
+		{"exec", "a.rkc", "b.rkc"},
+		{"disasm"},
+		{"fmt"},
+		{"lint"},
+		{"lint", "--disable=nonsense", "main.rk"},
+		{"fmt", "-w", "-check", "main.rk"},
+		{"debug"},
+		{"dap", "main.rk"},
+		{"lsp", "main.rk"},
+	}
+
+	for _, args := range tests {
+		code, _, _ := runCLI(args...)
+		if code != ExitUsage {
+			t.Errorf("%v: wrong exit code. want=%d, got=%d", args, ExitUsage, code)
+		}
+	}
+}
+
+func TestCheckCommand(t *testing.T) {
+	path := writeSource(t, "let = 5;\nlet y = 10;\nlet z = (1 + ;\n")
+
+	code, out, _ := runCLI("check", "--format=json", path)
+	if code != ExitError {
+		t.Errorf("wrong exit code. want=%d, got=%d", ExitError, code)
+	}
+
+	var results []checkResult
+	if err := json.Unmarshal([]byte(out), &results); err != nil {
+		t.Fatalf("output is not valid JSON: %s\n%s", err, out)
+	}
+
+	if len(results) != 1 || len(results[0].Diagnostics) != 2 {
+		t.Fatalf("expected 2 diagnostics for one file. got=%s", out)
+	}
+
+	first := results[0].Diagnostics[0]
+	if first.Code != "P001" || first.Span.Start.Line != 1 || first.Span.Start.Column != 5 {
+		t.Errorf("wrong first diagnostic: %+v", first)
+	}
+
+	second := results[0].Diagnostics[1]
+	if second.Code != "P002" || second.Span.Start.Line != 3 {
+		t.Errorf("wrong second diagnostic: %+v", second)
+	}
+
+	code, out, _ = runCLI("check", path)
+	if code != ExitError || !strings.Contains(out, path+":1:5: error[P001]: ") {
+		t.Errorf("wrong text output: %q", out)
+	}
+
+	clean := writeSource(t, "let x = 1;\n")
+	code, _, _ = runCLI("check", clean)
+	if code != ExitOK {
+		t.Errorf("wrong exit code for a clean file. want=%d, got=%d", ExitOK, code)
+	}
+}
+
+func TestLintCommand(t *testing.T) {
+	path := writeSource(t, "let f = fn(a, b) {\n  let unused = 1;\n  a\n};\nf(1);\n")
+
+	code, out, _ := runCLI("lint", path)
+	if code != ExitError {
+		t.Errorf("wrong exit code. want=%d, got=%d", ExitError, code)
+	}
+
+	for _, expected := range []string{
+		path + ":1:15: warning[L002]: parameter b is never used",
+		path + ":2:7: warning[L001]: unused is declared but never used",
+		path + ":5:2: warning[L005]: wrong number of arguments to f: want=2, got=1",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("output is missing %q. got=\n%s", expected, out)
+		}
+	}
+
+	code, out, _ = runCLI("lint", "--format=json", "--disable=L001,unused-parameter", path)
+	if code != ExitError {
+		t.Errorf("wrong exit code. want=%d, got=%d", ExitError, code)
+	}
+
+	var results []checkResult
+	if err := json.Unmarshal([]byte(out), &results); err != nil {
+		t.Fatalf("output is not valid JSON: %s\n%s", err, out)
+	}
+
+	if len(results) != 1 || len(results[0].Diagnostics) != 1 || results[0].Diagnostics[0].Code != "L005" {
+		t.Errorf("disabled rules still reported. got=%s", out)
+	}
+
+	clean := writeSource(t, "let x = 1;\nputs(x);\n")
+	if code, out, _ := runCLI("lint", clean); code != ExitOK || out != "" {
+		t.Errorf("clean file not clean. got=%q (exit %d)", out, code)
+	}
+}
+
+func TestBuildAndExecCommands(t *testing.T) {
+	path := writeSource(t, "let add = fn(a, b) { a + b };\nlet x = add(1, 2.5);\n")
+
+	code, _, errOut := runCLI("build", path)
+	if code != ExitOK {
+		t.Fatalf("build failed with %d: %s", code, errOut)
+	}
+
+	compiled := strings.TrimSuffix(path, ".rk") + ".rkc"
+	if _, err := os.Stat(compiled); err != nil {
+		t.Fatalf("build did not write %s: %s", compiled, err)
+	}
+
+	// Remove the source to show exec doesn't need it
+	os.Remove(path)
+
+	code, _, errOut = runCLI("exec", compiled)
+	if code != ExitOK || errOut != "" {
+		t.Fatalf("exec failed with %d: %s", code, errOut)
+	}
+}
+
+func TestBuildOutputFlag(t *testing.T) {
+	path := writeSource(t, "1 / 0;\n")
+	compiled := filepath.Join(t.TempDir(), "other.rkc")
+
+	code, _, errOut := runCLI("build", "-o", compiled, path)
+	if code != ExitOK {
+		t.Fatalf("build failed with %d: %s", code, errOut)
+	}
+
+	code, _, errOut = runCLI("exec", compiled)
+	if code != ExitError {
+		t.Errorf("wrong exit code. want=%d, got=%d", ExitError, code)
+	}
+
+	expected := path + ":1:3: division by zero\n    1 / 0;\n      ^\n"
+	if !strings.Contains(errOut, expected) {
+		t.Errorf("runtime error does not point at the source.\nwant=%q\ngot=%q", expected, errOut)
+	}
+}
+
+func TestExecRejectsOtherFiles(t *testing.T) {
+	path := writeSource(t, "let x = 1;")
+
+	code, _, errOut := runCLI("exec", path)
+	if code != ExitError {
+		t.Errorf("wrong exit code. want=%d, got=%d", ExitError, code)
+	}
+
+	if !strings.Contains(errOut, "not a compiled Rafiki file") {
+		t.Errorf("unexpected error output: %q", errOut)
+	}
+}
+
+func TestDisasmCommand(t *testing.T) {
+	path := writeSource(t, "let double = fn(x) { x * 2 };\ndouble(21);\n")
+
+	code, _, errOut := runCLI("build", path)
+	if code != ExitOK {
+		t.Fatalf("build failed with %d: %s", code, errOut)
+	}
+
+	for _, file := range []string{path, strings.TrimSuffix(path, ".rk") + ".rkc"} {
+		code, out, errOut := runCLI("disasm", file)
+		if code != ExitOK {
+			t.Fatalf("%s: disasm failed with %d: %s", file, code, errOut)
+		}
+
+		for _, expected := range []string{"\nmain:\n", "OpClosure 1 0            ; double", "\ndouble (constant 1, params: 1, locals: 1, free: 0):\n"} {
+			if !strings.Contains(out, expected) {
+				t.Errorf("%s: listing is missing %q. got=\n%s", file, expected, out)
+			}
+		}
+	}
+}
+
+func TestOptimizationFlags(t *testing.T) {
+	src := "let x = 2 * 3;\nif (x > 1) { x + 1 } else { 0 }\n"
+	path := writeSource(t, src)
+
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"disasm", path}, "INTEGER   6\n"},
+		{[]string{"disasm", "-O1", path}, "INTEGER   6\n"},
+		{[]string{"disasm", "-O0", path}, "OpMul\n"},
+		{[]string{"eval", "-O0", src}, "7\n"},
+		{[]string{"eval", "-O1", src}, "7\n"},
+	}
+
+	for _, tt := range tests {
+		code, out, errOut := runCLI(tt.args...)
+		if code != ExitOK {
+			t.Fatalf("%v: failed with %d: %s", tt.args, code, errOut)
+		}
+
+		if !strings.Contains(out, tt.expected) {
+			t.Errorf("%v: output is missing %q. got=\n%s", tt.args, tt.expected, out)
+		}
+	}
+}
+
+func TestDebugUnsetLocals(t *testing.T) {
+	// later isn't assigned when the breakpoint on line 2 is hit
+	path := writeSource(t, "let f = fn(n) {\n  let x = n + 1;\n  let later = x * 2;\n  later\n};\nf(1);\n")
+
+	var out bytes.Buffer
+	in := strings.NewReader("break 2\ncontinue\nstack\nlocals\nprint later\nquit\n")
+	code := Run([]string{"debug", path}, Streams{In: in, Out: &out, Err: &out})
+	if code != ExitOK {
+		t.Fatalf("debug failed with %d: %s", code, out.String())
+	}
+
+	if !strings.Contains(out.String(), ": <unset>\n") {
+		t.Errorf("stack should show the unassigned local as <unset>. got=\n%s", out.String())
+	}
+}
+
+func TestFmtCommand(t *testing.T) {
+	path := writeSource(t, "let add = fn(a,b){ (a+b) }\nadd(1,2)")
+	formatted := "let add = fn(a, b) {\n  a + b\n};\nadd(1, 2);\n"
+
+	code, out, _ := runCLI("fmt", path)
+	if code != ExitOK || out != formatted {
+		t.Errorf("wrong output. want=%q, got=%q (exit %d)", formatted, out, code)
+	}
+
+	code, out, _ = runCLI("fmt", "-check", path)
+	if code != ExitError || out != path+"\n" {
+		t.Errorf("-check should list the unformatted file. got=%q (exit %d)", out, code)
+	}
+
+	code, _, errOut := runCLI("fmt", "-w", path)
+	if code != ExitOK {
+		t.Fatalf("-w failed with %d: %s", code, errOut)
+	}
+
+	if src, _ := os.ReadFile(path); string(src) != formatted {
+		t.Errorf("file not rewritten. got=%q", src)
+	}
+
+	code, out, _ = runCLI("fmt", "-check", path)
+	if code != ExitOK || out != "" {
+		t.Errorf("-check should pass once formatted. got=%q (exit %d)", out, code)
+	}
+
+	broken := writeSource(t, "let = 5;\n")
+	code, _, errOut = runCLI("fmt", broken)
+	if code != ExitError || !strings.Contains(errOut, broken+":1:5: error[P001]: ") {
+		t.Errorf("syntax errors not reported. got=%q (exit %d)", errOut, code)
+	}
+}
+
+func TestDebugCommand(t *testing.T) {
+	path := writeSource(t, "let double = fn(x) {\n  x * 2\n};\nlet y = double(21);\nputs(y);\n")
+
+	var out, errOut bytes.Buffer
+	in := strings.NewReader("break 2\ncontinue\nprint x\nwhere\nnext\nglobals\ncontinue\n")
+
+	code := Run([]string{"debug", path}, Streams{In: in, Out: &out, Err: &errOut})
+	if code != ExitOK {
+		t.Fatalf("debug failed with %d: %s", code, errOut.String())
+	}
+
+	for _, expected := range []string{
+		"stopped at " + path + ":2:3 (breakpoint)\n    2 |   x * 2\n",
+		"  x = 21\n",
+		"  at double (" + path + ":2:3)\n  at <main> (" + path + ":4:15)\n",
+		"  double = Closure[",
+		"stopped at " + path + ":5:1 (step)\n",
+		"  y = 42\n",
+		"program exited\n",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("output is missing %q. got=\n%s", expected, out.String())
+		}
+	}
+}
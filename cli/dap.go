@@ -0,0 +1,63 @@
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"rafiki/compiler"
+	"rafiki/dap"
+	"rafiki/eval"
+	"rafiki/object"
+)
+
+// rafiki dap
+func dapCommand(args []string, s Streams) int {
+	fs := newFlagSet("dap", s)
+
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+
+	if fs.NArg() != 0 {
+		fmt.Fprintln(s.Err, "rafiki dap: takes no arguments, the program comes from the launch request")
+		return ExitUsage
+	}
+
+	server := dap.NewServer(s.In, s.Out, compileForDebugging)
+	if err := server.Serve(); err != nil {
+		fmt.Fprintf(s.Err, "rafiki dap: %s\n", err)
+		return ExitError
+	}
+
+	return ExitOK
+}
+
+// Compile a file for the DAP server, returning the report we'd have printed
+// as the error
+func compileForDebugging(filename string, builtins *object.BuiltinRegistry) (*compiler.Bytecode, error) {
+	src, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var report bytes.Buffer
+	s := Streams{Out: &report, Err: &report}
+
+	program, ok := parseSource(filename, string(src), s)
+	if !ok {
+		return nil, errors.New(report.String())
+	}
+
+	macroEnv := object.NewEnvironmentWithBuiltins(builtins)
+	eval.DefineMacros(program, macroEnv)
+
+	comp := compiler.NewCompilerWithBuiltins(builtins)
+	if err := comp.Compile(eval.ExpandMacros(program, macroEnv)); err != nil {
+		fmt.Fprintf(s.Err, "%s: compilation failed:\n", filename)
+		reportError(s, filename, string(src), err)
+		return nil, errors.New(report.String())
+	}
+
+	return comp.Bytecode(), nil
+}
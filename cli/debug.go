@@ -0,0 +1,249 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"rafiki/compiler"
+	"rafiki/object"
+	"rafiki/vm"
+	"strconv"
+	"strings"
+)
+
+const debugHelp = `Commands:
+  break <line>      stop when execution reaches a line (also b)
+  clear <line>      remove a breakpoint
+  continue          run to the next breakpoint (also c)
+  step              run to the next line, entering calls (also s)
+  next              run to the next line in this function (also n)
+  out               run until this function returns (also o)
+  where             show the call stack (also bt)
+  locals            show the current function's locals and free variables
+  globals           show the globals
+  stack             show the values on the VM stack
+  print <name>      show a variable (also p)
+  quit              stop debugging (also q)
+`
+
+// rafiki debug <file.rk>
+func debugCommand(args []string, s Streams) int {
+	fs := newFlagSet("debug", s)
+
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+
+	if fs.NArg() != 1 {
+		fmt.Fprintln(s.Err, "rafiki debug: expected exactly one source file")
+		return ExitUsage
+	}
+
+	filename := fs.Arg(0)
+	src, err := os.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintf(s.Err, "rafiki debug: %s\n", err)
+		return ExitError
+	}
+
+	// Unoptimized, so every statement is still there to stop on
+	code, ok := compileSource(filename, string(src), compiler.O0, s)
+	if !ok {
+		return ExitError
+	}
+
+	session := &debugSession{
+		debugger: vm.NewDebugger(code),
+		filename: filename,
+		lines:    strings.Split(string(src), "\n"),
+		src:      string(src),
+		s:        s,
+	}
+
+	return session.run()
+}
+
+type debugSession struct {
+	debugger *vm.Debugger
+	filename string
+	lines    []string
+	src      string
+	s        Streams
+	started  bool
+}
+
+func (ds *debugSession) run() int {
+	fmt.Fprintf(ds.s.Out, "Debugging %s. Set breakpoints, then 'continue' or 'step' to start. Type 'help' for commands.\n", ds.filename)
+
+	scanner := bufio.NewScanner(ds.s.In)
+	for {
+		fmt.Fprint(ds.s.Out, "(rafiki) ")
+
+		if !scanner.Scan() {
+			fmt.Fprintln(ds.s.Out)
+			break
+		}
+
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		if quit := ds.command(fields[0], fields[1:]); quit {
+			break
+		}
+	}
+
+	if ds.debugger.Err() != nil {
+		return ExitError
+	}
+
+	return ExitOK
+}
+
+// Run one command. Returns true when the session should end.
+func (ds *debugSession) command(name string, args []string) bool {
+	d := ds.debugger
+
+	switch name {
+	case "help", "h":
+		fmt.Fprint(ds.s.Out, debugHelp)
+
+	case "break", "b":
+		if line, ok := ds.lineArg(name, args); ok {
+			d.SetBreakpoint(ds.filename, line)
+			fmt.Fprintf(ds.s.Out, "breakpoint set at %s:%d\n", ds.filename, line)
+		}
+
+	case "clear":
+		if line, ok := ds.lineArg(name, args); ok {
+			d.ClearBreakpoint(ds.filename, line)
+			fmt.Fprintf(ds.s.Out, "breakpoint cleared at %s:%d\n", ds.filename, line)
+		}
+
+	case "continue", "c":
+		ds.stopped(d.Continue())
+	case "step", "s":
+		ds.stopped(d.Step())
+	case "next", "n":
+		ds.stopped(d.StepOver())
+	case "out", "o":
+		ds.stopped(d.StepOut())
+
+	case "where", "bt":
+		if ds.running() {
+			for _, frame := range d.Frames() {
+				fmt.Fprintf(ds.s.Out, "  at %s (%s)\n", frame.Function, frame.Pos)
+			}
+		}
+
+	case "locals":
+		if ds.running() {
+			frame := d.Frames()[0]
+			ds.variables(frame.Locals)
+			ds.variables(frame.Free)
+		}
+
+	case "globals":
+		if ds.running() {
+			ds.variables(d.Globals())
+		}
+
+	case "stack":
+		if ds.running() {
+			for i, value := range d.Stack() {
+				fmt.Fprintf(ds.s.Out, "  %d: %s\n", i, inspect(value))
+			}
+		}
+
+	case "print", "p":
+		if len(args) != 1 {
+			fmt.Fprintf(ds.s.Out, "usage: %s <name>\n", name)
+		} else if ds.running() {
+			ds.print(args[0])
+		}
+
+	case "quit", "q":
+		return true
+
+	default:
+		fmt.Fprintf(ds.s.Out, "unknown command %q, type 'help' for a list\n", name)
+	}
+
+	return false
+}
+
+func (ds *debugSession) lineArg(name string, args []string) (int, bool) {
+	if len(args) == 1 {
+		if line, err := strconv.Atoi(args[0]); err == nil && line > 0 {
+			return line, true
+		}
+	}
+
+	fmt.Fprintf(ds.s.Out, "usage: %s <line>\n", name)
+	return 0, false
+}
+
+// Whether there's a paused program to inspect, saying so if not
+func (ds *debugSession) running() bool {
+	switch {
+	case !ds.started:
+		fmt.Fprintln(ds.s.Out, "the program has not started, use 'continue' or 'step'")
+		return false
+	case ds.debugger.Done():
+		fmt.Fprintln(ds.s.Out, "the program has finished")
+		return false
+	}
+
+	return true
+}
+
+func (ds *debugSession) stopped(reason vm.StopReason) {
+	ds.started = true
+
+	switch reason {
+	case vm.StopExited:
+		fmt.Fprintln(ds.s.Out, "program exited")
+	case vm.StopError:
+		reportError(ds.s, ds.filename, ds.src, ds.debugger.Err())
+	default:
+		pos := ds.debugger.Position()
+		fmt.Fprintf(ds.s.Out, "stopped at %s (%s)\n", pos, reason)
+
+		if pos.Filename == ds.filename && pos.Line <= len(ds.lines) {
+			fmt.Fprintf(ds.s.Out, "%5d | %s\n", pos.Line, ds.lines[pos.Line-1])
+		}
+	}
+}
+
+func (ds *debugSession) variables(vars []vm.Variable) {
+	for _, v := range vars {
+		fmt.Fprintf(ds.s.Out, "  %s = %s\n", v.Name, inspect(v.Value))
+	}
+}
+
+// Look name up the way the program would: the current function first, then globals
+func (ds *debugSession) print(name string) {
+	frame := ds.debugger.Frames()[0]
+
+	for _, vars := range [][]vm.Variable{frame.Locals, frame.Free, ds.debugger.Globals()} {
+		for _, v := range vars {
+			if v.Name == name {
+				fmt.Fprintf(ds.s.Out, "  %s = %s\n", v.Name, inspect(v.Value))
+				return
+			}
+		}
+	}
+
+	fmt.Fprintf(ds.s.Out, "no variable named %q in scope\n", name)
+}
+
+// A value for display. Locals a function hasn't assigned yet are nil on the
+// stack.
+func inspect(value object.Object) string {
+	if value == nil {
+		return "<unset>"
+	}
+
+	return value.Inspect()
+}
@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"rafiki/bytecode"
+	"rafiki/compiler"
+	"rafiki/disasm"
+)
+
+// rafiki disasm [-O0|-O1] <file.rk|file.rkc>
+func disasmCommand(args []string, s Streams) int {
+	fs := newFlagSet("disasm", s)
+	optimization := addOptimizationFlags(fs)
+
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+
+	if fs.NArg() != 1 {
+		fmt.Fprintln(s.Err, "rafiki disasm: expected exactly one source or compiled file")
+		return ExitUsage
+	}
+
+	filename := fs.Arg(0)
+
+	var code *compiler.Bytecode
+	if filepath.Ext(filename) == bytecode.Extension {
+		var err error
+		code, err = bytecode.ReadFile(filename)
+		if err != nil {
+			fmt.Fprintf(s.Err, "rafiki disasm: %s: %s\n", filename, err)
+			return ExitError
+		}
+	} else {
+		src, err := os.ReadFile(filename)
+		if err != nil {
+			fmt.Fprintf(s.Err, "rafiki disasm: %s\n", err)
+			return ExitError
+		}
+
+		var ok bool
+		code, ok = compileSource(filename, string(src), *optimization, s)
+		if !ok {
+			return ExitError
+		}
+	}
+
+	fmt.Fprint(s.Out, disasm.Disassemble(code))
+	return ExitOK
+}
@@ -0,0 +1,257 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"rafiki/ast"
+	"rafiki/compiler"
+	"rafiki/diagnostic"
+	"rafiki/eval"
+	"rafiki/lexer"
+	"rafiki/object"
+	"rafiki/parser"
+	"rafiki/repl"
+	"rafiki/token"
+	"rafiki/vm"
+	"strconv"
+	"time"
+)
+
+const (
+	EngineVM   = repl.EngineVM
+	EngineEval = repl.EngineEval
+)
+
+func isValidEngine(engine string) bool {
+	return engine == EngineVM || engine == EngineEval
+}
+
+// How long a program run from the command line may take, and what it may use.
+// The zero value sets no limits.
+type runLimits struct {
+	object.Limits
+	timeout time.Duration
+}
+
+func addLimitFlags(fs *flag.FlagSet) *runLimits {
+	l := &runLimits{}
+
+	fs.DurationVar(&l.timeout, "timeout", 0, "stop the program after this long, like '2s' (0 for no limit)")
+	fs.Int64Var(&l.MaxSteps, "max-steps", 0, "stop the program after this many VM instructions or evaluation steps")
+	fs.IntVar(&l.MaxAllocation, "max-alloc", 0, "largest array or hash, in elements, or string, in bytes, the program may build")
+	fs.IntVar(&l.MaxDepth, "max-depth", 0, "most function calls the program may have in progress at once")
+
+	return l
+}
+
+// Adds -O0 and -O1, which set how much the compiler optimizes. The level
+// starts at O1.
+func addOptimizationFlags(fs *flag.FlagSet) *int {
+	level := compiler.O1
+
+	fs.Var(&optimizationFlag{&level, compiler.O0}, "O0", "compile without optimizing")
+	fs.Var(&optimizationFlag{&level, compiler.O1}, "O1", "fold constants and remove dead code and redundant jumps")
+
+	return &level
+}
+
+// A -O<n> flag, which sets the level it names when given
+type optimizationFlag struct {
+	level *int
+	value int
+}
+
+func (f *optimizationFlag) String() string {
+	return strconv.FormatBool(f.level != nil && *f.level == f.value)
+}
+
+func (f *optimizationFlag) Set(s string) error {
+	on, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+
+	if on {
+		*f.level = f.value
+	}
+
+	return nil
+}
+
+func (f *optimizationFlag) IsBoolFlag() bool { return true }
+
+func (l *runLimits) context() (context.Context, context.CancelFunc) {
+	if l.timeout > 0 {
+		return context.WithTimeout(context.Background(), l.timeout)
+	}
+
+	return context.WithCancel(context.Background())
+}
+
+// Parse the whole source as a single program, reporting every parser error
+func parseSource(filename string, src string, s Streams) (*ast.Program, bool) {
+	l := lexer.NewLexerWithFilename(src, filename)
+	p := parser.NewParser(l)
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		fmt.Fprintf(s.Err, "%s: parse failed:\n", filename)
+		for _, d := range p.Diagnostics() {
+			fmt.Fprint(s.Err, diagnostic.Render(src, d))
+		}
+		return nil, false
+	}
+
+	return program, true
+}
+
+// Print err with a snippet of the source line it points at, when we know it.
+// src is the source of filename; an error raised in an imported file is shown
+// with a line from that file instead.
+func reportError(s Streams, filename string, src string, err error) {
+	var diagErr *diagnostic.Error
+	var objErr *object.Error
+
+	switch {
+	case errors.As(err, &diagErr):
+		fmt.Fprint(s.Err, diagnostic.Format(sourceAt(filename, src, diagErr.Pos), diagErr.Pos, diagErr.Message))
+	case errors.As(err, &objErr):
+		fmt.Fprint(s.Err, diagnostic.Format(sourceAt(filename, src, objErr.Pos), objErr.Pos, objErr.Message))
+		// Only worth printing when the error was raised inside a call
+		if len(objErr.Stack) > 1 {
+			fmt.Fprint(s.Err, objErr.StackTrace())
+		}
+	default:
+		fmt.Fprintln(s.Err, err)
+	}
+}
+
+// The source of the file pos is in, or nothing if it can't be read
+func sourceAt(filename string, src string, pos token.Position) string {
+	if pos.Filename == "" || pos.Filename == filename {
+		return src
+	}
+
+	other, err := os.ReadFile(pos.Filename)
+	if err != nil {
+		return ""
+	}
+
+	return string(other)
+}
+
+// Parse, expand macros and run the program on the chosen engine.
+// Returns the value of the last expression statement, if any.
+func execute(filename string, src string, engine string, optimization int, limits *runLimits, s Streams) (object.Object, bool) {
+	expanded, ok := expandSource(filename, src, s)
+	if !ok {
+		return nil, false
+	}
+
+	switch engine {
+	case EngineEval:
+		return executeEval(filename, src, expanded, limits, s)
+	default:
+		return executeVM(filename, src, expanded, optimization, limits, s)
+	}
+}
+
+func executeVM(filename string, src string, program ast.Node, optimization int, limits *runLimits, s Streams) (object.Object, bool) {
+	bytecode, ok := compileProgram(filename, src, program, optimization, s)
+	if !ok {
+		return nil, false
+	}
+
+	result, ok := runBytecode(filename, src, bytecode, limits, s)
+	if ok && !endsInExpression(program) {
+		result = vm.Null
+	}
+
+	return result, ok
+}
+
+// Whether a program's value is that of its last statement. The VM's last
+// popped value is also left behind by a let, which has no value in the
+// evaluator, so a program ending in one is null on both.
+func endsInExpression(program ast.Node) bool {
+	p, ok := program.(*ast.Program)
+	if !ok || len(p.Statements) == 0 {
+		return true
+	}
+
+	_, ok = p.Statements[len(p.Statements)-1].(*ast.ExpressionStatement)
+	return ok
+}
+
+// Parse the source and expand its macros
+func expandSource(filename string, src string, s Streams) (ast.Node, bool) {
+	program, ok := parseSource(filename, src, s)
+	if !ok {
+		return nil, false
+	}
+
+	macroEnv := object.NewEnvironment()
+	eval.DefineMacros(program, macroEnv)
+
+	return eval.ExpandMacros(program, macroEnv), true
+}
+
+// Parse, expand macros and compile the whole source at the given optimization
+// level
+func compileSource(filename string, src string, optimization int, s Streams) (*compiler.Bytecode, bool) {
+	expanded, ok := expandSource(filename, src, s)
+	if !ok {
+		return nil, false
+	}
+
+	return compileProgram(filename, src, expanded, optimization, s)
+}
+
+func compileProgram(filename string, src string, program ast.Node, optimization int, s Streams) (*compiler.Bytecode, bool) {
+	comp := compiler.NewCompiler()
+	comp.SetOptimization(optimization)
+	err := comp.Compile(program)
+	if err != nil {
+		fmt.Fprintf(s.Err, "%s: compilation failed:\n", filename)
+		reportError(s, filename, src, err)
+		return nil, false
+	}
+
+	return comp.Bytecode(), true
+}
+
+// Run compiled code on the VM. src is only used to show where errors happened,
+// and may be empty.
+func runBytecode(filename string, src string, bytecode *compiler.Bytecode, limits *runLimits, s Streams) (object.Object, bool) {
+	ctx, cancel := limits.context()
+	defer cancel()
+
+	machine := vm.NewVm(bytecode)
+	err := machine.RunWithLimits(ctx, limits.Limits)
+	if err != nil {
+		fmt.Fprintf(s.Err, "%s: runtime error:\n", filename)
+		reportError(s, filename, src, err)
+		return nil, false
+	}
+
+	return machine.LastPoppedStackElem(), true
+}
+
+func executeEval(filename string, src string, program ast.Node, limits *runLimits, s Streams) (object.Object, bool) {
+	ctx, cancel := limits.context()
+	defer cancel()
+
+	env := object.NewEnvironment()
+
+	result := eval.EvalWithLimits(ctx, program, env, limits.Limits)
+	if exception, ok := result.(*object.Exception); ok {
+		fmt.Fprintf(s.Err, "%s: runtime error:\n", filename)
+		reportError(s, filename, src, exception.Error)
+		return nil, false
+	}
+
+	return result, true
+}
@@ -0,0 +1,79 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"rafiki/diagnostic"
+	"rafiki/format"
+)
+
+// rafiki fmt [-w | -check] <file.rk>...
+func fmtCommand(args []string, s Streams) int {
+	fs := newFlagSet("fmt", s)
+	write := fs.Bool("w", false, "write the result back to each file instead of printing it")
+	check := fs.Bool("check", false, "list the files that aren't formatted, and change nothing")
+
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+
+	if fs.NArg() == 0 {
+		fmt.Fprintln(s.Err, "rafiki fmt: expected at least one source file")
+		return ExitUsage
+	}
+
+	if *write && *check {
+		fmt.Fprintln(s.Err, "rafiki fmt: -w and -check can't be used together")
+		return ExitUsage
+	}
+
+	exitCode := ExitOK
+
+	for _, filename := range fs.Args() {
+		src, err := os.ReadFile(filename)
+		if err != nil {
+			fmt.Fprintf(s.Err, "rafiki fmt: %s\n", err)
+			exitCode = ExitError
+			continue
+		}
+
+		formatted, err := format.Source(filename, string(src))
+
+		var syntaxErr *format.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			for _, d := range syntaxErr.Diagnostics {
+				fmt.Fprint(s.Err, diagnostic.Render(string(src), d))
+			}
+			exitCode = ExitError
+			continue
+		}
+
+		switch {
+		case *check:
+			if formatted != string(src) {
+				fmt.Fprintln(s.Out, filename)
+				exitCode = ExitError
+			}
+
+		case *write:
+			if formatted == string(src) {
+				continue
+			}
+
+			info, err := os.Stat(filename)
+			if err == nil {
+				err = os.WriteFile(filename, []byte(formatted), info.Mode().Perm())
+			}
+			if err != nil {
+				fmt.Fprintf(s.Err, "rafiki fmt: %s\n", err)
+				exitCode = ExitError
+			}
+
+		default:
+			fmt.Fprint(s.Out, formatted)
+		}
+	}
+
+	return exitCode
+}
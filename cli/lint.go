@@ -0,0 +1,88 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"rafiki/diagnostic"
+	"rafiki/lint"
+	"strings"
+)
+
+// rafiki lint [--format=text|json] [--disable=<rule>,...] <file.rk>...
+func lintCommand(args []string, s Streams) int {
+	fs := newFlagSet("lint", s)
+	format := fs.String("format", "text", "output format: 'text' or 'json'")
+	disable := fs.String("disable", "", "comma-separated rule codes or names to skip, like 'L003,unused-parameter'")
+
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+
+	if fs.NArg() == 0 {
+		fmt.Fprintln(s.Err, "rafiki lint: expected at least one source file")
+		return ExitUsage
+	}
+
+	if *format != "text" && *format != "json" {
+		fmt.Fprintf(s.Err, "rafiki lint: unknown format %q\n", *format)
+		return ExitUsage
+	}
+
+	disabled := map[string]bool{}
+	for _, id := range strings.Split(*disable, ",") {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+
+		rule, ok := lint.LookupRule(id)
+		if !ok {
+			fmt.Fprintf(s.Err, "rafiki lint: unknown rule %q\n", id)
+			return ExitUsage
+		}
+		disabled[rule.Code] = true
+	}
+
+	results := []checkResult{}
+	exitCode := ExitOK
+
+	for _, filename := range fs.Args() {
+		src, err := os.ReadFile(filename)
+		if err != nil {
+			fmt.Fprintf(s.Err, "rafiki lint: %s\n", err)
+			return ExitError
+		}
+
+		diagnostics := []*diagnostic.Diagnostic{}
+		for _, d := range lint.Source(filename, string(src)) {
+			if !disabled[d.Code] {
+				diagnostics = append(diagnostics, d)
+			}
+		}
+
+		if len(diagnostics) > 0 {
+			exitCode = ExitError
+		}
+
+		if *format == "json" {
+			results = append(results, checkResult{File: filename, Diagnostics: diagnostics})
+			continue
+		}
+
+		for _, d := range diagnostics {
+			fmt.Fprint(s.Out, diagnostic.Render(string(src), d))
+		}
+	}
+
+	if *format == "json" {
+		encoder := json.NewEncoder(s.Out)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(results); err != nil {
+			fmt.Fprintf(s.Err, "rafiki lint: %s\n", err)
+			return ExitError
+		}
+	}
+
+	return exitCode
+}
@@ -0,0 +1,27 @@
+package cli
+
+import (
+	"fmt"
+	"rafiki/lsp"
+)
+
+// rafiki lsp
+func lspCommand(args []string, s Streams) int {
+	fs := newFlagSet("lsp", s)
+
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+
+	if fs.NArg() != 0 {
+		fmt.Fprintln(s.Err, "rafiki lsp: takes no arguments, documents come from the editor")
+		return ExitUsage
+	}
+
+	if err := lsp.NewServer(s.In, s.Out).Serve(); err != nil {
+		fmt.Fprintf(s.Err, "rafiki lsp: %s\n", err)
+		return ExitError
+	}
+
+	return ExitOK
+}
@@ -0,0 +1,11 @@
+// cmd/rafiki/main.go
+
+package main
+
+import (
+	"rafiki/cli"
+)
+
+func main() {
+	cli.Main()
+}
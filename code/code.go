@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"rafiki/token"
+	"sort"
 )
 
 type Instructions []byte
@@ -42,6 +44,22 @@ const (
 	OpClosure
 	OpGetFree
 	OpCurrentClosure
+	OpSetFree
+	OpGetLocalCell
+	OpGetFreeCell
+	OpSetIndex
+	OpDupTwo
+	OpGreaterThanOrEqual
+	OpMod
+	OpTry
+	OpEndTry
+	OpThrow
+	OpImport
+	OpModule
+	OpTailCall
+	OpWide
+	OpLessThan
+	OpLessThanOrEqual
 )
 
 type Definition struct {
@@ -50,36 +68,66 @@ type Definition struct {
 }
 
 var definitions = map[Opcode]*Definition{
-	OpConstant:       {"OpConstant", []int{2}},
-	OpAdd:            {"OpAdd", []int{}}, // Pop the two topmost elements and add them
-	OpSub:            {"OpSub", []int{}}, // Pop the two topmost elements and subtract them
-	OpMul:            {"OpMul", []int{}}, // Pop the two topmost elements and multiply them
-	OpDiv:            {"OpDiv", []int{}}, // Pop the two topmost elements and divide them
-	OpPop:            {"OpPop", []int{}}, // Pop the topmost element off the stack
-	OpTrue:           {"OpTrue", []int{}},
-	OpFalse:          {"OpFalse", []int{}},
-	OpEqual:          {"OpEqual", []int{}},
-	OpNotEqual:       {"OpNotEqual", []int{}},
-	OpGreaterThan:    {"OpGreaterThan", []int{}}, // To keep the instruction set small, we're going to reorder LessThans into GreaterThans
-	OpMinus:          {"OpMinus", []int{}},
-	OpBang:           {"OpBang", []int{}},
-	OpJumpNotTruthy:  {"OpJumpNotTruthy", []int{2}},
-	OpJump:           {"OpJump", []int{2}},
-	OpNull:           {"OpNull", []int{}},
-	OpGetGlobal:      {"OpGetGlobal", []int{2}},
-	OpSetGlobal:      {"OpSetGlobal", []int{2}},
-	OpArray:          {"OpArray", []int{2}},
-	OpHash:           {"OpHash", []int{2}},
-	OpIndex:          {"OpIndex", []int{}},
-	OpReturnValue:    {"OpReturnValue", []int{}},
-	OpCall:           {"OpCall", []int{1}},
-	OpReturn:         {"OpReturn", []int{}},
-	OpGetLocal:       {"OpGetLocal", []int{1}},
-	OpSetLocal:       {"OpSetLocal", []int{1}},
-	OpGetBuiltin:     {"OpGetBuiltin", []int{1}},
-	OpClosure:        {"OpClosure", []int{2, 1}},
-	OpGetFree:        {"OpGetFree", []int{1}},
-	OpCurrentClosure: {"OpCurrentClosure", []int{}},
+	OpConstant:           {"OpConstant", []int{2}},
+	OpAdd:                {"OpAdd", []int{}}, // Pop the two topmost elements and add them
+	OpSub:                {"OpSub", []int{}}, // Pop the two topmost elements and subtract them
+	OpMul:                {"OpMul", []int{}}, // Pop the two topmost elements and multiply them
+	OpDiv:                {"OpDiv", []int{}}, // Pop the two topmost elements and divide them
+	OpPop:                {"OpPop", []int{}}, // Pop the topmost element off the stack
+	OpTrue:               {"OpTrue", []int{}},
+	OpFalse:              {"OpFalse", []int{}},
+	OpEqual:              {"OpEqual", []int{}},
+	OpNotEqual:           {"OpNotEqual", []int{}},
+	OpGreaterThan:        {"OpGreaterThan", []int{}},
+	OpMinus:              {"OpMinus", []int{}},
+	OpBang:               {"OpBang", []int{}},
+	OpJumpNotTruthy:      {"OpJumpNotTruthy", []int{2}},
+	OpJump:               {"OpJump", []int{2}},
+	OpNull:               {"OpNull", []int{}},
+	OpGetGlobal:          {"OpGetGlobal", []int{2}},
+	OpSetGlobal:          {"OpSetGlobal", []int{2}},
+	OpArray:              {"OpArray", []int{2}},
+	OpHash:               {"OpHash", []int{2}},
+	OpIndex:              {"OpIndex", []int{}},
+	OpReturnValue:        {"OpReturnValue", []int{}},
+	OpCall:               {"OpCall", []int{1}},
+	OpReturn:             {"OpReturn", []int{}},
+	OpGetLocal:           {"OpGetLocal", []int{1}},
+	OpSetLocal:           {"OpSetLocal", []int{1}},
+	OpGetBuiltin:         {"OpGetBuiltin", []int{1}},
+	OpClosure:            {"OpClosure", []int{2, 1}},
+	OpGetFree:            {"OpGetFree", []int{1}},
+	OpCurrentClosure:     {"OpCurrentClosure", []int{}},
+	OpSetFree:            {"OpSetFree", []int{1}},
+	OpGetLocalCell:       {"OpGetLocalCell", []int{1}}, // Push the cell holding a local, boxing it first if needed, for a closure to capture
+	OpGetFreeCell:        {"OpGetFreeCell", []int{1}},  // Push a free variable's cell rather than its value, for a nested closure to capture
+	OpSetIndex:           {"OpSetIndex", []int{}},      // Pop value, index and collection, store the value, push it back
+	OpDupTwo:             {"OpDupTwo", []int{}},        // Duplicate the two topmost elements
+	OpGreaterThanOrEqual: {"OpGreaterThanOrEqual", []int{}},
+	OpMod:                {"OpMod", []int{}},
+	OpTry:                {"OpTry", []int{2}},       // Install a handler at the operand, until the matching OpEndTry
+	OpEndTry:             {"OpEndTry", []int{}},     // Remove the innermost handler
+	OpThrow:              {"OpThrow", []int{}},      // Pop a value and throw it
+	OpImport:             {"OpImport", []int{2, 2}}, // Push the module in the global slot, running the function constant to fill it the first time
+	OpModule:             {"OpModule", []int{2, 2}}, // Pop name and value pairs into a module, named by the constant
+	OpTailCall:           {"OpTailCall", []int{1}},  // Like OpCall, but a closure takes over the calling function's frame
+	OpWide:               {"OpWide", []int{}},       // Widen the next instruction's one and two byte operands to two and four
+	OpLessThan:           {"OpLessThan", []int{}},
+	OpLessThanOrEqual:    {"OpLessThanOrEqual", []int{}},
+}
+
+// The definitions of the instructions following an OpWide
+var wideDefinitions = map[Opcode]*Definition{}
+
+func init() {
+	for op, def := range definitions {
+		widths := make([]int, len(def.OperandWidths))
+		for i, w := range def.OperandWidths {
+			widths[i] = wideWidth(w)
+		}
+
+		wideDefinitions[op] = &Definition{Name: def.Name, OperandWidths: widths}
+	}
 }
 
 func Lookup(op byte) (*Definition, error) {
@@ -92,39 +140,133 @@ func Lookup(op byte) (*Definition, error) {
 	return def, nil
 }
 
+// How wide an operand usually width bytes wide is after an OpWide
+func wideWidth(width int) int {
+	if width < 4 {
+		return 2 * width
+	}
+
+	return width
+}
+
+// The largest operand width bytes can hold
+func MaxOperand(width int) int {
+	return 1<<(8*width) - 1
+}
+
+// An operand too large for even the wide form of its instruction
+type OperandError struct {
+	Op      Opcode
+	Operand int // Which of the instruction's operands
+	Value   int
+	Max     int
+}
+
+func (e *OperandError) Error() string {
+	return fmt.Sprintf("operand %d of %s is %d, more than the most it can hold, %d",
+		e.Operand, definitions[e.Op].Name, e.Value, e.Max)
+}
+
+// Like MakeChecked, but an instruction whose operands don't fit comes back empty
 func Make(op Opcode, operands ...int) []byte {
+	instruction, err := MakeChecked(op, operands...)
+	if err != nil {
+		return []byte{}
+	}
+
+	return instruction
+}
+
+/*
+MakeChecked encodes an instruction. When an operand is too large for its
+usual width, the operands are written wider, after an OpWide:
+
+	OpConstant 70000  =>  OpWide OpConstant 0x00 0x01 0x11 0x70
+
+It fails with an *OperandError if an operand doesn't fit even then.
+*/
+func MakeChecked(op Opcode, operands ...int) ([]byte, error) {
 	def, ok := definitions[op]
 
 	if !ok {
-		return []byte{}
+		return []byte{}, nil
 	}
 
-	// Figure out how long the instruction is going to be
-	instructionLength := 1
-	for _, w := range def.OperandWidths {
-		instructionLength += w
+	wide := false
+	for i, o := range operands {
+		width := def.OperandWidths[i]
+
+		if max := MaxOperand(wideWidth(width)); o < 0 || o > max {
+			return nil, &OperandError{Op: op, Operand: i, Value: o, Max: max}
+		}
+
+		if o > MaxOperand(width) {
+			wide = true
+		}
 	}
 
-	// Make a byte array of that size
-	instruction := make([]byte, instructionLength)
-	instruction[0] = byte(op)
+	var instruction []byte
+	if wide {
+		def = wideDefinitions[op]
+		instruction = []byte{byte(OpWide)}
+	}
 
 	// For each operand, convert the operands into bytecode
-	offset := 1
+	instruction = append(instruction, byte(op))
 	for i, o := range operands {
-		width := def.OperandWidths[i]
-
-		switch width {
+		switch def.OperandWidths[i] {
 		case 1:
-			instruction[offset] = byte(o)
+			instruction = append(instruction, byte(o))
 		case 2:
-			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
+			instruction = binary.BigEndian.AppendUint16(instruction, uint16(o))
+		case 4:
+			instruction = binary.BigEndian.AppendUint32(instruction, uint32(o))
 		}
+	}
 
-		offset += width
+	return instruction, nil
+}
+
+// An instruction read back from Instructions
+type Instruction struct {
+	Op       Opcode
+	Def      *Definition // With the widths the operands were read at
+	Operands []int
+	Length   int // In bytes, counting any OpWide prefix
+}
+
+// Read the instruction at the start of ins, looking through an OpWide prefix
+func Decode(ins Instructions) (Instruction, error) {
+	prefix := 0
+	definitions := definitions
+
+	if len(ins) > 0 && Opcode(ins[0]) == OpWide {
+		prefix = 1
+		definitions = wideDefinitions
 	}
 
-	return instruction
+	if len(ins) <= prefix {
+		return Instruction{}, fmt.Errorf("instruction runs past the end")
+	}
+
+	op := Opcode(ins[prefix])
+	def, ok := definitions[op]
+	if !ok || op == OpWide {
+		return Instruction{}, fmt.Errorf("opcode %d undefined", op)
+	}
+
+	width := 0
+	for _, w := range def.OperandWidths {
+		width += w
+	}
+
+	if prefix+1+width > len(ins) {
+		return Instruction{}, fmt.Errorf("%s runs past the end", def.Name)
+	}
+
+	operands, read := ReadOperands(def, ins[prefix+1:])
+
+	return Instruction{Op: op, Def: def, Operands: operands, Length: prefix + 1 + read}, nil
 }
 
 func (ins Instructions) String() string {
@@ -132,17 +274,15 @@ func (ins Instructions) String() string {
 
 	i := 0
 	for i < len(ins) {
-		def, err := Lookup(ins[i])
+		instruction, err := Decode(ins[i:])
 		if err != nil {
 			fmt.Fprintf(&out, "ERROR: %s\n", err)
-			continue
+			break
 		}
 
-		operands, read := ReadOperands(def, ins[i+1:])
-
-		fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, operands))
+		fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(instruction.Def, instruction.Operands))
 
-		i += 1 + read
+		i += instruction.Length
 	}
 
 	return out.String()
@@ -179,6 +319,8 @@ func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
 			operands[i] = int(ReadUint8(ins[offset:]))
 		case 2:
 			operands[i] = int(ReadUint16(ins[offset:]))
+		case 4:
+			operands[i] = int(ReadUint32(ins[offset:]))
 		}
 
 		offset += width
@@ -187,8 +329,34 @@ func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
 	return operands, offset
 }
 
+func ReadUint32(ins Instructions) uint32 {
+	return binary.BigEndian.Uint32(ins)
+}
+
 func ReadUint16(ins Instructions) uint16 {
 	return binary.BigEndian.Uint16(ins)
 }
 
 func ReadUint8(ins Instructions) uint8 { return uint8(ins[0]) }
+
+// Maps instruction offsets back to the source position they were compiled from.
+// Entries are sorted by Offset, and each covers every byte up to the next entry.
+type SourceMap []SourceMapEntry
+
+type SourceMapEntry struct {
+	Offset int
+	Pos    token.Position
+}
+
+// Position of the instruction at offset, or the zero Position if unknown
+func (sm SourceMap) Lookup(offset int) token.Position {
+	i := sort.Search(len(sm), func(i int) bool {
+		return sm[i].Offset > offset
+	})
+
+	if i == 0 {
+		return token.Position{}
+	}
+
+	return sm[i-1].Pos
+}
@@ -12,6 +12,12 @@ func TestMake(t *testing.T) {
 		{OpAdd, []int{}, []byte{byte(OpAdd)}},
 		{OpGetLocal, []int{255}, []byte{byte(OpGetLocal), 255}},
 		{OpClosure, []int{65534, 255}, []byte{byte(OpClosure), 255, 254, 255}},
+		{OpJump, []int{65535}, []byte{byte(OpJump), 255, 255}},
+		// Operands too large for their usual width widen them all
+		{OpConstant, []int{65536}, []byte{byte(OpWide), byte(OpConstant), 0, 1, 0, 0}},
+		{OpGetLocal, []int{256}, []byte{byte(OpWide), byte(OpGetLocal), 1, 0}},
+		{OpJump, []int{65536}, []byte{byte(OpWide), byte(OpJump), 0, 1, 0, 0}},
+		{OpClosure, []int{1, 256}, []byte{byte(OpWide), byte(OpClosure), 0, 0, 0, 1, 1, 0}},
 	}
 
 	for _, tt := range tests {
@@ -31,6 +37,37 @@ func TestMake(t *testing.T) {
 	}
 }
 
+func TestMakeChecked(t *testing.T) {
+	tests := []struct {
+		op       Opcode
+		operands []int
+		max      int
+	}{
+		{OpGetLocal, []int{65536}, 65535},
+		{OpCall, []int{-1}, 65535},
+		{OpClosure, []int{1, 65536}, 65535},
+	}
+
+	for _, tt := range tests {
+		instruction, err := MakeChecked(tt.op, tt.operands...)
+
+		operandErr, ok := err.(*OperandError)
+		if !ok {
+			t.Errorf("%v %v: expected an OperandError. got instruction=%v, err=%v",
+				tt.op, tt.operands, instruction, err)
+			continue
+		}
+
+		if operandErr.Max != tt.max {
+			t.Errorf("wrong limit. want=%d, got=%d", tt.max, operandErr.Max)
+		}
+
+		if len(Make(tt.op, tt.operands...)) != 0 {
+			t.Errorf("Make should return no instruction when an operand doesn't fit")
+		}
+	}
+}
+
 func TestInstructionsString(t *testing.T) {
 	instructions := []Instructions{
 		Make(OpAdd),
@@ -38,6 +75,8 @@ func TestInstructionsString(t *testing.T) {
 		Make(OpConstant, 2),
 		Make(OpConstant, 65535),
 		Make(OpClosure, 65535, 255),
+		Make(OpConstant, 65536),
+		Make(OpGetLocal, 256),
 	}
 
 	// Very fickle formatting test, do not alter
@@ -46,6 +85,8 @@ func TestInstructionsString(t *testing.T) {
 0003 OpConstant 2
 0006 OpConstant 65535
 0009 OpClosure 65535 255
+0013 OpConstant 65536
+0019 OpGetLocal 256
 `
 
 	concatted := Instructions{}
@@ -90,3 +131,41 @@ func TestReadOperands(t *testing.T) {
 		}
 	}
 }
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		instruction Instructions
+		op          Opcode
+		operands    []int
+		length      int
+	}{
+		{Make(OpAdd), OpAdd, []int{}, 1},
+		{Make(OpClosure, 65535, 255), OpClosure, []int{65535, 255}, 4},
+		{Make(OpClosure, 65536, 255), OpClosure, []int{65536, 255}, 8},
+		{Make(OpCall, 300), OpCall, []int{300}, 4},
+	}
+
+	for _, tt := range tests {
+		instruction, err := Decode(tt.instruction)
+		if err != nil {
+			t.Fatalf("decode error: %s", err)
+		}
+
+		if instruction.Op != tt.op || instruction.Length != tt.length {
+			t.Errorf("wrong instruction. want op=%d length=%d, got op=%d length=%d",
+				tt.op, tt.length, instruction.Op, instruction.Length)
+		}
+
+		for i, want := range tt.operands {
+			if instruction.Operands[i] != want {
+				t.Errorf("operand wrong. want=%d, got=%d", want, instruction.Operands[i])
+			}
+		}
+	}
+
+	for _, bad := range []Instructions{{byte(OpWide)}, {byte(OpWide), byte(OpWide)}, Make(OpConstant, 65536)[:4]} {
+		if _, err := Decode(bad); err == nil {
+			t.Errorf("expected an error decoding %v", bad)
+		}
+	}
+}
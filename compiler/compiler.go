@@ -1,10 +1,12 @@
 package compiler
 
 import (
-	"fmt"
+	"errors"
 	"rafiki/ast"
 	"rafiki/code"
+	"rafiki/diagnostic"
 	"rafiki/object"
+	"rafiki/token"
 	"sort"
 )
 
@@ -13,6 +15,15 @@ type Compiler struct {
 	symbolTable *SymbolTable
 	scopes      []CompilationScope
 	scopeIndex  int
+	builtins    *object.BuiltinRegistry // What the macros of imported files can call
+
+	position  token.Position // Source position of the node being compiled
+	importing []string       // Files whose modules are being compiled, outermost first
+	err       error          // The first instruction whose operands didn't fit
+
+	optimization  int  // O0 or O1
+	firstConstant int  // Constants before this belong to earlier compilations
+	optimized     bool // Bytecode has run the optimizer already
 }
 
 type EmittedInstruction struct {
@@ -27,49 +38,100 @@ func NewCompiler() *Compiler {
 		previousInstruction: EmittedInstruction{},
 	}
 
-	symbolTable := NewSymbolTable()
-
-	for i, v := range object.Builtins {
-		symbolTable.DefineBuiltin(i, v.Name)
-	}
+	builtins := object.DefaultBuiltins()
 
 	return &Compiler{
 		constants:   []object.Object{},
-		symbolTable: symbolTable,
+		symbolTable: NewSymbolTableWithBuiltins(builtins),
 		scopes:      []CompilationScope{mainScope},
 		scopeIndex:  0,
+		builtins:    builtins,
 	}
 }
 
+// A compiler for programs that can call only the functions in builtins
+func NewCompilerWithBuiltins(builtins *object.BuiltinRegistry) *Compiler {
+	compiler := NewCompiler()
+
+	compiler.symbolTable = NewSymbolTableWithBuiltins(builtins)
+	compiler.builtins = builtins
+
+	return compiler
+}
+
 func NewCompilerWithState(st *SymbolTable, constants []object.Object) *Compiler {
 	compiler := NewCompiler()
 
 	compiler.symbolTable = st
 	compiler.constants = constants
+	compiler.firstConstant = len(constants)
 
 	return compiler
 }
 
+// Set how much Bytecode optimizes the program, O0 (the default) or O1
+func (c *Compiler) SetOptimization(level int) {
+	c.optimization = level
+}
+
 func (c *Compiler) Bytecode() *Bytecode {
+	if c.optimization >= O1 && !c.optimized {
+		c.optimize()
+	}
+
 	return &Bytecode{
 		Instructions: c.currentInstructions(),
 		Constants:    c.constants,
+		SourceMap:    c.scopes[c.scopeIndex].sourceMap,
+		GlobalNames:  c.symbolTable.DefinedNames(),
+		Builtins:     c.symbolTable.globals.builtins,
 	}
 }
 
 type Bytecode struct {
 	Instructions code.Instructions
 	Constants    []object.Object
+	SourceMap    code.SourceMap
+	GlobalNames  []string // Indexed by global slot, for debuggers
+	Builtins     []string // The name of each builtin index, or nil to take the VM's registry as it is
 }
 
 type CompilationScope struct {
 	instructions        code.Instructions
 	lastInstruction     EmittedInstruction
 	previousInstruction EmittedInstruction
+	sourceMap           code.SourceMap
+	loops               []*loop // Innermost last
+	tries               int     // How many try bodies we're inside
+	module              bool    // The top level of an imported file
+
+	tailCalls map[*ast.CallExpression]bool // Calls in tail position in the function being compiled
+
+	// Jumps whose targets turned out too far for their operands, by offset,
+	// to be widened once the scope is done
+	farJumps map[int]int
+}
+
+// Jumps out of a loop body waiting for their targets to be known
+type loop struct {
+	breaks    []int
+	continues []int
+	tries     int // The scope's tries when the loop began
 }
 
 // Mimics the eval.Eval structure.
 func (c *Compiler) Compile(node ast.Node) error {
+	// Instructions emitted for this node point back at it, until we return to the parent
+	if node == nil {
+		return nil
+	}
+
+	if pos := node.Pos(); pos.IsValid() {
+		parentPosition := c.position
+		c.position = pos
+		defer func() { c.position = parentPosition }()
+	}
+
 	switch node := node.(type) {
 
 	// Base case, top node of the program or top node of a block
@@ -81,6 +143,8 @@ func (c *Compiler) Compile(node ast.Node) error {
 			}
 		}
 
+		c.widenJumps()
+
 	case *ast.BlockStatement:
 		for _, s := range node.Statements {
 			err := c.Compile(s)
@@ -106,25 +170,29 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return err
 		}
 
-		if symbol.Scope == GlobalScope {
-			c.emit(code.OpSetGlobal, symbol.Index)
-		} else {
-			c.emit(code.OpSetLocal, symbol.Index)
-		}
+		c.storeSymbol(symbol)
 
-	case *ast.InfixExpression:
-		if node.Operator == "<" {
-			err := c.Compile(node.Right)
+	case *ast.AssignExpression:
+		switch target := node.Target.(type) {
+		case *ast.Identifier:
+			err := c.compileIdentifierAssignment(target, node)
 			if err != nil {
 				return err
 			}
 
-			err = c.Compile(node.Left)
+		case *ast.IndexExpression:
+			err := c.compileIndexAssignment(target, node)
 			if err != nil {
 				return err
 			}
-			c.emit(code.OpGreaterThan)
-			return nil
+
+		default:
+			return diagnostic.Errorf(node.Pos(), "cannot assign to %s", node.Target.String())
+		}
+
+	case *ast.InfixExpression:
+		if node.Operator == "&&" || node.Operator == "||" {
+			return c.compileLogicalExpression(node)
 		}
 
 		err := c.Compile(node.Left)
@@ -151,9 +219,21 @@ func (c *Compiler) Compile(node ast.Node) error {
 		case "/":
 			c.emit(code.OpDiv)
 
+		case "%":
+			c.emit(code.OpMod)
+
 		case ">":
 			c.emit(code.OpGreaterThan)
 
+		case ">=":
+			c.emit(code.OpGreaterThanOrEqual)
+
+		case "<":
+			c.emit(code.OpLessThan)
+
+		case "<=":
+			c.emit(code.OpLessThanOrEqual)
+
 		case "==":
 			c.emit(code.OpEqual)
 
@@ -161,7 +241,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpNotEqual)
 
 		default:
-			return fmt.Errorf("unknown operator %s", node.Operator)
+			return diagnostic.Errorf(node.Pos(), "unknown operator %s", node.Operator)
 		}
 
 	case *ast.PrefixExpression:
@@ -179,7 +259,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpMinus)
 
 		default:
-			return fmt.Errorf("unknown operator %s", node.Operator)
+			return diagnostic.Errorf(node.Pos(), "unknown operator %s", node.Operator)
 		}
 
 	case *ast.IndexExpression:
@@ -209,9 +289,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return err
 		}
 
-		if c.lastInstructionIs(code.OpPop) {
-			c.removeLastPop()
-		}
+		c.keepBlockValue()
 
 		// `OpJump` with a bogus value
 		jumpPos := c.emit(code.OpJump, 9999)
@@ -227,19 +305,130 @@ func (c *Compiler) Compile(node ast.Node) error {
 				return err
 			}
 
-			if c.lastInstructionIs(code.OpPop) {
-				c.removeLastPop()
-			}
+			c.keepBlockValue()
 		}
 
 		afterAlternativePos := len(c.currentInstructions())
 		c.changeOperand(jumpPos, afterAlternativePos)
 
+	case *ast.TryExpression:
+		tryPos := c.emit(code.OpTry, 9999)
+
+		c.scopes[c.scopeIndex].tries++
+		err := c.Compile(node.Body)
+		c.scopes[c.scopeIndex].tries--
+		if err != nil {
+			return err
+		}
+
+		c.keepBlockValue()
+		c.emit(code.OpEndTry)
+		jumpPos := c.emit(code.OpJump, 9999)
+
+		// The VM lands here with the error on the stack
+		c.changeOperand(tryPos, len(c.currentInstructions()))
+
+		symbol := c.symbolTable.Define(node.Param.Value)
+		c.storeSymbol(symbol)
+
+		err = c.Compile(node.Handler)
+		if err != nil {
+			return err
+		}
+
+		c.keepBlockValue()
+		c.changeOperand(jumpPos, len(c.currentInstructions()))
+
+	case *ast.ThrowStatement:
+		err := c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+
+		c.emit(code.OpThrow)
+
+	case *ast.WhileStatement:
+		startPos := len(c.currentInstructions())
+
+		err := c.Compile(node.Condition)
+		if err != nil {
+			return err
+		}
+
+		exitPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+		c.enterLoop()
+
+		err = c.Compile(node.Body)
+		if err != nil {
+			return err
+		}
+
+		c.leaveLoop(startPos, startPos, exitPos)
+
+	case *ast.ForStatement:
+		err := c.Compile(node.Init)
+		if err != nil {
+			return err
+		}
+
+		startPos := len(c.currentInstructions())
+
+		// No condition means loop until a break or return
+		exitPos := -1
+		if node.Condition != nil {
+			err := c.Compile(node.Condition)
+			if err != nil {
+				return err
+			}
+
+			exitPos = c.emit(code.OpJumpNotTruthy, 9999)
+		}
+
+		c.enterLoop()
+
+		err = c.Compile(node.Body)
+		if err != nil {
+			return err
+		}
+
+		continuePos := len(c.currentInstructions())
+
+		err = c.Compile(node.Post)
+		if err != nil {
+			return err
+		}
+
+		c.leaveLoop(startPos, continuePos, exitPos)
+
+	case *ast.BreakStatement:
+		l := c.currentLoop()
+		if l == nil {
+			return diagnostic.Errorf(node.Pos(), "break outside of a loop")
+		}
+
+		c.leaveTries(l)
+		l.breaks = append(l.breaks, c.emit(code.OpJump, 9999))
+
+	case *ast.ContinueStatement:
+		l := c.currentLoop()
+		if l == nil {
+			return diagnostic.Errorf(node.Pos(), "continue outside of a loop")
+		}
+
+		c.leaveTries(l)
+		l.continues = append(l.continues, c.emit(code.OpJump, 9999))
+
 	case *ast.IntegerLiteral:
 		integer := &object.Integer{Value: node.Value}
 
 		c.emit(code.OpConstant, c.addConstant(integer))
 
+	case *ast.FloatLiteral:
+		float := &object.Float{Value: node.Value}
+
+		c.emit(code.OpConstant, c.addConstant(float))
+
 	case *ast.StringLiteral:
 		str := &object.String{Value: node.Value}
 
@@ -275,7 +464,14 @@ func (c *Compiler) Compile(node ast.Node) error {
 			}
 		}
 
-		c.emit(code.OpCall, len(node.Arguments))
+		if c.scopes[c.scopeIndex].tailCalls[node] {
+			c.emit(code.OpTailCall, len(node.Arguments))
+		} else {
+			c.emit(code.OpCall, len(node.Arguments))
+		}
+
+	case *ast.ImportExpression:
+		return c.compileImport(node)
 
 	case *ast.HashLiteral:
 		keys := []ast.Expression{}
@@ -307,6 +503,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 
 	case *ast.FunctionLiteral:
 		c.enterScope()
+		c.scopes[c.scopeIndex].tailCalls = ast.TailCalls(node.Body)
 
 		if node.Name != "" {
 			c.symbolTable.DefineFunctionName(node.Name)
@@ -329,24 +526,38 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpReturn)
 		}
 
+		c.widenJumps()
+
 		freeSymbols := c.symbolTable.FreeSymbols
 		numLocals := c.symbolTable.numDefinitions
+		localNames := c.symbolTable.DefinedNames()
+		sourceMap := c.scopes[c.scopeIndex].sourceMap
 		instructions := c.leaveScope()
 
-		for _, s := range freeSymbols {
-			c.loadSymbol(s)
+		freeNames := make([]string, len(freeSymbols))
+		for i, s := range freeSymbols {
+			c.loadCell(s)
+			freeNames[i] = s.Name
 		}
 
 		compiledFn := &object.CompiledFunction{
 			Instructions:  instructions,
 			NumLocals:     numLocals,
 			NumParameters: len(node.Parameters),
+			Name:          node.Name,
+			SourceMap:     sourceMap,
+			LocalNames:    localNames,
+			FreeNames:     freeNames,
 		}
 
 		fnIndex := c.addConstant(compiledFn)
 		c.emit(code.OpClosure, fnIndex, len(freeSymbols))
 
 	case *ast.ReturnStatement:
+		if c.scopes[c.scopeIndex].module {
+			return diagnostic.Errorf(node.Pos(), "cannot return from the top level of a module")
+		}
+
 		err := c.Compile(node.ReturnValue)
 		if err != nil {
 			return err
@@ -357,15 +568,195 @@ func (c *Compiler) Compile(node ast.Node) error {
 	case *ast.Identifier:
 		symbol, ok := c.symbolTable.Resolve(node.Value)
 		if !ok {
-			return fmt.Errorf("undefined variable %s", node.Value)
+			return diagnostic.Errorf(node.Pos(), "undefined variable %s", node.Value)
 		}
 
 		c.loadSymbol(symbol)
 	}
 
+	return c.err
+}
+
+// && and || jump past their right side when the left decides the result,
+// and always leave a boolean behind
+func (c *Compiler) compileLogicalExpression(node *ast.InfixExpression) error {
+	err := c.Compile(node.Left)
+	if err != nil {
+		return err
+	}
+
+	falseJumps := []int{}
+	trueJumps := []int{}
+
+	if node.Operator == "&&" {
+		falseJumps = append(falseJumps, c.emit(code.OpJumpNotTruthy, 9999))
+	} else {
+		rightPos := c.emit(code.OpJumpNotTruthy, 9999)
+		c.emit(code.OpTrue)
+		trueJumps = append(trueJumps, c.emit(code.OpJump, 9999))
+		c.changeOperand(rightPos, len(c.currentInstructions()))
+	}
+
+	err = c.Compile(node.Right)
+	if err != nil {
+		return err
+	}
+
+	falseJumps = append(falseJumps, c.emit(code.OpJumpNotTruthy, 9999))
+	c.emit(code.OpTrue)
+	trueJumps = append(trueJumps, c.emit(code.OpJump, 9999))
+
+	falsePos := len(c.currentInstructions())
+	c.emit(code.OpFalse)
+	afterPos := len(c.currentInstructions())
+
+	for _, pos := range falseJumps {
+		c.changeOperand(pos, falsePos)
+	}
+
+	for _, pos := range trueJumps {
+		c.changeOperand(pos, afterPos)
+	}
+
 	return nil
 }
 
+// Opcodes for the arithmetic a compound assignment applies
+var compoundOpcodes = map[string]code.Opcode{
+	"+=": code.OpAdd,
+	"-=": code.OpSub,
+	"*=": code.OpMul,
+	"/=": code.OpDiv,
+}
+
+// Store the new value, then load it back as the value of the expression
+func (c *Compiler) compileIdentifierAssignment(target *ast.Identifier, node *ast.AssignExpression) error {
+	symbol, ok := c.symbolTable.Resolve(target.Value)
+	if !ok {
+		return diagnostic.Errorf(target.Pos(), "undefined variable %s", target.Value)
+	}
+
+	// The VM refers to a function from inside it by the closure it's
+	// running, not by its binding, so reassigning it there would be lost
+	switch {
+	case symbol.Scope == BuiltinScope:
+		return diagnostic.Errorf(node.Pos(), "cannot assign to builtin %s", target.Value)
+	case c.symbolTable.isFunctionName(target.Value):
+		return diagnostic.Errorf(node.Pos(), "cannot assign to %s inside its own body", target.Value)
+	}
+
+	op, compound := compoundOpcodes[node.Operator]
+	if compound {
+		c.loadSymbol(symbol)
+	}
+
+	err := c.Compile(node.Value)
+	if err != nil {
+		return err
+	}
+
+	if compound {
+		c.emit(op)
+	}
+
+	c.storeSymbol(symbol)
+	c.loadSymbol(symbol)
+
+	return nil
+}
+
+// OpSetIndex leaves the stored value on the stack as the value of the expression
+func (c *Compiler) compileIndexAssignment(target *ast.IndexExpression, node *ast.AssignExpression) error {
+	err := c.Compile(target.Left)
+	if err != nil {
+		return err
+	}
+
+	err = c.Compile(target.Index)
+	if err != nil {
+		return err
+	}
+
+	// Keep the collection and index for OpSetIndex, and read the current element
+	op, compound := compoundOpcodes[node.Operator]
+	if compound {
+		c.emit(code.OpDupTwo)
+		c.emit(code.OpIndex)
+	}
+
+	err = c.Compile(node.Value)
+	if err != nil {
+		return err
+	}
+
+	if compound {
+		c.emit(op)
+	}
+
+	c.emit(code.OpSetIndex)
+
+	return nil
+}
+
+// Leave the value of an if branch on the stack. Blocks ending in an expression
+// already do once their trailing pop is removed; anything else is null.
+func (c *Compiler) keepBlockValue() {
+	if c.lastInstructionIs(code.OpPop) {
+		c.removeLastPop()
+	} else {
+		c.emit(code.OpNull)
+	}
+}
+
+func (c *Compiler) enterLoop() {
+	scope := &c.scopes[c.scopeIndex]
+	scope.loops = append(scope.loops, &loop{tries: scope.tries})
+}
+
+// A break or continue jumping out of try bodies inside the loop removes their handlers first
+func (c *Compiler) leaveTries(l *loop) {
+	for i := l.tries; i < c.scopes[c.scopeIndex].tries; i++ {
+		c.emit(code.OpEndTry)
+	}
+}
+
+// Close the innermost loop: jump back to startPos, then point the condition's
+// exit jump (if any) and every break past the loop, and every continue at
+// continuePos. Past the loop, null is pushed and popped, so the loop's value
+// is null like in the evaluator, rather than the last thing popped in it.
+func (c *Compiler) leaveLoop(startPos int, continuePos int, exitPos int) {
+	scope := &c.scopes[c.scopeIndex]
+	l := scope.loops[len(scope.loops)-1]
+	scope.loops = scope.loops[:len(scope.loops)-1]
+
+	c.emit(code.OpJump, startPos)
+	afterLoopPos := len(c.currentInstructions())
+
+	c.emit(code.OpNull)
+	c.emit(code.OpPop)
+
+	if exitPos >= 0 {
+		c.changeOperand(exitPos, afterLoopPos)
+	}
+
+	for _, pos := range l.breaks {
+		c.changeOperand(pos, afterLoopPos)
+	}
+
+	for _, pos := range l.continues {
+		c.changeOperand(pos, continuePos)
+	}
+}
+
+func (c *Compiler) currentLoop() *loop {
+	loops := c.scopes[c.scopeIndex].loops
+	if len(loops) == 0 {
+		return nil
+	}
+
+	return loops[len(loops)-1]
+}
+
 func (c *Compiler) addConstant(obj object.Object) int {
 	c.constants = append(c.constants, obj)
 
@@ -375,9 +766,15 @@ func (c *Compiler) addConstant(obj object.Object) int {
 // Take in an OpCode and the locations of the operands in c.constants memory
 // Add this instruction to the stack, then return the location of the next instruction
 func (c *Compiler) emit(op code.Opcode, operands ...int) int {
-	ins := code.Make(op, operands...)
+	ins, err := code.MakeChecked(op, operands...)
+	if err != nil {
+		c.operandError(err)
+	}
+
 	pos := c.addInstruction(ins)
 
+	c.addSourceMapping(pos)
+
 	c.setLastInstruction(op, pos)
 
 	return pos
@@ -393,6 +790,22 @@ func (c *Compiler) addInstruction(ins []byte) int {
 	return posNewInstruction
 }
 
+// Record the current node's position for the instruction at offset, unless
+// the previous instruction already came from the same place
+func (c *Compiler) addSourceMapping(offset int) {
+	if !c.position.IsValid() {
+		return
+	}
+
+	sourceMap := c.scopes[c.scopeIndex].sourceMap
+	if len(sourceMap) > 0 && sourceMap[len(sourceMap)-1].Pos == c.position {
+		return
+	}
+
+	entry := code.SourceMapEntry{Offset: offset, Pos: c.position}
+	c.scopes[c.scopeIndex].sourceMap = append(sourceMap, entry)
+}
+
 func (c *Compiler) setLastInstruction(op code.Opcode, pos int) {
 	previous := c.scopes[c.scopeIndex].lastInstruction
 	last := EmittedInstruction{Opcode: op, Position: pos}
@@ -414,6 +827,13 @@ func (c *Compiler) removeLastPop() {
 
 	c.scopes[c.scopeIndex].instructions = new
 	c.scopes[c.scopeIndex].lastInstruction = previous
+
+	// Drop mappings that pointed at the removed instruction
+	sourceMap := c.scopes[c.scopeIndex].sourceMap
+	for len(sourceMap) > 0 && sourceMap[len(sourceMap)-1].Offset >= last.Position {
+		sourceMap = sourceMap[:len(sourceMap)-1]
+	}
+	c.scopes[c.scopeIndex].sourceMap = sourceMap
 }
 
 func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
@@ -426,11 +846,91 @@ func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
 
 func (c *Compiler) changeOperand(opPos int, operand int) {
 	op := code.Opcode(c.currentInstructions()[opPos])
-	newInstruction := code.Make(op, operand)
+
+	newInstruction, err := code.MakeChecked(op, operand)
+	if err != nil {
+		c.operandError(err)
+		return
+	}
+
+	// The wide form doesn't fit in place of the placeholder, so leave that
+	// and remember where the jump really goes
+	if code.Opcode(newInstruction[0]) == code.OpWide {
+		if c.scopes[c.scopeIndex].farJumps == nil {
+			c.scopes[c.scopeIndex].farJumps = map[int]int{}
+		}
+		c.scopes[c.scopeIndex].farJumps[opPos] = operand
+		return
+	}
 
 	c.replaceInstruction(opPos, newInstruction)
 }
 
+// Lay out the current scope again if any of its jumps go too far for their
+// operands, writing those with an OpWide. It moves everything after them, so
+// it's left until the scope is done and every other jump has its target.
+func (c *Compiler) widenJumps() {
+	scope := &c.scopes[c.scopeIndex]
+	if len(scope.farJumps) == 0 {
+		return
+	}
+
+	list, ok := decode(scope.instructions, scope.sourceMap, scope.farJumps)
+	if !ok {
+		c.operandError(errors.New("could not lay out jumps"))
+		return
+	}
+
+	instructions, sourceMap, err := encode(list)
+	if err != nil {
+		c.operandError(err)
+		return
+	}
+
+	scope.instructions, scope.sourceMap, scope.farJumps = instructions, sourceMap, nil
+}
+
+// What each operand of an instruction counts, to say what there was too much of
+var operandNames = map[code.Opcode][]string{
+	code.OpConstant:      {"constants"},
+	code.OpJump:          {"instructions in one function"},
+	code.OpJumpNotTruthy: {"instructions in one function"},
+	code.OpTry:           {"instructions in one function"},
+	code.OpGetGlobal:     {"global variables"},
+	code.OpSetGlobal:     {"global variables"},
+	code.OpArray:         {"elements in an array literal"},
+	code.OpHash:          {"keys and values in a hash literal"},
+	code.OpCall:          {"arguments in a call"},
+	code.OpTailCall:      {"arguments in a call"},
+	code.OpGetLocal:      {"local variables in one function"},
+	code.OpSetLocal:      {"local variables in one function"},
+	code.OpGetLocalCell:  {"local variables in one function"},
+	code.OpGetBuiltin:    {"builtins"},
+	code.OpClosure:       {"constants", "free variables in one function"},
+	code.OpGetFree:       {"free variables in one function"},
+	code.OpSetFree:       {"free variables in one function"},
+	code.OpGetFreeCell:   {"free variables in one function"},
+	code.OpImport:        {"global variables", "constants"},
+	code.OpModule:        {"constants", "exports in one module"},
+}
+
+// Keep the first error about an operand too large to encode, for Compile
+// to return. The instruction is left out, since the bytecode is no good.
+func (c *Compiler) operandError(err error) {
+	if c.err != nil {
+		return
+	}
+
+	operandErr, ok := err.(*code.OperandError)
+	if !ok {
+		c.err = diagnostic.Errorf(c.position, "%s", err)
+		return
+	}
+
+	c.err = diagnostic.Errorf(c.position, "too many %s: at most %d are supported",
+		operandNames[operandErr.Op][operandErr.Operand], operandErr.Max)
+}
+
 func (c *Compiler) currentInstructions() code.Instructions {
 	return c.scopes[c.scopeIndex].instructions
 }
@@ -473,6 +973,36 @@ func (c *Compiler) replaceLastPopWithReturn() {
 	c.scopes[c.scopeIndex].lastInstruction.Opcode = code.OpReturnValue
 }
 
+func (c *Compiler) storeSymbol(s Symbol) {
+	switch s.Scope {
+
+	case GlobalScope:
+		c.emit(code.OpSetGlobal, s.Index)
+
+	case LocalScope:
+		c.emit(code.OpSetLocal, s.Index)
+
+	case FreeScope:
+		c.emit(code.OpSetFree, s.Index)
+	}
+}
+
+// Push what a closure captures for s: the cell holding a local or free
+// variable, so the closure and its creator share later assignments
+func (c *Compiler) loadCell(s Symbol) {
+	switch s.Scope {
+
+	case LocalScope:
+		c.emit(code.OpGetLocalCell, s.Index)
+
+	case FreeScope:
+		c.emit(code.OpGetFreeCell, s.Index)
+
+	default:
+		c.loadSymbol(s)
+	}
+}
+
 func (c *Compiler) loadSymbol(s Symbol) {
 	switch s.Scope {
 
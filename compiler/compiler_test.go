@@ -7,6 +7,7 @@ import (
 	"rafiki/lexer"
 	"rafiki/object"
 	"rafiki/parser"
+	"strings"
 	"testing"
 )
 
@@ -68,6 +69,16 @@ func TestIntegerArithmetic(t *testing.T) {
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			input:             "7 % 3",
+			expectedConstants: []interface{}{7, 3},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpMod),
+				code.Make(code.OpPop),
+			},
+		},
 		{
 			input:             "-1",
 			expectedConstants: []interface{}{1},
@@ -84,11 +95,18 @@ func TestIntegerArithmetic(t *testing.T) {
 
 func runCompilerTests(t *testing.T, tests []compilerTestCase) {
 	t.Helper()
+	runCompilerTestsAt(t, O0, tests)
+}
+
+// Like runCompilerTests, with the compiler optimizing at level
+func runCompilerTestsAt(t *testing.T, level int, tests []compilerTestCase) {
+	t.Helper()
 
 	for _, tt := range tests {
-		program := parse(tt.input)
+		program := parse(t, tt.input)
 
 		compiler := NewCompiler()
+		compiler.SetOptimization(level)
 		err := compiler.Compile(program)
 		if err != nil {
 			t.Fatalf("compiler error: %s", err)
@@ -108,10 +126,18 @@ func runCompilerTests(t *testing.T, tests []compilerTestCase) {
 	}
 }
 
-func parse(input string) *ast.Program {
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+
 	l := lexer.NewLexer(input)
 	p := parser.NewParser(l)
-	return p.ParseProgram()
+	program := p.ParseProgram()
+
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+
+	return program
 }
 
 func testInstructions(
@@ -164,6 +190,13 @@ func testConstants(
 					i, err)
 			}
 
+		case float64:
+			err := testFloatObject(constant, actual[i])
+			if err != nil {
+				return fmt.Errorf("constant %d - testFloatObject failed: %s",
+					i, err)
+			}
+
 		case string:
 			err := testStringObject(constant, actual[i])
 			if err != nil {
@@ -205,6 +238,47 @@ func testIntegerObject(expected int64, actual object.Object) error {
 	return nil
 }
 
+func TestFloatArithmetic(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "1.5 + 2",
+			expectedConstants: []interface{}{1.5, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "-0.5",
+			expectedConstants: []interface{}{0.5},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpMinus),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func testFloatObject(expected float64, actual object.Object) error {
+	result, ok := actual.(*object.Float)
+	if !ok {
+		return fmt.Errorf("object is not Float. got=%T (%+v)",
+			actual, actual)
+	}
+
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. got=%g, want=%g",
+			result.Value, expected)
+	}
+
+	return nil
+}
+
 func TestBooleanExpressions(t *testing.T) {
 	tests := []compilerTestCase{
 		{
@@ -235,11 +309,31 @@ func TestBooleanExpressions(t *testing.T) {
 		},
 		{
 			input:             "1 < 2",
-			expectedConstants: []interface{}{2, 1},
+			expectedConstants: []interface{}{1, 2},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
-				code.Make(code.OpGreaterThan),
+				code.Make(code.OpLessThan),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 <= 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpLessThanOrEqual),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 >= 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpGreaterThanOrEqual),
 				code.Make(code.OpPop),
 			},
 		},
@@ -297,6 +391,61 @@ func TestBooleanExpressions(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+func TestLogicalExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "true && false",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 12),
+				// 0004
+				code.Make(code.OpFalse),
+				// 0005
+				code.Make(code.OpJumpNotTruthy, 12),
+				// 0008
+				code.Make(code.OpTrue),
+				// 0009
+				code.Make(code.OpJump, 13),
+				// 0012
+				code.Make(code.OpFalse),
+				// 0013
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "true || false",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 8),
+				// 0004
+				code.Make(code.OpTrue),
+				// 0005
+				code.Make(code.OpJump, 17),
+				// 0008
+				code.Make(code.OpFalse),
+				// 0009
+				code.Make(code.OpJumpNotTruthy, 16),
+				// 0012
+				code.Make(code.OpTrue),
+				// 0013
+				code.Make(code.OpJump, 17),
+				// 0016
+				code.Make(code.OpFalse),
+				// 0017
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
 func TestConditionals(t *testing.T) {
 	tests := []compilerTestCase{
 		{
@@ -352,6 +501,182 @@ func TestConditionals(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+func TestTryCatch(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `try { 1 } catch (e) { e }`,
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTry, 10),
+				// 0003
+				code.Make(code.OpConstant, 0),
+				// 0006
+				code.Make(code.OpEndTry),
+				// 0007
+				code.Make(code.OpJump, 16),
+				// 0010
+				code.Make(code.OpSetGlobal, 0),
+				// 0013
+				code.Make(code.OpGetGlobal, 0),
+				// 0016
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `throw "oops"`,
+			expectedConstants: []interface{}{"oops"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpThrow),
+			},
+		},
+		{
+			input:             `while (true) { try { break; } catch (e) {} }`,
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 24),
+				// 0004
+				code.Make(code.OpTry, 16),
+				// 0007, the break leaves the try first
+				code.Make(code.OpEndTry),
+				// 0008
+				code.Make(code.OpJump, 24),
+				// 0011
+				code.Make(code.OpNull),
+				// 0012
+				code.Make(code.OpEndTry),
+				// 0013
+				code.Make(code.OpJump, 20),
+				// 0016
+				code.Make(code.OpSetGlobal, 0),
+				// 0019
+				code.Make(code.OpNull),
+				// 0020
+				code.Make(code.OpPop),
+				// 0021
+				code.Make(code.OpJump, 0),
+				// 0024
+				code.Make(code.OpNull),
+				// 0025
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestLoops(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `
+            while (true) { 10; }; 3333;
+            `,
+			expectedConstants: []interface{}{10, 3333},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 11),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpPop),
+				// 0008
+				code.Make(code.OpJump, 0),
+				// 0011
+				code.Make(code.OpNull),
+				// 0012
+				code.Make(code.OpPop),
+				// 0013
+				code.Make(code.OpConstant, 1),
+				// 0016
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `
+            while (true) { break; continue; }
+            `,
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 13),
+				// 0004
+				code.Make(code.OpJump, 13),
+				// 0007
+				code.Make(code.OpJump, 0),
+				// 0010
+				code.Make(code.OpJump, 0),
+				// 0013
+				code.Make(code.OpNull),
+				// 0014
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `
+            for (let i = 0; i < 10; let i = i + 1) { continue; }
+            `,
+			expectedConstants: []interface{}{0, 10, 1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpSetGlobal, 0),
+				// 0006
+				code.Make(code.OpGetGlobal, 0),
+				// 0009
+				code.Make(code.OpConstant, 1),
+				// 0012
+				code.Make(code.OpLessThan),
+				// 0013
+				code.Make(code.OpJumpNotTruthy, 32),
+				// 0016
+				code.Make(code.OpJump, 19),
+				// 0019
+				code.Make(code.OpGetGlobal, 0),
+				// 0022
+				code.Make(code.OpConstant, 2),
+				// 0025
+				code.Make(code.OpAdd),
+				// 0026
+				code.Make(code.OpSetGlobal, 0),
+				// 0029
+				code.Make(code.OpJump, 6),
+				// 0032
+				code.Make(code.OpNull),
+				// 0033
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `
+            for (;;) { break; }
+            `,
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpJump, 6),
+				// 0003
+				code.Make(code.OpJump, 0),
+				// 0006
+				code.Make(code.OpNull),
+				// 0007
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
 func TestGlobalLetStatements(t *testing.T) {
 	tests := []compilerTestCase{
 		{
@@ -401,6 +726,129 @@ func TestGlobalLetStatements(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+func TestAssignment(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `
+            let x = 1;
+            x = 2;
+            `,
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `
+            fn(x) { x += 1 }
+            `,
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `
+            fn(x) { fn() { x = 2 } }
+            `,
+			expectedConstants: []interface{}{
+				2,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetFree, 0),
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpGetLocalCell, 0),
+					code.Make(code.OpClosure, 1, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `
+            let xs = [];
+            xs[0] = 1;
+            `,
+			expectedConstants: []interface{}{0, 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetIndex),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `
+            let xs = [];
+            xs[0] *= 2;
+            `,
+			expectedConstants: []interface{}{0, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpDupTwo),
+				code.Make(code.OpIndex),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpMul),
+				code.Make(code.OpSetIndex),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestAssignmentErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"x = 1", "1:1: undefined variable x"},
+		{"len = 1", "1:5: cannot assign to builtin len"},
+		{"let f = fn() { f = 1 };", "1:18: cannot assign to f inside its own body"},
+	}
+
+	for _, tt := range tests {
+		comp := NewCompiler()
+		err := comp.Compile(parse(t, tt.input))
+		if err == nil {
+			t.Fatalf("expected compiler error for %q", tt.input)
+		}
+
+		if err.Error() != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%q", tt.expected, err.Error())
+		}
+	}
+}
+
 func TestStringExpressions(t *testing.T) {
 	tests := []compilerTestCase{
 		{
@@ -897,7 +1345,7 @@ func TestBuiltins(t *testing.T) {
 				[]code.Instructions{
 					code.Make(code.OpGetBuiltin, 0),
 					code.Make(code.OpArray, 0),
-					code.Make(code.OpCall, 1),
+					code.Make(code.OpTailCall, 1),
 					code.Make(code.OpReturnValue),
 				},
 			},
@@ -929,7 +1377,7 @@ func TestClosures(t *testing.T) {
 					code.Make(code.OpReturnValue),
 				},
 				[]code.Instructions{
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetLocalCell, 0),
 					code.Make(code.OpClosure, 0, 1),
 					code.Make(code.OpReturnValue),
 				},
@@ -959,13 +1407,13 @@ func TestClosures(t *testing.T) {
 					code.Make(code.OpReturnValue),
 				},
 				[]code.Instructions{
-					code.Make(code.OpGetFree, 0),
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetFreeCell, 0),
+					code.Make(code.OpGetLocalCell, 0),
 					code.Make(code.OpClosure, 0, 2),
 					code.Make(code.OpReturnValue),
 				},
 				[]code.Instructions{
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetLocalCell, 0),
 					code.Make(code.OpClosure, 1, 1),
 					code.Make(code.OpReturnValue),
 				},
@@ -1013,15 +1461,15 @@ func TestClosures(t *testing.T) {
 				[]code.Instructions{
 					code.Make(code.OpConstant, 2),
 					code.Make(code.OpSetLocal, 0),
-					code.Make(code.OpGetFree, 0),
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetFreeCell, 0),
+					code.Make(code.OpGetLocalCell, 0),
 					code.Make(code.OpClosure, 4, 2),
 					code.Make(code.OpReturnValue),
 				},
 				[]code.Instructions{
 					code.Make(code.OpConstant, 1),
 					code.Make(code.OpSetLocal, 0),
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetLocalCell, 0),
 					code.Make(code.OpClosure, 5, 1),
 					code.Make(code.OpReturnValue),
 				},
@@ -1052,7 +1500,7 @@ func TestRecursiveFunctions(t *testing.T) {
 					code.Make(code.OpGetLocal, 0),
 					code.Make(code.OpConstant, 0),
 					code.Make(code.OpSub),
-					code.Make(code.OpCall, 1),
+					code.Make(code.OpTailCall, 1),
 					code.Make(code.OpReturnValue),
 				},
 				1,
@@ -1081,7 +1529,7 @@ func TestRecursiveFunctions(t *testing.T) {
 					code.Make(code.OpGetLocal, 0),
 					code.Make(code.OpConstant, 0),
 					code.Make(code.OpSub),
-					code.Make(code.OpCall, 1),
+					code.Make(code.OpTailCall, 1),
 					code.Make(code.OpReturnValue),
 				},
 				1,
@@ -1090,7 +1538,7 @@ func TestRecursiveFunctions(t *testing.T) {
 					code.Make(code.OpSetLocal, 0),
 					code.Make(code.OpGetLocal, 0),
 					code.Make(code.OpConstant, 2),
-					code.Make(code.OpCall, 1),
+					code.Make(code.OpTailCall, 1),
 					code.Make(code.OpReturnValue),
 				},
 			},
@@ -1106,3 +1554,128 @@ func TestRecursiveFunctions(t *testing.T) {
 
 	runCompilerTests(t, tests)
 }
+
+func TestTailCalls(t *testing.T) {
+	tests := []struct {
+		input     string
+		tailCalls int
+		calls     int
+	}{
+		{`fn(n) { f(n) }`, 1, 0},
+		{`fn(n) { return f(n); }`, 1, 0},
+		{`fn(n) { if (n) { f(n) } else { g(n) } }`, 2, 0},
+		{`fn(n) { if (n) { return f(n); } 1 }`, 1, 0},
+		{`fn(n) { while (n) { return f(n); } }`, 1, 0},
+		// The caller still needs the result
+		{`fn(n) { 1 + f(n) }`, 0, 1},
+		{`fn(n) { f(g(n)) }`, 1, 1},
+		{`fn(n) { f(n); 1 }`, 0, 1},
+		{`fn(n) { let x = f(n); }`, 0, 1},
+		// The handler has to stay in place while the call runs
+		{`fn(n) { try { return f(n); } catch (e) { 0 } }`, 0, 1},
+		{`fn(n) { try { f(n) } catch (e) { 0 } }`, 0, 1},
+	}
+
+	for _, tt := range tests {
+		compiler := NewCompiler()
+		if err := compiler.Compile(parse(t, "let f = fn(n) { n }; let g = f; "+tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		constants := compiler.Bytecode().Constants
+		fn := constants[len(constants)-1].(*object.CompiledFunction)
+
+		tailCalls, calls := 0, 0
+		for i := 0; i < len(fn.Instructions); {
+			def, err := code.Lookup(fn.Instructions[i])
+			if err != nil {
+				t.Fatalf("bad instruction: %s", err)
+			}
+
+			switch code.Opcode(fn.Instructions[i]) {
+			case code.OpTailCall:
+				tailCalls++
+			case code.OpCall:
+				calls++
+			}
+
+			_, read := code.ReadOperands(def, fn.Instructions[i+1:])
+			i += 1 + read
+		}
+
+		if tailCalls != tt.tailCalls || calls != tt.calls {
+			t.Errorf("%s: wrong calls. want %d tail calls and %d calls, got %d and %d",
+				tt.input, tt.tailCalls, tt.calls, tailCalls, calls)
+		}
+	}
+}
+
+func TestOperandLimits(t *testing.T) {
+	args := strings.Repeat("1, ", 65535) + "1"
+
+	// 65,537 locals, named in letters since identifiers can't hold digits
+	var locals strings.Builder
+	for i := 0; i <= 65536; i++ {
+		name := ""
+		for j := i; ; j /= 26 {
+			name = string(rune('a'+j%26)) + name
+			if j < 26 {
+				break
+			}
+		}
+		fmt.Fprintf(&locals, "let v%s = 1; ", name)
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let f = fn() { 1 }; f(" + args + ")", "1:22: too many arguments in a call: at most 65535 are supported"},
+		{"fn() { len(" + args + ") }", "1:11: too many arguments in a call: at most 65535 are supported"},
+		{"fn() { " + locals.String() + "}", "too many local variables in one function: at most 65535 are supported"},
+	}
+
+	for _, tt := range tests {
+		compiler := NewCompiler()
+		err := compiler.Compile(parse(t, tt.input))
+
+		if err == nil || !strings.HasSuffix(err.Error(), tt.expected) {
+			t.Errorf("wrong error. want=%q, got=%v", tt.expected, err)
+		}
+	}
+}
+
+func TestFarJumps(t *testing.T) {
+	input := "let x = true; if (x) {\n" + strings.Repeat("1;\n", 20000) + "};\n3333;"
+
+	for _, level := range []int{O0, O1} {
+		compiler := NewCompiler()
+		compiler.SetOptimization(level)
+		if err := compiler.Compile(parse(t, input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		bytecode := compiler.Bytecode()
+		ins := bytecode.Instructions
+
+		// The if's jump to its missing else lands past 16 bit offsets, so it's
+		// widened, along with the jump over that else
+		jump, err := code.Decode(ins[7:])
+		if err != nil || jump.Op != code.OpJumpNotTruthy || jump.Length != 6 {
+			t.Fatalf("O%d: want a wide OpJumpNotTruthy. got=%+v (%v)", level, jump, err)
+		}
+
+		target := jump.Operands[0]
+		if alternative, err := code.Decode(ins[target:]); err != nil || alternative.Op != code.OpNull {
+			t.Errorf("O%d: jump lands on %+v, not the OpNull of the else", level, alternative)
+		}
+
+		if over, err := code.Decode(ins[target-6:]); err != nil || over.Op != code.OpJump || over.Operands[0] != target+1 {
+			t.Errorf("O%d: wrong jump over the else. got=%+v", level, over)
+		}
+
+		// The instructions after the widened jumps keep their positions
+		if pos := bytecode.SourceMap.Lookup(len(ins) - 4); pos.Line != 20003 {
+			t.Errorf("O%d: 3333 is on the wrong line. want=20003, got=%d", level, pos.Line)
+		}
+	}
+}
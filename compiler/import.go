@@ -0,0 +1,97 @@
+package compiler
+
+import (
+	"errors"
+	"rafiki/ast"
+	"rafiki/code"
+	"rafiki/diagnostic"
+	"rafiki/eval"
+	"rafiki/loader"
+	"rafiki/object"
+)
+
+// Each file is compiled once, into a function that runs it and returns its
+// module. Every import of the file pushes that module, running the function
+// first if no import has yet.
+func (c *Compiler) compileImport(node *ast.ImportExpression) error {
+	path, err := loader.Resolve(node.Pos(), node.Path.Value)
+	if err != nil {
+		return diagnostic.Errorf(node.Pos(), "cannot import %q: %s", node.Path.Value, err)
+	}
+
+	module, ok := c.symbolTable.globals.modules[path]
+	if !ok {
+		module, err = c.compileModule(node, path)
+		if err != nil {
+			return err
+		}
+
+		c.symbolTable.globals.modules[path] = module
+	}
+
+	c.emit(code.OpImport, module.slot, module.constant)
+
+	return nil
+}
+
+func (c *Compiler) compileModule(node *ast.ImportExpression, path string) (compiledModule, error) {
+	running, err := loader.Enter(c.importing, node.Pos(), path)
+	if err != nil {
+		return compiledModule{}, diagnostic.Errorf(node.Pos(), "%s", err)
+	}
+
+	importing := c.importing
+	c.importing = running
+	defer func() { c.importing = importing }()
+
+	file, err := loader.Load(path)
+	if err != nil {
+		var diagErr *diagnostic.Error
+		if errors.As(err, &diagErr) {
+			return compiledModule{}, err
+		}
+		return compiledModule{}, diagnostic.Errorf(node.Pos(), "cannot import %q: %s", node.Path.Value, err)
+	}
+
+	macroEnv := object.NewEnvironmentWithBuiltins(c.builtins)
+	eval.DefineMacros(file.Program, macroEnv)
+	program := eval.ExpandMacros(file.Program, macroEnv)
+
+	table := NewModuleSymbolTable(c.symbolTable)
+
+	outer := c.symbolTable
+	c.symbolTable = table
+	c.scopes = append(c.scopes, CompilationScope{instructions: code.Instructions{}, module: true})
+	c.scopeIndex++
+
+	defer func() {
+		c.symbolTable = outer
+		c.scopes = c.scopes[:len(c.scopes)-1]
+		c.scopeIndex--
+	}()
+
+	if err := c.Compile(program); err != nil {
+		return compiledModule{}, err
+	}
+
+	exports := table.DefinedSymbols()
+	for _, s := range exports {
+		c.emit(code.OpConstant, c.addConstant(&object.String{Value: s.Name}))
+		c.emit(code.OpGetGlobal, s.Index)
+	}
+
+	module := compiledModule{slot: table.globals.define("")}
+
+	c.emit(code.OpModule, c.addConstant(&object.String{Value: file.Name()}), len(exports))
+	c.emit(code.OpSetGlobal, module.slot)
+	c.emit(code.OpGetGlobal, module.slot)
+	c.emit(code.OpReturnValue)
+
+	module.constant = c.addConstant(&object.CompiledFunction{
+		Instructions: c.currentInstructions(),
+		Name:         "<module " + file.Name() + ">",
+		SourceMap:    c.scopes[c.scopeIndex].sourceMap,
+	})
+
+	return module, nil
+}
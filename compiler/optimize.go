@@ -0,0 +1,767 @@
+package compiler
+
+import (
+	"math"
+	"rafiki/code"
+	"rafiki/object"
+	"rafiki/token"
+)
+
+// How much Bytecode optimizes the program
+const (
+	O0 = iota // Not at all, the instructions are what Compile emitted
+	O1        // Run the peephole passes over every function
+)
+
+/*
+The optimizer works on one function at a time, decoded into a list of
+instructions whose jumps point at the instruction they land on, so passes can
+drop and rewrite instructions without tracking offsets. The passes run until
+none of them finds anything more to do:
+
+  - fold: arithmetic, comparisons and negation of constants, like 1 + 2
+  - branches: conditional jumps on a constant, like the one in while (true)
+  - thread: jumps to jumps, which go straight to the final target instead,
+    and jumps to the next instruction
+  - prune: instructions no path from the start of the function reaches, like
+    anything after a return
+
+Then the constants the program added are deduplicated, and dropped when no
+instruction uses them any more. Only numbers are merged: strings are compared
+by identity, so two equal string literals have to stay two objects, and for
+the same reason string concatenation isn't folded.
+
+Every instruction keeps the source position it was compiled from, so errors
+and stack traces point at the same places as in unoptimized code.
+*/
+type instruction struct {
+	op       code.Opcode
+	operands []int
+	target   *instruction // Where a jump lands, or nil for the end of the function
+	pos      token.Position
+}
+
+// The instructions whose first operand is an offset in the same function
+var jumps = map[code.Opcode]bool{
+	code.OpJump:          true,
+	code.OpJumpNotTruthy: true,
+	code.OpTry:           true,
+}
+
+// Which operand of an instruction is a constant index
+var constantOperands = map[code.Opcode]int{
+	code.OpConstant: 0,
+	code.OpClosure:  0,
+	code.OpImport:   1,
+	code.OpModule:   0,
+}
+
+// A function's code and where it goes back to once optimized
+type unit struct {
+	list []*instruction
+	fn   *object.CompiledFunction // nil for the main program
+}
+
+// Optimize everything compiled since the compiler was created. Constants from
+// earlier compilations sharing the pool, like previous REPL lines, keep their
+// indexes, since code that's already running refers to them. Anything that
+// can't be decoded leaves the program as it was.
+func (c *Compiler) optimize() {
+	c.optimized = true
+
+	o := &optimizer{constants: c.constants, first: c.firstConstant}
+
+	scope := &c.scopes[c.scopeIndex]
+	main, ok := decode(scope.instructions, scope.sourceMap, nil)
+	if !ok {
+		return
+	}
+
+	units := []*unit{{list: main}}
+	functions := map[int]*unit{}
+
+	for i := o.first; i < len(c.constants); i++ {
+		fn, isFunction := c.constants[i].(*object.CompiledFunction)
+		if !isFunction {
+			continue
+		}
+
+		list, ok := decode(fn.Instructions, fn.SourceMap, nil)
+		if !ok {
+			return
+		}
+
+		functions[i] = &unit{list: list, fn: fn}
+		units = append(units, functions[i])
+	}
+
+	for _, u := range units {
+		u.list = o.passes(u.list)
+	}
+
+	var modules []int
+	for _, module := range c.symbolTable.globals.modules {
+		modules = append(modules, module.constant)
+	}
+
+	remap := o.compact(units[0], functions, modules)
+
+	encoded := make([]code.Instructions, len(units))
+	sourceMaps := make([]code.SourceMap, len(units))
+	for i, u := range units {
+		var err error
+		if encoded[i], sourceMaps[i], err = encode(u.list); err != nil {
+			return
+		}
+	}
+
+	scope.instructions, scope.sourceMap = encoded[0], sourceMaps[0]
+	for i, u := range units[1:] {
+		u.fn.Instructions, u.fn.SourceMap = encoded[i+1], sourceMaps[i+1]
+	}
+
+	for path, module := range c.symbolTable.globals.modules {
+		module.constant = remap(module.constant)
+		c.symbolTable.globals.modules[path] = module
+	}
+
+	c.constants = o.constants
+}
+
+type optimizer struct {
+	constants []object.Object
+	first     int // Constants before this index belong to earlier compilations
+}
+
+func (o *optimizer) passes(list []*instruction) []*instruction {
+	for changed := true; changed; {
+		changed = false
+
+		for _, pass := range []func([]*instruction) ([]*instruction, bool){o.fold, branches, thread, prune} {
+			var passChanged bool
+			list, passChanged = pass(list)
+			changed = changed || passChanged
+		}
+	}
+
+	return list
+}
+
+// Read a function's instructions into a list, or fail if they don't decode
+// or a jump lands between instructions. far holds the real targets of jumps
+// whose operands couldn't hold them, by the jump's offset.
+func decode(ins code.Instructions, sourceMap code.SourceMap, far map[int]int) ([]*instruction, bool) {
+	var list []*instruction
+	byOffset := map[int]*instruction{}
+
+	for offset := 0; offset < len(ins); {
+		decoded, err := code.Decode(ins[offset:])
+		if err != nil {
+			return nil, false
+		}
+
+		if target, ok := far[offset]; ok {
+			decoded.Operands[0] = target
+		}
+
+		i := &instruction{op: decoded.Op, operands: decoded.Operands, pos: sourceMap.Lookup(offset)}
+		list = append(list, i)
+		byOffset[offset] = i
+
+		offset += decoded.Length
+	}
+
+	for _, i := range list {
+		if !jumps[i.op] || i.operands[0] == len(ins) {
+			continue
+		}
+
+		target, ok := byOffset[i.operands[0]]
+		if !ok {
+			return nil, false
+		}
+		i.target = target
+	}
+
+	return list, true
+}
+
+// Turn the list back into instructions, with a source map entry wherever the
+// position changes. A jump only needs an OpWide when it lands past the reach
+// of its usual operand, which depends on how wide the jumps before its target
+// are, so the list is laid out until the offsets settle. Jumps only ever get
+// wider from one layout to the next, so they do.
+func encode(list []*instruction) (code.Instructions, code.SourceMap, error) {
+	offsets := make(map[*instruction]int, len(list))
+	length := 0
+
+	for settled := false; !settled; {
+		next := make(map[*instruction]int, len(list))
+		nextLength := 0
+
+		for _, i := range list {
+			if jumps[i.op] {
+				i.operands[0] = length
+				if i.target != nil {
+					i.operands[0] = offsets[i.target]
+				}
+			}
+
+			ins, err := code.MakeChecked(i.op, i.operands...)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			next[i] = nextLength
+			nextLength += len(ins)
+		}
+
+		settled = nextLength == length
+		offsets, length = next, nextLength
+	}
+
+	ins := make(code.Instructions, 0, length)
+	var sourceMap code.SourceMap
+
+	for _, i := range list {
+		encoded, err := code.MakeChecked(i.op, i.operands...)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if i.pos.IsValid() && (len(sourceMap) == 0 || sourceMap[len(sourceMap)-1].Pos != i.pos) {
+			sourceMap = append(sourceMap, code.SourceMapEntry{Offset: len(ins), Pos: i.pos})
+		}
+
+		ins = append(ins, encoded...)
+	}
+
+	return ins, sourceMap, nil
+}
+
+// The instructions some jump lands on
+func jumpTargets(list []*instruction) map[*instruction]bool {
+	targets := map[*instruction]bool{}
+
+	for _, i := range list {
+		if i.target != nil {
+			targets[i.target] = true
+		}
+	}
+
+	return targets
+}
+
+// Drop the instructions in dead, sending jumps that landed on them on to the
+// instruction after
+func remove(list []*instruction, dead map[*instruction]bool) []*instruction {
+	next := map[*instruction]*instruction{}
+
+	var following *instruction
+	for i := len(list) - 1; i >= 0; i-- {
+		if dead[list[i]] {
+			next[list[i]] = following
+		} else {
+			following = list[i]
+		}
+	}
+
+	kept := make([]*instruction, 0, len(list)-len(dead))
+	for _, i := range list {
+		if dead[i] {
+			continue
+		}
+
+		if dead[i.target] {
+			i.target = next[i.target]
+		}
+		kept = append(kept, i)
+	}
+
+	return kept
+}
+
+// The value an instruction pushes, if it's a constant we can reason about
+func (o *optimizer) value(i *instruction) (object.Object, bool) {
+	switch i.op {
+	case code.OpConstant:
+		return o.constants[i.operands[0]], true
+	case code.OpTrue:
+		return &object.Boolean{Value: true}, true
+	case code.OpFalse:
+		return &object.Boolean{Value: false}, true
+	case code.OpNull:
+		return &object.Null{}, true
+	default:
+		return nil, false
+	}
+}
+
+// Make i push obj instead of what it pushed
+func (o *optimizer) push(i *instruction, obj object.Object) {
+	switch obj := obj.(type) {
+	case *object.Boolean:
+		i.op, i.operands = code.OpFalse, nil
+		if obj.Value {
+			i.op = code.OpTrue
+		}
+	default:
+		i.op, i.operands = code.OpConstant, []int{len(o.constants)}
+		o.constants = append(o.constants, obj)
+	}
+}
+
+// Replace constant operands and the operator using them with the result,
+// where the VM would compute it the same way every time without failing
+func (o *optimizer) fold(list []*instruction) ([]*instruction, bool) {
+	targets := jumpTargets(list)
+	dead := map[*instruction]bool{}
+
+	// Constants folded into the one before are gone, so each window starts
+	// at the last instruction still standing
+	var live []*instruction
+	for _, i := range list {
+		live = append(live, i)
+
+		n := len(live)
+		if targets[i] || n < 2 {
+			continue
+		}
+
+		if isBinary(i.op) {
+			if n < 3 || targets[live[n-2]] {
+				continue
+			}
+
+			left, leftOk := o.value(live[n-3])
+			right, rightOk := o.value(live[n-2])
+			if !leftOk || !rightOk {
+				continue
+			}
+
+			result, ok := foldBinary(i.op, left, right)
+			if !ok {
+				continue
+			}
+
+			o.push(live[n-3], result)
+			dead[live[n-2]], dead[i] = true, true
+			live = live[:n-2]
+			continue
+		}
+
+		operand, ok := o.value(live[n-2])
+		if !ok {
+			continue
+		}
+
+		result, ok := foldUnary(i.op, operand)
+		if !ok {
+			continue
+		}
+
+		o.push(live[n-2], result)
+		dead[i] = true
+		live = live[:n-1]
+	}
+
+	if len(dead) == 0 {
+		return list, false
+	}
+
+	return remove(list, dead), true
+}
+
+func isBinary(op code.Opcode) bool {
+	switch op {
+	case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod,
+		code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpGreaterThanOrEqual,
+		code.OpLessThan, code.OpLessThanOrEqual:
+		return true
+	default:
+		return false
+	}
+}
+
+// The result of op on two constants, as the VM computes it
+func foldBinary(op code.Opcode, left, right object.Object) (object.Object, bool) {
+	leftInt, leftIsInt := left.(*object.Integer)
+	rightInt, rightIsInt := right.(*object.Integer)
+
+	switch {
+	case leftIsInt && rightIsInt:
+		return foldIntegers(op, leftInt.Value, rightInt.Value)
+
+	case isNumber(left) && isNumber(right):
+		return foldFloats(op, toFloat(left), toFloat(right))
+	}
+
+	// true, false and null are one object each, so the VM's comparison of
+	// them by identity is a comparison of their values
+	leftBool, leftOk := singleton(left)
+	rightBool, rightOk := singleton(right)
+	if !leftOk || !rightOk {
+		return nil, false
+	}
+
+	switch op {
+	case code.OpEqual:
+		return &object.Boolean{Value: leftBool == rightBool}, true
+	case code.OpNotEqual:
+		return &object.Boolean{Value: leftBool != rightBool}, true
+	default:
+		return nil, false
+	}
+}
+
+// A comparable stand in for true, false or null
+func singleton(obj object.Object) (string, bool) {
+	switch obj := obj.(type) {
+	case *object.Boolean:
+		return obj.Inspect(), true
+	case *object.Null:
+		return "null", true
+	default:
+		return "", false
+	}
+}
+
+func foldIntegers(op code.Opcode, left, right int64) (object.Object, bool) {
+	switch op {
+	case code.OpAdd:
+		return &object.Integer{Value: left + right}, true
+	case code.OpSub:
+		return &object.Integer{Value: left - right}, true
+	case code.OpMul:
+		return &object.Integer{Value: left * right}, true
+	case code.OpDiv:
+		if right == 0 {
+			return nil, false
+		}
+		return &object.Integer{Value: left / right}, true
+	case code.OpMod:
+		if right == 0 {
+			return nil, false
+		}
+		return &object.Integer{Value: left % right}, true
+	case code.OpEqual:
+		return &object.Boolean{Value: left == right}, true
+	case code.OpNotEqual:
+		return &object.Boolean{Value: left != right}, true
+	case code.OpGreaterThan:
+		return &object.Boolean{Value: left > right}, true
+	case code.OpGreaterThanOrEqual:
+		return &object.Boolean{Value: left >= right}, true
+	case code.OpLessThan:
+		return &object.Boolean{Value: left < right}, true
+	case code.OpLessThanOrEqual:
+		return &object.Boolean{Value: left <= right}, true
+	default:
+		return nil, false
+	}
+}
+
+func foldFloats(op code.Opcode, left, right float64) (object.Object, bool) {
+	switch op {
+	case code.OpAdd:
+		return &object.Float{Value: left + right}, true
+	case code.OpSub:
+		return &object.Float{Value: left - right}, true
+	case code.OpMul:
+		return &object.Float{Value: left * right}, true
+	case code.OpDiv:
+		if right == 0 {
+			return nil, false
+		}
+		return &object.Float{Value: left / right}, true
+	case code.OpMod:
+		if right == 0 {
+			return nil, false
+		}
+		return &object.Float{Value: math.Mod(left, right)}, true
+	case code.OpEqual:
+		return &object.Boolean{Value: left == right}, true
+	case code.OpNotEqual:
+		return &object.Boolean{Value: left != right}, true
+	case code.OpGreaterThan:
+		return &object.Boolean{Value: left > right}, true
+	case code.OpGreaterThanOrEqual:
+		return &object.Boolean{Value: left >= right}, true
+	case code.OpLessThan:
+		return &object.Boolean{Value: left < right}, true
+	case code.OpLessThanOrEqual:
+		return &object.Boolean{Value: left <= right}, true
+	default:
+		return nil, false
+	}
+}
+
+func foldUnary(op code.Opcode, operand object.Object) (object.Object, bool) {
+	switch op {
+	case code.OpMinus:
+		switch operand := operand.(type) {
+		case *object.Integer:
+			return &object.Integer{Value: -operand.Value}, true
+		case *object.Float:
+			return &object.Float{Value: -operand.Value}, true
+		}
+
+	case code.OpBang:
+		return &object.Boolean{Value: !truthy(operand)}, true
+	}
+
+	return nil, false
+}
+
+func isNumber(obj object.Object) bool {
+	return obj.Type() == object.INTEGER_OBJ || obj.Type() == object.FLOAT_OBJ
+}
+
+func toFloat(obj object.Object) float64 {
+	if i, ok := obj.(*object.Integer); ok {
+		return float64(i.Value)
+	}
+
+	return obj.(*object.Float).Value
+}
+
+// Whether the VM treats a constant as true, which is anything but false and null
+func truthy(obj object.Object) bool {
+	switch obj := obj.(type) {
+	case *object.Boolean:
+		return obj.Value
+	case *object.Null:
+		return false
+	default:
+		return true
+	}
+}
+
+// Settle conditional jumps on a constant: one that's never taken goes, along
+// with the constant, and one that always is becomes an OpJump
+func branches(list []*instruction) ([]*instruction, bool) {
+	targets := jumpTargets(list)
+	dead := map[*instruction]bool{}
+
+	for n := 0; n+1 < len(list); n++ {
+		condition, jump := list[n], list[n+1]
+		if jump.op != code.OpJumpNotTruthy || targets[jump] || dead[condition] {
+			continue
+		}
+
+		var isTrue bool
+		switch condition.op {
+		case code.OpTrue, code.OpConstant:
+			isTrue = true
+		case code.OpFalse, code.OpNull:
+			isTrue = false
+		default:
+			continue
+		}
+
+		if isTrue {
+			dead[condition], dead[jump] = true, true
+		} else {
+			condition.op, condition.operands, condition.target = code.OpJump, []int{0}, jump.target
+			dead[jump] = true
+		}
+	}
+
+	if len(dead) == 0 {
+		return list, false
+	}
+
+	return remove(list, dead), true
+}
+
+// Send jumps that land on an OpJump straight to where that one goes, and
+// drop jumps to the next instruction
+func thread(list []*instruction) ([]*instruction, bool) {
+	changed := false
+
+	for _, i := range list {
+		if i.op != code.OpJump && i.op != code.OpJumpNotTruthy {
+			continue
+		}
+
+		seen := map[*instruction]bool{i: true}
+		for i.target != nil && i.target.op == code.OpJump && !seen[i.target] {
+			seen[i.target] = true
+			i.target = i.target.target
+			changed = true
+		}
+	}
+
+	dead := map[*instruction]bool{}
+	for n, i := range list {
+		var next *instruction
+		if n+1 < len(list) {
+			next = list[n+1]
+		}
+
+		if i.target != next {
+			continue
+		}
+
+		switch i.op {
+		case code.OpJump:
+			dead[i] = true
+		case code.OpJumpNotTruthy:
+			// Whichever way it goes, the condition still has to come off the stack
+			i.op, i.operands, i.target = code.OpPop, nil, nil
+			changed = true
+		}
+	}
+
+	if len(dead) == 0 {
+		return list, changed
+	}
+
+	return remove(list, dead), true
+}
+
+// Drop the instructions no path from the first one reaches
+func prune(list []*instruction) ([]*instruction, bool) {
+	if len(list) == 0 {
+		return list, false
+	}
+
+	index := make(map[*instruction]int, len(list))
+	for n, i := range list {
+		index[i] = n
+	}
+
+	reached := make([]bool, len(list))
+	work := []int{0}
+
+	for len(work) > 0 {
+		n := work[len(work)-1]
+		work = work[:len(work)-1]
+
+		if n >= len(list) || reached[n] {
+			continue
+		}
+		reached[n] = true
+
+		i := list[n]
+		if i.target != nil {
+			work = append(work, index[i.target])
+		}
+
+		switch i.op {
+		case code.OpJump, code.OpReturnValue, code.OpReturn, code.OpThrow:
+		default:
+			work = append(work, n+1)
+		}
+	}
+
+	dead := map[*instruction]bool{}
+	for n, i := range list {
+		if !reached[n] {
+			dead[i] = true
+		}
+	}
+
+	if len(dead) == 0 {
+		return list, false
+	}
+
+	return remove(list, dead), true
+}
+
+// Merge equal numbers among the constants this compilation added, drop the
+// ones nothing uses, and renumber the rest to close the gaps. The module
+// functions in modules are kept whether used or not, since later
+// compilations sharing the symbol table import them by index. Returns what
+// each old index became.
+func (o *optimizer) compact(main *unit, functions map[int]*unit, modules []int) func(int) int {
+	// The first constant with each number's value
+	canonical := make([]int, len(o.constants))
+	type number struct {
+		kind object.ObjectType
+		bits uint64
+	}
+	numbers := map[number]int{}
+
+	for index, constant := range o.constants {
+		canonical[index] = index
+
+		var key number
+		switch constant := constant.(type) {
+		case *object.Integer:
+			key = number{object.INTEGER_OBJ, uint64(constant.Value)}
+		case *object.Float:
+			key = number{object.FLOAT_OBJ, math.Float64bits(constant.Value)}
+		default:
+			continue
+		}
+
+		if first, ok := numbers[key]; ok && index >= o.first {
+			canonical[index] = first
+		} else if !ok {
+			numbers[key] = index
+		}
+	}
+
+	// The constants used from main, following the functions it creates
+	used := make([]bool, len(o.constants))
+	var visit func(index int)
+	visit = func(index int) {
+		index = canonical[index]
+		if used[index] {
+			return
+		}
+		used[index] = true
+
+		if fn, ok := functions[index]; ok {
+			for _, i := range fn.list {
+				if operand, ok := constantOperands[i.op]; ok {
+					visit(i.operands[operand])
+				}
+			}
+		}
+	}
+
+	for _, i := range main.list {
+		if operand, ok := constantOperands[i.op]; ok {
+			visit(i.operands[operand])
+		}
+	}
+	for _, index := range modules {
+		visit(index)
+	}
+
+	renumbered := make([]int, len(o.constants))
+	kept := o.constants[:o.first:o.first]
+	for index, constant := range o.constants {
+		switch {
+		case index < o.first:
+			renumbered[index] = index
+		case used[index] && canonical[index] == index:
+			renumbered[index] = len(kept)
+			kept = append(kept, constant)
+		}
+	}
+
+	remap := func(index int) int {
+		return renumbered[canonical[index]]
+	}
+
+	units := []*unit{main}
+	for index, fn := range functions {
+		if used[index] {
+			units = append(units, fn)
+		}
+	}
+
+	for _, u := range units {
+		for _, i := range u.list {
+			if operand, ok := constantOperands[i.op]; ok {
+				i.operands[operand] = remap(i.operands[operand])
+			}
+		}
+	}
+
+	o.constants = kept
+	return remap
+}
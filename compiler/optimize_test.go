@@ -0,0 +1,241 @@
+package compiler
+
+import (
+	"rafiki/code"
+	"rafiki/object"
+	"testing"
+)
+
+func TestConstantFolding(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "1 + 2 * 3;",
+			expectedConstants: []interface{}{7},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "-5 + 2.5;",
+			expectedConstants: []interface{}{-2.5},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// Equal numbers share one constant
+			input:             "1 + 2; 3 - 1;",
+			expectedConstants: []interface{}{2, 3},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 == 1.0; !true; true != false; 1 > 2; !!5;",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+				code.Make(code.OpFalse),
+				code.Make(code.OpPop),
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+				code.Make(code.OpFalse),
+				code.Make(code.OpPop),
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// Left for the VM to fail on at runtime
+			input:             "1 / 0;",
+			expectedConstants: []interface{}{1, 0},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpDiv),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// Strings are compared by identity, so each literal stays its own
+			input:             `"a" + "a";`,
+			expectedConstants: []interface{}{"a", "a"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTestsAt(t, O1, tests)
+}
+
+func TestDeadCodeRemoval(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "fn() { return 1; 2 };",
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn() { throw 1; 2 };",
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpThrow),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTestsAt(t, O1, tests)
+}
+
+func TestConstantConditions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "if (true) { 10 } else { 20 }; 3333;",
+			expectedConstants: []interface{}{10, 3333},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "if (1 > 2) { 10 }; 3333;",
+			expectedConstants: []interface{}{3333},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// Nothing after a loop without a way out runs
+			input:             "while (true) { 10; } 3333;",
+			expectedConstants: []interface{}{10},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpPop),
+				// 0004
+				code.Make(code.OpJump, 0),
+			},
+		},
+		{
+			input:             "while (false) { 10; } 3333;",
+			expectedConstants: []interface{}{3333},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTestsAt(t, O1, tests)
+}
+
+func TestJumpThreading(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			// The inner if's jump past its else lands on the outer one's,
+			// so it goes straight to the end
+			input:             "let x = 1; let y = 2; if (x) { if (y) { 1 } else { 2 } } else { 3 };",
+			expectedConstants: []interface{}{1, 2, 3},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpSetGlobal, 0),
+				// 0006
+				code.Make(code.OpConstant, 1),
+				// 0009
+				code.Make(code.OpSetGlobal, 1),
+				// 0012
+				code.Make(code.OpGetGlobal, 0),
+				// 0015
+				code.Make(code.OpJumpNotTruthy, 36),
+				// 0018
+				code.Make(code.OpGetGlobal, 1),
+				// 0021
+				code.Make(code.OpJumpNotTruthy, 30),
+				// 0024
+				code.Make(code.OpConstant, 0),
+				// 0027
+				code.Make(code.OpJump, 39),
+				// 0030
+				code.Make(code.OpConstant, 1),
+				// 0033
+				code.Make(code.OpJump, 39),
+				// 0036
+				code.Make(code.OpConstant, 2),
+				// 0039
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTestsAt(t, O1, tests)
+}
+
+func TestOptimizationKeepsEarlierConstants(t *testing.T) {
+	st := NewSymbolTable()
+
+	first := NewCompilerWithState(st, []object.Object{})
+	first.SetOptimization(O1)
+	if err := first.Compile(parse(t, "let a = 1 + 2; let b = 5;")); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	constants := first.Bytecode().Constants
+
+	second := NewCompilerWithState(st, constants)
+	second.SetOptimization(O1)
+	if err := second.Compile(parse(t, "a + (2 + 3);")); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := second.Bytecode()
+
+	// The 5 from the first program stands in for the 2 + 3 of the second
+	err := testConstants(t, []interface{}{5, 3}, bytecode.Constants)
+	if err != nil {
+		t.Fatalf("testConstants failed: %s", err)
+	}
+
+	err = testInstructions([]code.Instructions{
+		code.Make(code.OpGetGlobal, 0),
+		code.Make(code.OpConstant, 0),
+		code.Make(code.OpAdd),
+		code.Make(code.OpPop),
+	}, bytecode.Instructions)
+	if err != nil {
+		t.Fatalf("testInstructions failed: %s", err)
+	}
+}
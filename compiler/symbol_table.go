@@ -1,5 +1,10 @@
 package compiler
 
+import (
+	"rafiki/object"
+	"sort"
+)
+
 type SymbolScope string
 
 const (
@@ -23,27 +28,75 @@ type SymbolTable struct {
 	numDefinitions int
 
 	FreeSymbols []Symbol
+
+	globals *globals
+}
+
+// The VM has one array of globals, so the global tables of a program and of
+// the modules it imports number their slots together
+type globals struct {
+	names    []string                  // Indexed by slot; empty for the slots holding modules
+	modules  map[string]compiledModule // By absolute path
+	builtins []string                  // Defined in every global table, by index; nil if none were
+}
+
+// Where an imported file ended up: the function that runs it, and the global
+// slot that holds its module once it has run
+type compiledModule struct {
+	constant int
+	slot     int
 }
 
 func NewSymbolTable() *SymbolTable {
 	s := make(map[string]Symbol)
 	free := []Symbol{}
+	globals := &globals{modules: map[string]compiledModule{}}
+
+	return &SymbolTable{store: s, FreeSymbols: free, globals: globals}
+}
+
+// A global table where the functions in builtins are defined
+func NewSymbolTableWithBuiltins(builtins *object.BuiltinRegistry) *SymbolTable {
+	s := NewSymbolTable()
+	s.defineBuiltins(builtins.Names())
+
+	return s
+}
+
+// The global table of a module imported by a program with the global table
+// outer. The module's names are its own, but its slots follow the program's,
+// and it has the same builtins.
+func NewModuleSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewSymbolTable()
+	s.globals = outer.globals
+
+	s.defineBuiltins(outer.globals.builtins)
 
-	return &SymbolTable{store: s, FreeSymbols: free}
+	return s
 }
 
 func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
 	s := NewSymbolTable()
 	s.Outer = outer
+	s.globals = outer.globals
 
 	return s
 }
 
+// Redefining a name already bound in this table reuses its slot, so
+// `let x = x + 1` reads the value it replaces, and a loop body that rebinds
+// a name keeps updating the same variable
 func (s *SymbolTable) Define(name string) Symbol {
+	if existing, ok := s.store[name]; ok &&
+		(existing.Scope == GlobalScope || existing.Scope == LocalScope) {
+		return existing
+	}
+
 	symbol := Symbol{Name: name, Index: s.numDefinitions}
 
 	if s.Outer == nil {
 		symbol.Scope = GlobalScope
+		symbol.Index = s.globals.define(name)
 	} else {
 		symbol.Scope = LocalScope
 	}
@@ -80,6 +133,14 @@ func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
 	return symbol
 }
 
+func (s *SymbolTable) defineBuiltins(names []string) {
+	s.globals.builtins = names
+
+	for i, name := range names {
+		s.DefineBuiltin(i, name)
+	}
+}
+
 func (s *SymbolTable) defineFree(original Symbol) Symbol {
 	s.FreeSymbols = append(s.FreeSymbols, original)
 
@@ -95,3 +156,57 @@ func (s *SymbolTable) DefineFunctionName(name string) Symbol {
 	s.store[name] = symbol
 	return symbol
 }
+
+// Whether name, as Resolve sees it from this table, is the name of a function
+// whose body this is in, including one further out that reaches here as a
+// free variable
+func (s *SymbolTable) isFunctionName(name string) bool {
+	for table := s; table != nil; table = table.Outer {
+		symbol, ok := table.store[name]
+		if !ok || symbol.Scope != FreeScope {
+			return ok && symbol.Scope == FunctionScope
+		}
+	}
+
+	return false
+}
+
+// Symbols defined directly in this table (not builtins or free symbols), ordered by index
+func (s *SymbolTable) DefinedSymbols() []Symbol {
+	symbols := []Symbol{}
+
+	for _, symbol := range s.store {
+		if symbol.Scope == GlobalScope || symbol.Scope == LocalScope {
+			symbols = append(symbols, symbol)
+		}
+	}
+
+	sort.Slice(symbols, func(i, j int) bool {
+		return symbols[i].Index < symbols[j].Index
+	})
+
+	return symbols
+}
+
+// Names of the symbols defined in this table, indexed by their slot. For a
+// global table, that's every global, including those of imported modules.
+func (s *SymbolTable) DefinedNames() []string {
+	if s.Outer == nil {
+		return append([]string{}, s.globals.names...)
+	}
+
+	names := make([]string, s.numDefinitions)
+
+	for _, symbol := range s.DefinedSymbols() {
+		names[symbol.Index] = symbol.Name
+	}
+
+	return names
+}
+
+// Claim the next global slot
+func (g *globals) define(name string) int {
+	g.names = append(g.names, name)
+
+	return len(g.names) - 1
+}
@@ -1,6 +1,9 @@
 package compiler
 
-import "testing"
+import (
+	"strings"
+	"testing"
+)
 
 func TestResolveGlobal(t *testing.T) {
 	global := NewSymbolTable()
@@ -335,3 +338,55 @@ func TestShadowingFunctionName(t *testing.T) {
 			expected.Name, expected, result)
 	}
 }
+
+func TestRedefineReusesSlot(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+	global.Define("b")
+
+	expected := Symbol{Name: "a", Scope: GlobalScope, Index: 0}
+
+	result := global.Define("a")
+	if result != expected {
+		t.Errorf("expected redefinition of a to be %+v, got=%+v", expected, result)
+	}
+
+	if global.numDefinitions != 2 {
+		t.Errorf("numDefinitions wrong. want=2, got=%d", global.numDefinitions)
+	}
+}
+
+func TestModuleSymbolTable(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+
+	module := NewModuleSymbolTable(global)
+	module.Define("b")
+	global.Define("c")
+
+	if _, ok := module.Resolve("a"); ok {
+		t.Errorf("a module resolved a name of its importer")
+	}
+
+	expected := []Symbol{
+		{Name: "a", Scope: GlobalScope, Index: 0},
+		{Name: "b", Scope: GlobalScope, Index: 1},
+		{Name: "c", Scope: GlobalScope, Index: 2},
+	}
+
+	for _, sym := range expected {
+		table := global
+		if sym.Name == "b" {
+			table = module
+		}
+
+		if result, ok := table.Resolve(sym.Name); !ok || result != sym {
+			t.Errorf("expected %s to resolve to %+v, got=%+v", sym.Name, sym, result)
+		}
+	}
+
+	names := global.DefinedNames()
+	if strings.Join(names, ",") != "a,b,c" {
+		t.Errorf("wrong global names. got=%v", names)
+	}
+}
@@ -0,0 +1,351 @@
+package rafiki
+
+import (
+	"fmt"
+	"math"
+	"rafiki/object"
+	"rafiki/vm"
+	"reflect"
+)
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+/*
+ToObject converts a Go value to the Rafiki value a program sees:
+
+	nil, nil pointers          null
+	bool                       true or false
+	int and uint types         integer (an error if a uint64 doesn't fit)
+	float32, float64           float
+	string                     string
+	slices and arrays          array, converting each element
+	maps                       hash, converting keys and values
+	funcs                      builtin function, as with Runtime.Register
+	object.Object              itself
+
+A pointer converts as what it points to. Anything else, like a struct, is an
+error.
+*/
+func ToObject(value interface{}) (object.Object, error) {
+	switch value := value.(type) {
+	case nil:
+		return vm.Null, nil
+	case object.Object:
+		return value, nil
+	case bool:
+		if value {
+			return vm.True, nil
+		}
+		return vm.False, nil
+	case string:
+		return &object.String{Value: value}, nil
+	}
+
+	v := reflect.ValueOf(value)
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &object.Integer{Value: v.Int()}, nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return nil, fmt.Errorf("%d overflows an integer", v.Uint())
+		}
+		return &object.Integer{Value: int64(v.Uint())}, nil
+
+	case reflect.Float32, reflect.Float64:
+		return &object.Float{Value: v.Float()}, nil
+
+	case reflect.String:
+		return &object.String{Value: v.String()}, nil
+
+	case reflect.Bool:
+		return ToObject(v.Bool())
+
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return vm.Null, nil
+		}
+		return ToObject(v.Elem().Interface())
+
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return vm.Null, nil
+		}
+
+		elements := make([]object.Object, v.Len())
+		for i := range elements {
+			element, err := ToObject(v.Index(i).Interface())
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = element
+		}
+		return &object.Array{Elements: elements}, nil
+
+	case reflect.Map:
+		if v.IsNil() {
+			return vm.Null, nil
+		}
+
+		pairs := make(map[object.HashKey]object.HashPair, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key, err := ToObject(iter.Key().Interface())
+			if err != nil {
+				return nil, err
+			}
+
+			hashKey, ok := key.(object.Hashable)
+			if !ok {
+				return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
+			}
+
+			val, err := ToObject(iter.Value().Interface())
+			if err != nil {
+				return nil, err
+			}
+
+			pairs[hashKey.HashKey()] = object.HashPair{Key: key, Value: val}
+		}
+		return &object.Hash{Pairs: pairs}, nil
+
+	case reflect.Func:
+		return wrapFunction(v.Type().String(), value)
+	}
+
+	return nil, fmt.Errorf("cannot convert %T to a Rafiki value", value)
+}
+
+/*
+FromObject converts a Rafiki value to Go:
+
+	null                       nil
+	integer                    int64
+	float                      float64
+	boolean                    bool
+	string                     string
+	array                      []interface{}
+	hash                       map[string]interface{} when every key is a
+	                           string, otherwise map[interface{}]interface{}
+
+Anything else, like a function or an error, is returned as the object.Object
+it is, so it can be handed back to the program.
+*/
+func FromObject(obj object.Object) interface{} {
+	switch obj := obj.(type) {
+	case nil, *object.Null:
+		return nil
+	case *object.Integer:
+		return obj.Value
+	case *object.Float:
+		return obj.Value
+	case *object.Boolean:
+		return obj.Value
+	case *object.String:
+		return obj.Value
+
+	case *object.Array:
+		elements := make([]interface{}, len(obj.Elements))
+		for i, element := range obj.Elements {
+			elements[i] = FromObject(element)
+		}
+		return elements
+
+	case *object.Hash:
+		stringKeys := true
+		for _, pair := range obj.Pairs {
+			if pair.Key.Type() != object.STRING_OBJ {
+				stringKeys = false
+				break
+			}
+		}
+
+		if stringKeys {
+			m := make(map[string]interface{}, len(obj.Pairs))
+			for _, pair := range obj.Pairs {
+				m[pair.Key.(*object.String).Value] = FromObject(pair.Value)
+			}
+			return m
+		}
+
+		m := make(map[interface{}]interface{}, len(obj.Pairs))
+		for _, pair := range obj.Pairs {
+			m[FromObject(pair.Key)] = FromObject(pair.Value)
+		}
+		return m
+	}
+
+	return obj
+}
+
+// A builtin that converts its arguments to fn's parameter types, calls it,
+// and converts back what it returns
+func wrapFunction(name string, fn interface{}) (*object.Builtin, error) {
+	switch fn := fn.(type) {
+	case *object.Builtin:
+		return fn, nil
+	case func(args ...object.Object) object.Object:
+		return &object.Builtin{Fn: fn}, nil
+	case object.BuiltinFunction:
+		return &object.Builtin{Fn: fn}, nil
+	}
+
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return nil, fmt.Errorf("%s: not a function: %T", name, fn)
+	}
+
+	t := v.Type()
+	returnsError := t.NumOut() > 0 && t.Out(t.NumOut()-1) == errorType
+
+	if t.NumOut() > 2 || (t.NumOut() == 2 && !returnsError) {
+		return nil, fmt.Errorf("%s: a function can return at most a value and an error, got %s", name, t)
+	}
+
+	return &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		in, err := convertArguments(name, t, args)
+		if err != nil {
+			return &object.Error{Message: err.Error()}
+		}
+
+		out := v.Call(in)
+
+		if returnsError {
+			if err, _ := out[len(out)-1].Interface().(error); err != nil {
+				return &object.Error{Message: err.Error()}
+			}
+			out = out[:len(out)-1]
+		}
+
+		if len(out) == 0 {
+			return nil
+		}
+
+		result, err := ToObject(out[0].Interface())
+		if err != nil {
+			return &object.Error{Message: fmt.Sprintf("result of %s: %s", name, err)}
+		}
+
+		return result
+	}}, nil
+}
+
+func convertArguments(name string, t reflect.Type, args []object.Object) ([]reflect.Value, error) {
+	numParams := t.NumIn()
+
+	if t.IsVariadic() {
+		if len(args) < numParams-1 {
+			return nil, fmt.Errorf("wrong number of arguments to %s: want at least %d, got=%d", name, numParams-1, len(args))
+		}
+	} else if len(args) != numParams {
+		return nil, fmt.Errorf("wrong number of arguments to %s: want=%d, got=%d", name, numParams, len(args))
+	}
+
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		var paramType reflect.Type
+		if t.IsVariadic() && i >= numParams-1 {
+			paramType = t.In(numParams - 1).Elem()
+		} else {
+			paramType = t.In(i)
+		}
+
+		value, err := fromObjectTo(arg, paramType)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d to %s: %s", i+1, name, err)
+		}
+		in[i] = value
+	}
+
+	return in, nil
+}
+
+// Convert obj to a Go value of type t
+func fromObjectTo(obj object.Object, t reflect.Type) (reflect.Value, error) {
+	if t.Kind() == reflect.Interface && t.NumMethod() == 0 {
+		if value := FromObject(obj); value != nil {
+			return reflect.ValueOf(value), nil
+		}
+		return reflect.Zero(t), nil
+	}
+
+	if reflect.TypeOf(obj).AssignableTo(t) {
+		return reflect.ValueOf(obj), nil
+	}
+
+	switch obj := obj.(type) {
+	case *object.Null:
+		switch t.Kind() {
+		case reflect.Interface, reflect.Pointer, reflect.Map, reflect.Slice:
+			return reflect.Zero(t), nil
+		}
+
+	case *object.Boolean:
+		if t.Kind() == reflect.Bool {
+			return reflect.ValueOf(obj.Value).Convert(t), nil
+		}
+
+	case *object.String:
+		if t.Kind() == reflect.String {
+			return reflect.ValueOf(obj.Value).Convert(t), nil
+		}
+
+	case *object.Float:
+		if t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64 {
+			return reflect.ValueOf(obj.Value).Convert(t), nil
+		}
+
+	case *object.Integer:
+		switch t.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if v := reflect.New(t).Elem(); !v.OverflowInt(obj.Value) {
+				v.SetInt(obj.Value)
+				return v, nil
+			}
+			return reflect.Value{}, fmt.Errorf("%d overflows %s", obj.Value, t)
+
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			if v := reflect.New(t).Elem(); obj.Value >= 0 && !v.OverflowUint(uint64(obj.Value)) {
+				v.SetUint(uint64(obj.Value))
+				return v, nil
+			}
+			return reflect.Value{}, fmt.Errorf("%d overflows %s", obj.Value, t)
+
+		case reflect.Float32, reflect.Float64:
+			return reflect.ValueOf(float64(obj.Value)).Convert(t), nil
+		}
+
+	case *object.Array:
+		if t.Kind() == reflect.Slice {
+			slice := reflect.MakeSlice(t, len(obj.Elements), len(obj.Elements))
+			for i, element := range obj.Elements {
+				value, err := fromObjectTo(element, t.Elem())
+				if err != nil {
+					return reflect.Value{}, err
+				}
+				slice.Index(i).Set(value)
+			}
+			return slice, nil
+		}
+
+	case *object.Hash:
+		if t.Kind() == reflect.Map {
+			m := reflect.MakeMapWithSize(t, len(obj.Pairs))
+			for _, pair := range obj.Pairs {
+				key, err := fromObjectTo(pair.Key, t.Key())
+				if err != nil {
+					return reflect.Value{}, err
+				}
+				value, err := fromObjectTo(pair.Value, t.Elem())
+				if err != nil {
+					return reflect.Value{}, err
+				}
+				m.SetMapIndex(key, value)
+			}
+			return m, nil
+		}
+	}
+
+	return reflect.Value{}, fmt.Errorf("cannot use %s as %s", obj.Type(), t)
+}
@@ -0,0 +1,77 @@
+package rafiki
+
+import (
+	"math"
+	"rafiki/object"
+	"reflect"
+	"testing"
+)
+
+func TestToObject(t *testing.T) {
+	type celsius float64
+
+	tests := []struct {
+		input    interface{}
+		expected string
+	}{
+		{nil, "null"},
+		{true, "true"},
+		{int8(-3), "-3"},
+		{uint16(7), "7"},
+		{celsius(21.5), "21.5"},
+		{"hi", "hi"},
+		{[]int{1, 2}, "[1, 2]"},
+		{[2]string{"a", "b"}, "[a, b]"},
+		{map[string]bool{"ok": true}, "{ok: true}"},
+		{(*int)(nil), "null"},
+		{&[]interface{}{1, "a", nil}, "[1, a, null]"},
+		{&object.Integer{Value: 5}, "5"},
+	}
+
+	for _, tt := range tests {
+		obj, err := ToObject(tt.input)
+		if err != nil {
+			t.Errorf("%#v: unexpected error: %s", tt.input, err)
+			continue
+		}
+
+		if obj.Inspect() != tt.expected {
+			t.Errorf("%#v: wrong object. want=%s, got=%s", tt.input, tt.expected, obj.Inspect())
+		}
+	}
+
+	for _, input := range []interface{}{uint64(math.MaxUint64), struct{}{}, map[[1]int]int{{1}: 1}, make(chan int)} {
+		if _, err := ToObject(input); err == nil {
+			t.Errorf("%#v: expected an error", input)
+		}
+	}
+}
+
+func TestFromObject(t *testing.T) {
+	hash, _ := ToObject(map[interface{}]interface{}{1: "one", "two": 2.0})
+
+	tests := []struct {
+		input    object.Object
+		expected interface{}
+	}{
+		{nil, nil},
+		{&object.Null{}, nil},
+		{&object.Integer{Value: 1}, int64(1)},
+		{&object.Float{Value: 1.5}, 1.5},
+		{&object.Boolean{Value: true}, true},
+		{&object.String{Value: "s"}, "s"},
+		{&object.Array{Elements: []object.Object{&object.Integer{Value: 1}, &object.Null{}}}, []interface{}{int64(1), nil}},
+		{hash, map[interface{}]interface{}{int64(1): "one", "two": 2.0}},
+	}
+
+	for _, tt := range tests {
+		if actual := FromObject(tt.input); !reflect.DeepEqual(actual, tt.expected) {
+			t.Errorf("%v: wrong value. want=%#v, got=%#v", tt.input, tt.expected, actual)
+		}
+	}
+
+	fn := &object.Closure{}
+	if FromObject(fn) != fn {
+		t.Errorf("a closure should come back as itself")
+	}
+}
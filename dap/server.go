@@ -0,0 +1,635 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"rafiki/compiler"
+	"rafiki/object"
+	"rafiki/transport"
+	"rafiki/vm"
+	"sort"
+	"sync"
+)
+
+// Compiles the program named in a launch request, against builtins, which its
+// macros should run with too. The error's text is shown to the user as it is,
+// so it should say what went wrong and where.
+type CompileFunc func(filename string, builtins *object.BuiltinRegistry) (*compiler.Bytecode, error)
+
+// Rafiki programs have a single thread, and DAP wants it to have an id
+const threadId = 1
+
+/*
+Server debugs one program at a time for an editor, speaking DAP over a pair
+of streams. The program runs on its own goroutine, so the server can still
+answer a pause or disconnect while it runs, and is only inspected while it's
+stopped.
+*/
+type Server struct {
+	in       *bufio.Reader
+	out      io.Writer
+	compile  CompileFunc
+	builtins *object.BuiltinRegistry // Whose puts sends output events
+
+	mu  sync.Mutex // Guards out and seq, which the running program writes to through puts
+	seq int
+
+	lineBase   int // What the client calls the first line and column, 0 or 1
+	columnBase int
+
+	stopOnEntry bool
+	noDebug     bool
+	configured  bool
+	breakpoints map[string][]int // Lines by absolute path
+
+	debugger *vm.Debugger
+	started  bool
+	running  bool
+	stops    chan vm.StopReason
+
+	handles []interface{} // Things variablesReference n-1 can expand: []vm.Variable or *object.Array/*object.Hash
+}
+
+func NewServer(in io.Reader, out io.Writer, compile CompileFunc) *Server {
+	s := &Server{
+		in:          bufio.NewReader(in),
+		out:         out,
+		compile:     compile,
+		builtins:    object.DefaultBuiltins(),
+		lineBase:    1,
+		columnBase:  1,
+		breakpoints: map[string][]int{},
+		stops:       make(chan vm.StopReason, 1),
+	}
+
+	// The program's puts output goes to the client instead of to stdout,
+	// which is carrying the protocol
+	s.builtins.SetOutput(outputWriter{s})
+
+	return s
+}
+
+// Serve handles requests until the client disconnects or closes the input.
+func (s *Server) Serve() error {
+	requests := make(chan Request)
+	readErr := make(chan error, 1)
+
+	go func() {
+		for {
+			data, err := transport.ReadMessage(s.in)
+			if err != nil {
+				readErr <- err
+				return
+			}
+
+			var req Request
+			if err := json.Unmarshal(data, &req); err != nil {
+				readErr <- fmt.Errorf("dap: bad message: %w", err)
+				return
+			}
+
+			requests <- req
+		}
+	}()
+
+	for {
+		select {
+		case req := <-requests:
+			if quit := s.handle(req); quit {
+				return nil
+			}
+
+		case reason := <-s.stops:
+			s.stopped(reason)
+
+		case err := <-readErr:
+			s.halt()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+func (s *Server) send(msg interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+	switch msg := msg.(type) {
+	case *Response:
+		msg.Seq = s.seq
+	case *Event:
+		msg.Seq = s.seq
+	}
+
+	// Nothing useful to do if the client has gone; the read side will notice
+	_ = transport.WriteMessage(s.out, msg)
+}
+
+func (s *Server) respond(req Request, body interface{}) {
+	s.send(&Response{Type: "response", RequestSeq: req.Seq, Success: true, Command: req.Command, Body: body})
+}
+
+func (s *Server) fail(req Request, format string, a ...interface{}) {
+	s.send(&Response{Type: "response", RequestSeq: req.Seq, Command: req.Command, Message: fmt.Sprintf(format, a...)})
+}
+
+func (s *Server) event(name string, body interface{}) {
+	s.send(&Event{Type: "event", Event: name, Body: body})
+}
+
+// Handle one request. Returns true when the session is over.
+func (s *Server) handle(req Request) bool {
+	switch req.Command {
+	case "initialize":
+		var args InitializeArguments
+		if !s.arguments(req, &args) {
+			return false
+		}
+
+		if args.LinesStartAt1 != nil && !*args.LinesStartAt1 {
+			s.lineBase = 0
+		}
+		if args.ColumnsStartAt1 != nil && !*args.ColumnsStartAt1 {
+			s.columnBase = 0
+		}
+
+		s.respond(req, Capabilities{
+			SupportsConfigurationDoneRequest: true,
+			SupportsEvaluateForHovers:        true,
+			SupportsTerminateRequest:         true,
+		})
+		s.event("initialized", nil)
+
+	case "launch":
+		s.launch(req)
+
+	case "setBreakpoints":
+		s.setBreakpoints(req)
+
+	case "configurationDone":
+		s.configured = true
+		s.respond(req, nil)
+		s.start()
+
+	case "threads":
+		s.respond(req, map[string]interface{}{
+			"threads": []Thread{{Id: threadId, Name: "main"}},
+		})
+
+	case "stackTrace":
+		if s.paused(req) {
+			s.stackTrace(req)
+		}
+
+	case "scopes":
+		if s.paused(req) {
+			s.scopes(req)
+		}
+
+	case "variables":
+		if s.paused(req) {
+			s.variables(req)
+		}
+
+	case "evaluate":
+		if s.paused(req) {
+			s.evaluate(req)
+		}
+
+	case "continue":
+		s.resume(req, (*vm.Debugger).Continue)
+	case "next":
+		s.resume(req, (*vm.Debugger).StepOver)
+	case "stepIn":
+		s.resume(req, (*vm.Debugger).Step)
+	case "stepOut":
+		s.resume(req, (*vm.Debugger).StepOut)
+
+	case "pause":
+		if s.running {
+			s.debugger.Pause()
+		}
+		s.respond(req, nil)
+
+	case "disconnect", "terminate":
+		s.halt()
+		s.respond(req, nil)
+		if req.Command == "terminate" {
+			s.event("terminated", nil)
+		}
+		return true
+
+	default:
+		s.fail(req, "unsupported request %q", req.Command)
+	}
+
+	return false
+}
+
+func (s *Server) arguments(req Request, args interface{}) bool {
+	if len(req.Arguments) == 0 {
+		return true
+	}
+
+	if err := json.Unmarshal(req.Arguments, args); err != nil {
+		s.fail(req, "bad arguments: %s", err)
+		return false
+	}
+
+	return true
+}
+
+func (s *Server) launch(req Request) {
+	var args LaunchArguments
+	if !s.arguments(req, &args) {
+		return
+	}
+
+	if args.Program == "" {
+		s.fail(req, "launch needs a program to debug")
+		return
+	}
+
+	program, err := filepath.Abs(args.Program)
+	if err != nil {
+		s.fail(req, "%s", err)
+		return
+	}
+
+	code, err := s.compile(program, s.builtins)
+	if err != nil {
+		s.event("output", map[string]string{"category": "stderr", "output": err.Error()})
+		s.fail(req, "%s: could not compile", args.Program)
+		return
+	}
+
+	s.stopOnEntry = args.StopOnEntry
+	s.noDebug = args.NoDebug
+	s.debugger = vm.NewDebugger(code)
+	s.debugger.SetBuiltins(s.builtins)
+
+	if !s.noDebug {
+		for path, lines := range s.breakpoints {
+			for _, line := range lines {
+				s.debugger.SetBreakpoint(path, line)
+			}
+		}
+	}
+
+	s.respond(req, nil)
+	s.start()
+}
+
+// Start the program once it's launched and the client has sent its breakpoints
+func (s *Server) start() {
+	if s.debugger == nil || !s.configured || s.started {
+		return
+	}
+
+	s.started = true
+
+	if s.stopOnEntry {
+		s.run(func(d *vm.Debugger) vm.StopReason {
+			if reason := d.Step(); reason != vm.StopStep {
+				return reason
+			}
+			return stopEntry
+		})
+	} else {
+		s.run((*vm.Debugger).Continue)
+	}
+}
+
+// Reported as the "entry" stop. It's never one of the Debugger's own reasons.
+const stopEntry vm.StopReason = -1
+
+func (s *Server) setBreakpoints(req Request) {
+	var args SetBreakpointsArguments
+	if !s.arguments(req, &args) {
+		return
+	}
+
+	path, err := filepath.Abs(args.Source.Path)
+	if err != nil {
+		s.fail(req, "%s", err)
+		return
+	}
+
+	if s.debugger != nil && !s.noDebug {
+		for _, line := range s.breakpoints[path] {
+			s.debugger.ClearBreakpoint(path, line)
+		}
+	}
+
+	lines := []int{}
+	breakpoints := []Breakpoint{}
+	for _, bp := range args.Breakpoints {
+		line := s.fromClientLine(bp.Line)
+		lines = append(lines, line)
+		breakpoints = append(breakpoints, Breakpoint{Verified: true, Line: bp.Line, Source: args.Source})
+
+		if s.debugger != nil && !s.noDebug {
+			s.debugger.SetBreakpoint(path, line)
+		}
+	}
+
+	s.breakpoints[path] = lines
+
+	s.respond(req, map[string]interface{}{"breakpoints": breakpoints})
+}
+
+// Whether the program is stopped and can be inspected, failing req if not
+func (s *Server) paused(req Request) bool {
+	switch {
+	case s.debugger == nil || !s.started:
+		s.fail(req, "the program has not started")
+		return false
+	case s.running:
+		s.fail(req, "the program is running")
+		return false
+	}
+
+	return true
+}
+
+func (s *Server) resume(req Request, step func(d *vm.Debugger) vm.StopReason) {
+	if s.debugger == nil || !s.started {
+		s.fail(req, "the program has not started")
+		return
+	}
+
+	if s.running {
+		s.fail(req, "the program is already running")
+		return
+	}
+
+	if req.Command == "continue" {
+		s.respond(req, map[string]bool{"allThreadsContinued": true})
+	} else {
+		s.respond(req, nil)
+	}
+
+	s.run(step)
+}
+
+func (s *Server) run(step func(d *vm.Debugger) vm.StopReason) {
+	s.running = true
+	s.handles = nil
+
+	// The program has already failed and is only being kept around to look at
+	if s.debugger.Done() {
+		s.stops <- vm.StopExited
+		return
+	}
+
+	go func() {
+		s.stops <- step(s.debugger)
+	}()
+}
+
+// Stop a running program and wait for it, so it can't write after we return
+func (s *Server) halt() {
+	if s.running {
+		s.debugger.Pause()
+		<-s.stops
+		s.running = false
+	}
+}
+
+func (s *Server) stopped(reason vm.StopReason) {
+	s.running = false
+
+	switch reason {
+	case vm.StopExited:
+		code := 0
+		if s.debugger.Err() != nil {
+			code = 1
+		}
+
+		s.event("exited", map[string]int{"exitCode": code})
+		s.event("terminated", nil)
+
+	case vm.StopError:
+		err := s.debugger.Err()
+
+		output := err.Error() + "\n"
+		var errObj *object.Error
+		if errors.As(err, &errObj) {
+			output += errObj.StackTrace()
+		}
+
+		s.event("output", map[string]string{"category": "stderr", "output": output})
+		s.event("stopped", map[string]interface{}{
+			"reason":            "exception",
+			"description":       "Runtime error",
+			"text":              err.Error(),
+			"threadId":          threadId,
+			"allThreadsStopped": true,
+		})
+
+	default:
+		s.event("stopped", map[string]interface{}{
+			"reason":            stopName(reason),
+			"threadId":          threadId,
+			"allThreadsStopped": true,
+		})
+	}
+}
+
+func stopName(reason vm.StopReason) string {
+	switch reason {
+	case stopEntry:
+		return "entry"
+	case vm.StopBreakpoint:
+		return "breakpoint"
+	case vm.StopPause:
+		return "pause"
+	default:
+		return "step"
+	}
+}
+
+func (s *Server) stackTrace(req Request) {
+	var args StackTraceArguments
+	if !s.arguments(req, &args) {
+		return
+	}
+
+	frames := s.debugger.Frames()
+
+	stackFrames := []StackFrame{}
+	for i, frame := range frames {
+		if i < args.StartFrame || (args.Levels > 0 && i >= args.StartFrame+args.Levels) {
+			continue
+		}
+
+		stackFrames = append(stackFrames, StackFrame{
+			Id:     i + 1,
+			Name:   frame.Function,
+			Source: source(frame.Pos.Filename),
+			Line:   s.toClientLine(frame.Pos.Line),
+			Column: frame.Pos.Column - 1 + s.columnBase,
+		})
+	}
+
+	s.respond(req, map[string]interface{}{"stackFrames": stackFrames, "totalFrames": len(frames)})
+}
+
+func source(path string) Source {
+	return Source{Name: filepath.Base(path), Path: path}
+}
+
+// The frame a request's frameId names, innermost first from 1
+func (s *Server) frame(req Request, id int) (vm.DebugFrame, bool) {
+	frames := s.debugger.Frames()
+	if id < 1 || id > len(frames) {
+		s.fail(req, "no frame with id %d", id)
+		return vm.DebugFrame{}, false
+	}
+
+	return frames[id-1], true
+}
+
+func (s *Server) scopes(req Request) {
+	var args ScopesArguments
+	if !s.arguments(req, &args) {
+		return
+	}
+
+	frame, ok := s.frame(req, args.FrameId)
+	if !ok {
+		return
+	}
+
+	scopes := []Scope{}
+	if args.FrameId < len(s.debugger.Frames()) {
+		locals := append(append([]vm.Variable{}, frame.Locals...), frame.Free...)
+		scopes = append(scopes, Scope{Name: "Locals", VariablesReference: s.reference(locals)})
+	}
+	scopes = append(scopes, Scope{Name: "Globals", VariablesReference: s.reference(s.debugger.Globals())})
+
+	s.respond(req, map[string]interface{}{"scopes": scopes})
+}
+
+// Remember something the client can ask to expand, until the program moves on
+func (s *Server) reference(v interface{}) int {
+	s.handles = append(s.handles, v)
+	return len(s.handles)
+}
+
+func (s *Server) variables(req Request) {
+	var args VariablesArguments
+	if !s.arguments(req, &args) {
+		return
+	}
+
+	ref := args.VariablesReference
+	if ref < 1 || ref > len(s.handles) {
+		s.fail(req, "no variables with reference %d", ref)
+		return
+	}
+
+	var vars []vm.Variable
+	switch v := s.handles[ref-1].(type) {
+	case []vm.Variable:
+		vars = v
+
+	case *object.Array:
+		for i, element := range v.Elements {
+			vars = append(vars, vm.Variable{Name: fmt.Sprintf("[%d]", i), Value: element})
+		}
+
+	case *object.Hash:
+		for _, pair := range v.Pairs {
+			vars = append(vars, vm.Variable{Name: pair.Key.Inspect(), Value: pair.Value})
+		}
+		sort.Slice(vars, func(i, j int) bool { return vars[i].Name < vars[j].Name })
+	}
+
+	variables := []Variable{}
+	for _, v := range vars {
+		variables = append(variables, s.variable(v.Name, v.Value))
+	}
+
+	s.respond(req, map[string]interface{}{"variables": variables})
+}
+
+func (s *Server) variable(name string, value object.Object) Variable {
+	if value == nil {
+		return Variable{Name: name, Value: "null", Type: string(object.NULL_OBJ)}
+	}
+
+	v := Variable{Name: name, Value: value.Inspect(), Type: string(value.Type())}
+
+	switch value := value.(type) {
+	case *object.Array:
+		if len(value.Elements) > 0 {
+			v.VariablesReference = s.reference(value)
+		}
+	case *object.Hash:
+		if len(value.Pairs) > 0 {
+			v.VariablesReference = s.reference(value)
+		}
+	}
+
+	return v
+}
+
+// Only variable names can be evaluated, which is enough for hovers and watches
+func (s *Server) evaluate(req Request) {
+	var args EvaluateArguments
+	if !s.arguments(req, &args) {
+		return
+	}
+
+	id := args.FrameId
+	if id == 0 {
+		id = 1
+	}
+
+	frame, ok := s.frame(req, id)
+	if !ok {
+		return
+	}
+
+	for _, vars := range [][]vm.Variable{frame.Locals, frame.Free, s.debugger.Globals()} {
+		for _, v := range vars {
+			if v.Name == args.Expression {
+				result := s.variable(v.Name, v.Value)
+				s.respond(req, map[string]interface{}{
+					"result":             result.Value,
+					"type":               result.Type,
+					"variablesReference": result.VariablesReference,
+				})
+				return
+			}
+		}
+	}
+
+	s.fail(req, "no variable named %q in scope", args.Expression)
+}
+
+func (s *Server) toClientLine(line int) int {
+	return line - 1 + s.lineBase
+}
+
+func (s *Server) fromClientLine(line int) int {
+	return line + 1 - s.lineBase
+}
+
+// Sends what the program writes to the client as output events
+type outputWriter struct {
+	s *Server
+}
+
+func (w outputWriter) Write(p []byte) (int, error) {
+	w.s.event("output", map[string]string{"category": "stdout", "output": string(p)})
+	return len(p), nil
+}
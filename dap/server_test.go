@@ -0,0 +1,424 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"rafiki/compiler"
+	"rafiki/lexer"
+	"rafiki/object"
+	"rafiki/parser"
+	"rafiki/transport"
+	"strings"
+	"testing"
+	"time"
+)
+
+func compile(filename string, builtins *object.BuiltinRegistry) (*compiler.Bytecode, error) {
+	src, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	p := parser.NewParser(lexer.NewLexerWithFilename(string(src), filename))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, errors.New(strings.Join(p.Errors(), "\n"))
+	}
+
+	comp := compiler.NewCompilerWithBuiltins(builtins)
+	if err := comp.Compile(program); err != nil {
+		return nil, err
+	}
+
+	return comp.Bytecode(), nil
+}
+
+// A scripted DAP client talking to a Server over pipes
+type client struct {
+	t      *testing.T
+	in     *io.PipeWriter
+	out    *bufio.Reader
+	seq    int
+	done   chan error
+	events []message // Read while waiting for something else
+}
+
+// Requests, responses and events in one, for the test to pick through
+type message struct {
+	Type       string          `json:"type"`
+	Command    string          `json:"command"`
+	Event      string          `json:"event"`
+	RequestSeq int             `json:"request_seq"`
+	Success    bool            `json:"success"`
+	Message    string          `json:"message"`
+	Body       json.RawMessage `json:"body"`
+}
+
+func newClient(t *testing.T) *client {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+
+	c := &client{t: t, in: inW, out: bufio.NewReader(outR), done: make(chan error, 1)}
+
+	go func() {
+		c.done <- NewServer(inR, outW, compile).Serve()
+		outW.Close()
+	}()
+
+	return c
+}
+
+func writeProgram(t *testing.T, src string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "main.rk")
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatalf("could not write program: %s", err)
+	}
+
+	return path
+}
+
+func (c *client) read() message {
+	c.t.Helper()
+
+	type result struct {
+		data []byte
+		err  error
+	}
+
+	ch := make(chan result, 1)
+	go func() {
+		data, err := transport.ReadMessage(c.out)
+		ch <- result{data, err}
+	}()
+
+	select {
+	case r := <-ch:
+		if r.err != nil {
+			c.t.Fatalf("could not read message: %s", r.err)
+		}
+
+		var msg message
+		if err := json.Unmarshal(r.data, &msg); err != nil {
+			c.t.Fatalf("bad message %s: %s", r.data, err)
+		}
+		return msg
+
+	case <-time.After(5 * time.Second):
+		c.t.Fatalf("timed out waiting for a message")
+		return message{}
+	}
+}
+
+// Send a request and return the body of its successful response
+func (c *client) request(command string, args interface{}) json.RawMessage {
+	c.t.Helper()
+
+	msg := c.send(command, args)
+	if !msg.Success {
+		c.t.Fatalf("%s failed: %s", command, msg.Message)
+	}
+
+	return msg.Body
+}
+
+func (c *client) send(command string, args interface{}) message {
+	c.t.Helper()
+
+	c.seq++
+	req := map[string]interface{}{"seq": c.seq, "type": "request", "command": command}
+	if args != nil {
+		req["arguments"] = args
+	}
+
+	if err := transport.WriteMessage(c.in, req); err != nil {
+		c.t.Fatalf("could not send %s: %s", command, err)
+	}
+
+	for {
+		msg := c.read()
+		if msg.Type == "response" && msg.RequestSeq == c.seq {
+			return msg
+		}
+		c.events = append(c.events, msg)
+	}
+}
+
+// Wait for the named event, returning its body
+func (c *client) event(name string) json.RawMessage {
+	c.t.Helper()
+
+	for i, msg := range c.events {
+		if msg.Event == name {
+			c.events = append(c.events[:i], c.events[i+1:]...)
+			return msg.Body
+		}
+	}
+
+	for {
+		msg := c.read()
+		if msg.Type == "event" && msg.Event == name {
+			return msg.Body
+		}
+		c.events = append(c.events, msg)
+	}
+}
+
+func (c *client) decode(data json.RawMessage, v interface{}) {
+	c.t.Helper()
+
+	if err := json.Unmarshal(data, v); err != nil {
+		c.t.Fatalf("bad body %s: %s", data, err)
+	}
+}
+
+func (c *client) stopped(reason string) {
+	c.t.Helper()
+
+	var body struct{ Reason string }
+	c.decode(c.event("stopped"), &body)
+
+	if body.Reason != reason {
+		c.t.Fatalf("wrong stop reason. want=%q, got=%q", reason, body.Reason)
+	}
+}
+
+func (c *client) variables(ref int) []Variable {
+	c.t.Helper()
+
+	var body struct{ Variables []Variable }
+	c.decode(c.request("variables", VariablesArguments{VariablesReference: ref}), &body)
+
+	return body.Variables
+}
+
+func (c *client) stackTrace() []StackFrame {
+	c.t.Helper()
+
+	var body struct{ StackFrames []StackFrame }
+	c.decode(c.request("stackTrace", map[string]int{"threadId": threadId}), &body)
+
+	return body.StackFrames
+}
+
+func (c *client) disconnect() {
+	c.t.Helper()
+
+	c.request("disconnect", nil)
+
+	if err := <-c.done; err != nil {
+		c.t.Errorf("server failed: %s", err)
+	}
+}
+
+func (c *client) launch(path string, args map[string]interface{}, breakpoints ...int) {
+	c.t.Helper()
+
+	c.request("initialize", map[string]string{"adapterID": "rafiki"})
+	c.event("initialized")
+
+	if args == nil {
+		args = map[string]interface{}{}
+	}
+	args["program"] = path
+	c.request("launch", args)
+
+	bps := []SourceBreakpoint{}
+	for _, line := range breakpoints {
+		bps = append(bps, SourceBreakpoint{Line: line})
+	}
+	c.request("setBreakpoints", SetBreakpointsArguments{Source: Source{Path: path}, Breakpoints: bps})
+
+	c.request("configurationDone", nil)
+}
+
+const program = `let add = fn(a, b) {
+  let sum = a + b;
+  sum
+};
+let nums = [1, 2];
+puts("hi");
+let y = add(nums[0], 2);
+`
+
+func TestBreakpointSession(t *testing.T) {
+	path := writeProgram(t, program)
+	c := newClient(t)
+
+	c.launch(path, nil, 2)
+	c.stopped("breakpoint")
+
+	var output struct{ Category, Output string }
+	c.decode(c.event("output"), &output)
+	if output.Category != "stdout" || output.Output != "hi\n" {
+		t.Errorf("wrong output event. got=%+v", output)
+	}
+
+	frames := c.stackTrace()
+	if len(frames) != 2 {
+		t.Fatalf("wrong number of frames. want=2, got=%d", len(frames))
+	}
+
+	expectedFrames := []struct {
+		name string
+		line int
+	}{{"add", 2}, {"<main>", 7}}
+
+	for i, expected := range expectedFrames {
+		frame := frames[i]
+		if frame.Name != expected.name || frame.Line != expected.line || frame.Source.Path != path {
+			t.Errorf("frame %d: want %s at %s:%d, got=%+v", i, expected.name, path, expected.line, frame)
+		}
+	}
+
+	var scopes struct{ Scopes []Scope }
+	c.decode(c.request("scopes", ScopesArguments{FrameId: frames[0].Id}), &scopes)
+	if len(scopes.Scopes) != 2 || scopes.Scopes[0].Name != "Locals" || scopes.Scopes[1].Name != "Globals" {
+		t.Fatalf("wrong scopes. got=%+v", scopes.Scopes)
+	}
+
+	locals := c.variables(scopes.Scopes[0].VariablesReference)
+	if len(locals) != 2 || locals[0].Name != "a" || locals[0].Value != "1" || locals[1].Name != "b" || locals[1].Value != "2" {
+		t.Errorf("wrong locals. got=%+v", locals)
+	}
+
+	globals := c.variables(scopes.Scopes[1].VariablesReference)
+	if len(globals) != 2 || globals[1].Name != "nums" || globals[1].Type != "ARRAY" {
+		t.Fatalf("wrong globals. got=%+v", globals)
+	}
+
+	elements := c.variables(globals[1].VariablesReference)
+	if len(elements) != 2 || elements[0].Name != "[0]" || elements[1].Value != "2" {
+		t.Errorf("wrong array elements. got=%+v", elements)
+	}
+
+	var evaluated struct{ Result string }
+	c.decode(c.request("evaluate", EvaluateArguments{Expression: "b", FrameId: frames[0].Id}), &evaluated)
+	if evaluated.Result != "2" {
+		t.Errorf("wrong evaluate result. want=%q, got=%q", "2", evaluated.Result)
+	}
+
+	c.request("next", map[string]int{"threadId": threadId})
+	c.stopped("step")
+
+	if frames := c.stackTrace(); frames[0].Line != 3 {
+		t.Errorf("next stopped on the wrong line. want=3, got=%d", frames[0].Line)
+	}
+
+	c.request("continue", map[string]int{"threadId": threadId})
+
+	var exited struct{ ExitCode int }
+	c.decode(c.event("exited"), &exited)
+	if exited.ExitCode != 0 {
+		t.Errorf("wrong exit code. want=0, got=%d", exited.ExitCode)
+	}
+	c.event("terminated")
+
+	c.disconnect()
+}
+
+func TestStopOnEntryAndStepIn(t *testing.T) {
+	path := writeProgram(t, program)
+	c := newClient(t)
+
+	c.launch(path, map[string]interface{}{"stopOnEntry": true})
+	c.stopped("entry")
+
+	if frames := c.stackTrace(); frames[0].Line != 1 {
+		t.Errorf("stopped on the wrong line. want=1, got=%d", frames[0].Line)
+	}
+
+	for _, line := range []int{5, 6, 7, 2} {
+		c.request("stepIn", map[string]int{"threadId": threadId})
+		c.stopped("step")
+
+		if frames := c.stackTrace(); frames[0].Line != line {
+			t.Errorf("stepped to the wrong line. want=%d, got=%d", line, frames[0].Line)
+		}
+	}
+
+	c.request("stepOut", map[string]int{"threadId": threadId})
+	c.event("terminated")
+
+	c.disconnect()
+}
+
+func TestRuntimeError(t *testing.T) {
+	path := writeProgram(t, "let f = fn(x) {\n  x / 0\n};\nf(1);\n")
+	c := newClient(t)
+
+	c.launch(path, nil)
+
+	var stopped struct{ Reason, Text string }
+	c.decode(c.event("stopped"), &stopped)
+	if stopped.Reason != "exception" || !strings.HasSuffix(stopped.Text, "main.rk:2:5: division by zero") {
+		t.Errorf("wrong stopped event. got=%+v", stopped)
+	}
+
+	// The failed program can still be looked at
+	if frames := c.stackTrace(); len(frames) != 2 || frames[0].Name != "f" {
+		t.Errorf("wrong frames after the error. got=%+v", frames)
+	}
+
+	c.request("continue", map[string]int{"threadId": threadId})
+
+	var exited struct{ ExitCode int }
+	c.decode(c.event("exited"), &exited)
+	if exited.ExitCode != 1 {
+		t.Errorf("wrong exit code. want=1, got=%d", exited.ExitCode)
+	}
+
+	c.disconnect()
+}
+
+func TestPause(t *testing.T) {
+	path := writeProgram(t, "let i = 0;\nwhile (true) {\n  i += 1;\n}\n")
+	c := newClient(t)
+
+	c.launch(path, nil)
+	c.request("pause", map[string]int{"threadId": threadId})
+	c.stopped("pause")
+
+	if frames := c.stackTrace(); len(frames) != 1 || frames[0].Line < 2 {
+		t.Errorf("paused somewhere unexpected. got=%+v", frames)
+	}
+
+	// Disconnecting stops a running program too
+	c.request("continue", map[string]int{"threadId": threadId})
+	c.disconnect()
+}
+
+func TestLaunchErrors(t *testing.T) {
+	c := newClient(t)
+	c.request("initialize", nil)
+
+	tests := []struct {
+		args     map[string]interface{}
+		expected string
+	}{
+		{map[string]interface{}{}, "launch needs a program"},
+		{map[string]interface{}{"program": writeProgram(t, "let = 1;")}, "could not compile"},
+	}
+
+	for _, tt := range tests {
+		msg := c.send("launch", tt.args)
+		if msg.Success || !strings.Contains(msg.Message, tt.expected) {
+			t.Errorf("wrong launch response. want failure with %q, got=%+v", tt.expected, msg)
+		}
+	}
+
+	if msg := c.send("stackTrace", nil); msg.Success {
+		t.Errorf("stackTrace succeeded before the program started")
+	}
+
+	if msg := c.send("frobnicate", nil); msg.Success {
+		t.Errorf("unknown request succeeded")
+	}
+
+	c.disconnect()
+}
@@ -0,0 +1,160 @@
+package diagnostic
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"rafiki/token"
+	"strings"
+)
+
+// An error tied to a place in the source, e.g. a compile error
+type Error struct {
+	Pos     token.Position
+	Message string
+}
+
+func Errorf(pos token.Position, format string, a ...interface{}) *Error {
+	return &Error{Pos: pos, Message: fmt.Sprintf(format, a...)}
+}
+
+func (e *Error) Error() string {
+	if !e.Pos.IsValid() {
+		return e.Message
+	}
+
+	return e.Pos.String() + ": " + e.Message
+}
+
+// Format renders a message the way the CLI and REPL print errors:
+//
+//	main.rk:3:9: undefined variable y
+//	    let x = y;
+//	            ^
+func Format(src string, pos token.Position, message string) string {
+	var out bytes.Buffer
+
+	if pos.IsValid() {
+		out.WriteString(pos.String() + ": ")
+	}
+	out.WriteString(message + "\n")
+	out.WriteString(Snippet(src, pos))
+
+	return out.String()
+}
+
+// Snippet returns the source line pos points into, followed by a caret under
+// the offending column. It's empty when pos is unknown or out of range, or
+// there's no source to show.
+func Snippet(src string, pos token.Position) string {
+	if !pos.IsValid() || src == "" {
+		return ""
+	}
+
+	lines := strings.Split(src, "\n")
+	if pos.Line > len(lines) {
+		return ""
+	}
+
+	line := strings.TrimRight(lines[pos.Line-1], "\r")
+
+	// Keep tabs so the caret lines up with the source line
+	var caret bytes.Buffer
+	for i := 0; i < pos.Column-1 && i < len(line); i++ {
+		if line[i] == '\t' {
+			caret.WriteByte('\t')
+		} else {
+			caret.WriteByte(' ')
+		}
+	}
+	for i := len(line); i < pos.Column-1; i++ {
+		caret.WriteByte(' ')
+	}
+	caret.WriteByte('^')
+
+	return fmt.Sprintf("    %s\n    %s\n", line, caret.String())
+}
+
+type Severity int
+
+const (
+	SeverityError Severity = iota + 1
+	SeverityWarning
+	SeverityInfo
+	SeverityHint
+)
+
+var severityNames = map[Severity]string{
+	SeverityError:   "error",
+	SeverityWarning: "warning",
+	SeverityInfo:    "info",
+	SeverityHint:    "hint",
+}
+
+func (s Severity) String() string {
+	if name, ok := severityNames[s]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+func (s Severity) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+func (s *Severity) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return err
+	}
+
+	for severity, n := range severityNames {
+		if n == name {
+			*s = severity
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown severity %q", name)
+}
+
+// A machine-readable problem report, e.g. a parse error. Code is a stable
+// identifier tools can match on; Hints are optional suggestions for a fix.
+type Diagnostic struct {
+	Severity Severity   `json:"severity"`
+	Code     string     `json:"code"`
+	Span     token.Span `json:"span"`
+	Message  string     `json:"message"`
+	Hints    []string   `json:"hints,omitempty"`
+}
+
+func (d *Diagnostic) Pos() token.Position { return d.Span.Start }
+
+// Diagnostics are errors too: file:line:col: message
+func (d *Diagnostic) Error() string {
+	if !d.Span.Start.IsValid() {
+		return d.Message
+	}
+
+	return d.Span.Start.String() + ": " + d.Message
+}
+
+// Render a diagnostic for humans, with its code, a source snippet and hints:
+//
+//	main.rk:1:5: error[P001]: expected next token to be IDENT, got = instead
+//	    let = 5;
+//	        ^
+//	    hint: a let statement needs a name, like `let x = 5;`
+func Render(src string, d *Diagnostic) string {
+	var out bytes.Buffer
+
+	message := fmt.Sprintf("%s[%s]: %s", d.Severity, d.Code, d.Message)
+	out.WriteString(Format(src, d.Span.Start, message))
+
+	for _, hint := range d.Hints {
+		out.WriteString("    hint: " + hint + "\n")
+	}
+
+	return out.String()
+}
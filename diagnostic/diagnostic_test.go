@@ -0,0 +1,71 @@
+package diagnostic
+
+import (
+	"rafiki/token"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	src := "let a = 1;\nlet x = y;\n"
+	pos := token.Position{Filename: "main.rk", Offset: 19, Line: 2, Column: 9}
+
+	expected := "main.rk:2:9: undefined variable y\n" +
+		"    let x = y;\n" +
+		"            ^\n"
+
+	got := Format(src, pos, "undefined variable y")
+	if got != expected {
+		t.Errorf("wrong format.\nwant=%q\ngot=%q", expected, got)
+	}
+}
+
+func TestSnippetKeepsTabs(t *testing.T) {
+	src := "\tfoo(1,\t2)"
+	pos := token.Position{Line: 1, Column: 9}
+
+	expected := "    \tfoo(1,\t2)\n    \t      \t^\n"
+
+	got := Snippet(src, pos)
+	if got != expected {
+		t.Errorf("wrong snippet.\nwant=%q\ngot=%q", expected, got)
+	}
+}
+
+func TestErrorString(t *testing.T) {
+	err := Errorf(token.Position{Line: 3, Column: 4}, "unknown operator %s", "^")
+	if err.Error() != "3:4: unknown operator ^" {
+		t.Errorf("wrong error string. got=%q", err.Error())
+	}
+
+	err = Errorf(token.Position{}, "no position")
+	if err.Error() != "no position" {
+		t.Errorf("wrong error string. got=%q", err.Error())
+	}
+}
+
+func TestRender(t *testing.T) {
+	src := "let = 5;"
+	d := &Diagnostic{
+		Severity: SeverityError,
+		Code:     "P001",
+		Span: token.Span{
+			Start: token.Position{Filename: "main.rk", Offset: 4, Line: 1, Column: 5},
+			End:   token.Position{Filename: "main.rk", Offset: 5, Line: 1, Column: 6},
+		},
+		Message: "expected next token to be IDENT, got = instead",
+		Hints:   []string{"add a name"},
+	}
+
+	expected := "main.rk:1:5: error[P001]: expected next token to be IDENT, got = instead\n" +
+		"    let = 5;\n" +
+		"        ^\n" +
+		"    hint: add a name\n"
+
+	if got := Render(src, d); got != expected {
+		t.Errorf("wrong rendering.\nwant=%q\ngot=%q", expected, got)
+	}
+
+	if d.Error() != "main.rk:1:5: expected next token to be IDENT, got = instead" {
+		t.Errorf("wrong error string. got=%q", d.Error())
+	}
+}
@@ -0,0 +1,221 @@
+package disasm
+
+import (
+	"bytes"
+	"fmt"
+	"rafiki/code"
+	"rafiki/compiler"
+	"rafiki/object"
+	"sort"
+)
+
+// Opcodes whose first operand is an offset into the same instructions
+var jumps = map[code.Opcode]bool{
+	code.OpJump:          true,
+	code.OpJumpNotTruthy: true,
+	code.OpTry:           true,
+}
+
+/*
+Disassemble lists the constant pool, then the main program, then every
+compiled function in the pool. Closures nested in other functions are
+compiled into the same pool, so this covers all of them. e.g.
+
+	constants:
+	      0  INTEGER   1
+	      1  FUNCTION  inc (params: 1, locals: 1, free: 0)
+
+	main:
+	    0000  OpClosure 1 0            ; inc
+	    0004  OpSetGlobal 0
+	  ...
+
+	inc (constant 1, params: 1, locals: 1, free: 0):
+	    0000  OpGetLocal 0
+	  ...
+
+Jump targets are shown as labels, and operands that refer to a constant or
+builtin are followed by what they refer to.
+*/
+func Disassemble(bc *compiler.Bytecode) string {
+	d := &disassembler{constants: bc.Constants, builtins: bc.Builtins, free: map[int]int{}}
+	if d.builtins == nil {
+		d.builtins = object.DefaultBuiltins().Names()
+	}
+
+	d.countFree(bc.Instructions)
+	for _, constant := range bc.Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			d.countFree(fn.Instructions)
+		}
+	}
+
+	d.constantPool()
+
+	d.out.WriteString("\nmain:\n")
+	d.instructions(bc.Instructions)
+
+	for i, constant := range bc.Constants {
+		fn, ok := constant.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+
+		fmt.Fprintf(&d.out, "\n%s (constant %d, %s):\n", functionName(fn), i, d.counts(i, fn))
+		d.instructions(fn.Instructions)
+	}
+
+	return d.out.String()
+}
+
+type disassembler struct {
+	out       bytes.Buffer
+	constants []object.Object
+	builtins  []string
+	free      map[int]int // Free variable counts by constant index, from the OpClosures creating them
+}
+
+func (d *disassembler) countFree(ins code.Instructions) {
+	d.each(ins, func(offset int, instruction code.Instruction) {
+		if instruction.Op == code.OpClosure {
+			d.free[instruction.Operands[0]] = instruction.Operands[1]
+		}
+	})
+}
+
+// Call f for every instruction, stopping at the first unknown opcode. Returns
+// the offset it stopped at, or -1 if it got through them all.
+func (d *disassembler) each(ins code.Instructions, f func(offset int, instruction code.Instruction)) int {
+	for offset := 0; offset < len(ins); {
+		instruction, err := code.Decode(ins[offset:])
+		if err != nil {
+			return offset
+		}
+
+		f(offset, instruction)
+
+		offset += instruction.Length
+	}
+
+	return -1
+}
+
+func (d *disassembler) constantPool() {
+	d.out.WriteString("constants:\n")
+
+	if len(d.constants) == 0 {
+		d.out.WriteString("    (none)\n")
+	}
+
+	for i, constant := range d.constants {
+		switch constant := constant.(type) {
+		case *object.CompiledFunction:
+			fmt.Fprintf(&d.out, "    %3d  FUNCTION  %s (%s)\n", i, functionName(constant), d.counts(i, constant))
+		default:
+			fmt.Fprintf(&d.out, "    %3d  %-8s  %s\n", i, constant.Type(), d.value(i))
+		}
+	}
+}
+
+func (d *disassembler) counts(index int, fn *object.CompiledFunction) string {
+	return fmt.Sprintf("params: %d, locals: %d, free: %d", fn.NumParameters, fn.NumLocals, d.free[index])
+}
+
+func (d *disassembler) instructions(ins code.Instructions) {
+	labels := d.labels(ins)
+
+	stop := d.each(ins, func(offset int, instruction code.Instruction) {
+		if label, ok := labels[offset]; ok {
+			fmt.Fprintf(&d.out, "  %s:\n", label)
+		}
+
+		op, operands := instruction.Op, instruction.Operands
+		text := instruction.Def.Name
+		for i, operand := range operands {
+			if i == 0 && jumps[op] {
+				text += " " + labels[operand]
+			} else {
+				text += fmt.Sprintf(" %d", operand)
+			}
+		}
+
+		if comment := d.comment(op, operands); comment != "" {
+			text = fmt.Sprintf("%-24s ; %s", text, comment)
+		}
+
+		fmt.Fprintf(&d.out, "    %04d  %s\n", offset, text)
+	})
+
+	// A jump past the last instruction lands at the end
+	if label, ok := labels[len(ins)]; ok {
+		fmt.Fprintf(&d.out, "  %s:\n", label)
+	}
+
+	if stop >= 0 {
+		fmt.Fprintf(&d.out, "    %04d  ERROR: cannot decode opcode %d\n", stop, ins[stop])
+	}
+}
+
+// Name each jump target L1, L2, ... from the top
+func (d *disassembler) labels(ins code.Instructions) map[int]string {
+	var targets []int
+	seen := map[int]bool{}
+
+	d.each(ins, func(offset int, instruction code.Instruction) {
+		if !jumps[instruction.Op] {
+			return
+		}
+
+		if target := instruction.Operands[0]; !seen[target] {
+			seen[target] = true
+			targets = append(targets, target)
+		}
+	})
+
+	sort.Ints(targets)
+
+	labels := make(map[int]string, len(targets))
+	for i, target := range targets {
+		labels[target] = fmt.Sprintf("L%d", i+1)
+	}
+
+	return labels
+}
+
+// What an operand refers to, for the instructions where that isn't obvious
+func (d *disassembler) comment(op code.Opcode, operands []int) string {
+	switch op {
+	case code.OpConstant, code.OpClosure:
+		return d.value(operands[0])
+
+	case code.OpGetBuiltin:
+		if operands[0] < len(d.builtins) {
+			return d.builtins[operands[0]]
+		}
+	}
+
+	return ""
+}
+
+func (d *disassembler) value(index int) string {
+	if index >= len(d.constants) {
+		return "<missing constant>"
+	}
+
+	switch constant := d.constants[index].(type) {
+	case *object.String:
+		return fmt.Sprintf("%q", constant.Value)
+	case *object.CompiledFunction:
+		return functionName(constant)
+	default:
+		return constant.Inspect()
+	}
+}
+
+func functionName(fn *object.CompiledFunction) string {
+	if fn.Name == "" {
+		return "<anonymous>"
+	}
+
+	return fn.Name
+}
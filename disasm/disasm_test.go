@@ -0,0 +1,103 @@
+package disasm
+
+import (
+	"rafiki/code"
+	"rafiki/compiler"
+	"rafiki/lexer"
+	"rafiki/parser"
+	"strings"
+	"testing"
+)
+
+func compile(t *testing.T, input string) *compiler.Bytecode {
+	t.Helper()
+
+	p := parser.NewParser(lexer.NewLexer(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+
+	comp := compiler.NewCompiler()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	return comp.Bytecode()
+}
+
+func TestDisassemble(t *testing.T) {
+	input := `
+let newAdder = fn(a) { fn(b) { a + b } };
+if (len("ab") > 1) { newAdder(1) } else { 2.5 };
+`
+
+	expected := `constants:
+      0  FUNCTION  <anonymous> (params: 1, locals: 1, free: 1)
+      1  FUNCTION  newAdder (params: 1, locals: 1, free: 0)
+      2  STRING    "ab"
+      3  INTEGER   1
+      4  INTEGER   1
+      5  FLOAT     2.5
+
+main:
+    0000  OpClosure 1 0            ; newAdder
+    0004  OpSetGlobal 0
+    0007  OpGetBuiltin 0           ; len
+    0009  OpConstant 2             ; "ab"
+    0012  OpCall 1
+    0014  OpConstant 3             ; 1
+    0017  OpGreaterThan
+    0018  OpJumpNotTruthy L1
+    0021  OpGetGlobal 0
+    0024  OpConstant 4             ; 1
+    0027  OpCall 1
+    0029  OpJump L2
+  L1:
+    0032  OpConstant 5             ; 2.5
+  L2:
+    0035  OpPop
+
+<anonymous> (constant 0, params: 1, locals: 1, free: 1):
+    0000  OpGetFree 0
+    0002  OpGetLocal 0
+    0004  OpAdd
+    0005  OpReturnValue
+
+newAdder (constant 1, params: 1, locals: 1, free: 0):
+    0000  OpGetLocalCell 0
+    0002  OpClosure 0 1            ; <anonymous>
+    0006  OpReturnValue
+`
+
+	actual := Disassemble(compile(t, input))
+	if actual != expected {
+		t.Errorf("wrong listing.\nwant=\n%s\ngot=\n%s", expected, actual)
+	}
+}
+
+func TestLabelAtEnd(t *testing.T) {
+	bc := &compiler.Bytecode{
+		Instructions: append(code.Make(code.OpTrue), code.Make(code.OpJumpNotTruthy, 4)...),
+	}
+
+	actual := Disassemble(bc)
+	if !strings.HasSuffix(actual, "    0000  OpTrue\n    0001  OpJumpNotTruthy L1\n  L1:\n") {
+		t.Errorf("jump past the end is not labelled. got=\n%s", actual)
+	}
+}
+
+func TestUndecodableInstructions(t *testing.T) {
+	bc := &compiler.Bytecode{
+		Instructions: append(code.Make(code.OpTrue), 255, 0),
+	}
+
+	actual := Disassemble(bc)
+	if !strings.Contains(actual, "    0000  OpTrue\n    0001  ERROR: cannot decode opcode 255\n") {
+		t.Errorf("bad opcode not reported. got=\n%s", actual)
+	}
+
+	if !strings.Contains(actual, "constants:\n    (none)\n") {
+		t.Errorf("empty constant pool not reported. got=\n%s", actual)
+	}
+}
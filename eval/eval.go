@@ -1,19 +1,37 @@
 package eval
 
 import (
+	"context"
 	"fmt"
+	"math"
 	"rafiki/ast"
 	"rafiki/object"
+	"rafiki/token"
+	"strings"
+	"sync"
 )
 
+// How deeply calls can nest before the evaluator reports a stack overflow,
+// rather than running out of Go stack
+const MaxCallDepth = 10000
+
 var (
 	NULL  = &object.Null{}
 	TRUE  = &object.Boolean{Value: true}
 	FALSE = &object.Boolean{Value: false}
+
+	BREAK    = &object.Break{}
+	CONTINUE = &object.Continue{}
 )
 
 // Call recusively while swinging through the tree
 func Eval(node ast.Node, env *object.Environment) object.Object {
+	if limiter := env.Limiter(); limiter != nil {
+		if err := limiter.Step(); err != nil {
+			return withPosition(&object.Exception{Error: err}, node, env)
+		}
+	}
+
 	switch node := node.(type) {
 
 	// Base case, top node of the program or a top node of a block
@@ -34,13 +52,13 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			return right
 		}
 
-		return evalPrefixExpression(node.Operator, right)
+		return withPosition(evalPrefixExpression(node.Operator, right), node, env)
 
 	case *ast.FunctionLiteral:
 		params := node.Parameters
 		body := node.Body
 
-		return &object.Function{Parameters: params, FunctionEnv: env, Body: body}
+		return &object.Function{Name: node.Name, Parameters: params, FunctionEnv: env, Body: body}
 
 	case *ast.CallExpression:
 		if node.Function.TokenLiteral() == "quote" {
@@ -58,9 +76,17 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			return args[0]
 		}
 
-		return applyFunction(function, args)
+		if fn, ok := function.(*object.Function); ok && len(args) == len(fn.Parameters) && isTailCall(node, env) {
+			return &object.TailCall{Function: fn, Arguments: args}
+		}
+
+		return withPosition(applyFunction(function, args, env, node.Pos()), node, env)
 
 	case *ast.InfixExpression:
+		if node.Operator == "&&" || node.Operator == "||" {
+			return evalLogicalExpression(node, env)
+		}
+
 		left := Eval(node.Left, env)
 
 		if isError(left) {
@@ -73,11 +99,37 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			return right
 		}
 
-		return evalInfixExpression(node.Operator, left, right)
+		return withPosition(evalInfixExpression(env.Limiter(), node.Operator, left, right), node, env)
 
 	case *ast.IfExpression:
 		return evalIfExpression(node, env)
 
+	case *ast.TryExpression:
+		return evalTryExpression(node, env)
+
+	case *ast.ThrowStatement:
+		val := Eval(node.Value, env)
+		if isError(val) {
+			return val
+		}
+
+		return withPosition(&object.Exception{Error: object.ThrownValue(val)}, node, env)
+
+	case *ast.AssignExpression:
+		return withPosition(evalAssignExpression(node, env), node, env)
+
+	case *ast.WhileStatement:
+		return evalWhileStatement(node, env)
+
+	case *ast.ForStatement:
+		return evalForStatement(node, env)
+
+	case *ast.BreakStatement:
+		return BREAK
+
+	case *ast.ContinueStatement:
+		return CONTINUE
+
 	case *ast.ReturnStatement:
 		val := Eval(node.ReturnValue, env)
 
@@ -99,11 +151,14 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	case *ast.IntegerLiteral:
 		return &object.Integer{Value: node.Value}
 
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
+
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
 
 	case *ast.Identifier:
-		return evalIdentifier(node, env)
+		return withPosition(evalIdentifier(node, env), node, env)
 
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
@@ -115,6 +170,10 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			return elements[0]
 		}
 
+		if err := env.Limiter().Allocate(len(elements)); err != nil {
+			return withPosition(&object.Exception{Error: err}, node, env)
+		}
+
 		return &object.Array{Elements: elements}
 
 	case *ast.IndexExpression:
@@ -126,10 +185,13 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if isError(index) {
 			return index
 		}
-		return evalIndexExpression(left, index)
+		return withPosition(evalIndexExpression(left, index), node, env)
 
 	case *ast.HashLiteral:
-		return evalHashLiteral(node, env)
+		return withPosition(evalHashLiteral(node, env), node, env)
+
+	case *ast.ImportExpression:
+		return withPosition(evalImportExpression(node, env), node, env)
 
 	default:
 		fmt.Printf("node: %v\n", node)
@@ -138,6 +200,32 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	return NULL
 }
 
+/*
+EvalWithLimits evaluates node like Eval, but stops once ctx is done or the
+program goes past limits, with an error of the matching kind. Those errors
+unwrap to ctx's error or one of object.ErrStepLimit, ErrAllocationLimit and
+ErrRecursionLimit.
+*/
+func EvalWithLimits(
+	ctx context.Context,
+	node ast.Node,
+	env *object.Environment,
+	limits object.Limits,
+) object.Object {
+	limiter := object.NewLimiter(ctx, limits)
+	if err := limiter.Canceled(); err != nil {
+		return &object.Exception{Error: err}
+	}
+
+	previous := env.Limiter()
+	env.SetLimiter(limiter)
+	defer env.SetLimiter(previous)
+
+	return Eval(node, env)
+}
+
+// An error nobody caught stays an *object.Exception, so it can be told apart
+// from a program whose value is an error it caught
 func evalProgram(statements []ast.Statement, env *object.Environment) object.Object {
 	var result object.Object
 
@@ -147,7 +235,7 @@ func evalProgram(statements []ast.Statement, env *object.Environment) object.Obj
 		switch result := result.(type) {
 		case *object.ReturnValue:
 			return result.Value
-		case *object.Error:
+		case *object.Exception:
 			return result
 		}
 	}
@@ -184,12 +272,18 @@ func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) obje
 		if result != nil {
 			rt := result.Type()
 
-			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
+			if rt == object.RETURN_VALUE_OBJ || rt == object.EXCEPTION_OBJ ||
+				rt == object.BREAK_OBJ || rt == object.CONTINUE_OBJ {
 				return result
 			}
 		}
 	}
 
+	// An empty block, like `if (x) {}`, is null just as it is in the VM
+	if result == nil {
+		return NULL
+	}
+
 	return result
 }
 
@@ -204,7 +298,12 @@ func evalPrefixExpression(operator string, right object.Object) object.Object {
 	}
 }
 
-func evalInfixExpression(operator string, left object.Object, right object.Object) object.Object {
+func evalInfixExpression(
+	limiter *object.Limiter,
+	operator string,
+	left object.Object,
+	right object.Object,
+) object.Object {
 	leftType := left.Type()
 	rightType := right.Type()
 
@@ -212,8 +311,12 @@ func evalInfixExpression(operator string, left object.Object, right object.Objec
 	case leftType == rightType && leftType == object.INTEGER_OBJ:
 		return evalIntegerInfixExpression(operator, left, right)
 
+	// Mixing an integer with a float promotes the integer
+	case isNumber(left) && isNumber(right):
+		return evalFloatInfixExpression(operator, left, right)
+
 	case leftType == rightType && leftType == object.STRING_OBJ:
-		return evalStringInfixExpression(operator, left, right)
+		return evalStringInfixExpression(limiter, operator, left, right)
 
 	case operator == "==":
 		return nativeBoolToBooleanObject(left == right)
@@ -243,12 +346,65 @@ func evalIntegerInfixExpression(operator string, left object.Object, right objec
 	case "*":
 		return &object.Integer{Value: leftVal * rightVal}
 	case "/":
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
 		return &object.Integer{Value: leftVal / rightVal}
+	case "%":
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
+		return &object.Integer{Value: leftVal % rightVal}
+
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	default:
+		return newError("unknown operator: %s %s %s",
+			left.Type(), operator, right.Type())
+
+	}
+}
+
+func evalFloatInfixExpression(operator string, left object.Object, right object.Object) object.Object {
+	leftVal := toFloat(left)
+	rightVal := toFloat(right)
+
+	switch operator {
+	case "+":
+		return &object.Float{Value: leftVal + rightVal}
+	case "-":
+		return &object.Float{Value: leftVal - rightVal}
+	case "*":
+		return &object.Float{Value: leftVal * rightVal}
+	case "/":
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
+		return &object.Float{Value: leftVal / rightVal}
+	case "%":
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
+		return &object.Float{Value: math.Mod(leftVal, rightVal)}
 
 	case ">":
 		return nativeBoolToBooleanObject(leftVal > rightVal)
 	case "<":
 		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
 	case "==":
 		return nativeBoolToBooleanObject(leftVal == rightVal)
 	case "!=":
@@ -256,11 +412,48 @@ func evalIntegerInfixExpression(operator string, left object.Object, right objec
 	default:
 		return newError("unknown operator: %s %s %s",
 			left.Type(), operator, right.Type())
+	}
+}
+
+// && and || only evaluate their right side when the left doesn't already
+// decide the result. Either way the result is a boolean.
+func evalLogicalExpression(node *ast.InfixExpression, env *object.Environment) object.Object {
+	left := Eval(node.Left, env)
+	if isError(left) {
+		return left
+	}
+
+	if node.Operator == "&&" && !isTruthy(left) {
+		return FALSE
+	}
 
+	if node.Operator == "||" && isTruthy(left) {
+		return TRUE
+	}
+
+	right := Eval(node.Right, env)
+	if isError(right) {
+		return right
 	}
+
+	return nativeBoolToBooleanObject(isTruthy(right))
+}
+
+func isNumber(obj object.Object) bool {
+	return obj.Type() == object.INTEGER_OBJ || obj.Type() == object.FLOAT_OBJ
+}
+
+// The value of an integer or float as a float64
+func toFloat(obj object.Object) float64 {
+	if i, ok := obj.(*object.Integer); ok {
+		return float64(i.Value)
+	}
+
+	return obj.(*object.Float).Value
 }
 
 func evalStringInfixExpression(
+	limiter *object.Limiter,
 	operator string,
 	left, right object.Object,
 ) object.Object {
@@ -274,6 +467,10 @@ func evalStringInfixExpression(
 	leftVal := left.(*object.String).Value
 	rightVal := right.(*object.String).Value
 
+	if err := limiter.Allocate(len(leftVal) + len(rightVal)); err != nil {
+		return &object.Exception{Error: err}
+	}
+
 	concat := leftVal + rightVal
 
 	return &object.String{Value: concat}
@@ -293,12 +490,14 @@ func evalBangOperatorExpression(right object.Object) object.Object {
 }
 
 func evalNegatePrefixOperatorExpression(right object.Object) object.Object {
-	if right.Type() != object.INTEGER_OBJ {
+	switch right := right.(type) {
+	case *object.Integer:
+		return &object.Integer{Value: -right.Value}
+	case *object.Float:
+		return &object.Float{Value: -right.Value}
+	default:
 		return newError("unknown operator: -%s", right.Type())
 	}
-
-	value := right.(*object.Integer).Value
-	return &object.Integer{Value: -value}
 }
 
 func nativeBoolToBooleanObject(input bool) *object.Boolean {
@@ -328,6 +527,83 @@ func evalIfExpression(expr *ast.IfExpression, env *object.Environment) object.Ob
 	return NULL
 }
 
+// Run the body, and if it throws, bind the error and run the handler instead
+func evalTryExpression(node *ast.TryExpression, env *object.Environment) object.Object {
+	result := Eval(node.Body, env)
+
+	exception, ok := result.(*object.Exception)
+	if !ok || !exception.Error.Catchable() {
+		return result
+	}
+
+	env.Set(node.Param.Value, exception.Error)
+
+	return Eval(node.Handler, env)
+}
+
+func evalWhileStatement(node *ast.WhileStatement, env *object.Environment) object.Object {
+	for {
+		condition := Eval(node.Condition, env)
+		if isError(condition) {
+			return condition
+		}
+
+		if !isTruthy(condition) {
+			return NULL
+		}
+
+		if result, done := evalLoopBody(node.Body, env); done {
+			return result
+		}
+	}
+}
+
+func evalForStatement(node *ast.ForStatement, env *object.Environment) object.Object {
+	if node.Init != nil {
+		if init := Eval(node.Init, env); isError(init) {
+			return init
+		}
+	}
+
+	for {
+		if node.Condition != nil {
+			condition := Eval(node.Condition, env)
+			if isError(condition) {
+				return condition
+			}
+
+			if !isTruthy(condition) {
+				return NULL
+			}
+		}
+
+		if result, done := evalLoopBody(node.Body, env); done {
+			return result
+		}
+
+		if node.Post != nil {
+			if post := Eval(node.Post, env); isError(post) {
+				return post
+			}
+		}
+	}
+}
+
+// Run one iteration. Returns done when the loop should stop, along with the
+// value the loop statement should produce.
+func evalLoopBody(body *ast.BlockStatement, env *object.Environment) (object.Object, bool) {
+	result := Eval(body, env)
+
+	switch result.Type() {
+	case object.RETURN_VALUE_OBJ, object.EXCEPTION_OBJ:
+		return result, true
+	case object.BREAK_OBJ:
+		return NULL, true
+	}
+
+	return nil, false
+}
+
 func isTruthy(o object.Object) bool {
 	switch o {
 	case NULL:
@@ -341,13 +617,48 @@ func isTruthy(o object.Object) bool {
 	}
 }
 
-func newError(format string, a ...interface{}) *object.Error {
-	return &object.Error{Message: fmt.Sprintf(format, a...)}
+func newError(format string, a ...interface{}) *object.Exception {
+	err := &object.Error{Message: fmt.Sprintf(format, a...), Kind: object.RuntimeError}
+
+	return &object.Exception{Error: err}
+}
+
+// Errors are raised without a position; the innermost node they pass through
+// claims them, and records the calls that led there
+func withPosition(obj object.Object, node ast.Node, env *object.Environment) object.Object {
+	exception, ok := obj.(*object.Exception)
+	if !ok {
+		return obj
+	}
+
+	err := exception.Error
+	if !err.Pos.IsValid() {
+		err.Pos = node.Pos()
+	}
+
+	if err.Stack == nil {
+		err.Stack = stackTrace(err.Pos, env)
+	}
+
+	return obj
+}
+
+// The frames of the calls active in env, starting from pos in the innermost
+func stackTrace(pos token.Position, env *object.Environment) []object.StackFrame {
+	var stack []object.StackFrame
+
+	for call := env.Call(); call != nil; call = call.Caller.Call() {
+		stack = append(stack, object.StackFrame{Function: call.Function, Pos: pos})
+		pos = call.Pos
+	}
+
+	return append(stack, object.StackFrame{Function: "<main>", Pos: pos})
 }
 
+// Whether o is an error being thrown
 func isError(o object.Object) bool {
 	if o != nil {
-		return o.Type() == object.ERROR_OBJ
+		return o.Type() == object.EXCEPTION_OBJ
 	}
 
 	return false
@@ -358,7 +669,7 @@ func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object
 		return val
 	}
 
-	if builtin, ok := builtins[node.Value]; ok {
+	if builtin, ok := env.Builtins().Lookup(node.Value); ok {
 		return builtin
 	}
 
@@ -382,22 +693,63 @@ func evalExpressions(
 	return result
 }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
+// Call fn from the caller environment, at pos
+func applyFunction(
+	fn object.Object,
+	args []object.Object,
+	caller *object.Environment,
+	pos token.Position,
+) object.Object {
 	switch fn := fn.(type) {
 
 	case *object.Function:
-		extendedEnv := extendFunctionEnv(fn, args)
-		evaluated := Eval(fn.Body, extendedEnv)
+		if len(args) != len(fn.Parameters) {
+			return newError("wrong number of arguments: want=%d, got=%d",
+				len(fn.Parameters), len(args))
+		}
+
+		for {
+			extendedEnv := extendFunctionEnv(fn, args, caller, pos)
+			depth := extendedEnv.Call().Depth
+
+			if err := extendedEnv.Limiter().Enter(depth); err != nil {
+				return &object.Exception{Error: err}
+			}
+
+			if depth > MaxCallDepth {
+				return newError("stack overflow")
+			}
 
-		return unwrapReturnValue(evaluated)
+			evaluated := unwrapReturnValue(Eval(fn.Body, extendedEnv))
+
+			// A tail call takes over this call, the way it takes over the
+			// frame in the VM, so tail recursion doesn't nest
+			tail, ok := evaluated.(*object.TailCall)
+			if !ok {
+				return evaluated
+			}
+
+			fn, args = tail.Function, tail.Arguments
+		}
 
 	case *object.Builtin:
-		if result := fn.Fn(args...); result != nil {
+		result := fn.Fn(args...)
+
+		switch result := result.(type) {
+		case nil:
+			return NULL
+		case *object.Error:
+			if result.Kind == "" {
+				result.Kind = object.RuntimeError
+			}
+			return &object.Exception{Error: result}
+		default:
+			if err := caller.Limiter().Allocated(result); err != nil {
+				return &object.Exception{Error: err}
+			}
 			return result
 		}
 
-		return NULL
-
 	default:
 		return newError("not a function: %s", fn.Type())
 	}
@@ -406,8 +758,27 @@ func applyFunction(fn object.Object, args []object.Object) object.Object {
 func extendFunctionEnv(
 	fn *object.Function,
 	args []object.Object,
+	caller *object.Environment,
+	pos token.Position,
 ) *object.Environment {
-	env := object.NewEnclosedEnvironment(fn.FunctionEnv)
+	name := fn.Name
+	if name == "" {
+		name = "<anonymous>"
+	}
+
+	depth := 1
+	if outer := caller.Call(); outer != nil {
+		depth = outer.Depth + 1
+	}
+
+	call := &object.Call{
+		Depth:     depth,
+		Function:  name,
+		Pos:       pos,
+		Caller:    caller,
+		TailCalls: tailCalls(fn.Body),
+	}
+	env := object.NewCallEnvironment(fn.FunctionEnv, call)
 
 	for paramIdx, param := range fn.Parameters {
 		env.Set(param.Value, args[paramIdx])
@@ -416,6 +787,24 @@ func extendFunctionEnv(
 	return env
 }
 
+// Function bodies' tail calls, found once for each body
+var tailCallSets sync.Map
+
+func tailCalls(body *ast.BlockStatement) map[*ast.CallExpression]bool {
+	if calls, ok := tailCallSets.Load(body); ok {
+		return calls.(map[*ast.CallExpression]bool)
+	}
+
+	calls, _ := tailCallSets.LoadOrStore(body, ast.TailCalls(body))
+	return calls.(map[*ast.CallExpression]bool)
+}
+
+// Whether node is in tail position in the function env is running
+func isTailCall(node *ast.CallExpression, env *object.Environment) bool {
+	call := env.Call()
+	return call != nil && call.TailCalls[node]
+}
+
 func unwrapReturnValue(obj object.Object) object.Object {
 	if returnValue, ok := obj.(*object.ReturnValue); ok {
 		return returnValue.Value
@@ -424,6 +813,139 @@ func unwrapReturnValue(obj object.Object) object.Object {
 	return obj
 }
 
+func evalAssignExpression(node *ast.AssignExpression, env *object.Environment) object.Object {
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		return evalIdentifierAssignment(target, node, env)
+	case *ast.IndexExpression:
+		return evalIndexAssignment(target, node, env)
+	default:
+		return newError("cannot assign to %s", node.Target.String())
+	}
+}
+
+// The infix operator a compound assignment applies, e.g. + for +=.
+// Empty for a plain =.
+func compoundOperator(assign string) string {
+	return strings.TrimSuffix(assign, "=")
+}
+
+func evalIdentifierAssignment(
+	target *ast.Identifier,
+	node *ast.AssignExpression,
+	env *object.Environment,
+) object.Object {
+	name := target.Value
+
+	current, ok := env.Get(name)
+	if !ok {
+		if _, ok := env.Builtins().Lookup(name); ok {
+			return newError("cannot assign to builtin %s", name)
+		}
+
+		return newError("identifier not found: " + name)
+	}
+
+	// Rejected like the compiler rejects it, which can't reassign a function
+	// from inside its own body
+	if env.IsFunctionName(name) {
+		return newError("cannot assign to %s inside its own body", name)
+	}
+
+	value := Eval(node.Value, env)
+	if isError(value) {
+		return value
+	}
+
+	if operator := compoundOperator(node.Operator); operator != "" {
+		value = evalInfixExpression(env.Limiter(), operator, current, value)
+		if isError(value) {
+			return value
+		}
+	}
+
+	env.Assign(name, value)
+
+	return value
+}
+
+func evalIndexAssignment(
+	target *ast.IndexExpression,
+	node *ast.AssignExpression,
+	env *object.Environment,
+) object.Object {
+	left := Eval(target.Left, env)
+	if isError(left) {
+		return left
+	}
+
+	index := Eval(target.Index, env)
+	if isError(index) {
+		return index
+	}
+
+	operator := compoundOperator(node.Operator)
+
+	// Read the current element before evaluating the value, like the VM does
+	var current object.Object
+	if operator != "" {
+		current = evalIndexExpression(left, index)
+		if isError(current) {
+			return current
+		}
+	}
+
+	value := Eval(node.Value, env)
+	if isError(value) {
+		return value
+	}
+
+	if operator != "" {
+		value = evalInfixExpression(env.Limiter(), operator, current, value)
+		if isError(value) {
+			return value
+		}
+	}
+
+	return setIndex(env.Limiter(), left, index, value)
+}
+
+func setIndex(limiter *object.Limiter, left, index, value object.Object) object.Object {
+	switch {
+
+	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		arrayObject := left.(*object.Array)
+		idx := index.(*object.Integer).Value
+
+		if idx < 0 || idx >= int64(len(arrayObject.Elements)) {
+			return newError("index out of range: %d", idx)
+		}
+
+		arrayObject.Elements[idx] = value
+
+	case left.Type() == object.HASH_OBJ:
+		hashObject := left.(*object.Hash)
+
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return newError("unusable as hash key: %s", index.Type())
+		}
+
+		if _, ok := hashObject.Pairs[key.HashKey()]; !ok {
+			if err := limiter.Allocate(len(hashObject.Pairs) + 1); err != nil {
+				return &object.Exception{Error: err}
+			}
+		}
+
+		hashObject.Pairs[key.HashKey()] = object.HashPair{Key: index, Value: value}
+
+	default:
+		return newError("index assignment not supported: %s", left.Type())
+	}
+
+	return value
+}
+
 func evalIndexExpression(left, index object.Object) object.Object {
 	switch {
 
@@ -433,6 +955,19 @@ func evalIndexExpression(left, index object.Object) object.Object {
 	case left.Type() == object.HASH_OBJ:
 		return evalHashIndexExpression(left, index)
 
+	case left.Type() == object.MODULE_OBJ && index.Type() == object.STRING_OBJ:
+		module, name := left.(*object.Module), index.(*object.String).Value
+		if value, ok := module.Exports[name]; ok {
+			return value
+		}
+		return newError("module %s has no binding %s", module.Name, name)
+
+	case left.Type() == object.ERROR_OBJ && index.Type() == object.STRING_OBJ:
+		if field := left.(*object.Error).Field(index.(*object.String).Value); field != nil {
+			return field
+		}
+		return NULL
+
 	default:
 		return newError("index operator not supported: %s", left.Type())
 	}
@@ -454,6 +989,10 @@ func evalHashLiteral(
 	node *ast.HashLiteral,
 	env *object.Environment,
 ) object.Object {
+	if err := env.Limiter().Allocate(len(node.Pairs)); err != nil {
+		return &object.Exception{Error: err}
+	}
+
 	pairs := make(map[object.HashKey]object.HashPair)
 
 	for keyNode, valueNode := range node.Pairs {
@@ -1,10 +1,13 @@
 package eval
 
 import (
+	"context"
+	"errors"
 	"rafiki/lexer"
 	"rafiki/object"
 	"rafiki/parser"
 	"testing"
+	"time"
 )
 
 func TestEvalIntegerExpression(t *testing.T) {
@@ -30,11 +33,96 @@ func TestEvalIntegerExpression(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		testIntegerObject(t, evaluated, tt.expected)
 	}
 }
 
+func TestEvalFloatExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"3.14", 3.14},
+		{"-2.5", -2.5},
+		{"1.5 + 1.5", 3.0},
+		{"1 + 0.5", 1.5},
+		{"0.5 * 4", 2.0},
+		{"7 / 2.0", 3.5},
+		{"10 - 2.5", 7.5},
+		{"let x = 1.0; x += 1; x /= 4; x", 0.5},
+		{"float(3)", 3.0},
+		{"float(\"2.25\")", 2.25},
+		{"round(3.14159, 2)", 3.14},
+		{"round(1250.0, -2)", 1300.0},
+		{"round(1.5, 400)", 1.5},
+		{"round(1e300, 100)", 1e300},
+		{"round(1.5, -400)", 0.0},
+		{"7 / 2", 3},
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"10 % 5 + 1", 1},
+		{"int(3.9)", 3},
+		{"int(-3.9)", -3},
+		{"int(\"42\")", 42},
+		{"round(2.5)", 3},
+		{"round(-2.5)", -3},
+		{"round(7)", 7},
+		{"floor(-1.5)", -2},
+		{"floor(2.9)", 2},
+		{"int(-9.2e18)", -9200000000000000000},
+		{"int(1e19)", "argument to `int` out of range, got 1e+19"},
+		{"round(-1e19)", "argument to `round` out of range, got -1e+19"},
+		{"floor(9.3e18)", "argument to `floor` out of range, got 9.3e+18"},
+		{"1 == 1.0", true},
+		{"1.5 != 1.5", false},
+		{"2 > 1.5", true},
+		{"1.5 < 1", false},
+		{"1 / 0", "division by zero"},
+		{"1.5 / 0", "division by zero"},
+		{"1 % 0", "division by zero"},
+		{"7.5 % 2", 1.5},
+		{"1.5 + true", "type mismatch: FLOAT + BOOLEAN"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+
+		switch expected := tt.expected.(type) {
+		case float64:
+			testFloatObject(t, evaluated, expected)
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			errObj, ok := uncaught(evaluated)
+			if !ok {
+				t.Errorf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func testFloatObject(t *testing.T, obj object.Object, expected float64) bool {
+	result, ok := obj.(*object.Float)
+	if !ok {
+		t.Errorf("object is not Float. got=%T (%+v)", obj, obj)
+		return false
+	}
+
+	if result.Value != expected {
+		t.Errorf("object has wrong value. got=%g, want=%g", result.Value, expected)
+		return false
+	}
+
+	return true
+}
+
 func TestEvalBooleanExpression(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -59,10 +147,28 @@ func TestEvalBooleanExpression(t *testing.T) {
 		{"(1 < 2) == false", false},
 		{"(1 > 2) == true", false},
 		{"(1 > 2) == false", true},
+		{"1 <= 2", true},
+		{"2 <= 2", true},
+		{"3 <= 2", false},
+		{"1 >= 2", false},
+		{"2 >= 2", true},
+		{"1.5 <= 1", false},
+		{"2 >= 1.5", true},
+		{"true && true", true},
+		{"true && false", false},
+		{"false || true", true},
+		{"false || false", false},
+		{"1 && \"a\"", true},
+		{"0 || false", true},
+		{"!(if (false) { 5; }) && 1 < 2", true},
+		{"false && 1 / 0", false},
+		{"true || 1 / 0", true},
+		{"1 < 2 && 2 < 3 || false", true},
+		{"let x = 0; let f = fn() { x = 1; true }; false && f(); x == 0", true},
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		testBooleanObject(t, evaluated, tt.expected)
 	}
 }
@@ -81,7 +187,7 @@ func TestBangOperator(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		testBooleanObject(t, evaluated, tt.expected)
 	}
 }
@@ -98,10 +204,11 @@ func TestIfElseExpressions(t *testing.T) {
 		{"if (1 > 2) { 10 }", nil},
 		{"if (1 > 2) { 10 } else { 20 }", 20},
 		{"if (1 < 2) { 10 } else { 20 }", 10},
+		{"if (true) {}", nil},
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		integer, ok := tt.expected.(int)
 		if ok {
 			testIntegerObject(t, evaluated, int64(integer))
@@ -111,6 +218,53 @@ func TestIfElseExpressions(t *testing.T) {
 	}
 }
 
+func TestLoops(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let i = 0; while (i < 5) { let i = i + 1; } i", 5},
+		{"let i = 0; while (false) { let i = i + 1; } i", 0},
+		{"let s = 0; for (let i = 0; i < 5; let i = i + 1) { let s = s + i; } s", 10},
+		{"let s = 0; for (let i = 0; i < 10; let i = i + 1) { if (i == 2) { continue; } if (i == 5) { break; } let s = s + i; } s", 8},
+		{"let i = 0; for (;;) { let i = i + 1; if (i > 3) { break; } } i", 4},
+		{"let f = fn(n) { let i = 0; while (true) { if (i == n) { return i * 10; } let i = i + 1; } }; f(4)", 40},
+		{"let n = 0; for (let i = 0; i < 3; let i = i + 1) { for (let j = 0; j < 3; let j = j + 1) { if (j == 1) { break; } let n = n + 1; } } n", 3},
+		{"let s = 0; let xs = [1, 2, 3, 4]; let i = 0; while (i < len(xs)) { let s = s + xs[i]; let i = i + 1; } s", 10},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(t, tt.input), tt.expected)
+	}
+}
+
+func TestAssignment(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let x = 1; x = 2; x", 2},
+		{"let x = 1; x = x + 1", 2},
+		{"let x = 10; x += 5; x -= 3; x *= 2; x /= 4; x", 6},
+		{"let a = 1; let b = 2; a = b = 7; a + b", 14},
+		{"let f = fn() { let y = 1; y += 2; y }; f()", 3},
+		{"let x = 1; let f = fn() { x = 5 }; f(); x", 5},
+		{"let f = fn(n) { n *= 10; n }; f(4)", 40},
+		{"let counter = fn() { let n = 0; fn() { n += 1 } }; let c = counter(); c(); c(); c()", 3},
+		{"let pair = fn() { let n = 0; [fn() { n += 1 }, fn() { n }] }; let p = pair(); p[0](); p[0](); p[1]()", 2},
+		{"let f = fn() { let v = 1; let g = fn() { fn() { v = v * 3 } }; g()(); g()(); v }; f()", 9},
+		{"let f = fn() { let v = 1; let get = fn() { v }; v = 2; get() }; f()", 2},
+		{"let xs = [1, 2, 3]; xs[1] = 20; xs[1]", 20},
+		{"let xs = [1, 2, 3]; xs[2] += 10; xs[2]", 13},
+		{"let h = {}; h[\"a\"] = 1; h[\"a\"] += 4; h[\"a\"]", 5},
+		{"let xs = [1, 2, 3]; let i = 0; let s = 0; while (i < len(xs)) { s += xs[i]; i += 1; } s", 6},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(t, tt.input), tt.expected)
+	}
+}
+
 func TestReturnStatements(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -126,7 +280,7 @@ func TestReturnStatements(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		testIntegerObject(t, evaluated, tt.expected)
 	}
 }
@@ -185,12 +339,28 @@ func TestErrorHandling(t *testing.T) {
 			`{"name": "Monkey"}[fn(x) { x }];`,
 			"unusable as hash key: FUNCTION",
 		},
+		{
+			"y = 1",
+			"identifier not found: y",
+		},
+		{
+			"len = 1",
+			"cannot assign to builtin len",
+		},
+		{
+			"let xs = [1]; xs[3] = 1",
+			"index out of range: 3",
+		},
+		{
+			`let s = "a"; s[0] = 1`,
+			"index assignment not supported: STRING",
+		},
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 
-		errObj, ok := evaluated.(*object.Error)
+		errObj, ok := uncaught(evaluated)
 		if !ok {
 			t.Errorf("no error object returned. got=%T(%+v)",
 				evaluated, evaluated)
@@ -204,6 +374,34 @@ func TestErrorHandling(t *testing.T) {
 	}
 }
 
+func TestErrorPositions(t *testing.T) {
+	tests := []struct {
+		input  string
+		line   int
+		column int
+	}{
+		{"5 + true;", 1, 3},
+		{"let x = 1;\n  -true", 2, 3},
+		{"let f = fn(x) {\n  x + y\n};\nf(1)", 2, 7},
+		{`len(1, 2)`, 1, 4},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+
+		errObj, ok := uncaught(evaluated)
+		if !ok {
+			t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+			continue
+		}
+
+		if errObj.Pos.Line != tt.line || errObj.Pos.Column != tt.column {
+			t.Errorf("wrong error position for %q. want=%d:%d, got=%d:%d",
+				tt.input, tt.line, tt.column, errObj.Pos.Line, errObj.Pos.Column)
+		}
+	}
+}
+
 func TestLetStatements(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -216,7 +414,7 @@ func TestLetStatements(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		testIntegerObject(t, testEval(tt.input), tt.expected)
+		testIntegerObject(t, testEval(t, tt.input), tt.expected)
 	}
 }
 
@@ -242,15 +440,101 @@ func testBooleanObject(t *testing.T, obj object.Object, expected bool) bool {
 	return true
 }
 
-func testEval(input string) object.Object {
+func TestTryCatch(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`try { 1 } catch (e) { 2 }`, 1},
+		{`try { 1 / 0 } catch (e) { 2 }`, 2},
+		{`try { 1 / 0 } catch (e) { e["message"] }`, "division by zero"},
+		{`try { 1 / 0 } catch (e) { e["type"] }`, "RuntimeError"},
+		{`try { throw "oops" } catch (e) { e["message"] }`, "oops"},
+		{`try { throw "oops" } catch (e) { e["type"] }`, "Error"},
+		{`try { throw 42 } catch (e) { e["message"] }`, "42"},
+		{`try { len(1) } catch (e) { e["message"] }`, "argument to `len` not supported, got INTEGER"},
+		{`try { fn(a) { a }() } catch (e) { e["message"] }`, "wrong number of arguments: want=1, got=0"},
+		{`let f = fn() { throw "deep" }; let g = fn() { 1 + f() }; try { g() } catch (e) { e["message"] }`, "deep"},
+		{`let f = fn() { throw "x" }; try { f() } catch (e) { len(e["stack"]) }`, 2},
+		{`try { try { throw "inner" } catch (e) { throw e } } catch (e) { e["message"] }`, "inner"},
+		{`try { try { throw "inner" } catch (e) { 1 } } catch (e) { 2 }`, 1},
+		{`let f = fn() { try { return 1; } catch (e) { 2 }; 3 }; f(); try { throw "x" } catch (e) { 4 }`, 4},
+		{`let n = 0; while (n < 5) { try { n += 1; if (n == 3) { break; } } catch (e) {} } try { throw "x" } catch (e) { n }`, 3},
+		{`let r = try { 1 / 0; 10 } catch (e) { 20 }; r + 1`, 21},
+		{`let x = 1; try { x = 2; throw "x"; x = 3 } catch (e) {}; x`, 2},
+		{`[1, try { throw "x" } catch (e) { 2 }, 3][1]`, 2},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			str, ok := evaluated.(*object.String)
+			if !ok {
+				t.Errorf("%q: object is not String. got=%T (%+v)", tt.input, evaluated, evaluated)
+				continue
+			}
+			if str.Value != expected {
+				t.Errorf("%q: wrong value. want=%q, got=%q", tt.input, expected, str.Value)
+			}
+		}
+	}
+}
+
+func TestUncaughtThrow(t *testing.T) {
+	// g's call to f isn't in tail position, so g keeps its frame
+	input := "let f = fn() {\n  throw \"boom\"\n};\nlet g = fn() { let x = f(); x };\ng()"
+
+	errObj, ok := uncaught(testEval(t, input))
+	if !ok {
+		t.Fatalf("no error object returned")
+	}
+
+	if errObj.Message != "boom" || errObj.Kind != object.ThrownError {
+		t.Errorf("wrong error. got=%s %q", errObj.Kind, errObj.Message)
+	}
+
+	expected := []string{"f (2:3)", "g (4:25)", "<main> (5:2)"}
+	if len(errObj.Stack) != len(expected) {
+		t.Fatalf("wrong stack length. want=%d, got=%d (%v)", len(expected), len(errObj.Stack), errObj.Stack)
+	}
+
+	for i, frame := range expected {
+		if errObj.Stack[i].String() != frame {
+			t.Errorf("wrong frame %d. want=%q, got=%q", i, frame, errObj.Stack[i].String())
+		}
+	}
+}
+
+func testEval(t *testing.T, input string) object.Object {
+	t.Helper()
+
 	l := lexer.NewLexer(input)
 	p := parser.NewParser(l)
 	program := p.ParseProgram()
+
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+
 	env := object.NewEnvironment()
 
 	return Eval(program, env)
 }
 
+// The error a program stopped with, if one went uncaught
+func uncaught(obj object.Object) (*object.Error, bool) {
+	exception, ok := obj.(*object.Exception)
+	if !ok {
+		return nil, false
+	}
+
+	return exception.Error, true
+}
+
 func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
 	result, ok := obj.(*object.Integer)
 	if !ok {
@@ -269,7 +553,7 @@ func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
 func TestFunctionObject(t *testing.T) {
 	input := "fn(x) { x + 2; };"
 
-	evaluated := testEval(input)
+	evaluated := testEval(t, input)
 	fn, ok := evaluated.(*object.Function)
 	if !ok {
 		t.Fatalf("object is not Function. got=%T (%+v)", evaluated, evaluated)
@@ -305,7 +589,7 @@ func TestFunctionApplication(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		testIntegerObject(t, testEval(tt.input), tt.expected)
+		testIntegerObject(t, testEval(t, tt.input), tt.expected)
 	}
 }
 
@@ -319,13 +603,13 @@ func TestClosures(t *testing.T) {
 		addTwo(2);
 	`
 
-	testIntegerObject(t, testEval(input), 4)
+	testIntegerObject(t, testEval(t, input), 4)
 }
 
 func TestStringLiteral(t *testing.T) {
 	input := `"Hello Tyler!"`
 
-	evaluated := testEval(input)
+	evaluated := testEval(t, input)
 	str, ok := evaluated.(*object.String)
 	if !ok {
 		t.Fatalf("object is not String. got=%T (%+v)", evaluated, evaluated)
@@ -339,7 +623,7 @@ func TestStringLiteral(t *testing.T) {
 func TestStringConcatenation(t *testing.T) {
 	input := `"Hello" + " " + "World!"`
 
-	evaluated := testEval(input)
+	evaluated := testEval(t, input)
 	str, ok := evaluated.(*object.String)
 	if !ok {
 		t.Fatalf("object is not String. got=%T (%+v)", evaluated, evaluated)
@@ -360,16 +644,20 @@ func TestBuiltinFunctions(t *testing.T) {
 		{`len("hello world")`, 11},
 		{`len(1)`, "argument to `len` not supported, got INTEGER"},
 		{`len("one", "two")`, "wrong number of arguments. got=2, want=1"},
+		{`int("abc")`, `could not parse "abc" as integer`},
+		{`float([])`, "argument to `float` not supported, got ARRAY"},
+		{`round(1.5, 2.0)`, "second argument to `round` must be INTEGER, got FLOAT"},
+		{`floor("1")`, "argument to `floor` must be INTEGER or FLOAT, got STRING"},
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 
 		switch expected := tt.expected.(type) {
 		case int:
 			testIntegerObject(t, evaluated, int64(expected))
 		case string:
-			errObj, ok := evaluated.(*object.Error)
+			errObj, ok := uncaught(evaluated)
 			if !ok {
 				t.Errorf("object is not Error. got=%T (%+v)",
 					evaluated, evaluated)
@@ -386,7 +674,7 @@ func TestBuiltinFunctions(t *testing.T) {
 func TestArrayLiterals(t *testing.T) {
 	input := "[1, 2 * 2, 3 + 3]"
 
-	evaluated := testEval(input)
+	evaluated := testEval(t, input)
 	result, ok := evaluated.(*object.Array)
 	if !ok {
 		t.Fatalf("object is not Array. got=%T (%+v)", evaluated, evaluated)
@@ -450,7 +738,7 @@ func TestArrayIndexExpressions(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		integer, ok := tt.expected.(int)
 		if ok {
 			testIntegerObject(t, evaluated, int64(integer))
@@ -471,7 +759,7 @@ func TestHashLiterals(t *testing.T) {
         false: 6
     }`
 
-	evaluated := testEval(input)
+	evaluated := testEval(t, input)
 	result, ok := evaluated.(*object.Hash)
 	if !ok {
 		t.Fatalf("Eval didn't return Hash. got=%T (%+v)", evaluated, evaluated)
@@ -536,7 +824,7 @@ func TestHashIndexExpressions(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		integer, ok := tt.expected.(int)
 		if ok {
 			testIntegerObject(t, evaluated, int64(integer))
@@ -545,3 +833,184 @@ func TestHashIndexExpressions(t *testing.T) {
 		}
 	}
 }
+
+func TestBuiltinRegistries(t *testing.T) {
+	builtins := object.DefaultBuiltins()
+	builtins.Remove("puts")
+	builtins.Register("double", func(args ...object.Object) object.Object {
+		return &object.Integer{Value: args[0].(*object.Integer).Value * 2}
+	})
+
+	run := func(input string) object.Object {
+		program := parser.NewParser(lexer.NewLexer(input)).ParseProgram()
+		return Eval(program, object.NewEnvironmentWithBuiltins(builtins))
+	}
+
+	testIntegerObject(t, run(`let f = fn(x) { double(len(x)) }; f("abc")`), 6)
+
+	err, ok := uncaught(run(`puts("hi")`))
+	if !ok || err.Message != "identifier not found: puts" {
+		t.Errorf("expected puts to be undefined. got=%v", err)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		input    string
+		limits   object.Limits
+		kind     string
+		cause    error
+		expected string
+	}{
+		{
+			`while (true) {}`,
+			object.Limits{MaxSteps: 1000},
+			object.StepLimitError, object.ErrStepLimit,
+			"step limit of 1000 exceeded",
+		},
+		{
+			// A try can't catch running out of steps
+			`while (true) { try { while (true) {} } catch (e) {} }`,
+			object.Limits{MaxSteps: 1000},
+			object.StepLimitError, object.ErrStepLimit,
+			"step limit of 1000 exceeded",
+		},
+		{
+			`let s = "ab"; while (true) { s = s + s; }`,
+			object.Limits{MaxAllocation: 100},
+			object.AllocationLimitError, object.ErrAllocationLimit,
+			"allocation of 128 exceeds the limit of 100",
+		},
+		{
+			`let a = []; while (true) { a = push(a, 1); }`,
+			object.Limits{MaxAllocation: 10},
+			object.AllocationLimitError, object.ErrAllocationLimit,
+			"allocation of 11 exceeds the limit of 10",
+		},
+		{
+			`let h = {}; let i = 0; while (true) { h[i] = i; i += 1; }`,
+			object.Limits{MaxAllocation: 10},
+			object.AllocationLimitError, object.ErrAllocationLimit,
+			"allocation of 11 exceeds the limit of 10",
+		},
+		{
+			`[1, 2, 3]`,
+			object.Limits{MaxAllocation: 2},
+			object.AllocationLimitError, object.ErrAllocationLimit,
+			"allocation of 3 exceeds the limit of 2",
+		},
+		{
+			`let f = fn(n) { 1 + f(n + 1) }; f(0)`,
+			object.Limits{MaxDepth: 50},
+			object.RecursionLimitError, object.ErrRecursionLimit,
+			"recursion depth limit of 50 exceeded",
+		},
+	}
+
+	for _, tt := range tests {
+		program := parser.NewParser(lexer.NewLexer(tt.input)).ParseProgram()
+		result := EvalWithLimits(context.Background(), program, object.NewEnvironment(), tt.limits)
+
+		errObj, ok := uncaught(result)
+		if !ok {
+			t.Errorf("%s: expected an error. got=%s", tt.input, result.Inspect())
+			continue
+		}
+
+		if errObj.Kind != tt.kind || errObj.Message != tt.expected {
+			t.Errorf("%s: wrong error. want=%s %q, got=%s %q",
+				tt.input, tt.kind, tt.expected, errObj.Kind, errObj.Message)
+		}
+
+		if !errors.Is(errObj, tt.cause) {
+			t.Errorf("%s: error doesn't unwrap to %v", tt.input, tt.cause)
+		}
+	}
+}
+
+func TestCatchingLimits(t *testing.T) {
+	input := `
+	let f = fn(n) { 1 + f(n + 1) };
+	let kind = try { f(0) } catch (e) { e["type"] };
+	let size = try { [1, 2, 3, 4] } catch (e) { e["type"] };
+	[kind, size]`
+
+	program := parser.NewParser(lexer.NewLexer(input)).ParseProgram()
+	limits := object.Limits{MaxDepth: 20, MaxAllocation: 3}
+	result := EvalWithLimits(context.Background(), program, object.NewEnvironment(), limits)
+
+	expected := "[RecursionLimitError, AllocationLimitError]"
+	if result.Inspect() != expected {
+		t.Errorf("wrong result. want=%s, got=%s", expected, result.Inspect())
+	}
+}
+
+func TestCancellation(t *testing.T) {
+	program := parser.NewParser(lexer.NewLexer(`while (true) {}`)).ParseProgram()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	result := EvalWithLimits(ctx, program, object.NewEnvironment(), object.Limits{})
+
+	errObj, ok := uncaught(result)
+	if !ok || !errors.Is(errObj, context.DeadlineExceeded) || errObj.Kind != object.CanceledError {
+		t.Errorf("expected the deadline to stop the program. got=%s", result.Inspect())
+	}
+}
+
+func TestStackOverflow(t *testing.T) {
+	input := `
+	let f = fn(n) { 1 + f(n + 1) };
+	let g = fn() { let n = f(0); n };
+	g();`
+
+	result := testEval(t, input)
+
+	errObj, ok := uncaught(result)
+	if !ok || errObj.Message != "stack overflow" || errObj.Kind != object.RuntimeError {
+		t.Fatalf("expected a stack overflow. got=%s", result.Inspect())
+	}
+
+	if len(errObj.Stack) != MaxCallDepth+1 {
+		t.Errorf("wrong stack depth. want=%d, got=%d", MaxCallDepth+1, len(errObj.Stack))
+	}
+
+	bottom := errObj.Stack[len(errObj.Stack)-2:]
+	if bottom[0].Function != "g" || bottom[1].Function != "<main>" {
+		t.Errorf("wrong frames at the bottom of the stack: %v", bottom)
+	}
+
+	caught := testEval(t, `let f = fn(n) { 1 + f(n + 1) }; try { f(0) } catch (e) { e["message"] }`)
+	if caught.Inspect() != "stack overflow" {
+		t.Errorf("expected to catch the stack overflow. got=%s", caught.Inspect())
+	}
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		// Far deeper than MaxCallDepth, since each call takes over its caller's
+		{`let loop = fn(n, acc) { if (n == 0) { acc } else { loop(n - 1, acc + 1) } }; loop(100000, 0)`, "100000"},
+		{`let loop = fn(n) { if (n == 0) { return "done"; } return loop(n - 1); }; loop(100000)`, "done"},
+		{`
+		let odd = 0;
+		let even = fn(n) { if (n == 0) { true } else { odd(n - 1) } };
+		odd = fn(n) { if (n == 0) { false } else { even(n - 1) } };
+		even(100001)`, "false"},
+		{`let g = fn(x) { let y = x * 2; y }; let f = fn(a, b) { let c = a + b; g(c) }; f(1, 2)`, "6"},
+		{`let f = fn(a) { len(a) }; f([1, 2, 3])`, "3"},
+		{`let g = fn(n) { throw n }; let f = fn(n) { try { g(n) } catch (e) { e["message"] } }; f(7)`, "7"},
+		{`let h = fn(n) { throw n }; let g = fn(n) { h(n) }; let f = fn(n) { try { g(n) } catch (e) { e["message"] } }; f(7)`, "7"},
+		{`let g = fn(n) { n + 1 }; let f = fn(n) { while (true) { return g(n); } }; f(1)`, "2"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%s: wrong result. want=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
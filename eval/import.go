@@ -0,0 +1,71 @@
+package eval
+
+import (
+	"errors"
+	"rafiki/ast"
+	"rafiki/diagnostic"
+	"rafiki/loader"
+	"rafiki/object"
+)
+
+// Run the imported file in an environment of its own, the first time it's
+// imported, and hand back its top-level bindings
+func evalImportExpression(node *ast.ImportExpression, env *object.Environment) object.Object {
+	imports := env.Imports()
+
+	path, err := loader.Resolve(node.Pos(), node.Path.Value)
+	if err != nil {
+		return newError("cannot import %q: %s", node.Path.Value, err)
+	}
+
+	if module, ok := imports.Modules[path]; ok {
+		return module
+	}
+
+	running, err := loader.Enter(imports.Running, node.Pos(), path)
+	if err != nil {
+		return newError("%s", err)
+	}
+
+	saved := imports.Running
+	imports.Running = running
+	defer func() { imports.Running = saved }()
+
+	file, err := loader.Load(path)
+	if err != nil {
+		var diagErr *diagnostic.Error
+		if errors.As(err, &diagErr) {
+			// A syntax error points into the imported file
+			exception := newError("%s", diagErr.Message)
+			exception.Error.Pos = diagErr.Pos
+			return exception
+		}
+		return newError("cannot import %q: %s", node.Path.Value, err)
+	}
+
+	macroEnv := object.NewEnvironmentWithBuiltins(env.Builtins())
+	DefineMacros(file.Program, macroEnv)
+	program := ExpandMacros(file.Program, macroEnv).(*ast.Program)
+
+	moduleEnv := object.NewModuleEnvironment(env)
+	for _, statement := range program.Statements {
+		result := Eval(statement, moduleEnv)
+		if isError(result) {
+			return result
+		}
+		if _, ok := result.(*object.ReturnValue); ok {
+			exception := newError("cannot return from the top level of a module")
+			exception.Error.Pos = statement.Pos()
+			return exception
+		}
+	}
+
+	module := &object.Module{Name: file.Name(), Exports: map[string]object.Object{}}
+	for _, name := range moduleEnv.Names() {
+		module.Exports[name], _ = moduleEnv.Get(name)
+	}
+
+	imports.Modules[path] = module
+
+	return module
+}
@@ -0,0 +1,95 @@
+package eval
+
+import (
+	"os"
+	"path/filepath"
+	"rafiki/lexer"
+	"rafiki/object"
+	"rafiki/parser"
+	"strings"
+	"testing"
+)
+
+// Write the files into a fresh directory and run main.rk from it
+func evalModules(t *testing.T, files map[string]string) object.Object {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, src := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	l := lexer.NewLexerWithFilename(files["main.rk"], filepath.Join(dir, "main.rk"))
+	program := parser.NewParser(l).ParseProgram()
+
+	return Eval(program, object.NewEnvironment())
+}
+
+func TestImports(t *testing.T) {
+	lib := `
+let count = 0;
+let square = fn(x) { x * x };
+let bump = fn() { count += 1; count };
+`
+
+	tests := []struct {
+		main     string
+		expected interface{}
+	}{
+		{`let m = import "lib/math.rk"; m.square(4)`, 16},
+		{`let m = import "lib/math.rk"; m["square"](5)`, 25},
+		// The file runs once, so both imports share its state
+		{`let a = import "lib/math.rk"; let b = import "lib/math.rk"; a.bump(); b.bump()`, 2},
+		{`let f = fn() { import "lib/math.rk" }; f().bump() + f().bump()`, 3},
+		// Modules see their own globals, not the importer's
+		{`let count = 10; let m = import "lib/math.rk"; m.bump()`, 1},
+		{`let m = import "lib/uses.rk"; m.twice(3)`, 18},
+	}
+
+	for _, tt := range tests {
+		evaluated := evalModules(t, map[string]string{
+			"main.rk":     tt.main,
+			"lib/math.rk": lib,
+			"lib/uses.rk": `let math = import "math.rk"; let twice = fn(x) { 2 * math.square(x) };`,
+		})
+
+		integer, ok := tt.expected.(int)
+		if !ok || !testIntegerObject(t, evaluated, int64(integer)) {
+			t.Errorf("%q: wrong result", tt.main)
+		}
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	tests := []struct {
+		files    map[string]string
+		expected string
+	}{
+		{map[string]string{"main.rk": `let m = import "lib.rk"; m.nope`, "lib.rk": "let x = 1;"}, "module lib has no binding nope"},
+		{map[string]string{"main.rk": `import "missing.rk"`}, `cannot import "missing.rk": no such file or directory`},
+		{map[string]string{"main.rk": `import "lib.rk"`, "lib.rk": "let = 1;"}, "expected next token to be IDENT, got = instead"},
+		{map[string]string{"main.rk": `import "lib.rk"`, "lib.rk": "1 + true"}, "type mismatch: INTEGER + BOOLEAN"},
+		{map[string]string{"main.rk": `import "lib.rk"`, "lib.rk": "return 1;"}, "cannot return from the top level of a module"},
+		{map[string]string{"main.rk": `import "a.rk"`, "a.rk": `import "b.rk"`, "b.rk": `import "a.rk"`}, "a.rk -> b.rk -> a.rk"},
+		{map[string]string{"main.rk": `import "a.rk"`, "a.rk": `import "main.rk"`}, "main.rk -> a.rk -> main.rk"},
+		{map[string]string{"main.rk": `let m = import "lib.rk"; m.x = 2`, "lib.rk": "let x = 1;"}, "index assignment not supported: MODULE"},
+	}
+
+	for _, tt := range tests {
+		evaluated := evalModules(t, tt.files)
+
+		errObj, ok := uncaught(evaluated)
+		if !ok {
+			t.Errorf("%q: no error object returned. got=%T(%+v)", tt.files["main.rk"], evaluated, evaluated)
+			continue
+		}
+
+		if !strings.Contains(errObj.Message, tt.expected) {
+			t.Errorf("%q: wrong error message. want=%q, got=%q", tt.files["main.rk"], tt.expected, errObj.Message)
+		}
+	}
+}
@@ -0,0 +1,447 @@
+package format
+
+import (
+	"fmt"
+	"rafiki/ast"
+	"rafiki/diagnostic"
+	"rafiki/lexer"
+	"rafiki/parser"
+	"rafiki/token"
+	"strings"
+)
+
+// Returned by Source when the input doesn't parse. Formatting a partial tree
+// would silently drop the broken statements, so nothing is formatted.
+type SyntaxError struct {
+	Diagnostics []*diagnostic.Diagnostic
+}
+
+func (e *SyntaxError) Error() string {
+	d := e.Diagnostics[0]
+	return fmt.Sprintf("%s: %s", d.Span.Start, d.Message)
+}
+
+/*
+Source reformats a Rafiki program in canonical style:
+
+  - two spaces of indentation, and every non-empty block over several lines
+  - one space around infix operators and after commas, colons and keywords
+  - only the parentheses the parser needs to read the same tree back
+  - a semicolon after each statement, except loops, ifs and trys used as
+    statements, and the last expression of a block that produces a value
+  - comments where they were, and at most one blank line in a row
+
+Formatting its own output changes nothing.
+*/
+func Source(filename string, src string) (string, error) {
+	p := parser.NewParser(lexer.NewLexerWithFilename(src, filename))
+	program := p.ParseProgram()
+
+	if diagnostics := p.Diagnostics(); len(diagnostics) > 0 {
+		return "", &SyntaxError{Diagnostics: diagnostics}
+	}
+
+	pr := &printer{src: src, comments: program.Comments}
+	pr.statements(program.Statements, false, len(src))
+
+	return pr.String(), nil
+}
+
+// Anything above the highest operator precedence: literals, names and the
+// bracketed forms, which never need parentheses
+const primary = parser.INDEX + 1
+
+type printer struct {
+	src      string
+	comments []*ast.Comment
+	next     int // The first comment not yet printed
+
+	lines  []string
+	indent int
+
+	// Where the last statement or comment printed ends in src, and whether
+	// one has been printed in the current block, to keep blank lines between
+	// them but not at the start of a block
+	lastEnd int
+	started bool
+}
+
+func (p *printer) String() string {
+	if len(p.lines) == 0 {
+		return ""
+	}
+
+	return strings.Join(p.lines, "\n") + "\n"
+}
+
+func (p *printer) write(s string) {
+	if len(p.lines) == 0 {
+		p.lines = append(p.lines, "")
+	}
+
+	p.lines[len(p.lines)-1] += s
+}
+
+// Start a line for the statement or comment at offset, after a blank one if
+// the source had a blank line before it
+func (p *printer) newline(offset int) {
+	if p.started && p.lastEnd < offset && hasBlankLine(p.src[p.lastEnd:offset]) {
+		p.lines = append(p.lines, "")
+	}
+
+	p.lines = append(p.lines, strings.Repeat("  ", p.indent))
+	p.started = true
+}
+
+// Whether there's an empty line between code that ends and starts within gap
+func hasBlankLine(gap string) bool {
+	lines := strings.Split(gap, "\n")
+	if len(lines) < 3 {
+		return false
+	}
+
+	for _, line := range lines[1 : len(lines)-1] {
+		if strings.TrimSpace(line) == "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Print the comments that come before offset. A trailing comment stays on
+// the end of the line printed last; the others get lines of their own.
+func (p *printer) commentsBefore(offset int) {
+	for ; p.next < len(p.comments); p.next++ {
+		c := p.comments[p.next]
+		if c.Pos().Offset >= offset {
+			return
+		}
+
+		if c.Trailing && len(p.lines) > 0 && strings.TrimSpace(p.lines[len(p.lines)-1]) != "" {
+			p.write(" " + c.Token.Literal)
+		} else {
+			p.newline(c.Pos().Offset)
+			p.write(c.Token.Literal)
+		}
+
+		p.lastEnd = c.End().Offset
+	}
+}
+
+// Print statements up to end, where the enclosing block closes. When the
+// block produces a value, its last expression goes without a semicolon.
+func (p *printer) statements(statements []ast.Statement, value bool, end int) {
+	for i, s := range statements {
+		p.commentsBefore(s.Pos().Offset)
+		p.newline(s.Pos().Offset)
+		p.statement(s)
+
+		if i == len(statements)-1 {
+			if p.needsSemicolon(s, nil, value) {
+				p.write(";")
+			}
+		} else if p.needsSemicolon(s, statements[i+1], false) {
+			p.write(";")
+		}
+
+		if e := nodeEnd(s); e > p.lastEnd {
+			p.lastEnd = e
+		}
+	}
+
+	p.commentsBefore(end)
+}
+
+// Whether s, followed by next (nil at the end of a block), needs a semicolon.
+// isValue is set for the last statement of a block that produces a value.
+func (p *printer) needsSemicolon(s ast.Statement, next ast.Statement, isValue bool) bool {
+	switch s := s.(type) {
+	case *ast.WhileStatement, *ast.ForStatement:
+		return false
+
+	case *ast.ExpressionStatement:
+		switch s.Expression.(type) {
+		case *ast.IfExpression, *ast.TryExpression:
+			return next != nil && p.continues(next)
+		}
+		return !isValue
+	}
+
+	return true
+}
+
+// Whether s would be read as a continuation of the statement before it, like
+// `if (x) { f } (y)` calling the if's value, without a semicolon in between
+func (p *printer) continues(s ast.Statement) bool {
+	scratch := &printer{src: p.src}
+	scratch.statement(s)
+
+	first := strings.TrimSpace(scratch.String())
+	return strings.HasPrefix(first, "(") || strings.HasPrefix(first, "[") || strings.HasPrefix(first, "-")
+}
+
+// Print a statement, without its semicolon
+func (p *printer) statement(s ast.Statement) {
+	switch s := s.(type) {
+
+	case *ast.LetStatement:
+		p.write("let " + s.Name.Value + " = ")
+		p.expression(s.Value, parser.LOWEST)
+
+	case *ast.ReturnStatement:
+		p.write("return ")
+		p.expression(s.ReturnValue, parser.LOWEST)
+
+	case *ast.ThrowStatement:
+		p.write("throw ")
+		p.expression(s.Value, parser.LOWEST)
+
+	case *ast.ExpressionStatement:
+		p.expression(s.Expression, parser.LOWEST)
+
+	case *ast.BreakStatement:
+		p.write("break")
+
+	case *ast.ContinueStatement:
+		p.write("continue")
+
+	case *ast.WhileStatement:
+		p.write("while (")
+		p.expression(s.Condition, parser.LOWEST)
+		p.write(") ")
+		p.block(s.Body, false)
+
+	case *ast.ForStatement:
+		p.write("for (")
+		if s.Init != nil {
+			p.statement(s.Init)
+		}
+		p.write(";")
+		if s.Condition != nil {
+			p.write(" ")
+			p.expression(s.Condition, parser.LOWEST)
+		}
+		p.write(";")
+		if s.Post != nil {
+			p.write(" ")
+			p.statement(s.Post)
+		}
+		p.write(") ")
+		p.block(s.Body, false)
+
+	case *ast.BlockStatement:
+		p.block(s, false)
+	}
+}
+
+func (p *printer) block(b *ast.BlockStatement, value bool) {
+	end := b.Rbrace.Span.Start.Offset
+
+	if len(b.Statements) == 0 && !p.commentBefore(end) {
+		p.write("{}")
+		return
+	}
+
+	p.write("{")
+	p.indent++
+
+	outerStarted := p.started
+	p.started = false
+	p.lastEnd = b.Token.Span.End.Offset
+
+	p.statements(b.Statements, value, end)
+
+	p.indent--
+	p.started = false
+	p.newline(end)
+	p.write("}")
+
+	p.started = outerStarted
+	p.lastEnd = b.Rbrace.Span.End.Offset
+}
+
+// Whether a comment not yet printed starts before offset
+func (p *printer) commentBefore(offset int) bool {
+	return p.next < len(p.comments) && p.comments[p.next].Pos().Offset < offset
+}
+
+// Print e, in parentheses if it binds more loosely than the operator around
+// it, whose operand has to have at least the given precedence
+func (p *printer) expression(e ast.Expression, precedence int) {
+	if precedenceOf(e) < precedence {
+		p.write("(")
+		p.expression(e, parser.LOWEST)
+		p.write(")")
+		return
+	}
+
+	switch e := e.(type) {
+
+	case *ast.Identifier:
+		p.write(e.Value)
+
+	case *ast.IntegerLiteral:
+		p.write(e.Token.Literal)
+
+	case *ast.FloatLiteral:
+		p.write(e.Token.Literal)
+
+	case *ast.StringLiteral:
+		p.write(`"` + e.Value + `"`)
+
+	case *ast.Boolean:
+		p.write(e.Token.Literal)
+
+	case *ast.PrefixExpression:
+		p.write(e.Operator)
+		p.expression(e.Right, parser.PREFIX)
+
+	case *ast.InfixExpression:
+		// Operators group to the left, so an operand on the right with the
+		// same precedence needs parentheses: a - (b - c)
+		operator := parser.Precedence(e.Token.Type)
+		p.expression(e.Left, operator)
+		p.write(" " + e.Operator + " ")
+		p.expression(e.Right, operator+1)
+
+	case *ast.AssignExpression:
+		// Assignment groups to the right: a = b = c is a = (b = c)
+		p.expression(e.Target, parser.CALL)
+		p.write(" " + e.Operator + " ")
+		p.expression(e.Value, parser.LOWEST)
+
+	case *ast.CallExpression:
+		p.expression(e.Function, parser.CALL)
+		p.write("(")
+		p.list(e.Arguments)
+		p.write(")")
+
+	case *ast.IndexExpression:
+		p.expression(e.Left, parser.CALL)
+		if e.Token.Type == token.DOT {
+			p.write("." + e.Index.(*ast.StringLiteral).Value)
+			break
+		}
+		p.write("[")
+		p.expression(e.Index, parser.LOWEST)
+		p.write("]")
+
+	case *ast.ImportExpression:
+		p.write(`import "` + e.Path.Value + `"`)
+
+	case *ast.ArrayLiteral:
+		p.write("[")
+		p.list(e.Elements)
+		p.write("]")
+
+	case *ast.HashLiteral:
+		p.write("{")
+		for i, key := range e.Keys() {
+			if i > 0 {
+				p.write(", ")
+			}
+			p.expression(key, parser.LOWEST)
+			p.write(": ")
+			p.expression(e.Pairs[key], parser.LOWEST)
+		}
+		p.write("}")
+
+	case *ast.IfExpression:
+		p.write("if (")
+		p.expression(e.Condition, parser.LOWEST)
+		p.write(") ")
+		p.block(e.Consequence, true)
+		if e.Alternative != nil {
+			p.write(" else ")
+			p.block(e.Alternative, true)
+		}
+
+	case *ast.TryExpression:
+		p.write("try ")
+		p.block(e.Body, true)
+		p.write(" catch (" + e.Param.Value + ") ")
+		p.block(e.Handler, true)
+
+	case *ast.FunctionLiteral:
+		p.write("fn")
+		p.parameters(e.Parameters)
+		p.block(e.Body, true)
+
+	case *ast.MacroLiteral:
+		p.write("macro")
+		p.parameters(e.Parameters)
+		p.block(e.Body, true)
+	}
+}
+
+func (p *printer) list(expressions []ast.Expression) {
+	for i, e := range expressions {
+		if i > 0 {
+			p.write(", ")
+		}
+		p.expression(e, parser.LOWEST)
+	}
+}
+
+func (p *printer) parameters(params []*ast.Identifier) {
+	names := []string{}
+	for _, param := range params {
+		names = append(names, param.Value)
+	}
+
+	p.write("(" + strings.Join(names, ", ") + ") ")
+}
+
+// How tightly an expression binds, as the parser saw it
+func precedenceOf(e ast.Expression) int {
+	switch e := e.(type) {
+	case *ast.InfixExpression:
+		return parser.Precedence(e.Token.Type)
+	case *ast.AssignExpression:
+		return parser.ASSIGN
+	case *ast.PrefixExpression:
+		return parser.PREFIX
+	case *ast.CallExpression:
+		return parser.CALL
+	case *ast.IndexExpression:
+		return parser.INDEX
+	}
+
+	return primary
+}
+
+// Where a node's last token ends in the source
+func nodeEnd(node ast.Node) int {
+	end := 0
+
+	ast.Inspect(node, func(n ast.Node) bool {
+		var offset int
+
+		switch n := n.(type) {
+		case *ast.Identifier:
+			offset = n.Token.Span.End.Offset
+		case *ast.IntegerLiteral:
+			offset = n.Token.Span.End.Offset
+		case *ast.FloatLiteral:
+			offset = n.Token.Span.End.Offset
+		case *ast.StringLiteral:
+			offset = n.Token.Span.End.Offset
+		case *ast.Boolean:
+			offset = n.Token.Span.End.Offset
+		case *ast.BreakStatement:
+			offset = n.Token.Span.End.Offset
+		case *ast.ContinueStatement:
+			offset = n.Token.Span.End.Offset
+		case *ast.BlockStatement:
+			offset = n.Rbrace.Span.End.Offset
+		}
+
+		if offset > end {
+			end = offset
+		}
+		return true
+	})
+
+	return end
+}
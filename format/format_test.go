@@ -0,0 +1,123 @@
+package format
+
+import (
+	"errors"
+	"rafiki/lexer"
+	"rafiki/parser"
+	"testing"
+)
+
+var tests = []struct {
+	input    string
+	expected string
+}{
+	{"let x=1", "let x = 1;\n"},
+	{"(a + b) * c", "(a + b) * c;\n"},
+	{"((a + b) + c)", "a + b + c;\n"},
+	{"a + (b + c)", "a + (b + c);\n"},
+	{"a - (b * c)", "a - b * c;\n"},
+	{"(a * b)[0]", "(a * b)[0];\n"},
+	{"(f(x))[0]; (a[0])(1)", "f(x)[0];\na[0](1);\n"},
+	{"-(a + b); !(-x); (-a) * b", "-(a + b);\n!-x;\n-a * b;\n"},
+	{"(a && b) || c; a && (b || c)", "a && b || c;\na && (b || c);\n"},
+	{"x = (y = 2); (x = 1) + 2", "x = y = 2;\n(x = 1) + 2;\n"},
+	{"xs[0] += (1 + 2)", "xs[0] += 1 + 2;\n"},
+	{`let h = {"b": [1,2], "a": 2.50}`, "let h = {\"b\": [1, 2], \"a\": 2.50};\n"},
+	{"let f = fn(a,b){return a+b;}", "let f = fn(a, b) {\n  return a + b;\n};\n"},
+	{"let f = fn(){}; let m = macro(x){ quote(unquote(x)) }", "let f = fn() {};\nlet m = macro(x) {\n  quote(unquote(x))\n};\n"},
+	{
+		"if (x > 1) { puts(x); x } else { 0 }",
+		"if (x > 1) {\n  puts(x);\n  x\n} else {\n  0\n}\n",
+	},
+	{
+		"let r = try { throw \"no\" } catch (e) { e }",
+		"let r = try {\n  throw \"no\";\n} catch (e) {\n  e\n};\n",
+	},
+	{
+		"while (i < 3) { i += 1 } for (let i = 0; i < 3; i += 1) { if (i == 1) { continue; } puts(i) } for (;;) { break }",
+		"while (i < 3) {\n  i += 1;\n}\nfor (let i = 0; i < 3; i += 1) {\n  if (i == 1) {\n    continue;\n  }\n  puts(i);\n}\nfor (;;) {\n  break;\n}\n",
+	},
+	{
+		// Without the semicolon, -y would subtract from the if's value
+		"if (x) { 1 }; -y; if (x) { 1 } y",
+		"if (x) {\n  1\n};\n-y;\nif (x) {\n  1\n}\ny;\n",
+	},
+	{
+		"// Adds\n// two numbers\nlet add = fn(a, b) { // body\n  a + b // sum\n} // done\n",
+		"// Adds\n// two numbers\nlet add = fn(a, b) { // body\n  a + b // sum\n}; // done\n",
+	},
+	{
+		"\n\nlet a = 1;\n\n\n\nlet b = 2;\nlet f = fn() {\n\n  a\n\n  /* last */\n\n};\n",
+		"let a = 1;\n\nlet b = 2;\nlet f = fn() {\n  a\n\n  /* last */\n};\n",
+	},
+	{`let m=import "lib.rk"; m.f(m . xs[0])["k"]`, "let m = import \"lib.rk\";\nm.f(m.xs[0])[\"k\"];\n"},
+	{"// only a comment", "// only a comment\n"},
+	{"", ""},
+}
+
+func TestSource(t *testing.T) {
+	for _, tt := range tests {
+		actual, err := Source("main.rk", tt.input)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", tt.input, err)
+			continue
+		}
+
+		if actual != tt.expected {
+			t.Errorf("%q: wrong output.\nwant=%q\ngot= %q", tt.input, tt.expected, actual)
+		}
+	}
+}
+
+// Formatting must not change what the program means, and formatting the
+// result again must not change it at all
+func TestSourceIsStable(t *testing.T) {
+	for _, tt := range tests {
+		if tt.expected == "" {
+			continue
+		}
+
+		once, err := Source("main.rk", tt.input)
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %s", tt.input, err)
+		}
+
+		if before, after := parse(t, tt.input), parse(t, once); before != after {
+			t.Errorf("%q: formatting changed the tree.\nbefore=%s\nafter= %s", tt.input, before, after)
+		}
+
+		twice, err := Source("main.rk", once)
+		if err != nil {
+			t.Fatalf("%q: formatted output doesn't parse: %s", tt.input, err)
+		}
+
+		if twice != once {
+			t.Errorf("%q: formatting twice changed the output.\nonce= %q\ntwice=%q", tt.input, once, twice)
+		}
+	}
+}
+
+func parse(t *testing.T, src string) string {
+	t.Helper()
+
+	p := parser.NewParser(lexer.NewLexer(src))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("%q doesn't parse: %v", src, p.Errors())
+	}
+
+	return program.String()
+}
+
+func TestSyntaxError(t *testing.T) {
+	_, err := Source("main.rk", "let = 5;\nlet x = 5 ;;")
+
+	var syntaxErr *SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		t.Fatalf("expected a SyntaxError. got=%v", err)
+	}
+
+	if len(syntaxErr.Diagnostics) != 2 || err.Error() != "main.rk:1:5: expected next token to be IDENT, got = instead" {
+		t.Errorf("wrong error. got=%q with %d diagnostics", err.Error(), len(syntaxErr.Diagnostics))
+	}
+}
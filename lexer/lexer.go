@@ -9,21 +9,66 @@ type Lexer struct {
 	currentPosition int  // current position of the Lexer
 	readPosition    int  // the next position, the character we're about to read
 	char            byte // ASCII char we're examining
+
+	filename  string
+	line      int // line of the current char, starting at 1
+	lineStart int // offset of the first char on the current line
+
+	comments      []Comment
+	lastTokenLine int // line the last non-comment token ended on
+}
+
+// Comments never reach the parser as tokens. The lexer keeps them on the side
+// so tools that care about them (doc comments, the formatter) can pick them up.
+type Comment struct {
+	Token    token.Token // token.COMMENT, the literal includes the // or /* */ markers
+	Trailing bool        // Shares its line with code before it, like `x; // note`
 }
 
 func NewLexer(input string) *Lexer {
-	l := &Lexer{input: input}
+	l := &Lexer{input: input, line: 1}
 
 	l.readChar()
 
 	return l
 }
 
+// Same as NewLexer, but every token position also carries the file name
+func NewLexerWithFilename(input string, filename string) *Lexer {
+	l := NewLexer(input)
+	l.filename = filename
+
+	return l
+}
+
+// Wraps nextToken to record where each token starts and ends, and to set
+// comments aside
 func (l *Lexer) NextToken() token.Token {
-	var t token.Token
+	for {
+		// Skip over ' ', '\t', '\n', '\r'
+		l.skipWhitespace()
 
-	// Skip over ' ', '\t', '\n', '\r'
-	l.skipWhitespace()
+		start := l.position()
+		t := l.nextToken()
+		t.Span = token.Span{Start: start, End: l.position()}
+
+		if t.Type != token.COMMENT {
+			l.lastTokenLine = t.Span.End.Line
+			return t
+		}
+
+		trailing := l.lastTokenLine == start.Line
+		l.comments = append(l.comments, Comment{Token: t, Trailing: trailing})
+	}
+}
+
+// Every comment lexed so far, in source order
+func (l *Lexer) Comments() []Comment {
+	return l.comments
+}
+
+func (l *Lexer) nextToken() token.Token {
+	var t token.Token
 
 	switch l.char {
 	case '=':
@@ -56,22 +101,103 @@ func (l *Lexer) NextToken() token.Token {
 		t = token.NewToken(token.RBRACE, l.char)
 
 	case '+':
-		t = token.NewToken(token.PLUS, l.char)
+		if l.peekChar() == '=' {
+			ch := l.char
+			l.readChar()
+			literal := string(ch) + string(l.char)
+			t = token.Token{Type: token.PLUS_ASSIGN, Literal: literal}
+		} else {
+			t = token.NewToken(token.PLUS, l.char)
+		}
 
 	case '/':
-		t = token.NewToken(token.SLASH, l.char)
+		switch l.peekChar() {
+		case '/':
+			t.Type = token.COMMENT
+			t.Literal = l.readLineComment()
+			return t
+
+		case '*':
+			literal, ok := l.readBlockComment()
+			if !ok {
+				return token.Token{Type: token.ILLEGAL, Literal: literal}
+			}
+			t.Type = token.COMMENT
+			t.Literal = literal
+			return t
+
+		case '=':
+			ch := l.char
+			l.readChar()
+			literal := string(ch) + string(l.char)
+			t = token.Token{Type: token.SLASH_ASSIGN, Literal: literal}
+
+		default:
+			t = token.NewToken(token.SLASH, l.char)
+		}
 
 	case '-':
-		t = token.NewToken(token.MINUS, l.char)
+		if l.peekChar() == '=' {
+			ch := l.char
+			l.readChar()
+			literal := string(ch) + string(l.char)
+			t = token.Token{Type: token.MINUS_ASSIGN, Literal: literal}
+		} else {
+			t = token.NewToken(token.MINUS, l.char)
+		}
 
 	case '*':
-		t = token.NewToken(token.ASTERISK, l.char)
+		if l.peekChar() == '=' {
+			ch := l.char
+			l.readChar()
+			literal := string(ch) + string(l.char)
+			t = token.Token{Type: token.ASTERISK_ASSIGN, Literal: literal}
+		} else {
+			t = token.NewToken(token.ASTERISK, l.char)
+		}
 
 	case '>':
-		t = token.NewToken(token.GT, l.char)
+		if l.peekChar() == '=' {
+			ch := l.char
+			l.readChar()
+			literal := string(ch) + string(l.char)
+			t = token.Token{Type: token.GT_EQ, Literal: literal}
+		} else {
+			t = token.NewToken(token.GT, l.char)
+		}
 
 	case '<':
-		t = token.NewToken(token.LT, l.char)
+		if l.peekChar() == '=' {
+			ch := l.char
+			l.readChar()
+			literal := string(ch) + string(l.char)
+			t = token.Token{Type: token.LT_EQ, Literal: literal}
+		} else {
+			t = token.NewToken(token.LT, l.char)
+		}
+
+	case '%':
+		t = token.NewToken(token.PERCENT, l.char)
+
+	case '&':
+		if l.peekChar() == '&' {
+			ch := l.char
+			l.readChar()
+			literal := string(ch) + string(l.char)
+			t = token.Token{Type: token.AND, Literal: literal}
+		} else {
+			t = token.NewToken(token.ILLEGAL, l.char)
+		}
+
+	case '|':
+		if l.peekChar() == '|' {
+			ch := l.char
+			l.readChar()
+			literal := string(ch) + string(l.char)
+			t = token.Token{Type: token.OR, Literal: literal}
+		} else {
+			t = token.NewToken(token.ILLEGAL, l.char)
+		}
 
 	case '[':
 		t = token.NewToken(token.LBRACKET, l.char)
@@ -82,6 +208,9 @@ func (l *Lexer) NextToken() token.Token {
 	case ':':
 		t = token.NewToken(token.COLON, l.char)
 
+	case '.':
+		t = token.NewToken(token.DOT, l.char)
+
 	case '!':
 		if l.peekChar() == '=' {
 			ch := l.char
@@ -107,8 +236,7 @@ func (l *Lexer) NextToken() token.Token {
 		}
 
 		if isDigit(l.char) {
-			t.Type = token.INT
-			t.Literal = l.readNumber()
+			t.Literal, t.Type = l.readNumber()
 			return t
 		}
 
@@ -121,6 +249,11 @@ func (l *Lexer) NextToken() token.Token {
 }
 
 func (l *Lexer) readChar() {
+	if l.char == '\n' {
+		l.line++
+		l.lineStart = l.readPosition
+	}
+
 	if l.readPosition >= len(l.input) {
 		l.char = 0 // In ASCII, this is a NULL character. NULL/0 will represent our EOF
 	} else {
@@ -131,15 +264,56 @@ func (l *Lexer) readChar() {
 	l.readPosition += 1
 }
 
-func (l *Lexer) readNumber() string {
+// Position of the char currently being examined
+func (l *Lexer) position() token.Position {
+	return token.Position{
+		Filename: l.filename,
+		Offset:   l.currentPosition,
+		Line:     l.line,
+		Column:   l.currentPosition - l.lineStart + 1,
+	}
+}
+
+// Integers are a run of digits. A fraction or an exponent, like 3.14 or 1e-3,
+// makes it a float. The '.' has to be followed by a digit, so `1.` is still
+// an integer followed by a dot.
+func (l *Lexer) readNumber() (string, token.TokenType) {
 	startPosition := l.currentPosition
+	var tokenType token.TokenType = token.INT
 
-	for isDigit(l.char) {
+	l.readDigits()
+
+	if l.char == '.' && isDigit(l.peekChar()) {
+		tokenType = token.FLOAT
 		l.readChar()
+		l.readDigits()
+	}
+
+	if l.char == 'e' || l.char == 'E' {
+		// Only an exponent if digits follow, optionally signed
+		offset := l.readPosition
+		if offset < len(l.input) && (l.input[offset] == '+' || l.input[offset] == '-') {
+			offset++
+		}
+
+		if offset < len(l.input) && isDigit(l.input[offset]) {
+			tokenType = token.FLOAT
+			for l.readPosition < offset {
+				l.readChar()
+			}
+			l.readChar()
+			l.readDigits()
+		}
 	}
 
 	endPosition := l.currentPosition
-	return l.input[startPosition:endPosition]
+	return l.input[startPosition:endPosition], tokenType
+}
+
+func (l *Lexer) readDigits() {
+	for isDigit(l.char) {
+		l.readChar()
+	}
 }
 
 func (l *Lexer) readIdentifer() string {
@@ -190,3 +364,37 @@ func (l *Lexer) readString() string {
 
 	return l.input[stringStart:l.currentPosition]
 }
+
+// Reads up to, but not including, the end of the line
+func (l *Lexer) readLineComment() string {
+	start := l.currentPosition
+
+	for l.char != '\n' && l.char != 0 {
+		l.readChar()
+	}
+
+	return l.input[start:l.currentPosition]
+}
+
+// Reads through the closing */. Reports false if the input ends first.
+func (l *Lexer) readBlockComment() (string, bool) {
+	start := l.currentPosition
+
+	// Move past /*
+	l.readChar()
+	l.readChar()
+
+	for {
+		if l.char == 0 {
+			return l.input[start:l.currentPosition], false
+		}
+
+		if l.char == '*' && l.peekChar() == '/' {
+			l.readChar()
+			l.readChar()
+			return l.input[start:l.currentPosition], true
+		}
+
+		l.readChar()
+	}
+}
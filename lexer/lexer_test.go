@@ -18,7 +18,7 @@ func TestNextToken(t *testing.T) {
 		let result = add(five, ten);
 
 		5 < 10 > 5;
-		!-/*5;
+		!-/ *5;
 
 		if (5 < 10) {
 			return true;
@@ -37,6 +37,9 @@ func TestNextToken(t *testing.T) {
 		{"foo": "bar"}
 
 		macro(x, y) { x + y; };
+
+		x += 1 -= 2 *= 3 /= 4;
+		a <= b >= c % d && e || f;
 	`
 
 	tests := []struct {
@@ -142,6 +145,28 @@ func TestNextToken(t *testing.T) {
 		{token.SEMICOLON, ";"},
 		{token.RBRACE, "}"},
 		{token.SEMICOLON, ";"},
+		{token.IDENT, "x"},
+		{token.PLUS_ASSIGN, "+="},
+		{token.INT, "1"},
+		{token.MINUS_ASSIGN, "-="},
+		{token.INT, "2"},
+		{token.ASTERISK_ASSIGN, "*="},
+		{token.INT, "3"},
+		{token.SLASH_ASSIGN, "/="},
+		{token.INT, "4"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "a"},
+		{token.LT_EQ, "<="},
+		{token.IDENT, "b"},
+		{token.GT_EQ, ">="},
+		{token.IDENT, "c"},
+		{token.PERCENT, "%"},
+		{token.IDENT, "d"},
+		{token.AND, "&&"},
+		{token.IDENT, "e"},
+		{token.OR, "||"},
+		{token.IDENT, "f"},
+		{token.SEMICOLON, ";"},
 		{token.EOF, ""},
 	}
 
@@ -162,3 +187,135 @@ func TestNextToken(t *testing.T) {
 	}
 
 }
+
+func TestTokenPositions(t *testing.T) {
+	input := "let five = 5;\n  five == \"a b\";"
+
+	tests := []struct {
+		expectedLiteral string
+		expectedStart   token.Position
+		expectedEnd     token.Position
+	}{
+		{"let", token.Position{Filename: "main.rk", Offset: 0, Line: 1, Column: 1}, token.Position{Filename: "main.rk", Offset: 3, Line: 1, Column: 4}},
+		{"five", token.Position{Filename: "main.rk", Offset: 4, Line: 1, Column: 5}, token.Position{Filename: "main.rk", Offset: 8, Line: 1, Column: 9}},
+		{"=", token.Position{Filename: "main.rk", Offset: 9, Line: 1, Column: 10}, token.Position{Filename: "main.rk", Offset: 10, Line: 1, Column: 11}},
+		{"5", token.Position{Filename: "main.rk", Offset: 11, Line: 1, Column: 12}, token.Position{Filename: "main.rk", Offset: 12, Line: 1, Column: 13}},
+		{";", token.Position{Filename: "main.rk", Offset: 12, Line: 1, Column: 13}, token.Position{Filename: "main.rk", Offset: 13, Line: 1, Column: 14}},
+		{"five", token.Position{Filename: "main.rk", Offset: 16, Line: 2, Column: 3}, token.Position{Filename: "main.rk", Offset: 20, Line: 2, Column: 7}},
+		{"==", token.Position{Filename: "main.rk", Offset: 21, Line: 2, Column: 8}, token.Position{Filename: "main.rk", Offset: 23, Line: 2, Column: 10}},
+		{"a b", token.Position{Filename: "main.rk", Offset: 24, Line: 2, Column: 11}, token.Position{Filename: "main.rk", Offset: 29, Line: 2, Column: 16}},
+	}
+
+	l := NewLexerWithFilename(input, "main.rk")
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+
+		if tok.Span.Start != tt.expectedStart {
+			t.Errorf("tests[%d] - start wrong. expected=%+v, got=%+v",
+				i, tt.expectedStart, tok.Span.Start)
+		}
+
+		if tok.Span.End != tt.expectedEnd {
+			t.Errorf("tests[%d] - end wrong. expected=%+v, got=%+v",
+				i, tt.expectedEnd, tok.Span.End)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// leading
+let x = 1; // trailing
+/* block
+   comment */ x / 2;`
+
+	l := NewLexer(input)
+
+	expected := []token.TokenType{
+		token.LET, token.IDENT, token.ASSIGN, token.INT, token.SEMICOLON,
+		token.IDENT, token.SLASH, token.INT, token.SEMICOLON, token.EOF,
+	}
+
+	for i, tt := range expected {
+		tok := l.NextToken()
+		if tok.Type != tt {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt, tok.Type)
+		}
+	}
+
+	tests := []struct {
+		literal  string
+		line     int
+		trailing bool
+	}{
+		{"// leading", 1, false},
+		{"// trailing", 2, true},
+		{"/* block\n   comment */", 3, false},
+	}
+
+	comments := l.Comments()
+	if len(comments) != len(tests) {
+		t.Fatalf("wrong number of comments. expected=%d, got=%d", len(tests), len(comments))
+	}
+
+	for i, tt := range tests {
+		c := comments[i]
+
+		if c.Token.Type != token.COMMENT || c.Token.Literal != tt.literal {
+			t.Errorf("comments[%d] wrong. expected=%q, got=%s %q", i, tt.literal, c.Token.Type, c.Token.Literal)
+		}
+
+		if c.Token.Span.Start.Line != tt.line {
+			t.Errorf("comments[%d] line wrong. expected=%d, got=%d", i, tt.line, c.Token.Span.Start.Line)
+		}
+
+		if c.Trailing != tt.trailing {
+			t.Errorf("comments[%d] trailing wrong. expected=%t, got=%t", i, tt.trailing, c.Trailing)
+		}
+	}
+}
+
+func TestUnterminatedBlockComment(t *testing.T) {
+	l := NewLexer("1 /* never closed")
+
+	l.NextToken()
+	tok := l.NextToken()
+
+	if tok.Type != token.ILLEGAL {
+		t.Fatalf("tokentype wrong. expected=%q, got=%q", token.ILLEGAL, tok.Type)
+	}
+}
+
+func TestNumbers(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []token.Token
+	}{
+		{"42", []token.Token{{Type: token.INT, Literal: "42"}}},
+		{"3.14", []token.Token{{Type: token.FLOAT, Literal: "3.14"}}},
+		{"1e3", []token.Token{{Type: token.FLOAT, Literal: "1e3"}}},
+		{"2.5E-3", []token.Token{{Type: token.FLOAT, Literal: "2.5E-3"}}},
+		{"6e+2", []token.Token{{Type: token.FLOAT, Literal: "6e+2"}}},
+		{"1.", []token.Token{{Type: token.INT, Literal: "1"}, {Type: token.DOT, Literal: "."}}},
+		{"1e", []token.Token{{Type: token.INT, Literal: "1"}, {Type: token.IDENT, Literal: "e"}}},
+		{"1e-x", []token.Token{{Type: token.INT, Literal: "1"}, {Type: token.IDENT, Literal: "e"}, {Type: token.MINUS, Literal: "-"}}},
+	}
+
+	for _, tt := range tests {
+		l := NewLexer(tt.input)
+
+		for i, expected := range tt.expected {
+			tok := l.NextToken()
+
+			if tok.Type != expected.Type || tok.Literal != expected.Literal {
+				t.Errorf("%q tokens[%d] wrong. expected=%s %q, got=%s %q",
+					tt.input, i, expected.Type, expected.Literal, tok.Type, tok.Literal)
+			}
+		}
+	}
+}
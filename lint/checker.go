@@ -0,0 +1,449 @@
+package lint
+
+import (
+	"fmt"
+	"rafiki/ast"
+	"rafiki/compiler"
+	"rafiki/diagnostic"
+	"rafiki/eval"
+	"rafiki/object"
+	"rafiki/token"
+	"strings"
+)
+
+// How many arguments each builtin takes. max is -1 for no limit.
+var builtinArity = map[string]struct{ min, max int }{
+	"len":   {1, 1},
+	"puts":  {0, -1},
+	"first": {1, 1},
+	"last":  {1, 1},
+	"rest":  {1, 1},
+	"push":  {2, 2},
+	"int":   {1, 1},
+	"float": {1, 1},
+	"round": {1, 2},
+	"floor": {1, 1},
+}
+
+// Where a name was bound, and what the rest of the program does with it
+type binding struct {
+	name  string
+	kind  bindingKind
+	def   *ast.Identifier // nil for builtins
+	local bool            // Bound inside a function rather than globally
+
+	fn         *ast.FunctionLiteral // The function it was bound to, if any
+	used       bool
+	reassigned bool
+}
+
+type bindingKind int
+
+const (
+	bindingLet bindingKind = iota
+	bindingParameter
+	bindingCatch
+	bindingBuiltin
+)
+
+// A symbol table and the bindings made in it, by name
+type scope struct {
+	table    *compiler.SymbolTable
+	outer    *scope
+	bindings map[string]*binding
+}
+
+// A call to a name, checked once every assignment to the name has been seen
+type call struct {
+	expression *ast.CallExpression
+	binding    *binding
+}
+
+/*
+checker walks the tree in the order the compiler does, defining and resolving
+names in a compiler.SymbolTable so each name gets the scope it will have at
+runtime. The bindings alongside each table record how the names are used.
+*/
+type checker struct {
+	scope       *scope
+	bindings    []*binding // Every binding, in the order they were made
+	calls       []call
+	diagnostics []*diagnostic.Diagnostic
+}
+
+func newChecker() *checker {
+	c := &checker{scope: &scope{table: compiler.NewSymbolTable(), bindings: map[string]*binding{}}}
+
+	for i, name := range object.DefaultBuiltins().Names() {
+		c.scope.table.DefineBuiltin(i, name)
+		c.scope.bindings[name] = &binding{name: name, kind: bindingBuiltin}
+	}
+
+	return c
+}
+
+func (c *checker) check(program *ast.Program) {
+	c.statements(program.Statements)
+
+	for _, call := range c.calls {
+		if call.binding.fn != nil && !call.binding.reassigned {
+			c.checkArity(call.expression, call.binding.name, len(call.binding.fn.Parameters), len(call.binding.fn.Parameters))
+		}
+	}
+
+	for _, b := range c.bindings {
+		if b.used || strings.HasPrefix(b.name, "_") {
+			continue
+		}
+
+		switch {
+		case b.kind == bindingLet && b.local:
+			c.report(UnusedVariable, b.def.Token.Span, "%s is declared but never used", b.name)
+		case b.kind == bindingParameter:
+			c.report(UnusedParameter, b.def.Token.Span, "parameter %s is never used", b.name)
+		}
+	}
+}
+
+func (c *checker) report(rule Rule, span token.Span, format string, a ...interface{}) {
+	c.diagnostics = append(c.diagnostics, &diagnostic.Diagnostic{
+		Severity: diagnostic.SeverityWarning,
+		Code:     rule.Code,
+		Span:     span,
+		Message:  fmt.Sprintf(format, a...),
+	})
+}
+
+// The binding name refers to from the current scope, innermost first
+func (c *checker) lookup(name string) *binding {
+	for s := c.scope; s != nil; s = s.outer {
+		if b, ok := s.bindings[name]; ok {
+			return b
+		}
+	}
+
+	return nil
+}
+
+// Bind ident in the current scope. Binding a name again in the same scope
+// reuses its variable, as the compiler does, so it's the same binding.
+func (c *checker) define(ident *ast.Identifier, kind bindingKind) *binding {
+	symbol, ok := c.scope.table.Resolve(ident.Value)
+	sameScope := ok && (symbol.Scope == compiler.LocalScope || symbol.Scope == compiler.GlobalScope && c.scope.outer == nil)
+
+	if ok && !sameScope && kind != bindingCatch {
+		c.reportShadow(ident, symbol.Scope)
+	}
+
+	c.scope.table.Define(ident.Value)
+
+	if b := c.scope.bindings[ident.Value]; sameScope && b != nil {
+		b.reassigned = true
+		return b
+	}
+
+	b := &binding{
+		name:  ident.Value,
+		kind:  kind,
+		def:   ident,
+		local: c.scope.outer != nil,
+		used:  kind == bindingCatch,
+	}
+	c.scope.bindings[ident.Value] = b
+	c.bindings = append(c.bindings, b)
+
+	return b
+}
+
+func (c *checker) reportShadow(ident *ast.Identifier, outer compiler.SymbolScope) {
+	switch outer {
+	case compiler.BuiltinScope:
+		c.report(Shadow, ident.Token.Span, "%s shadows the builtin %s", ident.Value, ident.Value)
+	case compiler.FunctionScope:
+		c.report(Shadow, ident.Token.Span, "%s shadows the name of the function it's in", ident.Value)
+	case compiler.FreeScope:
+		c.report(Shadow, ident.Token.Span, "%s shadows a variable of an enclosing function", ident.Value)
+	case compiler.GlobalScope:
+		c.report(Shadow, ident.Token.Span, "%s shadows a global", ident.Value)
+	}
+}
+
+// Check a list of statements, reporting the first one that can't run
+func (c *checker) statements(statements []ast.Statement) {
+	reported := false
+
+	for i, s := range statements {
+		if i > 0 && !reported && terminates(statements[i-1]) {
+			c.report(Unreachable, statementToken(s).Span, "unreachable code after %s", statementToken(statements[i-1]).Literal)
+			reported = true
+		}
+
+		c.visit(s)
+	}
+}
+
+func terminates(s ast.Statement) bool {
+	switch s.(type) {
+	case *ast.ReturnStatement, *ast.ThrowStatement, *ast.BreakStatement, *ast.ContinueStatement:
+		return true
+	}
+
+	return false
+}
+
+func statementToken(s ast.Statement) token.Token {
+	switch s := s.(type) {
+	case *ast.LetStatement:
+		return s.Token
+	case *ast.ReturnStatement:
+		return s.Token
+	case *ast.ThrowStatement:
+		return s.Token
+	case *ast.ExpressionStatement:
+		return s.Token
+	case *ast.WhileStatement:
+		return s.Token
+	case *ast.ForStatement:
+		return s.Token
+	case *ast.BreakStatement:
+		return s.Token
+	case *ast.ContinueStatement:
+		return s.Token
+	case *ast.BlockStatement:
+		return s.Token
+	}
+
+	return token.Token{}
+}
+
+func (c *checker) visit(node ast.Node) {
+	switch node := node.(type) {
+
+	case *ast.BlockStatement:
+		c.statements(node.Statements)
+
+	case *ast.LetStatement:
+		b := c.define(node.Name, bindingLet)
+		if fn, ok := node.Value.(*ast.FunctionLiteral); ok && !b.reassigned {
+			b.fn = fn
+		}
+
+		c.visit(node.Value)
+
+	case *ast.Identifier:
+		symbol, ok := c.scope.table.Resolve(node.Value)
+		if !ok {
+			return
+		}
+
+		// A function calling itself doesn't make it used
+		if b := c.lookup(node.Value); b != nil && symbol.Scope != compiler.FunctionScope {
+			b.used = true
+		}
+
+	case *ast.AssignExpression:
+		if target, ok := node.Target.(*ast.Identifier); ok {
+			if b := c.lookup(target.Value); b != nil {
+				b.reassigned = true
+			}
+
+			// Only compound assignment reads the old value
+			if node.Operator != "=" {
+				c.visit(target)
+			}
+		} else {
+			c.visit(node.Target)
+		}
+
+		c.visit(node.Value)
+
+	case *ast.FunctionLiteral:
+		c.scope = &scope{
+			table:    compiler.NewEnclosedSymbolTable(c.scope.table),
+			outer:    c.scope,
+			bindings: map[string]*binding{},
+		}
+
+		if node.Name != "" {
+			c.scope.table.DefineFunctionName(node.Name)
+			if b := c.scope.outer.bindings[node.Name]; b != nil {
+				c.scope.bindings[node.Name] = b
+			}
+		}
+
+		for _, param := range node.Parameters {
+			c.define(param, bindingParameter)
+		}
+
+		c.visit(node.Body)
+
+		c.scope = c.scope.outer
+
+	case *ast.TryExpression:
+		c.visit(node.Body)
+		c.define(node.Param, bindingCatch)
+		c.visit(node.Handler)
+
+	case *ast.ForStatement:
+		// The post statement runs after the body, so it can see the body's lets
+		c.visit(node.Init)
+		c.visit(node.Condition)
+		c.visit(node.Body)
+		c.visit(node.Post)
+
+	case *ast.CallExpression:
+		c.visitCall(node)
+
+	case *ast.IfExpression:
+		c.checkCondition(node)
+		c.visitChildren(node)
+
+	case *ast.IndexExpression:
+		c.checkIndex(node)
+		c.visitChildren(node)
+
+	case *ast.MacroLiteral:
+		// Macro bodies are mostly quoted code, only checked where it's spliced in
+
+	default:
+		c.visitChildren(node)
+	}
+}
+
+func (c *checker) visitChildren(node ast.Node) {
+	for _, child := range ast.Children(node) {
+		c.visit(child)
+	}
+}
+
+func (c *checker) visitCall(node *ast.CallExpression) {
+	switch fn := node.Function.(type) {
+
+	case *ast.Identifier:
+		b := c.lookup(fn.Value)
+
+		// Quoted code is only code once a macro splices it in
+		if b == nil && (fn.Value == "quote" || fn.Value == "unquote") {
+			return
+		}
+
+		c.visit(fn)
+
+		switch {
+		case b == nil:
+		case b.kind == bindingBuiltin:
+			arity := builtinArity[b.name]
+			c.checkArity(node, b.name, arity.min, arity.max)
+		default:
+			c.calls = append(c.calls, call{expression: node, binding: b})
+		}
+
+	case *ast.FunctionLiteral:
+		c.visit(fn)
+		c.checkArity(node, "the function", len(fn.Parameters), len(fn.Parameters))
+
+	default:
+		c.visit(fn)
+	}
+
+	for _, arg := range node.Arguments {
+		c.visit(arg)
+	}
+}
+
+func (c *checker) checkArity(node *ast.CallExpression, name string, min int, max int) {
+	got := len(node.Arguments)
+	if got >= min && (max == -1 || got <= max) {
+		return
+	}
+
+	want := fmt.Sprint(min)
+	switch {
+	case max == -1:
+		want = fmt.Sprintf("at least %d", min)
+	case max != min:
+		want = fmt.Sprintf("%d to %d", min, max)
+	}
+
+	c.report(Arity, node.Token.Span, "wrong number of arguments to %s: want=%s, got=%d", name, want, got)
+}
+
+// An if whose condition is built only from literals always takes the same
+// branch. Evaluating the condition says which.
+func (c *checker) checkCondition(node *ast.IfExpression) {
+	if !isConstant(node.Condition) {
+		return
+	}
+
+	var truthy bool
+	switch result := eval.Eval(node.Condition, object.NewEnvironment()).(type) {
+	case *object.Boolean:
+		truthy = result.Value
+	case *object.Null:
+		truthy = false
+	case *object.Exception, *object.Error:
+		return
+	default:
+		truthy = true
+	}
+
+	c.report(ConstantCondition, node.Token.Span, "if condition is always %t", truthy)
+}
+
+func isConstant(node ast.Expression) bool {
+	switch node := node.(type) {
+	case *ast.Boolean, *ast.IntegerLiteral, *ast.FloatLiteral, *ast.StringLiteral:
+		return true
+	case *ast.PrefixExpression:
+		return isConstant(node.Right)
+	case *ast.InfixExpression:
+		return isConstant(node.Left) && isConstant(node.Right)
+	}
+
+	return false
+}
+
+// Arrays take integer indexes, and hashes take keys that can be hashed:
+// integers, floats, strings and booleans
+func (c *checker) checkIndex(node *ast.IndexExpression) {
+	index := literalType(node.Index)
+	if index == "" {
+		return
+	}
+
+	switch left := literalType(node.Left); left {
+	case "":
+	case object.ARRAY_OBJ:
+		if index != object.INTEGER_OBJ {
+			c.report(IndexType, node.Token.Span, "array index must be an integer, got %s", index)
+		}
+	case object.HASH_OBJ:
+		if index == object.ARRAY_OBJ || index == object.HASH_OBJ || index == object.FUNCTION_OBJ {
+			c.report(IndexType, node.Token.Span, "unusable as hash key: %s", index)
+		}
+	default:
+		c.report(IndexType, node.Token.Span, "index operator not supported: %s", left)
+	}
+}
+
+// The type of value a literal makes, or "" for anything else
+func literalType(node ast.Expression) object.ObjectType {
+	switch node.(type) {
+	case *ast.IntegerLiteral:
+		return object.INTEGER_OBJ
+	case *ast.FloatLiteral:
+		return object.FLOAT_OBJ
+	case *ast.StringLiteral:
+		return object.STRING_OBJ
+	case *ast.Boolean:
+		return object.BOOLEAN_OBJ
+	case *ast.ArrayLiteral:
+		return object.ARRAY_OBJ
+	case *ast.HashLiteral:
+		return object.HASH_OBJ
+	case *ast.FunctionLiteral:
+		return object.FUNCTION_OBJ
+	}
+
+	return ""
+}
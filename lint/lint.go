@@ -0,0 +1,128 @@
+package lint
+
+import (
+	"rafiki/ast"
+	"rafiki/diagnostic"
+	"rafiki/lexer"
+	"rafiki/parser"
+	"sort"
+	"strings"
+)
+
+// A check the linter makes. Either the Code or the Name can be used to turn
+// it off, on the command line or in a lint:ignore comment.
+type Rule struct {
+	Code    string // Stable, like L001
+	Name    string // Readable, like unused-variable
+	Summary string
+}
+
+var (
+	UnusedVariable    = Rule{"L001", "unused-variable", "a let inside a function is never read"}
+	UnusedParameter   = Rule{"L002", "unused-parameter", "a function parameter is never read"}
+	Shadow            = Rule{"L003", "shadow", "a let or parameter hides a name from an outer scope"}
+	Unreachable       = Rule{"L004", "unreachable", "a statement follows a return, throw, break or continue"}
+	Arity             = Rule{"L005", "arity", "a function or builtin is called with the wrong number of arguments"}
+	ConstantCondition = Rule{"L006", "constant-condition", "an if condition is always true or always false"}
+	IndexType         = Rule{"L007", "index-type", "a literal is indexed with a key of the wrong type"}
+)
+
+var Rules = []Rule{
+	UnusedVariable,
+	UnusedParameter,
+	Shadow,
+	Unreachable,
+	Arity,
+	ConstantCondition,
+	IndexType,
+}
+
+// Find a rule by its code or its name
+func LookupRule(id string) (Rule, bool) {
+	for _, rule := range Rules {
+		if rule.Code == id || rule.Name == id {
+			return rule, true
+		}
+	}
+
+	return Rule{}, false
+}
+
+/*
+Source lints a program, returning warnings in source order. A source that
+doesn't parse gets the parser's diagnostics instead, since the checks need
+the whole tree.
+
+A warning can be silenced with a comment naming its rule, either at the end
+of the line or on the line above:
+
+	let unused = 1; // lint:ignore unused-variable
+	// lint:ignore L005
+	f(1, 2, 3);
+*/
+func Source(filename string, src string) []*diagnostic.Diagnostic {
+	p := parser.NewParser(lexer.NewLexerWithFilename(src, filename))
+	program := p.ParseProgram()
+
+	if diagnostics := p.Diagnostics(); len(diagnostics) > 0 {
+		return diagnostics
+	}
+
+	c := newChecker()
+	c.check(program)
+
+	ignored := ignores(program.Comments)
+	diagnostics := []*diagnostic.Diagnostic{}
+
+	for _, d := range c.diagnostics {
+		if !ignored[d.Span.Start.Line][d.Code] {
+			diagnostics = append(diagnostics, d)
+		}
+	}
+
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		return diagnostics[i].Span.Start.Offset < diagnostics[j].Span.Start.Offset
+	})
+
+	return diagnostics
+}
+
+// The rule codes silenced on each line by lint:ignore comments. A comment
+// that names no rules silences all of them.
+func ignores(comments []*ast.Comment) map[int]map[string]bool {
+	ignored := map[int]map[string]bool{}
+
+	for _, c := range comments {
+		text := c.Text()
+		if !strings.HasPrefix(text, "lint:ignore") {
+			continue
+		}
+
+		line := c.End().Line + 1
+		if c.Trailing {
+			line = c.Pos().Line
+		}
+
+		if ignored[line] == nil {
+			ignored[line] = map[string]bool{}
+		}
+
+		ids := strings.FieldsFunc(strings.TrimPrefix(text, "lint:ignore"), func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t'
+		})
+
+		if len(ids) == 0 {
+			for _, rule := range Rules {
+				ignored[line][rule.Code] = true
+			}
+		}
+
+		for _, id := range ids {
+			if rule, ok := LookupRule(id); ok {
+				ignored[line][rule.Code] = true
+			}
+		}
+	}
+
+	return ignored
+}
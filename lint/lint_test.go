@@ -0,0 +1,137 @@
+package lint
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// Each warning as line:column code
+func lint(t *testing.T, src string) []string {
+	t.Helper()
+
+	warnings := []string{}
+	for _, d := range Source("main.rk", src) {
+		warnings = append(warnings, fmt.Sprintf("%d:%d %s", d.Span.Start.Line, d.Span.Start.Column, d.Code))
+	}
+
+	return warnings
+}
+
+func TestRules(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		// Unused lets are only reported inside functions, where nothing
+		// else can see them
+		{"let x = 1;", []string{}},
+		{"fn() { let x = 1; let y = 2; y }", []string{"1:12 L001"}},
+		{"fn() { let _x = 1; }", []string{}},
+		{"fn() { let n = 0; n += 1; }", []string{}},
+		{"fn() { let n = 0; n = 1; }", []string{"1:12 L001"}},
+		{"fn() { let x = 0; fn() { x } }", []string{}},
+		{"fn(a, b, _c) { a }", []string{"1:7 L002"}},
+		{"fn() { let f = fn() { f() }; }", []string{"1:12 L001"}},
+		{"try { 1 } catch (e) { 2 }", []string{}},
+
+		{"let len = 1;", []string{"1:5 L003"}},
+		{"let x = 1; fn(x) { x }", []string{"1:15 L003"}},
+		{"fn(a) { fn() { let a = 1; a } }", []string{"1:4 L002", "1:20 L003"}},
+		{"let f = fn(f) { f }", []string{"1:12 L003"}},
+		{"let x = 1; let x = 2; fn() { let y = 1; let y = 2; y }", []string{}},
+
+		{"fn() { return 1; 2; 3 }", []string{"1:18 L004"}},
+		{"while (true) { break; puts(1) }", []string{"1:23 L004"}},
+		{"fn() { throw 1; }", []string{}},
+
+		{"let f = fn(a, b) { a + b }; f(1); f(1, 2)", []string{"1:30 L005"}},
+		{"let f = fn(a) { a }; f = fn() { 1 }; f()", []string{}},
+		{"let f = fn(a) { a }; let f = fn() { 1 }; f()", []string{}},
+		{"let f = fn(n) { f() }; f(1)", []string{"1:12 L002", "1:18 L005"}},
+		{"fn(a) { a }()", []string{"1:12 L005"}},
+		{"len(); puts(); puts(1, 2); round(1, 2); round(1, 2, 3)", []string{"1:4 L005", "1:46 L005"}},
+		{"let f = 1; f(1, 2)", []string{}},
+
+		{"if (true) { 1 }", []string{"1:1 L006"}},
+		{"if (!(1 < 2)) { 1 }", []string{"1:1 L006"}},
+		{`if (0) { 1 }; if ("") { 1 }`, []string{"1:1 L006", "1:15 L006"}},
+		{"let x = 1; if (x) { 1 }", []string{}},
+		{"if (1 / 0 == 1) { 1 }", []string{}},
+
+		{`[1, 2]["a"]; [1, 2][0]; [1, 2][1.5]`, []string{"1:7 L007", "1:31 L007"}},
+		{`{"a": 1}[[1]]; {"a": 1}[fn() { 1 }]; {"a": 1}["a"]; {1: 2}[true]`, []string{"1:9 L007", "1:24 L007"}},
+		{`"abc"[0]; 5[0]; let xs = [1]; xs["a"]`, []string{"1:6 L007", "1:12 L007"}},
+
+		// Macros are checked where they're expanded, not where they're defined
+		{"let m = macro(a, b) { quote(unquote(a)) };", []string{}},
+	}
+
+	for _, tt := range tests {
+		warnings := lint(t, tt.input)
+		if !reflect.DeepEqual(warnings, tt.expected) {
+			t.Errorf("%q: wrong warnings.\nwant=%v\ngot= %v", tt.input, tt.expected, warnings)
+		}
+	}
+}
+
+func TestMessages(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"fn() { let x = 1; }", "x is declared but never used"},
+		{"fn(a) { 1 }", "parameter a is never used"},
+		{"let puts = 1;", "puts shadows the builtin puts"},
+		{"fn(a) { fn(a) { a } }", "a shadows a variable of an enclosing function"},
+		{"fn() { return 1; 2 }", "unreachable code after return"},
+		{"push([])", "wrong number of arguments to push: want=2, got=1"},
+		{"round()", "wrong number of arguments to round: want=1 to 2, got=0"},
+		{"if (1 > 2) { 1 }", "if condition is always false"},
+		{"[1][true]", "array index must be an integer, got BOOLEAN"},
+	}
+
+	for _, tt := range tests {
+		diagnostics := Source("main.rk", tt.input)
+		if len(diagnostics) == 0 || diagnostics[len(diagnostics)-1].Message != tt.expected {
+			t.Errorf("%q: wrong message. want=%q, got=%v", tt.input, tt.expected, diagnostics)
+		}
+	}
+}
+
+func TestIgnoreComments(t *testing.T) {
+	src := `let len = 1; // lint:ignore shadow
+// lint:ignore L005, L003
+let puts = push(1);
+// lint:ignore
+fn(a) { let b = 1; }
+fn(a) { 1 } // lint:ignore unused-variable
+`
+
+	expected := []string{"6:4 L002"}
+	if warnings := lint(t, src); !reflect.DeepEqual(warnings, expected) {
+		t.Errorf("wrong warnings. want=%v, got=%v", expected, warnings)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	diagnostics := Source("main.rk", "let = 1;")
+	if len(diagnostics) != 1 || diagnostics[0].Code != "P001" {
+		t.Errorf("expected the parse error. got=%v", diagnostics)
+	}
+}
+
+func TestLookupRule(t *testing.T) {
+	for _, rule := range Rules {
+		if byCode, ok := LookupRule(rule.Code); !ok || byCode != rule {
+			t.Errorf("%s not found by code", rule.Code)
+		}
+		if byName, ok := LookupRule(rule.Name); !ok || byName != rule {
+			t.Errorf("%s not found by name", rule.Name)
+		}
+	}
+
+	if _, ok := LookupRule("L999"); ok {
+		t.Errorf("found a rule that doesn't exist")
+	}
+}
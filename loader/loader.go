@@ -0,0 +1,119 @@
+package loader
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"rafiki/ast"
+	"rafiki/diagnostic"
+	"rafiki/lexer"
+	"rafiki/parser"
+	"rafiki/token"
+	"strings"
+)
+
+// A file an import expression refers to
+type Module struct {
+	Path     string // Absolute, so every import of the file shares one cache entry
+	Filename string // As shown in error messages, relative to the working directory when possible
+	Program  *ast.Program
+}
+
+// The name a module is known by: its file name without the extension
+func (m *Module) Name() string {
+	return strings.TrimSuffix(filepath.Base(m.Path), filepath.Ext(m.Path))
+}
+
+// The absolute path of an import. A relative path is resolved against the
+// directory of the file doing the importing, or the working directory when
+// the import isn't in a file, like in the REPL.
+func Resolve(from token.Position, path string) (string, error) {
+	if !filepath.IsAbs(path) && from.Filename != "" {
+		path = filepath.Join(filepath.Dir(from.Filename), path)
+	}
+
+	return filepath.Abs(path)
+}
+
+// Read and parse the file at the absolute path. Macros are left for the
+// engine to expand.
+func Load(path string) (*Module, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		// The importer already knows which file it asked for
+		var pathErr *fs.PathError
+		if errors.As(err, &pathErr) {
+			return nil, pathErr.Err
+		}
+		return nil, err
+	}
+
+	module := &Module{Path: path, Filename: displayName(path)}
+
+	p := parser.NewParser(lexer.NewLexerWithFilename(string(src), module.Filename))
+	module.Program = p.ParseProgram()
+
+	if diagnostics := p.Diagnostics(); len(diagnostics) > 0 {
+		// The first error is the one worth reading; the rest tend to follow from it
+		d := diagnostics[0]
+		return nil, diagnostic.Errorf(d.Span.Start, "%s", d.Message)
+	}
+
+	return module, nil
+}
+
+func displayName(path string) string {
+	wd, err := os.Getwd()
+	if err != nil {
+		return path
+	}
+
+	rel, err := filepath.Rel(wd, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return path
+	}
+
+	return rel
+}
+
+/*
+Enter returns the modules being run once the module at path starts, or an
+error if path is already among them. An import of a module that's still
+running would otherwise recurse forever:
+
+	import cycle: main.rk -> a.rk -> main.rk
+
+running lists absolute paths, outermost first. It's empty before the first
+import, in which case the file named by from, where the import is, starts
+the list: the main program is never imported itself.
+*/
+func Enter(running []string, from token.Position, path string) ([]string, error) {
+	if len(running) == 0 && from.Filename != "" {
+		if root, err := filepath.Abs(from.Filename); err == nil {
+			running = []string{root}
+		}
+	}
+
+	for i, p := range running {
+		if p != path {
+			continue
+		}
+
+		// Relative to the first file, which is usually the main program
+		dir := filepath.Dir(running[0])
+		names := []string{}
+		for _, p := range append(running[i:], path) {
+			if rel, err := filepath.Rel(dir, p); err == nil {
+				p = rel
+			}
+			names = append(names, p)
+		}
+
+		return nil, fmt.Errorf("import cycle: %s", strings.Join(names, " -> "))
+	}
+
+	// A fresh slice, so the caller's list is untouched when the module is done
+	return append(running[:len(running):len(running)], path), nil
+}
@@ -0,0 +1,93 @@
+package loader
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"rafiki/diagnostic"
+	"rafiki/token"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		from     string
+		path     string
+		expected string
+	}{
+		{filepath.Join(dir, "main.rk"), "lib.rk", filepath.Join(dir, "lib.rk")},
+		{filepath.Join(dir, "src", "main.rk"), "../lib/util.rk", filepath.Join(dir, "lib", "util.rk")},
+		{filepath.Join(dir, "main.rk"), "/abs/lib.rk", "/abs/lib.rk"},
+	}
+
+	for _, tt := range tests {
+		path, err := Resolve(token.Position{Filename: tt.from, Line: 1}, tt.path)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if path != tt.expected {
+			t.Errorf("%q from %q: wrong path. want=%q, got=%q", tt.path, tt.from, tt.expected, path)
+		}
+	}
+}
+
+func TestEnter(t *testing.T) {
+	dir := t.TempDir()
+	main := token.Position{Filename: filepath.Join(dir, "main.rk"), Line: 1}
+	a, b := filepath.Join(dir, "a.rk"), filepath.Join(dir, "b.rk")
+
+	running, err := Enter(nil, main, a)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(running) != 2 || running[0] != main.Filename || running[1] != a {
+		t.Fatalf("wrong modules running. got=%v", running)
+	}
+
+	running, err = Enter(running, token.Position{Filename: a, Line: 1}, b)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	_, err = Enter(running, token.Position{Filename: b, Line: 1}, a)
+	if err == nil || err.Error() != "import cycle: a.rk -> b.rk -> a.rk" {
+		t.Errorf("expected a cycle through a.rk. got=%v", err)
+	}
+
+	// The main program counts, though it was never imported
+	_, err = Enter(running, token.Position{Filename: b, Line: 1}, main.Filename)
+	if err == nil || err.Error() != "import cycle: main.rk -> a.rk -> b.rk -> main.rk" {
+		t.Errorf("expected a cycle back to main.rk")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	good, bad := filepath.Join(dir, "good.rk"), filepath.Join(dir, "bad.rk")
+	os.WriteFile(good, []byte("let x = 1;"), 0o644)
+	os.WriteFile(bad, []byte("let x = 1;\nlet = 2;"), 0o644)
+
+	module, err := Load(good)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if module.Name() != "good" || len(module.Program.Statements) != 1 {
+		t.Errorf("wrong module. name=%q, statements=%d", module.Name(), len(module.Program.Statements))
+	}
+
+	_, err = Load(bad)
+
+	var diagErr *diagnostic.Error
+	if !errors.As(err, &diagErr) || diagErr.Pos.Line != 2 || diagErr.Pos.Column != 5 {
+		t.Errorf("expected a syntax error at 2:5. got=%v", err)
+	}
+
+	if _, err = Load(filepath.Join(dir, "missing.rk")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the file not to exist. got=%v", err)
+	}
+}
@@ -0,0 +1,398 @@
+package lsp
+
+import (
+	"errors"
+	"io"
+	"rafiki/ast"
+	"rafiki/compiler"
+	"rafiki/diagnostic"
+	"rafiki/eval"
+	"rafiki/lexer"
+	"rafiki/object"
+	"rafiki/parser"
+	"rafiki/token"
+	"sort"
+	"strings"
+)
+
+// What the server knows about a document's source
+type analysis struct {
+	program     *ast.Program
+	diagnostics []*diagnostic.Diagnostic // From the parser, or the compiler if parsing succeeded
+	refs        []reference              // Every identifier, in source order
+	scopes      []*scope                 // Every scope, the global one first
+}
+
+// Where a name was bound
+type binding struct {
+	name string
+	kind bindingKind
+	def  *ast.Identifier // nil for builtins
+	doc  string          // The let's doc comment
+	fn   *ast.FunctionLiteral
+}
+
+type bindingKind int
+
+const (
+	bindingLet bindingKind = iota
+	bindingParameter
+	bindingCatch
+	bindingBuiltin
+)
+
+// An identifier in the source and what it refers to. binding is nil when the
+// name isn't defined. scope is how the compiler resolves it at this spot, so a
+// local of an enclosing function is FREE here.
+type reference struct {
+	ident   *ast.Identifier
+	binding *binding
+	scope   compiler.SymbolScope
+}
+
+// A symbol table and the bindings made in it, by name. start and end bound
+// the function it belongs to; the global scope covers everything.
+type scope struct {
+	table    *compiler.SymbolTable
+	outer    *scope
+	bindings map[string]*binding
+	defined  []*binding // In the order they were made
+	start    int
+	end      int
+}
+
+func analyze(filename string, src string) *analysis {
+	p := parser.NewParser(lexer.NewLexerWithFilename(src, filename))
+	program := p.ParseProgram()
+
+	a := &analysis{program: program, diagnostics: p.Diagnostics()}
+
+	r := &resolver{analysis: a}
+	r.resolve(program, len(src))
+
+	if len(a.diagnostics) == 0 {
+		if d := compileDiagnostic(filename, src); d != nil {
+			a.diagnostics = append(a.diagnostics, d)
+		}
+	}
+
+	return a
+}
+
+// Compile a fresh copy of the program, since taking its macros out changes
+// the tree, and turn the first compile error into a diagnostic
+func compileDiagnostic(filename string, src string) *diagnostic.Diagnostic {
+	p := parser.NewParser(lexer.NewLexerWithFilename(src, filename))
+	program := p.ParseProgram()
+
+	// Anything puts prints, like a macro in an imported file while it's
+	// compiled, is dropped, since stdout is carrying the protocol
+	builtins := object.DefaultBuiltins()
+	builtins.SetOutput(io.Discard)
+
+	macroEnv := object.NewEnvironmentWithBuiltins(builtins)
+	eval.DefineMacros(program, macroEnv)
+
+	err := compiler.NewCompilerWithBuiltins(builtins).Compile(withoutMacroCalls(program, macroEnv))
+	if err == nil {
+		return nil
+	}
+
+	d := &diagnostic.Diagnostic{Severity: diagnostic.SeverityError, Message: err.Error()}
+
+	var diagErr *diagnostic.Error
+	if errors.As(err, &diagErr) {
+		d.Message = diagErr.Message
+		d.Span = wordAt(src, diagErr.Pos)
+	}
+
+	return d
+}
+
+// Replace each call to a macro in env with a placeholder value. Expanding
+// the call would run the macro's body, which can print over the protocol or
+// never finish, so analysis leaves what it expands to unchecked.
+func withoutMacroCalls(program ast.Node, env *object.Environment) ast.Node {
+	return ast.Modify(program, func(node ast.Node) ast.Node {
+		call, ok := node.(*ast.CallExpression)
+		if !ok {
+			return node
+		}
+
+		name, ok := call.Function.(*ast.Identifier)
+		if !ok {
+			return node
+		}
+
+		if macro, ok := env.Get(name.Value); !ok || macro.Type() != object.MACRO_OBJ {
+			return node
+		}
+
+		return &ast.Boolean{Token: token.Token{Type: token.FALSE, Literal: "false", Span: name.Token.Span}}
+	})
+}
+
+// The span of the identifier or single character at pos, to underline
+func wordAt(src string, pos token.Position) token.Span {
+	end := pos
+	for end.Offset < len(src) && isIdentChar(src[end.Offset]) {
+		end.Offset++
+		end.Column++
+	}
+
+	if end.Offset == pos.Offset && end.Offset < len(src) {
+		end.Offset++
+		end.Column++
+	}
+
+	return token.Span{Start: pos, End: end}
+}
+
+func isIdentChar(ch byte) bool {
+	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
+}
+
+// The reference under the cursor, if any
+func (a *analysis) referenceAt(offset int) *reference {
+	for i := range a.refs {
+		if touches(a.refs[i].ident.Token.Span, offset) {
+			return &a.refs[i]
+		}
+	}
+
+	return nil
+}
+
+// The names visible at offset: the builtins and globals, then the bindings of
+// each function around offset, innermost last. Only bindings made before
+// offset count, as the compiler would see it.
+func (a *analysis) visible(offset int) []*binding {
+	seen := map[string]int{}
+	visible := []*binding{}
+
+	for _, s := range a.scopes {
+		if offset < s.start || offset > s.end {
+			continue
+		}
+
+		for _, b := range s.defined {
+			if b.def != nil && b.def.Token.Span.Start.Offset >= offset {
+				continue
+			}
+
+			// An inner binding shadows an outer one of the same name
+			if i, ok := seen[b.name]; ok {
+				visible[i] = b
+				continue
+			}
+
+			seen[b.name] = len(visible)
+			visible = append(visible, b)
+		}
+	}
+
+	return visible
+}
+
+/*
+resolver mirrors the compiler's scoping: it walks the tree in the order the
+compiler does, defining and resolving names in a compiler.SymbolTable, so a
+name resolves here exactly when it would compile. Alongside each table it
+remembers the identifier that made each binding.
+*/
+type resolver struct {
+	analysis *analysis
+	scope    *scope
+}
+
+func (r *resolver) resolve(program *ast.Program, end int) {
+	r.scope = &scope{
+		table:    compiler.NewSymbolTable(),
+		bindings: map[string]*binding{},
+		end:      end,
+	}
+	r.analysis.scopes = append(r.analysis.scopes, r.scope)
+
+	for i, name := range object.DefaultBuiltins().Names() {
+		r.scope.table.DefineBuiltin(i, name)
+		r.bind(&binding{name: name, kind: bindingBuiltin})
+	}
+
+	r.visit(program)
+
+	sort.SliceStable(r.analysis.refs, func(i, j int) bool {
+		return r.analysis.refs[i].ident.Token.Span.Start.Offset < r.analysis.refs[j].ident.Token.Span.Start.Offset
+	})
+}
+
+func (r *resolver) bind(b *binding) {
+	r.scope.bindings[b.name] = b
+	r.scope.defined = append(r.scope.defined, b)
+}
+
+// Define ident in the current scope, and record it as a reference to itself
+func (r *resolver) define(ident *ast.Identifier, b *binding) {
+	symbol := r.scope.table.Define(ident.Value)
+	r.bind(b)
+	r.analysis.refs = append(r.analysis.refs, reference{ident: ident, binding: b, scope: symbol.Scope})
+}
+
+// The binding name refers to from the current scope, innermost first
+func (r *resolver) lookup(name string) *binding {
+	for s := r.scope; s != nil; s = s.outer {
+		if b, ok := s.bindings[name]; ok {
+			return b
+		}
+	}
+
+	return nil
+}
+
+func (r *resolver) visit(node ast.Node) {
+	switch node := node.(type) {
+
+	case *ast.LetStatement:
+		b := &binding{name: node.Name.Value, kind: bindingLet, def: node.Name, doc: node.Doc.Text()}
+		if fn, ok := node.Value.(*ast.FunctionLiteral); ok {
+			b.fn = fn
+		}
+
+		r.define(node.Name, b)
+		r.visit(node.Value)
+
+	case *ast.Identifier:
+		ref := reference{ident: node}
+
+		if symbol, ok := r.scope.table.Resolve(node.Value); ok {
+			ref.binding = r.lookup(node.Value)
+			ref.scope = symbol.Scope
+		}
+
+		r.analysis.refs = append(r.analysis.refs, ref)
+
+	case *ast.FunctionLiteral:
+		r.scope = &scope{
+			table:    compiler.NewEnclosedSymbolTable(r.scope.table),
+			outer:    r.scope,
+			bindings: map[string]*binding{},
+			start:    node.Pos().Offset,
+			end:      end(node).Offset,
+		}
+		r.analysis.scopes = append(r.analysis.scopes, r.scope)
+
+		if node.Name != "" {
+			r.scope.table.DefineFunctionName(node.Name)
+			if b := r.scope.outer.bindings[node.Name]; b != nil {
+				r.scope.bindings[node.Name] = b
+			}
+		}
+
+		for _, param := range node.Parameters {
+			r.define(param, &binding{name: param.Value, kind: bindingParameter, def: param, fn: node})
+		}
+
+		r.visit(node.Body)
+
+		r.scope = r.scope.outer
+
+	case *ast.TryExpression:
+		r.visit(node.Body)
+		r.define(node.Param, &binding{name: node.Param.Value, kind: bindingCatch, def: node.Param})
+		r.visit(node.Handler)
+
+	case *ast.ForStatement:
+		// The post statement runs after the body, so it can see the body's lets
+		r.visit(node.Init)
+		r.visit(node.Condition)
+		r.visit(node.Body)
+		r.visit(node.Post)
+
+	case *ast.MacroLiteral:
+		// Macros are expanded away before compiling, and their bodies are
+		// mostly quoted code that's resolved where it's spliced in
+
+	default:
+		for _, child := range ast.Children(node) {
+			r.visit(child)
+		}
+	}
+}
+
+// Where a node's last token ends, counting the closing braces of blocks and
+// the semicolons of lets
+func end(node ast.Node) token.Position {
+	var last token.Position
+	later := func(pos token.Position) {
+		if pos.IsValid() && pos.Offset > last.Offset {
+			last = pos
+		}
+	}
+
+	ast.Inspect(node, func(n ast.Node) bool {
+		if t, ok := nodeToken(n); ok {
+			later(t.Span.End)
+		}
+
+		switch n := n.(type) {
+		case *ast.BlockStatement:
+			later(n.Rbrace.Span.End)
+		case *ast.LetStatement:
+			later(n.End)
+		}
+		return true
+	})
+
+	return last
+}
+
+func nodeToken(node ast.Node) (token.Token, bool) {
+	switch node := node.(type) {
+	case *ast.Identifier:
+		return node.Token, true
+	case *ast.IntegerLiteral:
+		return node.Token, true
+	case *ast.FloatLiteral:
+		return node.Token, true
+	case *ast.StringLiteral:
+		return node.Token, true
+	case *ast.Boolean:
+		return node.Token, true
+	case *ast.BreakStatement:
+		return node.Token, true
+	case *ast.ContinueStatement:
+		return node.Token, true
+	case *ast.BlockStatement:
+		return node.Token, true
+	case *ast.FunctionLiteral:
+		return node.Token, true
+	}
+
+	return token.Token{}, false
+}
+
+// How a binding is written, for hovers and completion details
+func (b *binding) signature() string {
+	switch b.kind {
+	case bindingBuiltin:
+		return "builtin " + b.name
+	case bindingParameter:
+		return "parameter " + b.name
+	case bindingCatch:
+		return "catch (" + b.name + ")"
+	}
+
+	if b.fn != nil {
+		return "let " + b.name + " = " + fnSignature(b.fn)
+	}
+
+	return "let " + b.name
+}
+
+func fnSignature(fn *ast.FunctionLiteral) string {
+	params := []string{}
+	for _, p := range fn.Parameters {
+		params = append(params, p.Value)
+	}
+
+	return "fn(" + strings.Join(params, ", ") + ")"
+}
@@ -0,0 +1,226 @@
+package lsp
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"rafiki/compiler"
+	"regexp"
+	"testing"
+	"time"
+)
+
+const source = `// Adds two numbers
+let add = fn(a, b) {
+  let sum = a + b;
+  sum
+};
+let makeCounter = fn() {
+  let count = 0;
+  fn() { count += 1; count }
+};
+let fact = fn(n) { if (n < 2) { 1 } else { n * fact(n - 1) } };
+let total = add(1, len("ab"));
+try { throw "x" } catch (e) { e };
+`
+
+// The offset of the nth (from 1) occurrence of the word in src
+func offsetOf(t *testing.T, src string, word string, n int) int {
+	t.Helper()
+
+	matches := regexp.MustCompile(`\b`+word+`\b`).FindAllStringIndex(src, -1)
+	if len(matches) < n {
+		t.Fatalf("%q occurs fewer than %d times", word, n)
+	}
+
+	return matches[n-1][0]
+}
+
+func TestResolution(t *testing.T) {
+	a := analyze("main.rk", source)
+	if len(a.diagnostics) != 0 {
+		t.Fatalf("unexpected diagnostics: %v", a.diagnostics)
+	}
+
+	tests := []struct {
+		name       string
+		occurrence int
+		scope      compiler.SymbolScope
+		defLine    int // 0 for builtins
+	}{
+		{"a", 2, compiler.LocalScope, 2},    // a + b
+		{"sum", 2, compiler.LocalScope, 3},  // sum
+		{"count", 2, compiler.FreeScope, 7}, // count += 1
+		{"count", 3, compiler.FreeScope, 7}, // count
+		{"fact", 2, compiler.FunctionScope, 10},
+		{"add", 2, compiler.GlobalScope, 2}, // add(1, ...)
+		{"len", 1, compiler.BuiltinScope, 0},
+		{"e", 2, compiler.GlobalScope, 12}, // catch (e) { e }
+		{"total", 1, compiler.GlobalScope, 11},
+	}
+
+	for _, tt := range tests {
+		ref := a.referenceAt(offsetOf(t, source, tt.name, tt.occurrence))
+		if ref == nil || ref.ident.Value != tt.name {
+			t.Fatalf("%s #%d: no reference found. got=%+v", tt.name, tt.occurrence, ref)
+		}
+
+		if ref.scope != tt.scope {
+			t.Errorf("%s #%d: wrong scope. want=%s, got=%s", tt.name, tt.occurrence, tt.scope, ref.scope)
+		}
+
+		if ref.binding == nil {
+			t.Fatalf("%s #%d: not resolved", tt.name, tt.occurrence)
+		}
+
+		defLine := 0
+		if ref.binding.def != nil {
+			defLine = ref.binding.def.Pos().Line
+		}
+		if defLine != tt.defLine {
+			t.Errorf("%s #%d: wrong definition line. want=%d, got=%d", tt.name, tt.occurrence, tt.defLine, defLine)
+		}
+	}
+}
+
+func TestSignatures(t *testing.T) {
+	a := analyze("main.rk", source)
+
+	tests := []struct {
+		name       string
+		occurrence int
+		expected   string
+		doc        string
+	}{
+		{"add", 1, "let add = fn(a, b)", "Adds two numbers"},
+		{"b", 2, "parameter b", ""},
+		{"len", 1, "builtin len", ""},
+		{"e", 1, "catch (e)", ""},
+		{"total", 1, "let total", ""},
+	}
+
+	for _, tt := range tests {
+		ref := a.referenceAt(offsetOf(t, source, tt.name, tt.occurrence))
+		if ref == nil || ref.binding == nil {
+			t.Fatalf("%s: not resolved", tt.name)
+		}
+
+		if sig := ref.binding.signature(); sig != tt.expected {
+			t.Errorf("%s: wrong signature. want=%q, got=%q", tt.name, tt.expected, sig)
+		}
+
+		if ref.binding.doc != tt.doc {
+			t.Errorf("%s: wrong doc. want=%q, got=%q", tt.name, tt.doc, ref.binding.doc)
+		}
+	}
+}
+
+func TestUndefinedName(t *testing.T) {
+	src := "let x = 1;\nlet y = x + z;\n"
+	a := analyze("main.rk", src)
+
+	ref := a.referenceAt(offsetOf(t, src, "z", 1))
+	if ref == nil || ref.binding != nil {
+		t.Errorf("z should be an unresolved reference. got=%+v", ref)
+	}
+
+	if len(a.diagnostics) != 1 {
+		t.Fatalf("wrong number of diagnostics. want=1, got=%d", len(a.diagnostics))
+	}
+
+	d := a.diagnostics[0]
+	if d.Message != "undefined variable z" || d.Span.Start.Line != 2 || d.Span.End.Offset-d.Span.Start.Offset != 1 {
+		t.Errorf("wrong compile diagnostic. got=%+v", d)
+	}
+}
+
+func TestMacrosAreNotExpanded(t *testing.T) {
+	src := "let loud = macro(x) { puts(\"expanding\"); while (true) {} };\nlet y = loud(1) + 1;\ny;\n"
+
+	done := make(chan *analysis)
+	go func() { done <- analyze("main.rk", src) }()
+
+	select {
+	case a := <-done:
+		if len(a.diagnostics) != 0 {
+			t.Errorf("expected no diagnostics. got=%v", a.diagnostics)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("analysis ran the macro's body")
+	}
+}
+
+// Compiling an import runs the imported file's macros, but what they print
+// mustn't reach stdout, which is carrying the protocol
+func TestAnalysisPrintsNothing(t *testing.T) {
+	dir := t.TempDir()
+	lib := "let loud = macro(x) { puts(\"expanding\"); x };\nlet one = loud(1);\n"
+	if err := os.WriteFile(filepath.Join(dir, "lib.rk"), []byte(lib), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+
+	a := analyze(filepath.Join(dir, "main.rk"), "let lib = import \"lib.rk\";\nlib.one;\n")
+
+	os.Stdout = stdout
+	w.Close()
+	var output bytes.Buffer
+	output.ReadFrom(r)
+
+	if len(a.diagnostics) != 0 {
+		t.Errorf("expected no diagnostics. got=%v", a.diagnostics)
+	}
+	if output.Len() != 0 {
+		t.Errorf("analysis printed %q", output.String())
+	}
+}
+
+func TestParseErrorsStillResolve(t *testing.T) {
+	src := "let x = 1;\nlet = 2;\nx + 1;\n"
+	a := analyze("main.rk", src)
+
+	if len(a.diagnostics) == 0 || a.diagnostics[0].Code == "" {
+		t.Fatalf("expected parser diagnostics. got=%v", a.diagnostics)
+	}
+
+	ref := a.referenceAt(offsetOf(t, src, "x", 2))
+	if ref == nil || ref.binding == nil || ref.binding.def.Pos().Line != 1 {
+		t.Errorf("x not resolved past the parse error. got=%+v", ref)
+	}
+}
+
+func TestVisible(t *testing.T) {
+	names := func(offset int) map[string]string {
+		visible := map[string]string{}
+		for _, b := range analyze("main.rk", source).visible(offset) {
+			visible[b.name] = b.signature()
+		}
+		return visible
+	}
+
+	inAdd := names(offsetOf(t, source, "sum", 2))
+	for _, name := range []string{"a", "b", "sum", "add", "len", "puts"} {
+		if _, ok := inAdd[name]; !ok {
+			t.Errorf("%s is not visible inside add", name)
+		}
+	}
+	for _, name := range []string{"count", "total", "makeCounter"} {
+		if _, ok := inAdd[name]; ok {
+			t.Errorf("%s is visible inside add before it's defined", name)
+		}
+	}
+
+	atEnd := names(len(source))
+	if _, ok := atEnd["a"]; ok {
+		t.Errorf("add's parameter is visible outside it")
+	}
+	if _, ok := atEnd["total"]; !ok {
+		t.Errorf("total is not visible at the end")
+	}
+}
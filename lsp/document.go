@@ -0,0 +1,154 @@
+package lsp
+
+import (
+	"fmt"
+	"net/url"
+	"rafiki/token"
+	"strings"
+	"unicode/utf8"
+)
+
+// An open document, as the client last described it
+type document struct {
+	uri        string
+	version    int
+	text       string
+	lineStarts []int // Byte offset of the start of each line
+
+	analysis *analysis
+}
+
+func newDocument(uri string, version int, text string) *document {
+	d := &document{uri: uri, version: version}
+	d.setText(text)
+
+	return d
+}
+
+func (d *document) setText(text string) {
+	d.text = text
+	d.lineStarts = lineStarts(text)
+	d.analysis = analyze(filename(d.uri), text)
+}
+
+// Apply the edits from a didChange in order, then analyze the result once.
+// An edit whose range is outside the document or backwards is still made,
+// as near as it can be, since dropping it would lose the text it adds. The
+// error says which ones they were: the document may no longer be the
+// client's, until it sends the whole text again.
+func (d *document) applyChanges(changes []TextDocumentContentChangeEvent) error {
+	text := d.text
+	var problems []string
+
+	for i, change := range changes {
+		if change.Range == nil {
+			text = change.Text
+			continue
+		}
+
+		// Offsets must be worked out against the text as it is after the previous edits
+		current := &document{text: text, lineStarts: lineStarts(text)}
+
+		if !current.contains(change.Range.Start) || !current.contains(change.Range.End) {
+			problems = append(problems, fmt.Sprintf("change %d is outside the document: %+v", i+1, *change.Range))
+		}
+
+		start := current.offset(change.Range.Start)
+		end := current.offset(change.Range.End)
+		if end < start {
+			problems = append(problems, fmt.Sprintf("change %d ends before it starts: %+v", i+1, *change.Range))
+			start, end = end, start
+		}
+
+		text = text[:start] + change.Text + text[end:]
+	}
+
+	d.setText(text)
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%s: %s", d.uri, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func lineStarts(text string) []int {
+	starts := []int{0}
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			starts = append(starts, i+1)
+		}
+	}
+
+	return starts
+}
+
+// Whether pos is on one of the document's lines. A character past the end of
+// its line is fine, the protocol takes it to mean the end.
+func (d *document) contains(pos Position) bool {
+	return pos.Line >= 0 && pos.Line < len(d.lineStarts) && pos.Character >= 0
+}
+
+// The byte offset of pos, clamped to the document
+func (d *document) offset(pos Position) int {
+	if pos.Line < 0 {
+		return 0
+	}
+	if pos.Line >= len(d.lineStarts) {
+		return len(d.text)
+	}
+
+	offset := d.lineStarts[pos.Line]
+	for units := 0; units < pos.Character && offset < len(d.text) && d.text[offset] != '\n'; {
+		r, size := utf8.DecodeRuneInString(d.text[offset:])
+		offset += size
+
+		units++
+		if r >= 0x10000 {
+			units++ // A surrogate pair in UTF-16
+		}
+	}
+
+	return offset
+}
+
+// The protocol position of a byte offset
+func (d *document) position(offset int) Position {
+	if offset > len(d.text) {
+		offset = len(d.text)
+	}
+
+	line := 0
+	for line+1 < len(d.lineStarts) && d.lineStarts[line+1] <= offset {
+		line++
+	}
+
+	character := 0
+	for _, r := range d.text[d.lineStarts[line]:offset] {
+		character++
+		if r >= 0x10000 {
+			character++
+		}
+	}
+
+	return Position{Line: line, Character: character}
+}
+
+func (d *document) rangeOf(span token.Span) Range {
+	return Range{Start: d.position(span.Start.Offset), End: d.position(span.End.Offset)}
+}
+
+// The file path behind a file:// URI, used to name the document in positions
+func filename(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+
+	return u.Path
+}
+
+// Whether offset is on the identifier, counting the position just after it,
+// where the cursor sits when it's been typed
+func touches(span token.Span, offset int) bool {
+	return span.Start.Offset <= offset && offset <= span.End.Offset
+}
@@ -0,0 +1,94 @@
+package lsp
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPositions(t *testing.T) {
+	// é is two bytes and one UTF-16 unit; 😀 is four bytes and two units
+	d := newDocument(uri, 1, "let s = \"é😀\";\nlet x = 1;\n")
+
+	tests := []struct {
+		offset   int
+		position Position
+	}{
+		{0, Position{0, 0}},
+		{9, Position{0, 9}},   // é
+		{11, Position{0, 10}}, // 😀
+		{15, Position{0, 12}}, // The closing quote
+		{20, Position{1, 2}},
+		{len(d.text), Position{2, 0}},
+	}
+
+	for _, tt := range tests {
+		if pos := d.position(tt.offset); pos != tt.position {
+			t.Errorf("position(%d): want=%+v, got=%+v", tt.offset, tt.position, pos)
+		}
+
+		if offset := d.offset(tt.position); offset != tt.offset {
+			t.Errorf("offset(%+v): want=%d, got=%d", tt.position, tt.offset, offset)
+		}
+	}
+
+	// Out of range positions are clamped
+	if offset := d.offset(Position{0, 100}); offset != 17 {
+		t.Errorf("past the end of a line: want=17, got=%d", offset)
+	}
+	if offset := d.offset(Position{10, 0}); offset != len(d.text) {
+		t.Errorf("past the last line: want=%d, got=%d", len(d.text), offset)
+	}
+}
+
+func TestApplyChanges(t *testing.T) {
+	d := newDocument(uri, 1, "let x = 1;\nlet y = 2;\n")
+
+	err := d.applyChanges([]TextDocumentContentChangeEvent{
+		// Later edits see the text as the earlier ones left it
+		{Range: &Range{Start: Position{0, 8}, End: Position{0, 9}}, Text: "10"},
+		{Range: &Range{Start: Position{1, 4}, End: Position{1, 5}}, Text: "total"},
+		{Range: &Range{Start: Position{2, 0}, End: Position{2, 0}}, Text: "total;\n"},
+	})
+	if err != nil {
+		t.Fatalf("applyChanges failed: %s", err)
+	}
+
+	expected := "let x = 10;\nlet total = 2;\ntotal;\n"
+	if d.text != expected {
+		t.Errorf("wrong text. want=%q, got=%q", expected, d.text)
+	}
+
+	if ref := d.analysis.referenceAt(len("let x = 10;\nlet total = 2;\n")); ref == nil || ref.binding == nil {
+		t.Errorf("analysis not updated after the change")
+	}
+
+	if err := d.applyChanges([]TextDocumentContentChangeEvent{{Text: "1;"}}); err != nil || d.text != "1;" {
+		t.Errorf("full replacement failed. text=%q, err=%v", d.text, err)
+	}
+}
+
+func TestApplyBadChanges(t *testing.T) {
+	tests := []struct {
+		change   Range
+		expected string
+		problem  string
+	}{
+		{Range{Start: Position{0, 3}, End: Position{0, 1}}, "lX x = 1;", "ends before it starts"},
+		{Range{Start: Position{5, 0}, End: Position{5, 0}}, "let x = 1;X", "outside the document"},
+		{Range{Start: Position{-1, 0}, End: Position{0, 0}}, "Xlet x = 1;", "outside the document"},
+	}
+
+	for _, tt := range tests {
+		d := newDocument(uri, 1, "let x = 1;")
+
+		err := d.applyChanges([]TextDocumentContentChangeEvent{{Range: &tt.change, Text: "X"}})
+		if err == nil || !strings.Contains(err.Error(), tt.problem) {
+			t.Errorf("%+v: want an error saying it %s, got %v", tt.change, tt.problem, err)
+		}
+
+		// The edit is made anyway, as near as it can be
+		if d.text != tt.expected {
+			t.Errorf("%+v: wrong text. want=%q, got=%q", tt.change, tt.expected, d.text)
+		}
+	}
+}
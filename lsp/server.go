@@ -0,0 +1,293 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"rafiki/compiler"
+	"rafiki/diagnostic"
+	"rafiki/transport"
+	"sort"
+)
+
+// Returned by Serve when the client sends exit without asking to shut down
+// first, which the protocol says should end the server with a failure
+var ErrNoShutdown = errors.New("lsp: exit without shutdown")
+
+/*
+Server answers a single editor over a pair of streams. Documents are kept as
+the client last sent them; every change is applied to the stored text, which
+is then parsed and resolved again and its diagnostics published.
+*/
+type Server struct {
+	in  *bufio.Reader
+	out io.Writer
+
+	documents    map[string]*document // By URI
+	shuttingDown bool
+}
+
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{
+		in:        bufio.NewReader(in),
+		out:       out,
+		documents: map[string]*document{},
+	}
+}
+
+// Serve handles messages until the client sends exit or closes the input.
+func (s *Server) Serve() error {
+	for {
+		data, err := transport.ReadMessage(s.in)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var msg Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			s.send(Response{JSONRPC: "2.0", Error: &ResponseError{Code: CodeParseError, Message: err.Error()}})
+			continue
+		}
+
+		if msg.Method == "exit" {
+			if !s.shuttingDown {
+				return ErrNoShutdown
+			}
+			return nil
+		}
+
+		result, respErr := s.handle(msg)
+
+		// Notifications get no response
+		if msg.ID == nil {
+			continue
+		}
+
+		s.send(Response{JSONRPC: "2.0", ID: msg.ID, Result: result, Error: respErr})
+	}
+}
+
+func (s *Server) send(msg interface{}) {
+	// If the client has gone, the next read will say so
+	_ = transport.WriteMessage(s.out, msg)
+}
+
+func (s *Server) notify(method string, params interface{}) {
+	s.send(Notification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+func (s *Server) handle(msg Message) (interface{}, *ResponseError) {
+	if s.shuttingDown && msg.ID != nil {
+		return nil, &ResponseError{Code: CodeInvalidRequest, Message: "the server is shutting down"}
+	}
+
+	switch msg.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync": map[string]interface{}{
+					"openClose": true,
+					"change":    SyncIncremental,
+				},
+				"definitionProvider":     true,
+				"hoverProvider":          true,
+				"completionProvider":     map[string]interface{}{},
+				"documentSymbolProvider": true,
+			},
+			"serverInfo": map[string]string{"name": "rafiki"},
+		}, nil
+
+	case "shutdown":
+		s.shuttingDown = true
+		return nil, nil
+
+	case "textDocument/didOpen":
+		var params DidOpenTextDocumentParams
+		if err := decode(msg, &params); err != nil {
+			return nil, err
+		}
+
+		doc := newDocument(params.TextDocument.URI, params.TextDocument.Version, params.TextDocument.Text)
+		s.documents[doc.uri] = doc
+		s.publishDiagnostics(doc)
+
+	case "textDocument/didChange":
+		var params DidChangeTextDocumentParams
+		if err := decode(msg, &params); err != nil {
+			return nil, err
+		}
+
+		doc, ok := s.documents[params.TextDocument.URI]
+		if !ok {
+			return nil, nil
+		}
+
+		// A notification can't fail, so bad edits are shown to the user
+		// instead, with a way to get back in step
+		if err := doc.applyChanges(params.ContentChanges); err != nil {
+			s.notify("window/showMessage", ShowMessageParams{
+				Type:    MessageError,
+				Message: fmt.Sprintf("rafiki: %s. Close and reopen the document to resync it.", err),
+			})
+		}
+		doc.version = params.TextDocument.Version
+		s.publishDiagnostics(doc)
+
+	case "textDocument/didClose":
+		var params DidCloseTextDocumentParams
+		if err := decode(msg, &params); err != nil {
+			return nil, err
+		}
+
+		delete(s.documents, params.TextDocument.URI)
+		s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{URI: params.TextDocument.URI, Diagnostics: []Diagnostic{}})
+
+	case "textDocument/definition":
+		return s.positionRequest(msg, s.definition)
+	case "textDocument/hover":
+		return s.positionRequest(msg, s.hover)
+	case "textDocument/completion":
+		return s.positionRequest(msg, s.completion)
+
+	case "textDocument/documentSymbol":
+		var params DocumentSymbolParams
+		if err := decode(msg, &params); err != nil {
+			return nil, err
+		}
+
+		doc, ok := s.documents[params.TextDocument.URI]
+		if !ok {
+			return nil, unknownDocument(params.TextDocument.URI)
+		}
+
+		return documentSymbols(doc), nil
+
+	case "initialized", "$/cancelRequest", "$/setTrace", "workspace/didChangeConfiguration":
+		// Nothing to do
+
+	default:
+		if msg.ID != nil {
+			return nil, &ResponseError{Code: CodeMethodNotFound, Message: fmt.Sprintf("method %q is not supported", msg.Method)}
+		}
+	}
+
+	return nil, nil
+}
+
+func decode(msg Message, params interface{}) *ResponseError {
+	if err := json.Unmarshal(msg.Params, params); err != nil {
+		return &ResponseError{Code: CodeInvalidParams, Message: err.Error()}
+	}
+
+	return nil
+}
+
+func unknownDocument(uri string) *ResponseError {
+	return &ResponseError{Code: CodeInvalidParams, Message: fmt.Sprintf("document %s is not open", uri)}
+}
+
+// Decode a request about a spot in a document, and answer it with f
+func (s *Server) positionRequest(msg Message, f func(doc *document, offset int) interface{}) (interface{}, *ResponseError) {
+	var params TextDocumentPositionParams
+	if err := decode(msg, &params); err != nil {
+		return nil, err
+	}
+
+	doc, ok := s.documents[params.TextDocument.URI]
+	if !ok {
+		return nil, unknownDocument(params.TextDocument.URI)
+	}
+
+	return f(doc, doc.offset(params.Position)), nil
+}
+
+func (s *Server) publishDiagnostics(doc *document) {
+	diagnostics := []Diagnostic{}
+
+	for _, d := range doc.analysis.diagnostics {
+		diagnostics = append(diagnostics, Diagnostic{
+			Range:    doc.rangeOf(d.Span),
+			Severity: severity(d.Severity),
+			Code:     d.Code,
+			Source:   "rafiki",
+			Message:  d.Message,
+		})
+	}
+
+	s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{
+		URI:         doc.uri,
+		Version:     doc.version,
+		Diagnostics: diagnostics,
+	})
+}
+
+func severity(s diagnostic.Severity) int {
+	switch s {
+	case diagnostic.SeverityWarning:
+		return SeverityWarning
+	case diagnostic.SeverityInfo:
+		return SeverityInfo
+	case diagnostic.SeverityHint:
+		return SeverityHint
+	default:
+		return SeverityError
+	}
+}
+
+func (s *Server) definition(doc *document, offset int) interface{} {
+	ref := doc.analysis.referenceAt(offset)
+	if ref == nil || ref.binding == nil || ref.binding.def == nil {
+		return nil
+	}
+
+	return Location{URI: doc.uri, Range: doc.rangeOf(ref.binding.def.Token.Span)}
+}
+
+// How a name resolves where it's used, as hovers describe it
+var scopeNames = map[compiler.SymbolScope]string{
+	compiler.GlobalScope:   "global",
+	compiler.LocalScope:    "local",
+	compiler.FreeScope:     "free, captured from an enclosing function",
+	compiler.BuiltinScope:  "builtin",
+	compiler.FunctionScope: "the function's own name",
+}
+
+func (s *Server) hover(doc *document, offset int) interface{} {
+	ref := doc.analysis.referenceAt(offset)
+	if ref == nil || ref.binding == nil {
+		return nil
+	}
+
+	value := "```rafiki\n" + ref.binding.signature() + "\n```\n\n" + scopeNames[ref.scope]
+	if ref.binding.doc != "" {
+		value += "\n\n" + ref.binding.doc
+	}
+
+	return Hover{
+		Contents: MarkupContent{Kind: "markdown", Value: value},
+		Range:    doc.rangeOf(ref.ident.Token.Span),
+	}
+}
+
+// Everything in scope at the cursor; the client filters by what's been typed
+func (s *Server) completion(doc *document, offset int) interface{} {
+	items := []CompletionItem{}
+
+	for _, b := range doc.analysis.visible(offset) {
+		item := CompletionItem{Label: b.name, Kind: CompletionVariable, Detail: b.signature()}
+		if b.kind == bindingBuiltin || b.fn != nil && b.kind == bindingLet {
+			item.Kind = CompletionFunction
+		}
+
+		items = append(items, item)
+	}
+
+	sort.SliceStable(items, func(i, j int) bool { return items[i].Label < items[j].Label })
+
+	return items
+}
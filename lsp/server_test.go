@@ -0,0 +1,405 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"rafiki/transport"
+	"strings"
+	"testing"
+	"time"
+)
+
+const uri = "file:///work/main.rk"
+
+// A scripted LSP client talking to a Server over pipes
+type client struct {
+	t             *testing.T
+	in            *io.PipeWriter
+	out           *bufio.Reader
+	id            int
+	done          chan error
+	notifications []message
+}
+
+type message struct {
+	ID     *int            `json:"id"`
+	Method string          `json:"method"`
+	Result json.RawMessage `json:"result"`
+	Error  *ResponseError  `json:"error"`
+	Params json.RawMessage `json:"params"`
+}
+
+func newClient(t *testing.T) *client {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+
+	c := &client{t: t, in: inW, out: bufio.NewReader(outR), done: make(chan error, 1)}
+
+	go func() {
+		c.done <- NewServer(inR, outW).Serve()
+		outW.Close()
+	}()
+
+	c.request("initialize", map[string]interface{}{"capabilities": map[string]interface{}{}})
+	c.notify("initialized", map[string]interface{}{})
+
+	return c
+}
+
+func (c *client) read() message {
+	c.t.Helper()
+
+	type result struct {
+		data []byte
+		err  error
+	}
+
+	ch := make(chan result, 1)
+	go func() {
+		data, err := transport.ReadMessage(c.out)
+		ch <- result{data, err}
+	}()
+
+	select {
+	case r := <-ch:
+		if r.err != nil {
+			c.t.Fatalf("could not read message: %s", r.err)
+		}
+
+		var msg message
+		if err := json.Unmarshal(r.data, &msg); err != nil {
+			c.t.Fatalf("bad message %s: %s", r.data, err)
+		}
+		return msg
+
+	case <-time.After(5 * time.Second):
+		c.t.Fatalf("timed out waiting for a message")
+		return message{}
+	}
+}
+
+func (c *client) write(msg map[string]interface{}) {
+	c.t.Helper()
+
+	msg["jsonrpc"] = "2.0"
+	if err := transport.WriteMessage(c.in, msg); err != nil {
+		c.t.Fatalf("could not send %v: %s", msg["method"], err)
+	}
+}
+
+func (c *client) notify(method string, params interface{}) {
+	c.t.Helper()
+	c.write(map[string]interface{}{"method": method, "params": params})
+}
+
+// Send a request and return its response
+func (c *client) call(method string, params interface{}) message {
+	c.t.Helper()
+
+	c.id++
+	c.write(map[string]interface{}{"id": c.id, "method": method, "params": params})
+
+	for {
+		msg := c.read()
+		if msg.ID != nil && *msg.ID == c.id && msg.Method == "" {
+			return msg
+		}
+		c.notifications = append(c.notifications, msg)
+	}
+}
+
+// Send a request and return its successful result
+func (c *client) request(method string, params interface{}) json.RawMessage {
+	c.t.Helper()
+
+	msg := c.call(method, params)
+	if msg.Error != nil {
+		c.t.Fatalf("%s failed: %s", method, msg.Error.Message)
+	}
+
+	return msg.Result
+}
+
+func (c *client) decode(data json.RawMessage, v interface{}) {
+	c.t.Helper()
+
+	if err := json.Unmarshal(data, v); err != nil {
+		c.t.Fatalf("bad result %s: %s", data, err)
+	}
+}
+
+// Wait for the next diagnostics published for uri
+func (c *client) diagnostics() []Diagnostic {
+	c.t.Helper()
+
+	for {
+		var msg message
+		if len(c.notifications) > 0 {
+			msg, c.notifications = c.notifications[0], c.notifications[1:]
+		} else {
+			msg = c.read()
+		}
+
+		if msg.Method != "textDocument/publishDiagnostics" {
+			continue
+		}
+
+		var params PublishDiagnosticsParams
+		c.decode(msg.Params, &params)
+		if params.URI == uri {
+			return params.Diagnostics
+		}
+	}
+}
+
+func (c *client) open(text string) {
+	c.t.Helper()
+
+	c.notify("textDocument/didOpen", DidOpenTextDocumentParams{
+		TextDocument: TextDocumentItem{URI: uri, Version: 1, Text: text},
+	})
+}
+
+func (c *client) at(line int, character int) TextDocumentPositionParams {
+	return TextDocumentPositionParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		Position:     Position{Line: line, Character: character},
+	}
+}
+
+func (c *client) shutdown() {
+	c.t.Helper()
+
+	c.request("shutdown", nil)
+	c.notify("exit", nil)
+
+	if err := <-c.done; err != nil {
+		c.t.Errorf("server failed: %s", err)
+	}
+}
+
+func TestDiagnosticsFollowEdits(t *testing.T) {
+	c := newClient(t)
+
+	c.open("let x = 1;\nlet y = x + z;\n")
+
+	diagnostics := c.diagnostics()
+	if len(diagnostics) != 1 || diagnostics[0].Message != "undefined variable z" {
+		t.Fatalf("wrong diagnostics. got=%+v", diagnostics)
+	}
+
+	expectedRange := Range{Start: Position{Line: 1, Character: 12}, End: Position{Line: 1, Character: 13}}
+	if diagnostics[0].Range != expectedRange || diagnostics[0].Severity != SeverityError {
+		t.Errorf("wrong diagnostic. got=%+v", diagnostics[0])
+	}
+
+	// Replace z with x
+	c.notify("textDocument/didChange", DidChangeTextDocumentParams{
+		TextDocument:   VersionedTextDocumentIdentifier{URI: uri, Version: 2},
+		ContentChanges: []TextDocumentContentChangeEvent{{Range: &expectedRange, Text: "x"}},
+	})
+
+	if diagnostics := c.diagnostics(); len(diagnostics) != 0 {
+		t.Errorf("diagnostics not cleared by the fix. got=%+v", diagnostics)
+	}
+
+	// Break the first line
+	c.notify("textDocument/didChange", DidChangeTextDocumentParams{
+		TextDocument: VersionedTextDocumentIdentifier{URI: uri, Version: 3},
+		ContentChanges: []TextDocumentContentChangeEvent{{
+			Range: &Range{Start: Position{Line: 0, Character: 4}, End: Position{Line: 0, Character: 5}},
+			Text:  "",
+		}},
+	})
+
+	diagnostics = c.diagnostics()
+	if len(diagnostics) == 0 || diagnostics[0].Code == "" || diagnostics[0].Range.Start.Line != 0 {
+		t.Errorf("parse error not reported. got=%+v", diagnostics)
+	}
+
+	// A backwards edit, putting the x back, is still made and the user told
+	c.notify("textDocument/didChange", DidChangeTextDocumentParams{
+		TextDocument: VersionedTextDocumentIdentifier{URI: uri, Version: 4},
+		ContentChanges: []TextDocumentContentChangeEvent{{
+			Range: &Range{Start: Position{Line: 0, Character: 5}, End: Position{Line: 0, Character: 4}},
+			Text:  "x ",
+		}},
+	})
+
+	var shown ShowMessageParams
+	for shown.Message == "" {
+		msg := c.read()
+		if msg.Method == "window/showMessage" {
+			c.decode(msg.Params, &shown)
+		}
+	}
+	if shown.Type != MessageError || !strings.Contains(shown.Message, "ends before it starts") {
+		t.Errorf("wrong message for a bad edit. got=%+v", shown)
+	}
+
+	if diagnostics := c.diagnostics(); len(diagnostics) != 0 {
+		t.Errorf("the bad edit wasn't applied. got=%+v", diagnostics)
+	}
+
+	c.notify("textDocument/didClose", DidCloseTextDocumentParams{TextDocument: TextDocumentIdentifier{URI: uri}})
+	if diagnostics := c.diagnostics(); len(diagnostics) != 0 {
+		t.Errorf("diagnostics not cleared on close. got=%+v", diagnostics)
+	}
+
+	c.shutdown()
+}
+
+func TestNavigation(t *testing.T) {
+	c := newClient(t)
+	c.open(source)
+	c.diagnostics()
+
+	// add in `let total = add(...)`
+	var location Location
+	c.decode(c.request("textDocument/definition", c.at(10, 13)), &location)
+
+	expected := Range{Start: Position{Line: 1, Character: 4}, End: Position{Line: 1, Character: 7}}
+	if location.URI != uri || location.Range != expected {
+		t.Errorf("wrong definition. want=%+v, got=%+v", expected, location)
+	}
+
+	var hover Hover
+	c.decode(c.request("textDocument/hover", c.at(10, 13)), &hover)
+	if !strings.Contains(hover.Contents.Value, "let add = fn(a, b)") ||
+		!strings.Contains(hover.Contents.Value, "global") ||
+		!strings.Contains(hover.Contents.Value, "Adds two numbers") {
+		t.Errorf("wrong hover. got=%q", hover.Contents.Value)
+	}
+
+	// count in `fn() { count += 1; count }`
+	c.decode(c.request("textDocument/hover", c.at(7, 10)), &hover)
+	if !strings.Contains(hover.Contents.Value, "free") {
+		t.Errorf("wrong hover for a free variable. got=%q", hover.Contents.Value)
+	}
+
+	// Nothing there
+	if result := c.request("textDocument/hover", c.at(4, 0)); string(result) != "null" {
+		t.Errorf("expected no hover. got=%s", result)
+	}
+
+	c.shutdown()
+}
+
+func TestCompletion(t *testing.T) {
+	c := newClient(t)
+	c.open(source)
+	c.diagnostics()
+
+	// Inside add, after `sum`
+	var items []CompletionItem
+	c.decode(c.request("textDocument/completion", c.at(3, 2)), &items)
+
+	labels := map[string]CompletionItem{}
+	for _, item := range items {
+		labels[item.Label] = item
+	}
+
+	for _, builtin := range []string{"len", "puts", "first", "push"} {
+		if item, ok := labels[builtin]; !ok || item.Kind != CompletionFunction {
+			t.Errorf("builtin %s missing from completions. got=%+v", builtin, item)
+		}
+	}
+
+	for _, name := range []string{"a", "b", "sum", "add"} {
+		if _, ok := labels[name]; !ok {
+			t.Errorf("%s missing from completions", name)
+		}
+	}
+
+	if _, ok := labels["total"]; ok {
+		t.Errorf("total offered before it's defined")
+	}
+
+	c.shutdown()
+}
+
+func TestDocumentSymbols(t *testing.T) {
+	c := newClient(t)
+	c.open(source)
+	c.diagnostics()
+
+	var symbols []DocumentSymbol
+	c.decode(c.request("textDocument/documentSymbol", map[string]interface{}{
+		"textDocument": TextDocumentIdentifier{URI: uri},
+	}), &symbols)
+
+	names := []string{}
+	for _, symbol := range symbols {
+		names = append(names, symbol.Name)
+	}
+
+	if strings.Join(names, ",") != "add,makeCounter,fact,total" {
+		t.Fatalf("wrong symbols. got=%v", names)
+	}
+
+	add := symbols[0]
+	if add.Kind != SymbolFunction || add.Detail != "fn(a, b)" || len(add.Children) != 1 || add.Children[0].Name != "sum" {
+		t.Errorf("wrong symbol for add. got=%+v", add)
+	}
+
+	if symbols[3].Kind != SymbolVariable {
+		t.Errorf("total should be a variable. got=%+v", symbols[3])
+	}
+
+	// Ranges run to the end of the let, past closing parentheses, braces and
+	// the semicolon
+	ranges := []struct {
+		symbol   DocumentSymbol
+		expected Range
+	}{
+		{add, Range{Start: Position{Line: 1, Character: 0}, End: Position{Line: 4, Character: 2}}},
+		{add.Children[0], Range{Start: Position{Line: 2, Character: 2}, End: Position{Line: 2, Character: 18}}},
+		{symbols[2], Range{Start: Position{Line: 9, Character: 0}, End: Position{Line: 9, Character: 63}}},
+		{symbols[3], Range{Start: Position{Line: 10, Character: 0}, End: Position{Line: 10, Character: 30}}},
+	}
+
+	for _, tt := range ranges {
+		if tt.symbol.Range != tt.expected {
+			t.Errorf("wrong range for %s. want=%+v, got=%+v", tt.symbol.Name, tt.expected, tt.symbol.Range)
+		}
+	}
+
+	c.shutdown()
+}
+
+func TestProtocolErrors(t *testing.T) {
+	c := newClient(t)
+
+	if msg := c.call("textDocument/hover", c.at(0, 0)); msg.Error == nil || msg.Error.Code != CodeInvalidParams {
+		t.Errorf("hover on a document that isn't open should fail. got=%+v", msg)
+	}
+
+	if msg := c.call("workspace/symbol", map[string]string{}); msg.Error == nil || msg.Error.Code != CodeMethodNotFound {
+		t.Errorf("unknown method should fail. got=%+v", msg)
+	} else if msg.Result != nil {
+		t.Errorf("an error response should have no result. got=%s", msg.Result)
+	}
+
+	// A null result is still sent
+	if result := c.request("shutdown", nil); string(result) != "null" {
+		t.Errorf("shutdown should answer with a null result. got=%q", result)
+	}
+	if msg := c.call("textDocument/hover", c.at(0, 0)); msg.Error == nil || msg.Error.Code != CodeInvalidRequest {
+		t.Errorf("requests after shutdown should fail. got=%+v", msg)
+	}
+
+	c.notify("exit", nil)
+	if err := <-c.done; err != nil {
+		t.Errorf("server failed: %s", err)
+	}
+}
+
+func TestExitWithoutShutdown(t *testing.T) {
+	c := newClient(t)
+	c.notify("exit", nil)
+
+	if err := <-c.done; err != ErrNoShutdown {
+		t.Errorf("wrong error. want=%v, got=%v", ErrNoShutdown, err)
+	}
+}
@@ -0,0 +1,47 @@
+package lsp
+
+import "rafiki/ast"
+
+// The document's lets as an outline. Lets inside a function are listed under
+// the let that names it.
+func documentSymbols(doc *document) []DocumentSymbol {
+	return letSymbols(doc, doc.analysis.program)
+}
+
+// The lets under node, down to the next function
+func letSymbols(doc *document, node ast.Node) []DocumentSymbol {
+	symbols := []DocumentSymbol{}
+
+	ast.Inspect(node, func(n ast.Node) bool {
+		if n == node {
+			return true
+		}
+
+		switch n := n.(type) {
+		case *ast.LetStatement:
+			symbol := DocumentSymbol{
+				Name:           n.Name.Value,
+				Kind:           SymbolVariable,
+				Range:          Range{Start: doc.position(n.Pos().Offset), End: doc.position(end(n).Offset)},
+				SelectionRange: doc.rangeOf(n.Name.Token.Span),
+			}
+
+			if fn, ok := n.Value.(*ast.FunctionLiteral); ok {
+				symbol.Kind = SymbolFunction
+				symbol.Detail = fnSignature(fn)
+				symbol.Children = letSymbols(doc, fn)
+			}
+
+			symbols = append(symbols, symbol)
+			return false
+
+		case *ast.FunctionLiteral:
+			// An anonymous function's lets are its own business
+			return false
+		}
+
+		return true
+	})
+
+	return symbols
+}
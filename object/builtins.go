@@ -1,8 +1,16 @@
 package object
 
-import "fmt"
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
 
-var Builtins = []struct {
+// The standard builtins, in the order DefaultBuiltins registers them
+var standardBuiltins = []struct {
 	Name    string
 	Builtin *Builtin
 }{
@@ -28,16 +36,10 @@ var Builtins = []struct {
 		},
 	},
 	{
+		// Writes to its registry's output, so DefaultBuiltins makes each
+		// registry its own
 		"puts",
-		&Builtin{
-			Fn: func(args ...Object) Object {
-				for _, arg := range args {
-					fmt.Println(arg.Inspect())
-				}
-
-				return nil
-			},
-		},
+		nil,
 	},
 	{
 		"first",
@@ -133,17 +135,254 @@ var Builtins = []struct {
 			},
 		},
 	},
+	{
+		"int",
+		&Builtin{
+			Fn: func(args ...Object) Object {
+				if len(args) != 1 {
+					return newError("wrong number of arguments. got=%d, want=1",
+						len(args))
+				}
+
+				switch arg := args[0].(type) {
+				case *Integer:
+					return arg
+				case *Float:
+					// Truncates toward zero
+					return floatToInteger("int", math.Trunc(arg.Value))
+				case *String:
+					value, err := strconv.ParseInt(strings.TrimSpace(arg.Value), 0, 64)
+					if err != nil {
+						return newError("could not parse %q as integer", arg.Value)
+					}
+					return &Integer{Value: value}
+				default:
+					return newError("argument to `int` not supported, got %s",
+						args[0].Type())
+				}
+			},
+		},
+	},
+	{
+		"float",
+		&Builtin{
+			Fn: func(args ...Object) Object {
+				if len(args) != 1 {
+					return newError("wrong number of arguments. got=%d, want=1",
+						len(args))
+				}
+
+				switch arg := args[0].(type) {
+				case *Integer:
+					return &Float{Value: float64(arg.Value)}
+				case *Float:
+					return arg
+				case *String:
+					value, err := strconv.ParseFloat(strings.TrimSpace(arg.Value), 64)
+					if err != nil {
+						return newError("could not parse %q as float", arg.Value)
+					}
+					return &Float{Value: value}
+				default:
+					return newError("argument to `float` not supported, got %s",
+						args[0].Type())
+				}
+			},
+		},
+	},
+	{
+		// round(x) is the nearest integer, halves away from zero.
+		// round(x, digits) keeps that many decimal places and stays a float.
+		"round",
+		&Builtin{
+			Fn: func(args ...Object) Object {
+				if len(args) != 1 && len(args) != 2 {
+					return newError("wrong number of arguments. got=%d, want=1 or 2",
+						len(args))
+				}
+
+				var value float64
+				switch arg := args[0].(type) {
+				case *Integer:
+					if len(args) == 1 {
+						return arg
+					}
+					value = float64(arg.Value)
+				case *Float:
+					value = arg.Value
+				default:
+					return newError("argument to `round` must be INTEGER or FLOAT, got %s",
+						args[0].Type())
+				}
+
+				if len(args) == 1 {
+					return floatToInteger("round", math.Round(value))
+				}
+
+				digits, ok := args[1].(*Integer)
+				if !ok {
+					return newError("second argument to `round` must be INTEGER, got %s",
+						args[1].Type())
+				}
+
+				scale := math.Pow(10, float64(digits.Value))
+				switch {
+				case math.IsInf(value*scale, 0):
+					// There are no digits that far right to round away
+					return &Float{Value: value}
+				case scale == 0:
+					// Nor any left of that place
+					return &Float{Value: math.Copysign(0, value)}
+				}
+
+				return &Float{Value: math.Round(value*scale) / scale}
+			},
+		},
+	},
+	{
+		"floor",
+		&Builtin{
+			Fn: func(args ...Object) Object {
+				if len(args) != 1 {
+					return newError("wrong number of arguments. got=%d, want=1",
+						len(args))
+				}
+
+				switch arg := args[0].(type) {
+				case *Integer:
+					return arg
+				case *Float:
+					return floatToInteger("floor", math.Floor(arg.Value))
+				default:
+					return newError("argument to `floor` must be INTEGER or FLOAT, got %s",
+						args[0].Type())
+				}
+			},
+		},
+	},
+}
+
+// Convert a whole float to an integer, unless it's NaN, infinite or too big
+// for one. float64(math.MaxInt64) rounds up to 2^63, one past the largest.
+func floatToInteger(name string, value float64) Object {
+	if math.IsNaN(value) || value < math.MinInt64 || value >= math.MaxInt64 {
+		return newError("argument to `%s` out of range, got %v", name, value)
+	}
+
+	return &Integer{Value: int64(value)}
 }
 
 func newError(format string, a ...interface{}) *Error {
 	return &Error{Message: fmt.Sprintf(format, a...)}
 }
 
-func GetBuiltinByName(name string) *Builtin {
-	for _, def := range Builtins {
-		if def.Name == name {
-			return def.Builtin
+/*
+BuiltinRegistry holds the functions a program can call without defining
+them. Compiled code refers to a builtin by its index in the registry, and
+bytecode records the names it was compiled against, so a VM can find each
+one by name in its own registry.
+
+Each runtime can have its own registry, to offer host functions, to leave
+some of the standard ones out, or to capture what puts prints:
+
+	builtins := object.DefaultBuiltins()
+	builtins.Remove("first")
+	builtins.Register("now", func(args ...object.Object) object.Object { ... })
+	builtins.SetOutput(&log)
+*/
+type BuiltinRegistry struct {
+	names    []string
+	builtins []*Builtin
+	output   io.Writer // Where puts writes
+}
+
+// An empty registry
+func NewBuiltinRegistry() *BuiltinRegistry {
+	return &BuiltinRegistry{output: os.Stdout}
+}
+
+// A new registry holding the standard builtins: len, puts, first, last,
+// rest, push, int, float, round and floor. Changing it changes no other.
+func DefaultBuiltins() *BuiltinRegistry {
+	r := NewBuiltinRegistry()
+
+	for _, def := range standardBuiltins {
+		builtin := def.Builtin
+		if def.Name == "puts" {
+			builtin = &Builtin{Fn: r.puts}
 		}
+
+		r.names = append(r.names, def.Name)
+		r.builtins = append(r.builtins, builtin)
+	}
+
+	return r
+}
+
+// Send what puts prints to w instead of stdout. Tools that need stdout for
+// themselves, like the DAP and LSP servers, point it somewhere else.
+func (r *BuiltinRegistry) SetOutput(w io.Writer) {
+	r.output = w
+}
+
+func (r *BuiltinRegistry) puts(args ...Object) Object {
+	for _, arg := range args {
+		fmt.Fprintln(r.output, arg.Inspect())
 	}
+
 	return nil
 }
+
+// Add fn as name. Replacing a builtin keeps its index; a new one goes last.
+func (r *BuiltinRegistry) Register(name string, fn BuiltinFunction) {
+	if i, ok := r.Index(name); ok {
+		r.builtins[i] = &Builtin{Fn: fn}
+		return
+	}
+
+	r.names = append(r.names, name)
+	r.builtins = append(r.builtins, &Builtin{Fn: fn})
+}
+
+// Take name out of the registry. The builtins after it move down an index.
+func (r *BuiltinRegistry) Remove(name string) {
+	i, ok := r.Index(name)
+	if !ok {
+		return
+	}
+
+	r.names = append(r.names[:i], r.names[i+1:]...)
+	r.builtins = append(r.builtins[:i], r.builtins[i+1:]...)
+}
+
+func (r *BuiltinRegistry) Lookup(name string) (*Builtin, bool) {
+	if i, ok := r.Index(name); ok {
+		return r.builtins[i], true
+	}
+
+	return nil, false
+}
+
+func (r *BuiltinRegistry) Index(name string) (int, bool) {
+	for i, n := range r.names {
+		if n == name {
+			return i, true
+		}
+	}
+
+	return 0, false
+}
+
+// The builtin at index, which must be less than Len
+func (r *BuiltinRegistry) At(index int) *Builtin {
+	return r.builtins[index]
+}
+
+func (r *BuiltinRegistry) Len() int {
+	return len(r.names)
+}
+
+// The name of each builtin, indexed like the registry: its layout
+func (r *BuiltinRegistry) Names() []string {
+	return append([]string{}, r.names...)
+}
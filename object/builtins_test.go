@@ -0,0 +1,116 @@
+package object
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuiltinRegistry(t *testing.T) {
+	r := NewBuiltinRegistry()
+	r.Register("a", func(args ...Object) Object { return &Integer{Value: 1} })
+	r.Register("b", func(args ...Object) Object { return &Integer{Value: 2} })
+	r.Register("c", func(args ...Object) Object { return &Integer{Value: 3} })
+
+	// Registering a name again replaces it where it is
+	r.Register("b", func(args ...Object) Object { return &Integer{Value: 20} })
+
+	if names := r.Names(); !reflect.DeepEqual(names, []string{"a", "b", "c"}) {
+		t.Fatalf("wrong names. got=%v", names)
+	}
+
+	b, ok := r.Lookup("b")
+	if !ok || b.Fn().(*Integer).Value != 20 {
+		t.Errorf("b wasn't replaced")
+	}
+
+	r.Remove("a")
+
+	if names := r.Names(); !reflect.DeepEqual(names, []string{"b", "c"}) {
+		t.Fatalf("wrong names after remove. got=%v", names)
+	}
+
+	if index, ok := r.Index("c"); !ok || index != 1 || r.At(index).Fn().(*Integer).Value != 3 {
+		t.Errorf("c is at the wrong index. got=%d", index)
+	}
+
+	if _, ok := r.Lookup("a"); ok {
+		t.Errorf("a is still registered")
+	}
+
+	if r.Len() != 2 {
+		t.Errorf("wrong length. want=2, got=%d", r.Len())
+	}
+}
+
+func TestDefaultBuiltins(t *testing.T) {
+	first := DefaultBuiltins()
+	first.Remove("puts")
+
+	second := DefaultBuiltins()
+	if _, ok := second.Lookup("puts"); !ok {
+		t.Errorf("removing from one default registry changed another")
+	}
+
+	if second.Names()[0] != "len" {
+		t.Errorf("wrong first builtin. want=len, got=%s", second.Names()[0])
+	}
+}
+
+func TestBuiltinOutput(t *testing.T) {
+	var first, second strings.Builder
+
+	a := DefaultBuiltins()
+	a.SetOutput(&first)
+	b := DefaultBuiltins()
+	b.SetOutput(&second)
+
+	puts, _ := a.Lookup("puts")
+	puts.Fn(&Integer{Value: 1}, &String{Value: "two"})
+
+	if first.String() != "1\ntwo\n" {
+		t.Errorf("wrong output. want=%q, got=%q", "1\ntwo\n", first.String())
+	}
+	if second.Len() != 0 {
+		t.Errorf("puts wrote to another registry's output: %q", second.String())
+	}
+}
+
+func TestFloatToIntegerRange(t *testing.T) {
+	tests := []struct {
+		value    float64
+		expected interface{} // The integer, or false for an out of range error
+	}{
+		{-2, int64(-2)},
+		{math.MinInt64, int64(math.MinInt64)},
+		{math.Nextafter(math.MaxInt64, 0), int64(1<<63 - 1024)},
+		{math.MaxInt64, false},
+		{math.Nextafter(math.MinInt64, math.Inf(-1)), false},
+		{math.Inf(1), false},
+		{math.Inf(-1), false},
+		{math.NaN(), false},
+	}
+
+	builtins := DefaultBuiltins()
+	for _, name := range []string{"int", "round", "floor"} {
+		builtin, _ := builtins.Lookup(name)
+
+		for _, tt := range tests {
+			result := builtin.Fn(&Float{Value: tt.value})
+
+			switch expected := tt.expected.(type) {
+			case int64:
+				integer, ok := result.(*Integer)
+				if !ok || integer.Value != expected {
+					t.Errorf("%s(%v): want %d, got %s", name, tt.value, expected, result.Inspect())
+				}
+			case bool:
+				err, ok := result.(*Error)
+				if !ok || !strings.Contains(err.Message, "out of range") {
+					t.Errorf("%s(%v): want an out of range error, got %s", name, tt.value, result.Inspect())
+				}
+			}
+		}
+	}
+}
@@ -1,22 +1,112 @@
 package object
 
+import (
+	"rafiki/ast"
+	"rafiki/token"
+	"sort"
+)
+
 type Environment struct {
-	store map[string]Object
-	outer *Environment
+	store    map[string]Object
+	outer    *Environment
+	call     *Call
+	imports  *Imports
+	builtins *BuiltinRegistry
+	limiter  *Limiter
+}
+
+// The files a program has imported. Shared by every environment of the
+// program, including the ones its modules run in, so each file runs once.
+type Imports struct {
+	Modules map[string]*Module // By absolute path
+	Running []string           // The modules being run, outermost first
+}
+
+// The function call an environment was created for. Walking the callers
+// gives the evaluator its stack trace.
+type Call struct {
+	Depth    int    // How many calls are in progress, counting this one
+	Function string // The function's name, or <anonymous>
+	Pos      token.Position
+	Caller   *Environment
+
+	// The calls in the function's body in tail position, from ast.TailCalls
+	TailCalls map[*ast.CallExpression]bool
 }
 
+// The builtins environments get unless they're given others. Programs can't
+// change a registry, so they can all share this one.
+var defaultBuiltins = DefaultBuiltins()
+
 func NewEnvironment() *Environment {
-	s := make(map[string]Object)
+	return NewEnvironmentWithBuiltins(defaultBuiltins)
+}
 
-	return &Environment{store: s, outer: nil}
+// A top-level environment whose programs can call the functions in builtins
+func NewEnvironmentWithBuiltins(builtins *BuiltinRegistry) *Environment {
+	return &Environment{
+		store:    make(map[string]Object),
+		imports:  &Imports{Modules: map[string]*Module{}},
+		builtins: builtins,
+	}
 }
 
 func NewEnclosedEnvironment(outer *Environment) *Environment {
-	env := NewEnvironment()
-	env.outer = outer
+	return &Environment{
+		store:    make(map[string]Object),
+		outer:    outer,
+		imports:  outer.imports,
+		builtins: outer.builtins,
+		limiter:  outer.limiter,
+	}
+}
+
+// The top-level environment of a module imported from importer. It sees
+// none of the importer's bindings, but shares its imports and builtins.
+func NewModuleEnvironment(importer *Environment) *Environment {
+	env := NewEnvironmentWithBuiltins(importer.builtins)
+	env.imports = importer.imports
+	env.limiter = importer.limiter
 	return env
 }
 
+func (e *Environment) Imports() *Imports {
+	return e.imports
+}
+
+func (e *Environment) Builtins() *BuiltinRegistry {
+	return e.builtins
+}
+
+// The environment of a call's body. It's limited like the caller, which
+// needn't be where the function was defined.
+func NewCallEnvironment(outer *Environment, call *Call) *Environment {
+	env := NewEnclosedEnvironment(outer)
+	env.call = call
+	env.limiter = call.Caller.limiter
+	return env
+}
+
+// What keeps programs running in this environment within their limits, nil if
+// nothing does
+func (e *Environment) Limiter() *Limiter {
+	return e.limiter
+}
+
+// Limit what runs in this environment and those created from it from now on
+func (e *Environment) SetLimiter(limiter *Limiter) {
+	e.limiter = limiter
+}
+
+// The call whose body this environment belongs to, nil at the top level
+func (e *Environment) Call() *Call {
+	if e.call != nil || e.outer == nil {
+		return e.call
+	}
+
+	return e.outer.Call()
+}
+
 func (e *Environment) Get(name string) (Object, bool) {
 	obj, ok := e.store[name]
 
@@ -32,3 +122,47 @@ func (e *Environment) Set(name string, value Object) Object {
 
 	return value
 }
+
+// Names bound directly in this environment, sorted alphabetically
+func (e *Environment) Names() []string {
+	names := make([]string, 0, len(e.store))
+
+	for name := range e.store {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// Whether name, looked up from here, is the name of a function whose body
+// this environment is in, rather than a binding of its own
+func (e *Environment) IsFunctionName(name string) bool {
+	for env := e; env != nil; env = env.outer {
+		if _, ok := env.store[name]; ok {
+			return false
+		}
+
+		if env.call != nil && env.call.Function == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Update name in the nearest environment that binds it. Returns false, and
+// changes nothing, if none does.
+func (e *Environment) Assign(name string, value Object) (Object, bool) {
+	if _, ok := e.store[name]; ok {
+		e.store[name] = value
+		return value, true
+	}
+
+	if e.outer != nil {
+		return e.outer.Assign(name, value)
+	}
+
+	return nil, false
+}
@@ -1,2 +1,31 @@
 package object
 
+import "testing"
+
+func TestAssign(t *testing.T) {
+	outer := NewEnvironment()
+	outer.Set("x", &Integer{Value: 1})
+
+	inner := NewEnclosedEnvironment(outer)
+
+	if _, ok := inner.Assign("x", &Integer{Value: 2}); !ok {
+		t.Fatalf("expected x to be assignable from an enclosed environment")
+	}
+
+	if len(inner.Names()) != 0 {
+		t.Errorf("assignment defined a new binding in the inner environment: %v", inner.Names())
+	}
+
+	x, _ := outer.Get("x")
+	if x.(*Integer).Value != 2 {
+		t.Errorf("x has wrong value. want=2, got=%s", x.Inspect())
+	}
+
+	if _, ok := inner.Assign("y", &Integer{Value: 3}); ok {
+		t.Errorf("expected assigning an undefined name to fail")
+	}
+
+	if _, ok := outer.Get("y"); ok {
+		t.Errorf("failed assignment defined y")
+	}
+}
@@ -0,0 +1,124 @@
+package object
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// What a program may use while it runs. A zero field means no limit.
+type Limits struct {
+	MaxSteps      int64 // Instructions the VM runs, or nodes the evaluator evaluates
+	MaxAllocation int   // Elements in one array or hash, or bytes in one string
+	MaxDepth      int   // Function calls in progress at once
+}
+
+// The kinds of the errors a Limiter raises
+const (
+	CanceledError        = "CanceledError"
+	StepLimitError       = "StepLimitError"
+	AllocationLimitError = "AllocationLimitError"
+	RecursionLimitError  = "RecursionLimitError"
+)
+
+// What the errors a Limiter raises unwrap to, for errors.Is. A canceled
+// program's error unwraps to its context's error instead.
+var (
+	ErrStepLimit       = errors.New("step limit exceeded")
+	ErrAllocationLimit = errors.New("allocation limit exceeded")
+	ErrRecursionLimit  = errors.New("recursion depth limit exceeded")
+)
+
+// How many steps pass between looks at the context, which costs more than
+// counting them
+const contextCheckInterval = 1024
+
+/*
+Limiter keeps a running program within its Limits, and stops it once its
+context is done. Each engine asks it before every step, call and allocation.
+A nil Limiter allows everything.
+
+Cancellation and running out of steps can't be caught by a try, or a program
+could carry on past them. Allocation and recursion errors can, like any other
+runtime error.
+*/
+type Limiter struct {
+	ctx    context.Context
+	limits Limits
+	steps  int64
+}
+
+func NewLimiter(ctx context.Context, limits Limits) *Limiter {
+	return &Limiter{ctx: ctx, limits: limits}
+}
+
+// Count a step, failing once there have been too many or the context is done
+func (l *Limiter) Step() *Error {
+	if l == nil {
+		return nil
+	}
+
+	l.steps++
+
+	if l.limits.MaxSteps > 0 && l.steps > l.limits.MaxSteps {
+		return limitError(StepLimitError, ErrStepLimit, "step limit of %d exceeded", l.limits.MaxSteps)
+	}
+
+	if l.steps%contextCheckInterval == 0 {
+		return l.Canceled()
+	}
+
+	return nil
+}
+
+// An error if the context is done
+func (l *Limiter) Canceled() *Error {
+	if l == nil {
+		return nil
+	}
+
+	if err := l.ctx.Err(); err != nil {
+		return limitError(CanceledError, err, "execution canceled: %s", err)
+	}
+
+	return nil
+}
+
+// Check a new array or hash of size elements, or a string of size bytes,
+// before building it
+func (l *Limiter) Allocate(size int) *Error {
+	if l == nil || l.limits.MaxAllocation <= 0 || size <= l.limits.MaxAllocation {
+		return nil
+	}
+
+	return limitError(AllocationLimitError, ErrAllocationLimit,
+		"allocation of %d exceeds the limit of %d", size, l.limits.MaxAllocation)
+}
+
+// Check a value something else built, like a builtin's result
+func (l *Limiter) Allocated(obj Object) *Error {
+	switch obj := obj.(type) {
+	case *Array:
+		return l.Allocate(len(obj.Elements))
+	case *Hash:
+		return l.Allocate(len(obj.Pairs))
+	case *String:
+		return l.Allocate(len(obj.Value))
+	default:
+		return nil
+	}
+}
+
+// Check a call that would make depth calls in progress
+func (l *Limiter) Enter(depth int) *Error {
+	if l == nil || l.limits.MaxDepth <= 0 || depth <= l.limits.MaxDepth {
+		return nil
+	}
+
+	return limitError(RecursionLimitError, ErrRecursionLimit,
+		"recursion depth limit of %d exceeded", l.limits.MaxDepth)
+}
+
+func limitError(kind string, cause error, format string, a ...interface{}) *Error {
+	return &Error{Message: fmt.Sprintf(format, a...), Kind: kind, Cause: cause}
+}
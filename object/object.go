@@ -4,8 +4,11 @@ import (
 	"bytes"
 	"fmt"
 	"hash/fnv"
+	"math"
 	"rafiki/ast"
 	"rafiki/code"
+	"rafiki/token"
+	"strconv"
 	"strings"
 )
 
@@ -17,10 +20,16 @@ type Hashable interface {
 
 const (
 	INTEGER_OBJ           = "INTEGER"
+	FLOAT_OBJ             = "FLOAT"
 	BOOLEAN_OBJ           = "BOOLEAN"
 	NULL_OBJ              = "NULL"
 	RETURN_VALUE_OBJ      = "RETURN"
+	CELL_OBJ              = "CELL"
+	BREAK_OBJ             = "BREAK"
+	CONTINUE_OBJ          = "CONTINUE"
+	TAIL_CALL_OBJ         = "TAIL_CALL"
 	ERROR_OBJ             = "ERROR"
+	EXCEPTION_OBJ         = "EXCEPTION"
 	FUNCTION_OBJ          = "FUNCTION"
 	STRING_OBJ            = "STRING"
 	BUILTIN_OBJ           = "BUILTIN"
@@ -30,6 +39,7 @@ const (
 	MACRO_OBJ             = "MACRO"
 	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION_OBJ"
 	CLOSURE_OBJ           = "CLOSURE"
+	MODULE_OBJ            = "MODULE"
 )
 
 type Object interface {
@@ -46,6 +56,23 @@ type Integer struct {
 func (i *Integer) Type() ObjectType { return INTEGER_OBJ }
 func (i *Integer) Inspect() string  { return fmt.Sprintf("%d", i.Value) }
 
+type Float struct {
+	Value float64
+}
+
+func (f *Float) Type() ObjectType { return FLOAT_OBJ }
+
+// Always shows a fraction or exponent, so 3.0 doesn't print like the integer 3
+func (f *Float) Inspect() string {
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+
+	return s
+}
+
 type Boolean struct {
 	Value bool
 }
@@ -65,14 +92,156 @@ type ReturnValue struct {
 func (r *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 func (r *ReturnValue) Inspect() string  { return r.Value.Inspect() }
 
+// A variable captured by a closure. The frame that defines the variable and
+// every closure capturing it share one cell, so they all see assignments.
+type Cell struct {
+	Value Object
+}
+
+func (c *Cell) Type() ObjectType { return CELL_OBJ }
+func (c *Cell) Inspect() string  { return c.Value.Inspect() }
+
+// Signals the evaluator passes up from a break or continue to the enclosing loop
+type Break struct{}
+
+func (b *Break) Type() ObjectType { return BREAK_OBJ }
+func (b *Break) Inspect() string  { return "break" }
+
+type Continue struct{}
+
+func (c *Continue) Type() ObjectType { return CONTINUE_OBJ }
+func (c *Continue) Inspect() string  { return "continue" }
+
+// A call in tail position, which the evaluator passes up to the function
+// returning its result to make in place of a nested one
+type TailCall struct {
+	Function  *Function
+	Arguments []Object
+}
+
+func (t *TailCall) Type() ObjectType { return TAIL_CALL_OBJ }
+func (t *TailCall) Inspect() string  { return "tail call to " + t.Function.Inspect() }
+
+// The kind of error the engines raise themselves, and the kind given to thrown values
+const (
+	RuntimeError = "RuntimeError"
+	ThrownError  = "Error"
+)
+
 type Error struct {
 	Message string
+	Kind    string         // RuntimeError, or Error for values thrown by the program
+	Pos     token.Position // Where the error was raised, if known
+	Stack   []StackFrame   // The Rafiki calls active when it was raised, innermost first
+	Cause   error          // The Go error behind it, for errors raised by a Limiter
 }
 
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
 func (e *Error) Inspect() string  { return "ERROR: " + e.Message }
 
+// Errors double as Go errors, which is how the VM reports them
+func (e *Error) Error() string {
+	if !e.Pos.IsValid() {
+		return e.Message
+	}
+
+	return e.Pos.String() + ": " + e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Cause
+}
+
+// Whether a try can catch the error. A program that was canceled or ran out
+// of steps has to stop.
+func (e *Error) Catchable() bool {
+	return e.Kind != CanceledError && e.Kind != StepLimitError
+}
+
+// The error's fields, as a program indexing it sees them: e["message"],
+// e["type"] and e["stack"]. Nil for any other name.
+func (e *Error) Field(name string) Object {
+	switch name {
+	case "message":
+		return &String{Value: e.Message}
+	case "type":
+		return &String{Value: e.Kind}
+	case "stack":
+		frames := make([]Object, len(e.Stack))
+		for i, frame := range e.Stack {
+			frames[i] = &String{Value: frame.String()}
+		}
+		return &Array{Elements: frames}
+	default:
+		return nil
+	}
+}
+
+// The stack trace, one "at <function> (<position>)" line per frame. A run of
+// three or more identical frames, as deep recursion leaves, is shown once
+// with a count.
+func (e *Error) StackTrace() string {
+	var out bytes.Buffer
+
+	for i := 0; i < len(e.Stack); {
+		line := e.Stack[i].String()
+
+		run := 1
+		for i+run < len(e.Stack) && e.Stack[i+run].String() == line {
+			run++
+		}
+
+		out.WriteString("  at " + line + "\n")
+		switch {
+		case run == 2:
+			out.WriteString("  at " + line + "\n")
+		case run > 2:
+			fmt.Fprintf(&out, "  ... repeated %d more times\n", run-1)
+		}
+
+		i += run
+	}
+
+	return out.String()
+}
+
+type StackFrame struct {
+	Function string // The function's name, <anonymous> or <main>
+	Pos      token.Position
+}
+
+func (f StackFrame) String() string {
+	if !f.Pos.IsValid() {
+		return f.Function
+	}
+
+	return f.Function + " (" + f.Pos.String() + ")"
+}
+
+// The error a throw statement raises for value. Throwing a caught error
+// rethrows it as it was; anything else becomes the message of a new Error.
+func ThrownValue(value Object) *Error {
+	switch value := value.(type) {
+	case *Error:
+		return value
+	case *String:
+		return &Error{Message: value.Value, Kind: ThrownError}
+	default:
+		return &Error{Message: value.Inspect(), Kind: ThrownError}
+	}
+}
+
+// Carries an Error up through the evaluator while it's being thrown, so a
+// caught error can be passed around as an ordinary value
+type Exception struct {
+	Error *Error
+}
+
+func (e *Exception) Type() ObjectType { return EXCEPTION_OBJ }
+func (e *Exception) Inspect() string  { return e.Error.Inspect() }
+
 type Function struct {
+	Name        string
 	Parameters  []*ast.Identifier
 	Body        *ast.BlockStatement
 	FunctionEnv *Environment
@@ -152,6 +321,11 @@ func (i *Integer) HashKey() HashKey {
 	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
 }
 
+// 1.0 and 1 are different keys, as their types differ
+func (f *Float) HashKey() HashKey {
+	return HashKey{Type: f.Type(), Value: math.Float64bits(f.Value)}
+}
+
 func (s *String) HashKey() HashKey {
 	h := fnv.New64a()
 	h.Write([]byte(s.Value))
@@ -185,6 +359,16 @@ func (h *Hash) Inspect() string {
 	return out.String()
 }
 
+// The top-level bindings of an imported file, read with mod.name or
+// mod["name"]
+type Module struct {
+	Name    string
+	Exports map[string]Object
+}
+
+func (m *Module) Type() ObjectType { return MODULE_OBJ }
+func (m *Module) Inspect() string  { return "<module " + m.Name + ">" }
+
 type Quote struct {
 	Node ast.Node
 }
@@ -223,6 +407,10 @@ type CompiledFunction struct {
 	Instructions  code.Instructions
 	NumLocals     int
 	NumParameters int
+	Name          string         // Empty for anonymous functions
+	SourceMap     code.SourceMap // Maps instruction offsets back to source positions
+	LocalNames    []string       // Indexed by local slot, for debuggers
+	FreeNames     []string       // Indexed like the closure's Free
 }
 
 func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
@@ -20,3 +20,92 @@ func TestStringHashKey(t *testing.T) {
 		t.Errorf("strings with different content have same hash keys")
 	}
 }
+
+func TestFloatInspect(t *testing.T) {
+	tests := []struct {
+		value    float64
+		expected string
+	}{
+		{3.14, "3.14"},
+		{3, "3.0"},
+		{-0.5, "-0.5"},
+		{1e21, "1e+21"},
+	}
+
+	for _, tt := range tests {
+		f := &Float{Value: tt.value}
+		if f.Inspect() != tt.expected {
+			t.Errorf("wrong Inspect for %g. want=%q, got=%q", tt.value, tt.expected, f.Inspect())
+		}
+	}
+}
+
+func TestFloatHashKey(t *testing.T) {
+	one := &Float{Value: 1.5}
+	same := &Float{Value: 1.5}
+	diff := &Float{Value: 2.5}
+
+	if one.HashKey() != same.HashKey() {
+		t.Errorf("floats with same value have different hash keys")
+	}
+
+	if one.HashKey() == diff.HashKey() {
+		t.Errorf("floats with different values have same hash keys")
+	}
+
+	if (&Float{Value: 1}).HashKey() == (&Integer{Value: 1}).HashKey() {
+		t.Errorf("float and integer share a hash key")
+	}
+}
+
+func TestThrownValue(t *testing.T) {
+	caught := &Error{Message: "caught", Kind: RuntimeError}
+
+	tests := []struct {
+		value           Object
+		expectedMessage string
+		expectedKind    string
+	}{
+		{&String{Value: "oops"}, "oops", ThrownError},
+		{&Integer{Value: 42}, "42", ThrownError},
+		{caught, "caught", RuntimeError},
+	}
+
+	for _, tt := range tests {
+		err := ThrownValue(tt.value)
+
+		if err.Message != tt.expectedMessage || err.Kind != tt.expectedKind {
+			t.Errorf("wrong error. want=%s %q, got=%s %q",
+				tt.expectedKind, tt.expectedMessage, err.Kind, err.Message)
+		}
+	}
+
+	if ThrownValue(caught) != caught {
+		t.Errorf("rethrowing an error does not keep it")
+	}
+}
+
+func TestStackTrace(t *testing.T) {
+	frame := func(function string) StackFrame { return StackFrame{Function: function} }
+
+	tests := []struct {
+		stack    []StackFrame
+		expected string
+	}{
+		{
+			[]StackFrame{frame("f"), frame("f"), frame("<main>")},
+			"  at f\n  at f\n  at <main>\n",
+		},
+		{
+			[]StackFrame{frame("f"), frame("f"), frame("f"), frame("f"), frame("g"), frame("<main>")},
+			"  at f\n  ... repeated 3 more times\n  at g\n  at <main>\n",
+		},
+	}
+
+	for _, tt := range tests {
+		trace := (&Error{Stack: tt.stack}).StackTrace()
+		if trace != tt.expected {
+			t.Errorf("wrong trace. want=%q, got=%q", tt.expected, trace)
+		}
+	}
+}
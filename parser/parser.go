@@ -3,9 +3,11 @@ package parser
 import (
 	"fmt"
 	"rafiki/ast"
+	"rafiki/diagnostic"
 	"rafiki/lexer"
 	"rafiki/token"
 	"strconv"
+	"strings"
 )
 
 type Parser struct {
@@ -18,9 +20,34 @@ type Parser struct {
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
 
-	errors []string
+	diagnostics []*diagnostic.Diagnostic
+
+	// The lexer's comments, wrapped as ast nodes as we go
+	comments []*ast.Comment
+
+	// Set after an error until we resync at a statement boundary, so one
+	// mistake doesn't produce a cascade of follow-on errors
+	panicking bool
+
+	// A block comment that runs to the end of the input. The parser sees the
+	// end there, and reports the comment instead of what it left unfinished.
+	unclosedComment *token.Span
+
+	// How many loops enclose the current statement within this function body
+	loopDepth int
 }
 
+// Stable codes for parser diagnostics
+const (
+	CodeUnexpectedToken   = "P001"
+	CodeMissingExpression = "P002"
+	CodeInvalidInteger    = "P003"
+	CodeOutsideLoop       = "P004"
+	CodeInvalidAssignment = "P005"
+	CodeInvalidFloat      = "P006"
+	CodeUnterminated      = "P007"
+)
+
 type (
 	prefixParseFn func() ast.Expression               // prefix operation, like a --x or ++x, or !true
 	infixParseFn  func(ast.Expression) ast.Expression // infix operation, like 5 * 8
@@ -31,6 +58,9 @@ type (
 const (
 	_ int = iota
 	LOWEST
+	ASSIGN      // = or +=
+	OR          // ||
+	AND         // &&
 	EQUALS      // ==
 	LESSGREATER // > or <
 	SUM         // +
@@ -41,38 +71,62 @@ const (
 )
 
 var precedences = map[token.TokenType]int{
-	token.EQ:       EQUALS,
-	token.NOT_EQ:   EQUALS,
-	token.LT:       LESSGREATER,
-	token.GT:       LESSGREATER,
-	token.PLUS:     SUM,
-	token.MINUS:    SUM,
-	token.SLASH:    PRODUCT,
-	token.ASTERISK: PRODUCT,
-	token.LPAREN:   CALL,
-	token.LBRACKET: INDEX,
+	token.ASSIGN:          ASSIGN,
+	token.PLUS_ASSIGN:     ASSIGN,
+	token.MINUS_ASSIGN:    ASSIGN,
+	token.ASTERISK_ASSIGN: ASSIGN,
+	token.SLASH_ASSIGN:    ASSIGN,
+	token.OR:              OR,
+	token.AND:             AND,
+	token.EQ:              EQUALS,
+	token.NOT_EQ:          EQUALS,
+	token.LT:              LESSGREATER,
+	token.GT:              LESSGREATER,
+	token.LT_EQ:           LESSGREATER,
+	token.GT_EQ:           LESSGREATER,
+	token.PLUS:            SUM,
+	token.MINUS:           SUM,
+	token.SLASH:           PRODUCT,
+	token.ASTERISK:        PRODUCT,
+	token.PERCENT:         PRODUCT,
+	token.LPAREN:          CALL,
+	token.LBRACKET:        INDEX,
+	token.DOT:             INDEX,
+}
+
+// The precedence of an infix operator, or LOWEST for any other token. Tools
+// that print code, like the formatter, use it to decide where parentheses go.
+func Precedence(t token.TokenType) int {
+	if p, ok := precedences[t]; ok {
+		return p
+	}
+
+	return LOWEST
 }
 
 func NewParser(l *lexer.Lexer) *Parser {
 	p := &Parser{
-		l:      l,
-		errors: []string{},
+		l:           l,
+		diagnostics: []*diagnostic.Diagnostic{},
 	}
 
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn)
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
+	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
 	p.registerPrefix(token.BANG, p.parsePrefixExpression)
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
 	p.registerPrefix(token.TRUE, p.parseBoolean)
 	p.registerPrefix(token.FALSE, p.parseBoolean)
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
 	p.registerPrefix(token.IF, p.parseIfExpression)
+	p.registerPrefix(token.TRY, p.parseTryExpression)
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
 	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
 	p.registerPrefix(token.LBRACE, p.parseHashLiteral)
 	p.registerPrefix(token.MACRO, p.parseMacroLiteral)
+	p.registerPrefix(token.IMPORT, p.parseImportExpression)
 
 	p.infixParseFns = make(map[token.TokenType]infixParseFn)
 	p.registerInfix(token.PLUS, p.parseInfixExpression)
@@ -83,8 +137,19 @@ func NewParser(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
+	p.registerInfix(token.LT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.GT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.PERCENT, p.parseInfixExpression)
+	p.registerInfix(token.AND, p.parseInfixExpression)
+	p.registerInfix(token.OR, p.parseInfixExpression)
+	p.registerInfix(token.ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.PLUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.MINUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.ASTERISK_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.SLASH_ASSIGN, p.parseAssignExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
+	p.registerInfix(token.DOT, p.parseDotExpression)
 
 	// Read two tokens
 	p.nextToken()
@@ -93,23 +158,124 @@ func NewParser(l *lexer.Lexer) *Parser {
 	return p
 }
 
+// Error messages prefixed with their line:col
 func (p *Parser) Errors() []string {
-	return p.errors
+	messages := []string{}
+
+	for _, d := range p.diagnostics {
+		messages = append(messages, d.Error())
+	}
+
+	return messages
+}
+
+// Everything ParseProgram found wrong, in source order
+func (p *Parser) Diagnostics() []*diagnostic.Diagnostic {
+	return p.diagnostics
+}
+
+func (p *Parser) addError(
+	code string,
+	span token.Span,
+	hints []string,
+	format string,
+	a ...interface{},
+) {
+	if p.panicking {
+		return
+	}
+
+	p.panicking = true
+
+	if p.unclosedComment != nil && span.Start.Offset >= p.unclosedComment.Start.Offset {
+		return
+	}
+
+	p.diagnostics = append(p.diagnostics, &diagnostic.Diagnostic{
+		Severity: diagnostic.SeverityError,
+		Code:     code,
+		Span:     span,
+		Message:  fmt.Sprintf(format, a...),
+		Hints:    hints,
+	})
 }
 
 func (p *Parser) peekError(expectedTokenType token.TokenType) {
-	msg := fmt.Sprintf(
+	p.addError(
+		CodeUnexpectedToken,
+		p.peekToken.Span,
+		unexpectedTokenHints(expectedTokenType, p.peekToken.Type),
 		"expected next token to be %s, got %s instead",
 		expectedTokenType,
 		p.peekToken.Type,
 	)
+}
+
+func unexpectedTokenHints(expected token.TokenType, got token.TokenType) []string {
+	switch {
+	case expected == token.IDENT:
+		return []string{"a name is required here, like `let x = 5;` or `fn(a, b) { a + b }`"}
+	case expected == token.ASSIGN:
+		return []string{"bind a value with `=`, like `let x = 5;`"}
+	case got == token.EOF:
+		return []string{fmt.Sprintf("the input ended before a closing %s", expected)}
+	case expected == token.RPAREN || expected == token.RBRACKET || expected == token.RBRACE:
+		return []string{fmt.Sprintf("check for a missing %s or a missing comma", expected)}
+	}
+
+	return nil
+}
+
+// Skip ahead to the end of the broken statement: a ';' at this nesting level,
+// or the token before a '}' that closes the enclosing block, a new let/return,
+// or the end of input. Callers advance past the token we stop on.
+func (p *Parser) synchronize() {
+	depth := 0
+
+	for {
+		if depth == 0 && p.currentTokenIs(token.SEMICOLON) {
+			return
+		}
+
+		if p.peekTokenIs(token.EOF) {
+			return
+		}
+
+		if depth == 0 && (p.peekTokenIs(token.RBRACE) ||
+			p.peekTokenIs(token.LET) ||
+			p.peekTokenIs(token.RETURN) ||
+			p.peekTokenIs(token.WHILE) ||
+			p.peekTokenIs(token.FOR) ||
+			p.peekTokenIs(token.THROW)) {
+			return
+		}
+
+		p.nextToken()
 
-	p.errors = append(p.errors, msg)
+		switch p.currentToken.Type {
+		case token.LBRACE:
+			depth++
+		case token.RBRACE:
+			if depth > 0 {
+				depth--
+			}
+		}
+	}
 }
 
 func (p *Parser) nextToken() {
 	p.currentToken = p.peekToken
 	p.peekToken = p.l.NextToken()
+
+	// The comment swallowed the rest of the input
+	if p.peekToken.Type == token.ILLEGAL && strings.HasPrefix(p.peekToken.Literal, "/*") {
+		span := p.peekToken.Span
+		p.unclosedComment = &span
+		p.peekToken = token.Token{
+			Type: token.EOF,
+			Span: token.Span{Start: p.peekToken.Span.End, End: p.peekToken.Span.End},
+		}
+	}
 }
 
 func (p *Parser) ParseProgram() *ast.Program {
@@ -128,10 +294,76 @@ func (p *Parser) ParseProgram() *ast.Program {
 		p.nextToken()
 	}
 
+	program.Comments = p.syncComments()
+
+	if p.unclosedComment != nil {
+		p.diagnostics = append(p.diagnostics, &diagnostic.Diagnostic{
+			Severity: diagnostic.SeverityError,
+			Code:     CodeUnterminated,
+			Span:     *p.unclosedComment,
+			Message:  "unterminated block comment",
+		})
+	}
+
 	return program
 }
 
+// Wrap any comments the lexer has set aside since the last call
+func (p *Parser) syncComments() []*ast.Comment {
+	lexed := p.l.Comments()
+
+	for _, c := range lexed[len(p.comments):] {
+		p.comments = append(p.comments, &ast.Comment{Token: c.Token, Trailing: c.Trailing})
+	}
+
+	return p.comments
+}
+
+// The comments sitting on the lines right above t, with no blank line or code
+// in between. Returns nil if there are none.
+func (p *Parser) docComment(t token.Token) *ast.CommentGroup {
+	comments := p.syncComments()
+	line := t.Span.Start.Line
+	list := []*ast.Comment{}
+
+	for i := len(comments) - 1; i >= 0; i-- {
+		c := comments[i]
+
+		// Already lexed as part of the lookahead
+		if c.Pos().Offset >= t.Span.Start.Offset {
+			continue
+		}
+
+		if c.Trailing || c.End().Line != line-1 {
+			break
+		}
+
+		list = append([]*ast.Comment{c}, list...)
+		line = c.Pos().Line
+	}
+
+	if len(list) == 0 {
+		return nil
+	}
+
+	return &ast.CommentGroup{List: list}
+}
+
+// Parse one statement. A malformed statement is dropped, and parsing resumes
+// where the next one most likely starts.
 func (p *Parser) parseStatement() ast.Statement {
+	statement := p.parseStatementByType()
+
+	if p.panicking {
+		p.synchronize()
+		p.panicking = false
+		return nil
+	}
+
+	return statement
+}
+
+func (p *Parser) parseStatementByType() ast.Statement {
 	switch p.currentToken.Type {
 
 	case token.LET:
@@ -140,6 +372,18 @@ func (p *Parser) parseStatement() ast.Statement {
 	case token.RETURN:
 		return p.parseReturnStatement()
 
+	case token.WHILE:
+		return p.parseWhileStatement()
+
+	case token.FOR:
+		return p.parseForStatement()
+
+	case token.BREAK, token.CONTINUE:
+		return p.parseLoopControlStatement()
+
+	case token.THROW:
+		return p.parseThrowStatement()
+
 	default:
 		return p.parseExpressionStatement()
 	}
@@ -156,6 +400,7 @@ let <name> = <expression | value>;
 */
 func (p *Parser) parseLetStatement() ast.Statement {
 	statement := &ast.LetStatement{Token: p.currentToken}
+	statement.Doc = p.docComment(p.currentToken)
 
 	if !p.expectPeekThenConsume(token.IDENT) {
 		return nil
@@ -183,6 +428,7 @@ func (p *Parser) parseLetStatement() ast.Statement {
 	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
+	statement.End = p.currentToken.Span.End
 
 	return statement
 }
@@ -211,6 +457,21 @@ func (p *Parser) parseReturnStatement() ast.Statement {
 	return statement
 }
 
+// <throw> <expression> <;>
+func (p *Parser) parseThrowStatement() ast.Statement {
+	statement := &ast.ThrowStatement{Token: p.currentToken}
+
+	p.nextToken()
+
+	statement.Value = p.parseExpression(LOWEST)
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return statement
+}
+
 func (p *Parser) parseExpressionStatement() ast.Statement {
 	statement := &ast.ExpressionStatement{Token: p.currentToken}
 
@@ -223,6 +484,134 @@ func (p *Parser) parseExpressionStatement() ast.Statement {
 	return statement
 }
 
+// <while> <(> <condition> <)> <{> <body> <}> <;>?
+func (p *Parser) parseWhileStatement() ast.Statement {
+	statement := &ast.WhileStatement{Token: p.currentToken}
+
+	if !p.expectPeekThenConsume(token.LPAREN) {
+		return nil
+	}
+
+	p.nextToken()
+	statement.Condition = p.parseExpression(LOWEST)
+
+	if !p.expectPeekThenConsume(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeekThenConsume(token.LBRACE) {
+		return nil
+	}
+
+	statement.Body = p.parseLoopBody()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return statement
+}
+
+// <for> <(> <init>? <;> <condition>? <;> <post>? <)> <{> <body> <}> <;>?
+func (p *Parser) parseForStatement() ast.Statement {
+	statement := &ast.ForStatement{Token: p.currentToken}
+
+	if !p.expectPeekThenConsume(token.LPAREN) {
+		return nil
+	}
+
+	// Move to <init>, or the <;> if there isn't one
+	p.nextToken()
+	if !p.currentTokenIs(token.SEMICOLON) {
+		statement.Init = p.parseForClause()
+		if p.panicking {
+			return nil
+		}
+
+		// Let and expression statements already stop on their <;> when there is one
+		if !p.currentTokenIs(token.SEMICOLON) && !p.expectPeekThenConsume(token.SEMICOLON) {
+			return nil
+		}
+	}
+
+	// Move to <condition>, or the second <;>
+	p.nextToken()
+	if !p.currentTokenIs(token.SEMICOLON) {
+		statement.Condition = p.parseExpression(LOWEST)
+
+		if !p.expectPeekThenConsume(token.SEMICOLON) {
+			return nil
+		}
+	}
+
+	// Move to <post>, or the <)>
+	p.nextToken()
+	if !p.currentTokenIs(token.RPAREN) {
+		statement.Post = p.parseForClause()
+		if p.panicking {
+			return nil
+		}
+
+		if !p.expectPeekThenConsume(token.RPAREN) {
+			return nil
+		}
+	}
+
+	if !p.expectPeekThenConsume(token.LBRACE) {
+		return nil
+	}
+
+	statement.Body = p.parseLoopBody()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return statement
+}
+
+// The init and post clauses of a for loop are a let or a bare expression
+func (p *Parser) parseForClause() ast.Statement {
+	if p.currentTokenIs(token.LET) {
+		return p.parseLetStatement()
+	}
+
+	return p.parseExpressionStatement()
+}
+
+func (p *Parser) parseLoopBody() *ast.BlockStatement {
+	p.loopDepth++
+	defer func() { p.loopDepth-- }()
+
+	return p.parseBlockStatement()
+}
+
+// <break | continue> <;>
+func (p *Parser) parseLoopControlStatement() ast.Statement {
+	tok := p.currentToken
+
+	if p.loopDepth == 0 {
+		p.addError(
+			CodeOutsideLoop,
+			tok.Span,
+			[]string{"break and continue only work inside a while or for loop in the same function"},
+			"%s outside of a loop",
+			tok.Literal,
+		)
+		return nil
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	if tok.Type == token.BREAK {
+		return &ast.BreakStatement{Token: tok}
+	}
+
+	return &ast.ContinueStatement{Token: tok}
+}
+
 func (p *Parser) parseExpression(precendence int) ast.Expression {
 
 	prefix := p.prefixParseFns[p.currentToken.Type]
@@ -286,8 +675,13 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 	value, err := strconv.ParseInt(p.currentToken.Literal, 0, 64)
 
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as integer", p.currentToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.addError(
+			CodeInvalidInteger,
+			p.currentToken.Span,
+			[]string{"integers must fit in 64 bits"},
+			"could not parse %q as integer",
+			p.currentToken.Literal,
+		)
 		return nil
 	}
 
@@ -296,9 +690,44 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 	return il
 }
 
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	fl := &ast.FloatLiteral{Token: p.currentToken}
+
+	value, err := strconv.ParseFloat(p.currentToken.Literal, 64)
+
+	if err != nil {
+		p.addError(
+			CodeInvalidFloat,
+			p.currentToken.Span,
+			[]string{"floats must fit in 64 bits"},
+			"could not parse %q as float",
+			p.currentToken.Literal,
+		)
+		return nil
+	}
+
+	fl.Value = value
+
+	return fl
+}
+
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
-	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+	var hints []string
+
+	switch t {
+	case token.EOF:
+		hints = []string{"the input ended in the middle of an expression"}
+	case token.RPAREN, token.RBRACKET, token.RBRACE, token.SEMICOLON, token.COMMA:
+		hints = []string{fmt.Sprintf("an expression is missing before %s", t)}
+	}
+
+	p.addError(
+		CodeMissingExpression,
+		p.currentToken.Span,
+		hints,
+		"no prefix parse function for %s found",
+		t,
+	)
 }
 
 // <prefix-expression> <expression>
@@ -331,6 +760,36 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	return expression
 }
 
+// <identifier | index> <= | += | -= | *= | /=> <expression>
+func (p *Parser) parseAssignExpression(target ast.Expression) ast.Expression {
+	expression := &ast.AssignExpression{
+		Token:    p.currentToken,
+		Target:   target,
+		Operator: p.currentToken.Literal,
+	}
+
+	switch target.(type) {
+	case *ast.Identifier, *ast.IndexExpression:
+	default:
+		p.addError(
+			CodeInvalidAssignment,
+			p.currentToken.Span,
+			[]string{"only a variable, like `x = 1`, or an index, like `xs[0] = 1`, can be assigned to"},
+			"cannot assign to %s",
+			target.String(),
+		)
+		return nil
+	}
+
+	p.nextToken()
+
+	// Parsing the value at the lowest precedence makes assignment right
+	// associative: a = b = c is a = (b = c)
+	expression.Value = p.parseExpression(LOWEST)
+
+	return expression
+}
+
 func (p *Parser) parseBoolean() ast.Expression {
 	return &ast.Boolean{Token: p.currentToken, Value: p.currentTokenIs(token.TRUE)}
 }
@@ -385,6 +844,43 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	return expression
 }
 
+// <try> <{> <body> <}> <catch> <(> <ident> <)> <{> <handler> <}>
+func (p *Parser) parseTryExpression() ast.Expression {
+	expression := &ast.TryExpression{Token: p.currentToken}
+
+	if !p.expectPeekThenConsume(token.LBRACE) {
+		return nil
+	}
+
+	expression.Body = p.parseBlockStatement()
+
+	if !p.expectPeekThenConsume(token.CATCH) {
+		return nil
+	}
+
+	if !p.expectPeekThenConsume(token.LPAREN) {
+		return nil
+	}
+
+	if !p.expectPeekThenConsume(token.IDENT) {
+		return nil
+	}
+
+	expression.Param = &ast.Identifier{Token: p.currentToken, Value: p.currentToken.Literal}
+
+	if !p.expectPeekThenConsume(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeekThenConsume(token.LBRACE) {
+		return nil
+	}
+
+	expression.Handler = p.parseBlockStatement()
+
+	return expression
+}
+
 // Parses statements between { }
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	block := &ast.BlockStatement{Token: p.currentToken}
@@ -400,6 +896,8 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 		p.nextToken()
 	}
 
+	block.Rbrace = p.currentToken
+
 	return block
 }
 
@@ -407,6 +905,11 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 func (p *Parser) parseFunctionLiteral() ast.Expression {
 	lit := &ast.FunctionLiteral{Token: p.currentToken}
 
+	// Loops around a function don't let its body break out of them
+	outerLoopDepth := p.loopDepth
+	p.loopDepth = 0
+	defer func() { p.loopDepth = outerLoopDepth }()
+
 	// Move from <(> into <args> if <(>
 	if !p.expectPeekThenConsume(token.LPAREN) {
 		return nil
@@ -459,19 +962,11 @@ func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 }
 
 func (p *Parser) peekPrecedence() int {
-	if p, ok := precedences[p.peekToken.Type]; ok {
-		return p
-	}
-
-	return LOWEST
+	return Precedence(p.peekToken.Type)
 }
 
 func (p *Parser) currentPrecedence() int {
-	if p, ok := precedences[p.currentToken.Type]; ok {
-		return p
-	}
-
-	return LOWEST
+	return Precedence(p.currentToken.Type)
 }
 func (p *Parser) parseStringLiteral() ast.Expression {
 	return &ast.StringLiteral{Token: p.currentToken, Value: p.currentToken.Literal}
@@ -522,6 +1017,33 @@ func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	return exp
 }
 
+// mod.name is shorthand for mod["name"]
+func (p *Parser) parseDotExpression(left ast.Expression) ast.Expression {
+	exp := &ast.IndexExpression{Token: p.currentToken, Left: left}
+
+	if !p.expectPeekThenConsume(token.IDENT) {
+		return nil
+	}
+
+	name := p.currentToken
+	name.Type = token.STRING
+	exp.Index = &ast.StringLiteral{Token: name, Value: name.Literal}
+
+	return exp
+}
+
+func (p *Parser) parseImportExpression() ast.Expression {
+	exp := &ast.ImportExpression{Token: p.currentToken}
+
+	if !p.expectPeekThenConsume(token.STRING) {
+		return nil
+	}
+
+	exp.Path = &ast.StringLiteral{Token: p.currentToken, Value: p.currentToken.Literal}
+
+	return exp
+}
+
 func (p *Parser) parseHashLiteral() ast.Expression {
 	hash := &ast.HashLiteral{Token: p.currentToken}
 	hash.Pairs = make(map[ast.Expression]ast.Expression)
@@ -554,6 +1076,10 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 func (p *Parser) parseMacroLiteral() ast.Expression {
 	lit := &ast.MacroLiteral{Token: p.currentToken}
 
+	outerLoopDepth := p.loopDepth
+	p.loopDepth = 0
+	defer func() { p.loopDepth = outerLoopDepth }()
+
 	if !p.expectPeekThenConsume(token.LPAREN) {
 		return nil
 	}
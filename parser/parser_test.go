@@ -3,7 +3,9 @@ package parser
 import (
 	"fmt"
 	"rafiki/ast"
+	"rafiki/diagnostic"
 	"rafiki/lexer"
+	"rafiki/token"
 	"testing"
 )
 
@@ -142,6 +144,42 @@ func TestIntegerLiteralExpression(t *testing.T) {
 	}
 }
 
+func TestFloatLiteralExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"3.14;", 3.14},
+		{"1e3;", 1000},
+		{"2.5e-1;", 0.25},
+	}
+
+	for _, tt := range tests {
+		l := lexer.NewLexer(tt.input)
+		p := NewParser(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+
+		literal, ok := stmt.Expression.(*ast.FloatLiteral)
+		if !ok {
+			t.Fatalf("exp not *ast.FloatLiteral. got=%T", stmt.Expression)
+		}
+
+		if literal.Value != tt.expected {
+			t.Errorf("literal.Value not %g. got=%g", tt.expected, literal.Value)
+		}
+	}
+
+	p := NewParser(lexer.NewLexer("1e999"))
+	p.ParseProgram()
+
+	if len(p.Diagnostics()) != 1 || p.Diagnostics()[0].Code != CodeInvalidFloat {
+		t.Errorf("expected an out of range float to be a %s error. got=%q", CodeInvalidFloat, p.Errors())
+	}
+}
+
 func TestParsingPrefixExpressions(t *testing.T) {
 	prefixTests := []struct {
 		input    string
@@ -340,6 +378,30 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"add(a * b[2], b[1], 2 * [1, 2][1])",
 			"add((a * (b[2])), (b[1]), (2 * ([1, 2][1])))",
 		},
+		{
+			"a <= b == c >= d",
+			"((a <= b) == (c >= d))",
+		},
+		{
+			"-mod.f(x).y + mod.xs[0]",
+			"((-((mod.f)(x).y)) + ((mod.xs)[0]))",
+		},
+		{
+			"a + b % c * d",
+			"(a + ((b % c) * d))",
+		},
+		{
+			"a || b && c",
+			"(a || (b && c))",
+		},
+		{
+			"a && b || c && d",
+			"((a && b) || (c && d))",
+		},
+		{
+			"a == b && c != d || !e",
+			"(((a == b) && (c != d)) || (!e))",
+		},
 	}
 
 	for _, tt := range tests {
@@ -355,6 +417,81 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 	}
 }
 
+func TestAssignExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"x = 5;", "x = 5"},
+		{"x += 1 + 2;", "x += (1 + 2)"},
+		{"x -= y * 2;", "x -= (y * 2)"},
+		{"x *= 2;", "x *= 2"},
+		{"x /= 2;", "x /= 2"},
+		{"a = b = c;", "a = b = c"},
+		{"xs[0] = 1;", "(xs[0]) = 1"},
+		{"h[\"k\"] += f(1);", "(h[k]) += f(1)"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.NewLexer(tt.input)
+		p := NewParser(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statement. got=%d", len(program.Statements))
+		}
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+
+		exp, ok := stmt.Expression.(*ast.AssignExpression)
+		if !ok {
+			t.Fatalf("stmt.Expression is not ast.AssignExpression. got=%T", stmt.Expression)
+		}
+
+		if exp.String() != tt.expected {
+			t.Errorf("wrong string. want=%q, got=%q", tt.expected, exp.String())
+		}
+	}
+
+	// Right associative: the value of the outer assignment is the inner one
+	program := NewParser(lexer.NewLexer("a = b = c")).ParseProgram()
+	outer := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.AssignExpression)
+	if _, ok := outer.Value.(*ast.AssignExpression); !ok {
+		t.Errorf("a = b = c did not nest to the right. got value=%T", outer.Value)
+	}
+}
+
+func TestInvalidAssignmentTarget(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{"1 = 2;", "1:3: cannot assign to 1"},
+		{"a + b = c;", "1:7: cannot assign to (a + b)"},
+		{"f() += 1;", "1:5: cannot assign to f()"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.NewLexer(tt.input)
+		p := NewParser(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != 1 {
+			t.Fatalf("expected 1 error for %q, got=%q", tt.input, errors)
+		}
+
+		if errors[0] != tt.expectedError {
+			t.Errorf("wrong error. want=%q, got=%q", tt.expectedError, errors[0])
+		}
+
+		if p.Diagnostics()[0].Code != CodeInvalidAssignment {
+			t.Errorf("wrong code. want=%s, got=%s", CodeInvalidAssignment, p.Diagnostics()[0].Code)
+		}
+	}
+}
+
 func TestIfExpression(t *testing.T) {
 	input := `if (x < y) { x }`
 
@@ -404,6 +541,217 @@ func TestIfExpression(t *testing.T) {
 	}
 }
 
+func TestWhileStatement(t *testing.T) {
+	input := `while (x < y) { x; break; continue; }`
+
+	l := lexer.NewLexer(input)
+	p := NewParser(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain %d statements. got=%d\n",
+			1, len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.WhileStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.WhileStatement. got=%T",
+			program.Statements[0])
+	}
+
+	if !testInfixExpression(t, stmt.Condition, "x", "<", "y") {
+		return
+	}
+
+	if len(stmt.Body.Statements) != 3 {
+		t.Fatalf("body is not 3 statements. got=%d\n", len(stmt.Body.Statements))
+	}
+
+	if _, ok := stmt.Body.Statements[1].(*ast.BreakStatement); !ok {
+		t.Errorf("Statements[1] is not ast.BreakStatement. got=%T", stmt.Body.Statements[1])
+	}
+
+	if _, ok := stmt.Body.Statements[2].(*ast.ContinueStatement); !ok {
+		t.Errorf("Statements[2] is not ast.ContinueStatement. got=%T", stmt.Body.Statements[2])
+	}
+}
+
+func TestForStatement(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"for (let i = 0; i < 10; let i = i + 1) { i }", "for (let i = 0; (i < 10); let i = (i + 1)) i"},
+		{"for (i; i; i) { i }", "for (i; i; i) i"},
+		{"for (;;) { break; }", "for (; ; ) break;"},
+		{"for (; x;) { }", "for (; x; ) "},
+	}
+
+	for _, tt := range tests {
+		l := lexer.NewLexer(tt.input)
+		p := NewParser(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statement for %q. got=%d",
+				tt.input, len(program.Statements))
+		}
+
+		stmt, ok := program.Statements[0].(*ast.ForStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not ast.ForStatement. got=%T",
+				program.Statements[0])
+		}
+
+		if stmt.String() != tt.expected {
+			t.Errorf("wrong string. want=%q, got=%q", tt.expected, stmt.String())
+		}
+	}
+}
+
+func TestLoopTrailingSemicolon(t *testing.T) {
+	tests := []string{
+		"while (x) { x; }; y;",
+		"for (;;) { break; }; y;",
+	}
+
+	for _, input := range tests {
+		l := lexer.NewLexer(input)
+		p := NewParser(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 2 {
+			t.Fatalf("program.Statements does not contain 2 statements for %q. got=%d",
+				input, len(program.Statements))
+		}
+	}
+}
+
+func TestLoopControlOutsideLoop(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{"break;", "1:1: break outside of a loop"},
+		{"if (true) { continue; }", "1:13: continue outside of a loop"},
+		{"while (true) { fn() { break; }; }", "1:23: break outside of a loop"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.NewLexer(tt.input)
+		p := NewParser(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != 1 {
+			t.Fatalf("expected 1 error for %q, got=%q", tt.input, errors)
+		}
+
+		if errors[0] != tt.expectedError {
+			t.Errorf("wrong error. want=%q, got=%q", tt.expectedError, errors[0])
+		}
+
+		if p.Diagnostics()[0].Code != CodeOutsideLoop {
+			t.Errorf("wrong code. want=%s, got=%s", CodeOutsideLoop, p.Diagnostics()[0].Code)
+		}
+	}
+}
+
+func TestTryExpression(t *testing.T) {
+	input := `try { risky(); } catch (err) { err }`
+
+	l := lexer.NewLexer(input)
+	p := NewParser(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain %d statements. got=%d\n",
+			1, len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T",
+			program.Statements[0])
+	}
+
+	exp, ok := stmt.Expression.(*ast.TryExpression)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.TryExpression. got=%T", stmt.Expression)
+	}
+
+	if len(exp.Body.Statements) != 1 {
+		t.Errorf("body is not 1 statement. got=%d\n", len(exp.Body.Statements))
+	}
+
+	if !testIdentifier(t, exp.Param, "err") {
+		return
+	}
+
+	if len(exp.Handler.Statements) != 1 {
+		t.Fatalf("handler is not 1 statement. got=%d\n", len(exp.Handler.Statements))
+	}
+
+	handler, ok := exp.Handler.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("Statements[0] is not ast.ExpressionStatement. got=%T", exp.Handler.Statements[0])
+	}
+
+	testIdentifier(t, handler.Expression, "err")
+}
+
+func TestThrowStatement(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`throw "oops";`, `throw oops;`},
+		{`throw err`, `throw err;`},
+		{`throw 1 + 2`, `throw (1 + 2);`},
+	}
+
+	for _, tt := range tests {
+		l := lexer.NewLexer(tt.input)
+		p := NewParser(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+}
+
+func TestMalformedTryExpression(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{"try { 1 } (e) { 2 }", "1:11: expected next token to be CATCH, got ( instead"},
+		{"try { 1 } catch { 2 }", "1:17: expected next token to be (, got { instead"},
+		{"try { 1 } catch (1) { 2 }", "1:18: expected next token to be IDENT, got INT instead"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.NewLexer(tt.input)
+		p := NewParser(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Fatalf("expected an error for %q, got none", tt.input)
+		}
+
+		if errors[0] != tt.expectedError {
+			t.Errorf("wrong error. want=%q, got=%q", tt.expectedError, errors[0])
+		}
+	}
+}
+
 func TestIfElseExpression(t *testing.T) {
 	input := `if (x < y) { x } else { y }`
 
@@ -793,6 +1141,49 @@ func TestParsingIndexExpressions(t *testing.T) {
 	}
 }
 
+func TestParsingDotExpressions(t *testing.T) {
+	input := "mod.name"
+
+	l := lexer.NewLexer(input)
+	p := NewParser(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	indexExp, ok := stmt.Expression.(*ast.IndexExpression)
+	if !ok {
+		t.Fatalf("exp not *ast.IndexExpression. got=%T", stmt.Expression)
+	}
+
+	if !testIdentifier(t, indexExp.Left, "mod") {
+		return
+	}
+
+	name, ok := indexExp.Index.(*ast.StringLiteral)
+	if !ok || name.Value != "name" {
+		t.Fatalf("index is not the string \"name\". got=%T (%+v)", indexExp.Index, indexExp.Index)
+	}
+}
+
+func TestParsingImportExpressions(t *testing.T) {
+	input := `let lib = import "lib/util.rk";`
+
+	l := lexer.NewLexer(input)
+	p := NewParser(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.LetStatement)
+	imp, ok := stmt.Value.(*ast.ImportExpression)
+	if !ok {
+		t.Fatalf("exp not *ast.ImportExpression. got=%T", stmt.Value)
+	}
+
+	if imp.Path.Value != "lib/util.rk" {
+		t.Errorf("wrong path. want=%q, got=%q", "lib/util.rk", imp.Path.Value)
+	}
+}
+
 func TestParsingHashLiteralsStringKeys(t *testing.T) {
 	input := `{"one": 1, "two": 2, "three": 3}`
 
@@ -971,3 +1362,188 @@ func TestFunctionLiteralWithName(t *testing.T) {
 			function.Name)
 	}
 }
+
+func TestParserErrorPositions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let = 5;", "1:5: expected next token to be IDENT, got = instead"},
+		{"let x = 5;\nadd(1, 2;", "2:9: expected next token to be ), got ; instead"},
+		{"let x = 5;\n  * 2", "2:3: no prefix parse function for * found"},
+		{"mod.1", "1:5: expected next token to be IDENT, got INT instead"},
+		{"import lib", "1:8: expected next token to be STRING, got IDENT instead"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.NewLexer(tt.input)
+		p := NewParser(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Fatalf("expected parser errors for %q, got none", tt.input)
+		}
+
+		if errors[0] != tt.expected {
+			t.Errorf("wrong first error. want=%q, got=%q", tt.expected, errors[0])
+		}
+	}
+}
+
+func TestErrorRecovery(t *testing.T) {
+	tests := []struct {
+		input              string
+		expectedErrors     []string
+		expectedStatements []string
+	}{
+		{
+			"let = 5; let y = 10; y;",
+			[]string{"1:5: expected next token to be IDENT, got = instead"},
+			[]string{"let y = 10;", "y"},
+		},
+		{
+			"let x = (1 + ; let y = 2;",
+			[]string{"1:14: no prefix parse function for ; found"},
+			[]string{"let y = 2;"},
+		},
+		{
+			"let f = fn(x) { let = 1; x }; f(1); let = 2;",
+			[]string{
+				"1:21: expected next token to be IDENT, got = instead",
+				"1:41: expected next token to be IDENT, got = instead",
+			},
+			[]string{"let f = fn<f>(fn(x) x;", "f(1)"},
+		},
+		{
+			"let x = 1; /* never closed",
+			[]string{"1:12: unterminated block comment"},
+			[]string{"let x = 1;"},
+		},
+		{
+			"let x = 1 + /* never closed",
+			[]string{"1:13: unterminated block comment"},
+			[]string{},
+		},
+		{
+			"let = 1; /* never closed",
+			[]string{
+				"1:5: expected next token to be IDENT, got = instead",
+				"1:10: unterminated block comment",
+			},
+			[]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		l := lexer.NewLexer(tt.input)
+		p := NewParser(l)
+		program := p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != len(tt.expectedErrors) {
+			t.Fatalf("wrong number of errors for %q. want=%d, got=%d (%q)",
+				tt.input, len(tt.expectedErrors), len(errors), errors)
+		}
+
+		for i, expected := range tt.expectedErrors {
+			if errors[i] != expected {
+				t.Errorf("wrong error %d. want=%q, got=%q", i, expected, errors[i])
+			}
+		}
+
+		if len(program.Statements) != len(tt.expectedStatements) {
+			t.Fatalf("wrong number of statements for %q. want=%d, got=%d",
+				tt.input, len(tt.expectedStatements), len(program.Statements))
+		}
+
+		for i, expected := range tt.expectedStatements {
+			if program.Statements[i].String() != expected {
+				t.Errorf("wrong statement %d. want=%q, got=%q",
+					i, expected, program.Statements[i].String())
+			}
+		}
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	l := lexer.NewLexerWithFilename("let x = add(1, 2;", "main.rk")
+	p := NewParser(l)
+	p.ParseProgram()
+
+	diagnostics := p.Diagnostics()
+	if len(diagnostics) != 1 {
+		t.Fatalf("expected 1 diagnostic, got=%d", len(diagnostics))
+	}
+
+	d := diagnostics[0]
+	if d.Severity != diagnostic.SeverityError {
+		t.Errorf("wrong severity. want=%s, got=%s", diagnostic.SeverityError, d.Severity)
+	}
+
+	if d.Code != CodeUnexpectedToken {
+		t.Errorf("wrong code. want=%s, got=%s", CodeUnexpectedToken, d.Code)
+	}
+
+	start := token.Position{Filename: "main.rk", Offset: 16, Line: 1, Column: 17}
+	end := token.Position{Filename: "main.rk", Offset: 17, Line: 1, Column: 18}
+	if d.Span.Start != start || d.Span.End != end {
+		t.Errorf("wrong span. want=%s-%s, got=%s-%s", start, end, d.Span.Start, d.Span.End)
+	}
+
+	if len(d.Hints) == 0 {
+		t.Errorf("expected a hint for a missing )")
+	}
+}
+
+func TestDocComments(t *testing.T) {
+	input := `// unrelated
+
+// Adds two numbers.
+// Returns their sum.
+let add = fn(a, b) { a + b };
+let x = 1; // not a doc comment
+let y = 2;
+/* Block doc */
+let z = [1, 2][0]; // => 1
+`
+
+	l := lexer.NewLexer(input)
+	p := NewParser(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 4 {
+		t.Fatalf("program.Statements does not contain 4 statements. got=%d", len(program.Statements))
+	}
+
+	tests := []struct {
+		expectedDoc string
+	}{
+		{"Adds two numbers.\nReturns their sum."},
+		{""},
+		{""},
+		{"Block doc"},
+	}
+
+	for i, tt := range tests {
+		statement := program.Statements[i].(*ast.LetStatement)
+
+		doc := ""
+		if statement.Doc != nil {
+			doc = statement.Doc.Text()
+		}
+
+		if doc != tt.expectedDoc {
+			t.Errorf("statement %d doc wrong. want=%q, got=%q", i, tt.expectedDoc, doc)
+		}
+	}
+
+	if len(program.Comments) != 6 {
+		t.Fatalf("program.Comments does not contain 6 comments. got=%d", len(program.Comments))
+	}
+
+	if program.Statements[0].(*ast.LetStatement).Doc.List[0] != program.Comments[1] {
+		t.Errorf("doc comment is not shared with program.Comments")
+	}
+}
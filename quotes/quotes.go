@@ -2,7 +2,9 @@ package quotes
 
 import (
 	"fmt"
+	"io"
 	"math/rand"
+	"os"
 	"time"
 )
 
@@ -17,10 +19,14 @@ var quoteList = []string{
 }
 
 func PrintQuote() {
+	FprintQuote(os.Stdout)
+}
+
+func FprintQuote(w io.Writer) {
 	rand.Seed(time.Now().UnixNano())
 	randomIndex := rand.Intn(len(quoteList))
 
 	quote := quoteList[randomIndex]
 
-	fmt.Printf("\n%s\n- Rafiki\n", quote)
+	fmt.Fprintf(w, "\n%s\n- Rafiki\n", quote)
 }
@@ -4,13 +4,17 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"os"
+	"rafiki/ast"
 	"rafiki/compiler"
+	"rafiki/diagnostic"
 	"rafiki/eval"
 	"rafiki/lexer"
 	"rafiki/object"
 	"rafiki/parser"
 	"rafiki/quotes"
 	"rafiki/vm"
+	"strings"
 )
 
 // TODO - find an equivalent package to readline and implement
@@ -96,85 +100,342 @@ $$$$$$$$$$$$   > <~ ~d$$$$$$$$$$$$$$$$$$$$$$$$$$$$$$$$$$$$$$$$$$`
 	VM:			The VM then takes these OpCodes and Operands and evaluate them in a very similar manner to the Evaluator.
 */
 
+const (
+	EngineVM   = "vm"
+	EngineEval = "eval"
+	EngineBoth = "both"
+)
+
+const CONTINUATION_PROMPT = "       .. "
+
+var Null = &object.Null{}
+
+const HELP = `Meta commands:
+  :engine [vm|eval|both]   show or switch the execution engine
+  :reset                   forget all bindings, macros and constants
+  :globals                 list the global bindings of the current engine
+  :load <file>             run a source file in the current session
+  :help                    show this message
+  :quit                    leave the REPL
+`
+
+// Everything that has to survive between two inputs
+type session struct {
+	out    io.Writer
+	engine string
+
+	env      *object.Environment
+	macroEnv *object.Environment
+
+	constants   []object.Object
+	globals     []object.Object
+	symbolTable *compiler.SymbolTable
+}
+
+func newSession(out io.Writer, engine string) *session {
+	s := &session{out: out, engine: engine}
+	s.reset()
+	return s
+}
+
+func (s *session) reset() {
+	s.env = object.NewEnvironment()
+	s.macroEnv = object.NewEnvironment()
+
+	s.constants = []object.Object{}
+	s.globals = make([]object.Object, vm.GlobalsSize)
+	s.symbolTable = compiler.NewSymbolTableWithBuiltins(object.DefaultBuiltins())
+}
+
 func Start(in io.Reader, out io.Writer) {
+	StartWithEngine(in, out, EngineVM)
+}
+
+func StartWithEngine(in io.Reader, out io.Writer, engine string) {
 	scanner := bufio.NewScanner(in)
 
 	// We want our environment to persist between REPL calls
-	e := object.NewEnvironment()
-	macroEnv := object.NewEnvironment()
+	s := newSession(out, engine)
 
-	constants := []object.Object{}
-	globals := make([]object.Object, vm.GlobalsSize)
-	symbolTable := compiler.NewSymbolTable()
-	for i, v := range object.Builtins {
-		symbolTable.DefineBuiltin(i, v.Name)
-	}
-
-	fmt.Printf("\n")
+	io.WriteString(out, "\n")
 	io.WriteString(out, RAFIKI)
-	fmt.Printf("\n\n")
-	fmt.Fprintf(out, WELCOME)
-	quotes.PrintQuote()
-	fmt.Printf("\n")
+	io.WriteString(out, "\n\n")
+	io.WriteString(out, WELCOME)
+	quotes.FprintQuote(out)
+	io.WriteString(out, "\n")
 
 	for {
-		fmt.Fprintf(out, PROMPT)
-		scanned := scanner.Scan()
-		if !scanned {
+		io.WriteString(out, PROMPT)
+
+		input, ok := readInput(scanner, out)
+		if !ok {
 			return
 		}
 
-		line := scanner.Text()
+		trimmed := strings.TrimSpace(input)
+		if trimmed == "" {
+			continue
+		}
 
-		l := lexer.NewLexer(line)
-		p := parser.NewParser(l)
+		if strings.HasPrefix(trimmed, ":") {
+			if !s.runMetaCommand(trimmed) {
+				return
+			}
+			continue
+		}
 
-		program := p.ParseProgram()
-		if len(p.Errors()) != 0 {
-			printParserErrors(out, p.Errors())
+		s.execute(input)
+	}
+}
+
+// Read one line, then keep reading continuation lines for as long as the
+// input has unclosed braces, brackets, parens or strings.
+func readInput(scanner *bufio.Scanner, out io.Writer) (string, bool) {
+	if !scanner.Scan() {
+		return "", false
+	}
+
+	input := scanner.Text()
+	if strings.HasPrefix(strings.TrimSpace(input), ":") {
+		return input, true
+	}
+
+	for needsMoreInput(input) {
+		io.WriteString(out, CONTINUATION_PROMPT)
+
+		if !scanner.Scan() {
+			// Let the parser report whatever is left dangling
+			return input, true
+		}
+
+		input += "\n" + scanner.Text()
+	}
+
+	return input, true
+}
+
+// Reports whether input has more openers than closers. Extra closers are left
+// for the parser to complain about.
+func needsMoreInput(input string) bool {
+	depth := 0
+	inString := false
+
+	for i := 0; i < len(input); i++ {
+		char := input[i]
+
+		if inString {
+			if char == '"' {
+				inString = false
+			}
 			continue
 		}
 
-		eval.DefineMacros(program, macroEnv)
-		expandedProgram := eval.ExpandMacros(program, macroEnv)
+		next := byte(0)
+		if i+1 < len(input) {
+			next = input[i+1]
+		}
 
-		compiler := compiler.NewCompilerWithState(symbolTable, constants)
-		err := compiler.Compile(expandedProgram)
-		if err != nil {
-			fmt.Fprintf(out, "Woops! Compilation failed:\n %s\n", err)
+		switch {
+		case char == '/' && next == '/':
+			for i < len(input) && input[i] != '\n' {
+				i++
+			}
 			continue
+		case char == '/' && next == '*':
+			end := strings.Index(input[i+2:], "*/")
+			if end < 0 {
+				return true
+			}
+			i += end + 3
+			continue
+		}
+
+		switch char {
+		case '"':
+			inString = true
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
 		}
+	}
+
+	return inString || depth > 0
+}
 
-		code := compiler.Bytecode()
-		constants = code.Constants
+// Returns false when the REPL should exit
+func (s *session) runMetaCommand(line string) bool {
+	fields := strings.Fields(line)
+	command, args := fields[0], fields[1:]
 
-		machine := vm.NewVmWithGlobalsStore(code, globals)
-		err = machine.Run()
+	switch command {
+	case ":quit", ":q", ":exit":
+		return false
+
+	case ":help":
+		io.WriteString(s.out, HELP)
+
+	case ":engine":
+		if len(args) == 0 {
+			fmt.Fprintf(s.out, "engine: %s\n", s.engine)
+			break
+		}
+
+		switch args[0] {
+		case EngineVM, EngineEval, EngineBoth:
+			s.engine = args[0]
+			fmt.Fprintf(s.out, "engine: %s\n", s.engine)
+		default:
+			fmt.Fprintf(s.out, "unknown engine %q, want vm, eval or both\n", args[0])
+		}
+
+	case ":reset":
+		s.reset()
+		io.WriteString(s.out, "session reset\n")
+
+	case ":globals":
+		s.printGlobals()
+
+	case ":load":
+		if len(args) != 1 {
+			io.WriteString(s.out, "usage: :load <file>\n")
+			break
+		}
+
+		src, err := os.ReadFile(args[0])
 		if err != nil {
-			fmt.Fprintf(out, "Woops! Executing bytecode failed:\n %s\n", err)
-			continue
+			fmt.Fprintf(s.out, "could not load file: %s\n", err)
+			break
+		}
+
+		s.execute(string(src))
+
+	default:
+		fmt.Fprintf(s.out, "unknown command %s, try :help\n", command)
+	}
+
+	return true
+}
+
+func (s *session) printGlobals() {
+	if s.engine != EngineEval {
+		if s.engine == EngineBoth {
+			io.WriteString(s.out, "Compiler Globals:\n")
+		}
+
+		for _, symbol := range s.symbolTable.DefinedSymbols() {
+			if symbol.Index >= len(s.globals) || s.globals[symbol.Index] == nil {
+				continue
+			}
+			value := s.globals[symbol.Index]
+			fmt.Fprintf(s.out, "%s = %s\n", symbol.Name, value.Inspect())
+		}
+	}
+
+	if s.engine != EngineVM {
+		if s.engine == EngineBoth {
+			io.WriteString(s.out, "Interpreted Globals:\n")
+		}
+
+		for _, name := range s.env.Names() {
+			value, _ := s.env.Get(name)
+			fmt.Fprintf(s.out, "%s = %s\n", name, value.Inspect())
+		}
+	}
+}
+
+func (s *session) execute(input string) {
+	l := lexer.NewLexer(input)
+	p := parser.NewParser(l)
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		printParserErrors(s.out, input, p.Diagnostics())
+		return
+	}
+
+	eval.DefineMacros(program, s.macroEnv)
+	expandedProgram := eval.ExpandMacros(program, s.macroEnv)
+
+	switch s.engine {
+	case EngineVM:
+		if result, ok := s.runCompiled(input, expandedProgram); ok {
+			io.WriteString(s.out, result.Inspect()+"\n")
 		}
 
-		lastPopped := machine.LastPoppedStackElem()
-		io.WriteString(out, "Compiler Output:\n")
-		io.WriteString(out, lastPopped.Inspect())
-		io.WriteString(out, "\n")
+	case EngineEval:
+		result := s.runInterpreted(expandedProgram)
+		io.WriteString(s.out, result.Inspect()+"\n")
+		printErrorSnippet(s.out, input, result)
+
+	case EngineBoth:
+		if result, ok := s.runCompiled(input, expandedProgram); ok {
+			io.WriteString(s.out, "Compiler Output:\n")
+			io.WriteString(s.out, result.Inspect()+"\n")
+		}
+
+		result := s.runInterpreted(expandedProgram)
+		io.WriteString(s.out, "Interpreted Output:\n")
+		io.WriteString(s.out, result.Inspect()+"\n")
+		printErrorSnippet(s.out, input, result)
+	}
+}
+
+func (s *session) runCompiled(input string, program ast.Node) (object.Object, bool) {
+	compiler := compiler.NewCompilerWithState(s.symbolTable, s.constants)
+	err := compiler.Compile(program)
+	if err != nil {
+		fmt.Fprintf(s.out, "Woops! Compilation failed:\n %s\n", err)
+		if diagErr, ok := err.(*diagnostic.Error); ok {
+			io.WriteString(s.out, diagnostic.Snippet(input, diagErr.Pos))
+		}
+		return nil, false
+	}
+
+	code := compiler.Bytecode()
+	s.constants = code.Constants
+	s.globals = vm.GrowGlobals(s.globals, len(code.GlobalNames))
+
+	machine := vm.NewVmWithGlobalsStore(code, s.globals)
+	err = machine.Run()
+	if err != nil {
+		fmt.Fprintf(s.out, "Woops! Executing bytecode failed:\n %s\n", err)
+		if objErr, ok := err.(*object.Error); ok {
+			io.WriteString(s.out, diagnostic.Snippet(input, objErr.Pos))
+		}
+		return nil, false
+	}
+
+	lastPopped := machine.LastPoppedStackElem()
+	if lastPopped == nil {
+		return Null, true
+	}
+
+	return lastPopped, true
+}
+
+func (s *session) runInterpreted(program ast.Node) object.Object {
+	result := eval.Eval(program, s.env)
+	if result == nil {
+		return Null
+	}
+
+	return result
+}
 
-		// Interpreted Output
-		result := eval.Eval(expandedProgram, e)
-		io.WriteString(out, "Interpreted Output:\n")
-		io.WriteString(out, result.Inspect())
-		io.WriteString(out, "\n")
+func printErrorSnippet(out io.Writer, input string, result object.Object) {
+	if exception, ok := result.(*object.Exception); ok {
+		io.WriteString(out, diagnostic.Snippet(input, exception.Error.Pos))
 	}
 }
 
-func printParserErrors(out io.Writer, errors []string) {
+func printParserErrors(out io.Writer, input string, diagnostics []*diagnostic.Diagnostic) {
 	// io.WriteString(out, RAFIKI)
 	io.WriteString(out, "\n\n")
 	io.WriteString(out, "Whoops! We ran into some monkey business here!\n\n")
 	io.WriteString(out, "\tparser errors:\n")
-	for _, msg := range errors {
-		io.WriteString(out, "\t\t"+msg+"\n")
+	for _, d := range diagnostics {
+		io.WriteString(out, diagnostic.Render(input, d))
 	}
 	io.WriteString(out, "\n\n")
 }
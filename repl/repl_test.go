@@ -0,0 +1,96 @@
+package repl
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNeedsMoreInput(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"1 + 2", false},
+		{"let add = fn(a, b) {", true},
+		{"let add = fn(a, b) {\n a + b\n};", false},
+		{"[1, 2,", true},
+		{"add(1,", true},
+		{`"{"`, false},
+		{`"unterminated`, true},
+		{"}", false},
+		{"let f = fn() { // }", true},
+		{"/* { */ 1", false},
+		{"/* still going", true},
+	}
+
+	for _, tt := range tests {
+		if got := needsMoreInput(tt.input); got != tt.expected {
+			t.Errorf("needsMoreInput(%q) wrong. want=%t, got=%t",
+				tt.input, tt.expected, got)
+		}
+	}
+}
+
+func runSession(engine string, input string) string {
+	var out bytes.Buffer
+	StartWithEngine(strings.NewReader(input), &out, engine)
+	return out.String()
+}
+
+func TestMultiLineInput(t *testing.T) {
+	input := "let add = fn(a, b) {\n  a + b\n};\nadd(2, 3)\n"
+
+	for _, engine := range []string{EngineVM, EngineEval} {
+		out := runSession(engine, input)
+
+		if !strings.Contains(out, CONTINUATION_PROMPT) {
+			t.Errorf("engine=%s: expected a continuation prompt. got=%q", engine, out)
+		}
+
+		if !strings.HasSuffix(out, "5\n"+PROMPT) {
+			t.Errorf("engine=%s: expected result 5. got=%q", engine, out)
+		}
+	}
+}
+
+func TestMetaCommands(t *testing.T) {
+	out := runSession(EngineVM, ":engine eval\n:engine\nlet x = 10;\n:globals\n:reset\n:globals\nx\n")
+
+	if !strings.Contains(out, "engine: eval\n") {
+		t.Errorf("engine was not switched. got=%q", out)
+	}
+
+	if strings.Count(out, "x = 10\n") != 1 {
+		t.Errorf("expected x = 10 to be listed exactly once. got=%q", out)
+	}
+
+	if !strings.Contains(out, "identifier not found: x") {
+		t.Errorf("expected :reset to forget x. got=%q", out)
+	}
+}
+
+func TestEngineBoth(t *testing.T) {
+	out := runSession(EngineBoth, "1 + 1\n")
+
+	if !strings.Contains(out, "Compiler Output:\n2\n") ||
+		!strings.Contains(out, "Interpreted Output:\n2\n") {
+		t.Errorf("expected output from both engines. got=%q", out)
+	}
+}
+
+func TestLoadCommand(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lib.rk")
+	src := "let double = fn(x) {\n  x * 2\n};\n"
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatalf("could not write source file: %s", err)
+	}
+
+	out := runSession(EngineVM, ":load "+path+"\ndouble(21)\n")
+
+	if !strings.HasSuffix(out, "42\n"+PROMPT) {
+		t.Errorf("expected loaded function to be callable. got=%q", out)
+	}
+}
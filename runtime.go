@@ -0,0 +1,265 @@
+package rafiki
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"rafiki/compiler"
+	"rafiki/diagnostic"
+	"rafiki/eval"
+	"rafiki/lexer"
+	"rafiki/object"
+	"rafiki/parser"
+	"rafiki/vm"
+	"strings"
+)
+
+/*
+Runtime runs Rafiki programs inside a Go application. Its globals outlive
+each program, so a host can set inputs, compile a set of rules once, then run
+them and call the functions they define as often as it needs:
+
+	rt := rafiki.New()
+	rt.SetGlobal("threshold", 100)
+	rt.Register("log", func(msg string) { log.Print(msg) })
+
+	if err := rt.Compile(`let discount = fn(o) { if (o["total"] > threshold) { 10 } else { 0 } };`); err != nil {
+		return err
+	}
+	if _, err := rt.Run(ctx); err != nil {
+		return err
+	}
+	percent, err := rt.Call("discount", map[string]interface{}{"total": 80})
+
+Values cross between Go and Rafiki as described by ToObject and FromObject.
+A Runtime is not safe for concurrent use.
+*/
+type Runtime struct {
+	symbolTable *compiler.SymbolTable
+	constants   []object.Object
+	globals     []object.Object
+	macroEnv    *object.Environment
+	builtins    *object.BuiltinRegistry
+	limits      object.Limits
+
+	bytecode *compiler.Bytecode // The program Run runs, nil until something compiles
+}
+
+// Returned by Compile when the source doesn't parse
+type SyntaxError struct {
+	Diagnostics []*diagnostic.Diagnostic
+}
+
+func (e *SyntaxError) Error() string {
+	messages := make([]string, len(e.Diagnostics))
+	for i, d := range e.Diagnostics {
+		messages[i] = d.Error()
+	}
+
+	return strings.Join(messages, "\n")
+}
+
+// A runtime with the standard builtins
+func New() *Runtime {
+	return NewWithBuiltins(object.DefaultBuiltins())
+}
+
+/*
+NewWithBuiltins gives programs only the functions in builtins, so a host can
+take away what a sandboxed program shouldn't have, or add its own:
+
+	builtins := object.DefaultBuiltins()
+	builtins.Remove("puts")
+	rt := rafiki.NewWithBuiltins(builtins)
+
+A program using a name that isn't in the registry fails to compile. Finish
+changing the registry before calling NewWithBuiltins: the runtime's programs
+are compiled against the builtins it had then.
+*/
+func NewWithBuiltins(builtins *object.BuiltinRegistry) *Runtime {
+	return &Runtime{
+		symbolTable: compiler.NewSymbolTableWithBuiltins(builtins),
+		constants:   []object.Object{},
+		globals:     make([]object.Object, vm.GlobalsSize),
+		macroEnv:    object.NewEnvironmentWithBuiltins(builtins),
+		builtins:    builtins,
+	}
+}
+
+/*
+SetLimits bounds what Run and Call let a program do, for running code the
+host doesn't trust:
+
+	rt.SetLimits(object.Limits{MaxSteps: 1_000_000, MaxAllocation: 10_000, MaxDepth: 200})
+
+A program that goes past a limit fails with an *object.Error whose Kind says
+which, and which unwraps to object.ErrStepLimit, ErrAllocationLimit or
+ErrRecursionLimit. Every Run and Call gets the whole budget.
+*/
+func (r *Runtime) SetLimits(limits object.Limits) {
+	r.limits = limits
+}
+
+// Compile src as the program the next Run runs. It can use the globals set so
+// far, and those of earlier programs. A source that doesn't parse gives a
+// *SyntaxError, and one that doesn't compile a *diagnostic.Error.
+func (r *Runtime) Compile(src string) error {
+	return r.compile("", src)
+}
+
+// Like Compile, for the source in a file. Errors name the file, and its
+// imports are found relative to it.
+func (r *Runtime) CompileFile(path string) error {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	return r.compile(path, string(src))
+}
+
+func (r *Runtime) compile(filename string, src string) error {
+	r.bytecode = nil
+
+	p := parser.NewParser(lexer.NewLexerWithFilename(src, filename))
+	program := p.ParseProgram()
+
+	if diagnostics := p.Diagnostics(); len(diagnostics) > 0 {
+		return &SyntaxError{Diagnostics: diagnostics}
+	}
+
+	eval.DefineMacros(program, r.macroEnv)
+	expanded := eval.ExpandMacros(program, r.macroEnv)
+
+	comp := compiler.NewCompilerWithState(r.symbolTable, r.constants)
+	if err := comp.Compile(expanded); err != nil {
+		return err
+	}
+
+	r.bytecode = comp.Bytecode()
+	r.constants = r.bytecode.Constants
+
+	return nil
+}
+
+// Run the compiled program, returning the value of its last expression
+// statement. Runtime errors, including uncaught throws, are *object.Error.
+// The program stops once ctx is done, with an error that unwraps to ctx's.
+func (r *Runtime) Run(ctx context.Context) (interface{}, error) {
+	if r.bytecode == nil {
+		return nil, fmt.Errorf("nothing compiled to run")
+	}
+
+	r.globals = vm.GrowGlobals(r.globals, len(r.bytecode.GlobalNames))
+
+	machine := vm.NewVmWithGlobalsStore(r.bytecode, r.globals)
+	machine.SetBuiltins(r.builtins)
+	if err := machine.RunWithLimits(ctx, r.limits); err != nil {
+		return nil, err
+	}
+
+	return FromObject(machine.LastPoppedStackElem()), nil
+}
+
+// Call the function bound to the global name, converting args with ToObject
+// and the result with FromObject
+func (r *Runtime) Call(name string, args ...interface{}) (interface{}, error) {
+	return r.CallContext(context.Background(), name, args...)
+}
+
+// Like Call, stopping the function once ctx is done
+func (r *Runtime) CallContext(ctx context.Context, name string, args ...interface{}) (interface{}, error) {
+	fn, ok := r.global(name)
+	if !ok {
+		return nil, fmt.Errorf("undefined function %s", name)
+	}
+
+	if fn.Type() != object.CLOSURE_OBJ && fn.Type() != object.BUILTIN_OBJ {
+		return nil, fmt.Errorf("%s is not a function: %s", name, fn.Type())
+	}
+
+	objects := make([]object.Object, len(args))
+	for i, arg := range args {
+		obj, err := ToObject(arg)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d to %s: %w", i+1, name, err)
+		}
+		objects[i] = obj
+	}
+
+	machine, err := vm.NewVmForCall(r.constants, r.globals, fn, objects...)
+	if err != nil {
+		return nil, err
+	}
+	machine.SetBuiltins(r.builtins)
+
+	if err := machine.RunWithLimits(ctx, r.limits); err != nil {
+		return nil, err
+	}
+
+	return FromObject(machine.LastPoppedStackElem()), nil
+}
+
+// Bind name to value, converted with ToObject, defining the global if it's
+// new. Programs compiled afterwards can use it.
+func (r *Runtime) SetGlobal(name string, value interface{}) error {
+	obj, err := ToObject(value)
+	if err != nil {
+		return fmt.Errorf("global %s: %w", name, err)
+	}
+
+	symbol, ok := r.symbolTable.Resolve(name)
+	if ok && symbol.Scope == compiler.BuiltinScope {
+		return fmt.Errorf("cannot assign to builtin %s", name)
+	}
+
+	if !ok {
+		symbol = r.symbolTable.Define(name)
+		r.globals = vm.GrowGlobals(r.globals, symbol.Index+1)
+	}
+
+	r.globals[symbol.Index] = obj
+
+	return nil
+}
+
+// The value of the global name, converted with FromObject. False if there's
+// no such global, or it hasn't been given a value yet.
+func (r *Runtime) GetGlobal(name string) (interface{}, bool) {
+	obj, ok := r.global(name)
+	if !ok {
+		return nil, false
+	}
+
+	return FromObject(obj), true
+}
+
+/*
+Register makes the Go function fn callable from Rafiki as the global name.
+Arguments are converted to fn's parameter types and its result back with
+ToObject. fn can return nothing, a value, an error, or a value and an
+error; a non-nil error is thrown as a RuntimeError the program can catch.
+
+	rt.Register("upper", strings.ToUpper)
+	rt.Register("fetch", func(id int) (map[string]interface{}, error) { ... })
+
+A func(args ...object.Object) object.Object is registered as it is, for
+hosts that want the raw objects.
+*/
+func (r *Runtime) Register(name string, fn interface{}) error {
+	builtin, err := wrapFunction(name, fn)
+	if err != nil {
+		return err
+	}
+
+	return r.SetGlobal(name, builtin)
+}
+
+func (r *Runtime) global(name string) (object.Object, bool) {
+	symbol, ok := r.symbolTable.Resolve(name)
+	if !ok || symbol.Scope != compiler.GlobalScope || symbol.Index >= len(r.globals) || r.globals[symbol.Index] == nil {
+		return nil, false
+	}
+
+	return r.globals[symbol.Index], true
+}
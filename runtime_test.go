@@ -0,0 +1,317 @@
+package rafiki
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"rafiki/diagnostic"
+	"rafiki/object"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	rt := New()
+
+	if err := rt.Compile("let double = fn(x) { x * 2 }; double(21)"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	result, err := rt.Run(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if result != int64(42) {
+		t.Errorf("wrong result. want=42, got=%v (%T)", result, result)
+	}
+
+	// Later programs see what earlier ones defined
+	if err := rt.Compile("double(double(1))"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if result, _ := rt.Run(context.Background()); result != int64(4) {
+		t.Errorf("wrong result. want=4, got=%v", result)
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	rt := New()
+
+	var syntaxErr *SyntaxError
+	if err := rt.Compile("let = 1;"); !errors.As(err, &syntaxErr) || len(syntaxErr.Diagnostics) != 1 {
+		t.Errorf("expected a SyntaxError. got=%v", err)
+	}
+
+	var diagErr *diagnostic.Error
+	if err := rt.Compile("nope"); !errors.As(err, &diagErr) || diagErr.Message != "undefined variable nope" {
+		t.Errorf("expected a compile error. got=%v", err)
+	}
+
+	if _, err := rt.Run(context.Background()); err == nil {
+		t.Errorf("ran a program that didn't compile")
+	}
+
+	rt.Compile(`throw "no"`)
+	var objErr *object.Error
+	if _, err := rt.Run(context.Background()); !errors.As(err, &objErr) || objErr.Message != "no" {
+		t.Errorf("expected the thrown error. got=%v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rt.Compile("1")
+	if _, err := rt.Run(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the context's error. got=%v", err)
+	}
+}
+
+func TestGlobals(t *testing.T) {
+	rt := New()
+
+	if err := rt.SetGlobal("limits", map[string]interface{}{"max": 10}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	rt.Compile(`let over = limits["max"] < 12; let names = ["a", "b"];`)
+	if _, err := rt.Run(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if over, ok := rt.GetGlobal("over"); !ok || over != true {
+		t.Errorf("wrong value for over. got=%v", over)
+	}
+
+	if names, _ := rt.GetGlobal("names"); !reflect.DeepEqual(names, []interface{}{"a", "b"}) {
+		t.Errorf("wrong value for names. got=%v", names)
+	}
+
+	// Setting an existing global changes what the next run sees
+	rt.SetGlobal("limits", map[string]int{"max": 20})
+	rt.Compile(`limits["max"]`)
+	if result, _ := rt.Run(context.Background()); result != int64(20) {
+		t.Errorf("wrong result. want=20, got=%v", result)
+	}
+
+	if _, ok := rt.GetGlobal("missing"); ok {
+		t.Errorf("found a global that doesn't exist")
+	}
+
+	if err := rt.SetGlobal("len", 1); err == nil {
+		t.Errorf("expected an error assigning to a builtin")
+	}
+
+	if err := rt.SetGlobal("point", struct{ X int }{1}); err == nil {
+		t.Errorf("expected an error converting a struct")
+	}
+}
+
+func TestManyGlobals(t *testing.T) {
+	rt := New()
+
+	// More than the VM starts with room for
+	for i := 0; i < 70000; i++ {
+		if err := rt.SetGlobal(fmt.Sprintf("host%d", i), i); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	rt.Compile(`let last = 1; last + 1`)
+	if result, err := rt.Run(context.Background()); result != int64(2) {
+		t.Fatalf("wrong result. want=2, got=%v (%v)", result, err)
+	}
+
+	if value, _ := rt.GetGlobal("host69999"); value != int64(69999) {
+		t.Errorf("wrong value for host69999. got=%v", value)
+	}
+
+	if value, _ := rt.GetGlobal("last"); value != int64(1) {
+		t.Errorf("wrong value for last. got=%v", value)
+	}
+}
+
+func TestCall(t *testing.T) {
+	rt := New()
+	rt.Compile(`
+let total = 0;
+let add = fn(n) { total += n; total };
+let fail = fn() { throw "failed" };
+`)
+	if _, err := rt.Run(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for i, expected := range []int64{5, 10} {
+		result, err := rt.Call("add", 5)
+		if err != nil || result != expected {
+			t.Errorf("call %d: wrong result. want=%d, got=%v (%v)", i, expected, result, err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		args     []interface{}
+		expected string
+	}{
+		{"missing", nil, "undefined function missing"},
+		{"total", nil, "total is not a function: INTEGER"},
+		{"add", nil, "wrong number of arguments: want=1, got=0"},
+		{"fail", nil, "failed"},
+		{"add", []interface{}{struct{}{}}, "argument 1 to add: cannot convert struct {} to a Rafiki value"},
+	}
+
+	for _, tt := range tests {
+		_, err := rt.Call(tt.name, tt.args...)
+		if err == nil || !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("%s: wrong error. want=%q, got=%v", tt.name, tt.expected, err)
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	rt := New()
+
+	logged := []string{}
+	register := map[string]interface{}{
+		"upper": strings.ToUpper,
+		"log":   func(msg string) { logged = append(logged, msg) },
+		"sum": func(xs ...float64) (total float64) {
+			for _, x := range xs {
+				total += x
+			}
+			return
+		},
+		"lookup": func(id int) (map[string]interface{}, error) {
+			if id != 1 {
+				return nil, fmt.Errorf("no user %d", id)
+			}
+			return map[string]interface{}{"name": "ada"}, nil
+		},
+		"raw": func(args ...object.Object) object.Object { return &object.Integer{Value: int64(len(args))} },
+	}
+
+	for name, fn := range register {
+		if err := rt.Register(name, fn); err != nil {
+			t.Fatalf("%s: unexpected error: %s", name, err)
+		}
+	}
+
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`upper("abc")`, "ABC"},
+		{`log("hi")`, nil},
+		{`sum(1, 2.5)`, 3.5},
+		{`lookup(1)["name"]`, "ada"},
+		{`try { lookup(2) } catch (e) { e["message"] }`, "no user 2"},
+		{`try { upper(1) } catch (e) { e["message"] }`, "argument 1 to upper: cannot use INTEGER as string"},
+		{`try { upper() } catch (e) { e["message"] }`, "wrong number of arguments to upper: want=1, got=0"},
+		{`raw(1, 2, 3)`, int64(3)},
+	}
+
+	for _, tt := range tests {
+		if err := rt.Compile(tt.input); err != nil {
+			t.Fatalf("%q: unexpected error: %s", tt.input, err)
+		}
+
+		result, err := rt.Run(context.Background())
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %s", tt.input, err)
+		}
+
+		if !reflect.DeepEqual(result, tt.expected) {
+			t.Errorf("%q: wrong result. want=%v, got=%v", tt.input, tt.expected, result)
+		}
+	}
+
+	if !reflect.DeepEqual(logged, []string{"hi"}) {
+		t.Errorf("log wasn't called. got=%v", logged)
+	}
+
+	if err := rt.Register("bad", 5); err == nil {
+		t.Errorf("expected an error registering a non-function")
+	}
+
+	if err := rt.Register("bad", func() (int, int) { return 1, 2 }); err == nil {
+		t.Errorf("expected an error registering a function with two results")
+	}
+}
+
+func TestCompileFile(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "lib.rk"), []byte("let answer = 42;"), 0o644)
+	os.WriteFile(filepath.Join(dir, "main.rk"), []byte(`(import "lib.rk").answer`), 0o644)
+
+	rt := New()
+	if err := rt.CompileFile(filepath.Join(dir, "main.rk")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if result, err := rt.Run(context.Background()); err != nil || result != int64(42) {
+		t.Errorf("wrong result. want=42, got=%v (%v)", result, err)
+	}
+}
+
+func TestNewWithBuiltins(t *testing.T) {
+	builtins := object.DefaultBuiltins()
+	builtins.Remove("puts")
+	builtins.Register("triple", func(args ...object.Object) object.Object {
+		return &object.Integer{Value: args[0].(*object.Integer).Value * 3}
+	})
+
+	rt := NewWithBuiltins(builtins)
+
+	var diagErr *diagnostic.Error
+	if err := rt.Compile(`puts("hi")`); !errors.As(err, &diagErr) || diagErr.Message != "undefined variable puts" {
+		t.Errorf("expected puts to be undefined. got=%v", err)
+	}
+
+	if err := rt.Compile("let f = fn(x) { triple(len(x)) }; f([1, 2])"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if result, err := rt.Run(context.Background()); err != nil || result != int64(6) {
+		t.Errorf("wrong result. want=6, got=%v (%v)", result, err)
+	}
+
+	if result, err := rt.Call("f", []int{1}); err != nil || result != int64(3) {
+		t.Errorf("wrong result. want=3, got=%v (%v)", result, err)
+	}
+
+	if err := rt.SetGlobal("triple", 1); err == nil {
+		t.Errorf("expected assigning to a registered builtin to fail")
+	}
+}
+
+func TestLimits(t *testing.T) {
+	rt := New()
+	rt.SetLimits(object.Limits{MaxSteps: 10000})
+
+	if err := rt.Compile("let spin = fn() { while (true) {} }; 1"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if _, err := rt.Run(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if _, err := rt.Call("spin"); !errors.Is(err, object.ErrStepLimit) {
+		t.Errorf("expected the step limit to stop spin. got=%v", err)
+	}
+
+	// Without a step limit, the context stops it
+	rt.SetLimits(object.Limits{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if _, err := rt.CallContext(ctx, "spin"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the deadline to stop spin. got=%v", err)
+	}
+}
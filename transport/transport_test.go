@@ -0,0 +1,44 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+
+	for _, msg := range []string{"first", "sécond"} {
+		if err := WriteMessage(&buf, map[string]string{"msg": msg}); err != nil {
+			t.Fatalf("write failed: %s", err)
+		}
+	}
+
+	r := bufio.NewReader(&buf)
+	for _, expected := range []string{`{"msg":"first"}`, `{"msg":"sécond"}`} {
+		data, err := ReadMessage(r)
+		if err != nil {
+			t.Fatalf("read failed: %s", err)
+		}
+
+		if string(data) != expected {
+			t.Errorf("wrong message. want=%s, got=%s", expected, data)
+		}
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	tests := []string{
+		"Content-Type: application/json\r\n\r\n{}",
+		"Content-Length: many\r\n\r\n{}",
+		"Content-Length: 10\r\n\r\n{}",
+	}
+
+	for _, input := range tests {
+		if _, err := ReadMessage(bufio.NewReader(strings.NewReader(input))); err == nil {
+			t.Errorf("%q: expected an error", input)
+		}
+	}
+}
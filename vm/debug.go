@@ -0,0 +1,312 @@
+package vm
+
+import (
+	"errors"
+	"rafiki/compiler"
+	"rafiki/object"
+	"rafiki/token"
+	"sync/atomic"
+)
+
+// Returned by run when the debugger's hook pauses it
+var errPaused = errors.New("paused")
+
+// Why a Debugger handed control back
+type StopReason int
+
+const (
+	StopBreakpoint StopReason = iota // Reached a line with a breakpoint
+	StopStep                         // Finished a step, step over or step out
+	StopExited                       // The program ran to completion
+	StopError                        // The program failed, see Err
+	StopPause                        // Pause was called while it ran
+)
+
+func (r StopReason) String() string {
+	switch r {
+	case StopBreakpoint:
+		return "breakpoint"
+	case StopStep:
+		return "step"
+	case StopExited:
+		return "exited"
+	case StopPause:
+		return "pause"
+	default:
+		return "error"
+	}
+}
+
+type Breakpoint struct {
+	File string
+	Line int
+}
+
+// A named value in a frame or the globals
+type Variable struct {
+	Name  string
+	Value object.Object
+}
+
+// A call on the stack, as the debugger shows it
+type DebugFrame struct {
+	Function string
+	Pos      token.Position // What the frame is executing: the next instruction, or the call it's waiting on
+	Locals   []Variable
+	Free     []Variable
+}
+
+type stepMode int
+
+const (
+	modeContinue stepMode = iota
+	modeStep
+	modeStepOver
+	modeStepOut
+)
+
+// The line a frame was last on, to tell when it moves to a new one
+type frameLine struct {
+	frame *Frame
+	ip    int
+	pos   token.Position
+}
+
+/*
+Debugger runs a program on the VM a source line at a time. It stops before
+the first instruction of a line, which is a line the frame wasn't on a moment
+ago, or one it has jumped back to, as a loop does. Nothing runs until the
+first Continue or Step.
+*/
+type Debugger struct {
+	vm          *VM
+	bytecode    *compiler.Bytecode
+	breakpoints map[Breakpoint]bool
+
+	mode       stepMode
+	startDepth int
+	lines      []frameLine // By frame depth
+	stop       StopReason  // Why the hook last paused the VM
+	pause      atomic.Bool
+
+	done bool
+	err  error
+}
+
+func NewDebugger(bytecode *compiler.Bytecode) *Debugger {
+	d := &Debugger{
+		vm:          NewVm(bytecode),
+		bytecode:    bytecode,
+		breakpoints: map[Breakpoint]bool{},
+	}
+
+	d.vm.hook = d.hook
+
+	return d
+}
+
+// Give the program the functions in registry, as VM.SetBuiltins does
+func (d *Debugger) SetBuiltins(registry *object.BuiltinRegistry) {
+	d.vm.SetBuiltins(registry)
+}
+
+func (d *Debugger) SetBreakpoint(file string, line int) {
+	d.breakpoints[Breakpoint{File: file, Line: line}] = true
+}
+
+func (d *Debugger) ClearBreakpoint(file string, line int) {
+	delete(d.breakpoints, Breakpoint{File: file, Line: line})
+}
+
+func (d *Debugger) ClearBreakpoints() {
+	d.breakpoints = map[Breakpoint]bool{}
+}
+
+// Run until a breakpoint, or the end
+func (d *Debugger) Continue() StopReason {
+	return d.resume(modeContinue)
+}
+
+// Run to the next line, entering any function called on the way
+func (d *Debugger) Step() StopReason {
+	return d.resume(modeStep)
+}
+
+// Run to the next line in this function, or its caller if it returns
+func (d *Debugger) StepOver() StopReason {
+	return d.resume(modeStepOver)
+}
+
+// Run until this function returns to its caller
+func (d *Debugger) StepOut() StopReason {
+	return d.resume(modeStepOut)
+}
+
+func (d *Debugger) resume(mode stepMode) StopReason {
+	if d.done {
+		return d.finished()
+	}
+
+	d.mode = mode
+	d.startDepth = d.vm.framesIndex
+
+	err := d.vm.Run()
+	if err == errPaused {
+		return d.stop
+	}
+
+	d.done = true
+	d.err = err
+
+	return d.finished()
+}
+
+func (d *Debugger) finished() StopReason {
+	if d.err != nil {
+		return StopError
+	}
+
+	return StopExited
+}
+
+func (d *Debugger) breakpointAt(pos token.Position) Breakpoint {
+	return Breakpoint{File: pos.Filename, Line: pos.Line}
+}
+
+/*
+Pause stops the program before its next instruction, wherever that is. It's
+the one method that's safe to call from another goroutine while Continue or
+a step is running, which then returns StopPause.
+*/
+func (d *Debugger) Pause() {
+	d.pause.Store(true)
+}
+
+// Decide whether to pause before the next instruction
+func (d *Debugger) hook() bool {
+	depth := d.vm.framesIndex
+	frame := d.vm.currentFrame()
+	ip := frame.ip + 1
+
+	pos := frame.cl.Fn.SourceMap.Lookup(ip)
+	if !pos.IsValid() {
+		return false
+	}
+
+	if d.pause.Swap(false) {
+		d.stop = StopPause
+		return true
+	}
+
+	// Forget frames that have returned, and make room for new ones
+	if len(d.lines) > depth {
+		d.lines = d.lines[:depth]
+	}
+	for len(d.lines) < depth {
+		d.lines = append(d.lines, frameLine{})
+	}
+
+	last := d.lines[depth-1]
+	d.lines[depth-1] = frameLine{frame: frame, ip: ip, pos: pos}
+
+	newLine := last.frame != frame ||
+		last.pos.Line != pos.Line ||
+		last.pos.Filename != pos.Filename ||
+		ip < last.ip
+
+	if !newLine {
+		return false
+	}
+
+	switch {
+	case d.mode == modeStep,
+		d.mode == modeStepOver && depth <= d.startDepth,
+		d.mode == modeStepOut && depth < d.startDepth:
+		d.stop = StopStep
+	case d.breakpoints[d.breakpointAt(pos)]:
+		d.stop = StopBreakpoint
+	default:
+		return false
+	}
+
+	return true
+}
+
+// Whether the program has finished, successfully or not
+func (d *Debugger) Done() bool {
+	return d.done
+}
+
+// The runtime error the program failed with, if it did
+func (d *Debugger) Err() error {
+	return d.err
+}
+
+// Where the program is paused: the next instruction to run
+func (d *Debugger) Position() token.Position {
+	frame := d.vm.currentFrame()
+	return frame.cl.Fn.SourceMap.Lookup(frame.ip + 1)
+}
+
+// The calls on the stack, innermost first
+func (d *Debugger) Frames() []DebugFrame {
+	frames := make([]DebugFrame, 0, d.vm.framesIndex)
+
+	for i := d.vm.framesIndex - 1; i >= 0; i-- {
+		frame := d.vm.frames[i]
+		fn := frame.cl.Fn
+
+		info := DebugFrame{Function: fn.Name}
+		switch {
+		case i == 0:
+			info.Function = "<main>"
+		case info.Function == "":
+			info.Function = "<anonymous>"
+		}
+
+		// Callers are part way through their OpCall
+		if i == d.vm.framesIndex-1 {
+			info.Pos = fn.SourceMap.Lookup(frame.ip + 1)
+		} else {
+			info.Pos = fn.SourceMap.Lookup(frame.ip)
+		}
+
+		if i > 0 {
+			locals := d.vm.stack[frame.basePointer : frame.basePointer+fn.NumLocals]
+			info.Locals = variables(fn.LocalNames, locals)
+			info.Free = variables(fn.FreeNames, frame.cl.Free)
+		}
+
+		frames = append(frames, info)
+	}
+
+	return frames
+}
+
+// The globals that have been given a value
+func (d *Debugger) Globals() []Variable {
+	return variables(d.bytecode.GlobalNames, d.vm.globals)
+}
+
+// The values on the VM's stack, bottom first. This includes every frame's locals.
+func (d *Debugger) Stack() []object.Object {
+	stack := make([]object.Object, d.vm.sp)
+	copy(stack, d.vm.stack[:d.vm.sp])
+
+	return stack
+}
+
+// Pair names with the slots they name, skipping slots not assigned yet
+func variables(names []string, slots []object.Object) []Variable {
+	vars := []Variable{}
+
+	for i, name := range names {
+		if i >= len(slots) || slots[i] == nil || name == "" {
+			continue
+		}
+
+		vars = append(vars, Variable{Name: name, Value: deref(slots[i])})
+	}
+
+	return vars
+}
@@ -0,0 +1,173 @@
+package vm
+
+import (
+	"rafiki/compiler"
+	"testing"
+)
+
+const debugProgram = `let add = fn(a, b) {
+  let sum = a + b;
+  sum
+};
+let x = 1;
+let y = add(x, 2);
+let z = y * 2;
+`
+
+func newTestDebugger(t *testing.T, input string) *Debugger {
+	t.Helper()
+
+	comp := compiler.NewCompiler()
+	if err := comp.Compile(parse(t, input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	return NewDebugger(comp.Bytecode())
+}
+
+func TestDebuggerStepping(t *testing.T) {
+	tests := []struct {
+		name     string
+		action   func(d *Debugger) StopReason
+		expected []int // Line after each action
+	}{
+		{"step", (*Debugger).Step, []int{1, 5, 6, 2, 3, 7}},
+		{"step over", (*Debugger).StepOver, []int{1, 5, 6, 7}},
+	}
+
+	for _, tt := range tests {
+		d := newTestDebugger(t, debugProgram)
+
+		for i, line := range tt.expected {
+			if reason := tt.action(d); reason != StopStep {
+				t.Fatalf("%s %d: wrong stop reason. want=%s, got=%s", tt.name, i, StopStep, reason)
+			}
+
+			if pos := d.Position(); pos.Line != line {
+				t.Errorf("%s %d: wrong line. want=%d, got=%d", tt.name, i, line, pos.Line)
+			}
+		}
+
+		if reason := tt.action(d); reason != StopExited {
+			t.Errorf("%s: program did not exit. got=%s", tt.name, reason)
+		}
+	}
+}
+
+func TestDebuggerBreakpoints(t *testing.T) {
+	d := newTestDebugger(t, `let f = fn(n) {
+  n * 2
+};
+f(1);
+f(2);
+`)
+	d.SetBreakpoint("", 2)
+
+	for _, n := range []int64{1, 2} {
+		if reason := d.Continue(); reason != StopBreakpoint {
+			t.Fatalf("wrong stop reason. want=%s, got=%s", StopBreakpoint, reason)
+		}
+
+		frames := d.Frames()
+		if len(frames) != 2 || frames[0].Function != "f" || frames[1].Function != "<main>" {
+			t.Fatalf("wrong frames. got=%+v", frames)
+		}
+
+		locals := frames[0].Locals
+		if len(locals) != 1 || locals[0].Name != "n" {
+			t.Fatalf("wrong locals. got=%+v", locals)
+		}
+
+		if err := testIntegerObject(n, locals[0].Value); err != nil {
+			t.Errorf("wrong value for n: %s", err)
+		}
+	}
+
+	if reason := d.Continue(); reason != StopExited {
+		t.Errorf("program did not exit. got=%s", reason)
+	}
+}
+
+func TestDebuggerStepOut(t *testing.T) {
+	d := newTestDebugger(t, debugProgram)
+	d.SetBreakpoint("", 2)
+
+	if reason := d.Continue(); reason != StopBreakpoint {
+		t.Fatalf("wrong stop reason. want=%s, got=%s", StopBreakpoint, reason)
+	}
+
+	if reason := d.StepOut(); reason != StopStep {
+		t.Fatalf("wrong stop reason. want=%s, got=%s", StopStep, reason)
+	}
+
+	if pos := d.Position(); pos.Line != 7 {
+		t.Errorf("wrong line. want=7, got=%d", pos.Line)
+	}
+
+	globals := d.Globals()
+	names := []string{"add", "x", "y"}
+	if len(globals) != len(names) {
+		t.Fatalf("wrong number of globals. want=%d, got=%d (%+v)", len(names), len(globals), globals)
+	}
+
+	for i, name := range names {
+		if globals[i].Name != name {
+			t.Errorf("wrong global %d. want=%s, got=%s", i, name, globals[i].Name)
+		}
+	}
+
+	if err := testIntegerObject(3, globals[2].Value); err != nil {
+		t.Errorf("wrong value for y: %s", err)
+	}
+}
+
+func TestDebuggerFreeVariables(t *testing.T) {
+	d := newTestDebugger(t, `let outer = fn(a) {
+  let inner = fn() {
+    a + 1
+  };
+  let b = inner(); b
+};
+outer(41);
+`)
+	d.SetBreakpoint("", 3)
+
+	if reason := d.Continue(); reason != StopBreakpoint {
+		t.Fatalf("wrong stop reason. want=%s, got=%s", StopBreakpoint, reason)
+	}
+
+	frames := d.Frames()
+	if len(frames) != 3 {
+		t.Fatalf("wrong number of frames. want=3, got=%d", len(frames))
+	}
+
+	free := frames[0].Free
+	if len(free) != 1 || free[0].Name != "a" {
+		t.Fatalf("wrong free variables. got=%+v", free)
+	}
+
+	if err := testIntegerObject(41, free[0].Value); err != nil {
+		t.Errorf("wrong value for a: %s", err)
+	}
+
+	// The caller's position is the call it's waiting on
+	if frames[1].Function != "outer" || frames[1].Pos.Line != 5 {
+		t.Errorf("wrong caller frame. got=%+v", frames[1])
+	}
+}
+
+func TestDebuggerError(t *testing.T) {
+	d := newTestDebugger(t, "let x = 1;\nx / 0;\n")
+
+	if reason := d.Continue(); reason != StopError {
+		t.Fatalf("wrong stop reason. want=%s, got=%s", StopError, reason)
+	}
+
+	if d.Err() == nil || d.Err().Error() != "2:3: division by zero" {
+		t.Errorf("wrong error. got=%v", d.Err())
+	}
+
+	if !d.Done() {
+		t.Errorf("debugger is not done after an error")
+	}
+}
@@ -1,15 +1,29 @@
 package vm
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math"
 	"rafiki/code"
 	"rafiki/compiler"
 	"rafiki/object"
 )
 
+// Room for globals a VM starts with, unless its program defines more
 const GlobalsSize = 65536
+
+// The stack starts with room for StackSize values, and doubles whenever it
+// fills up, to at most MaxStackSize
 const StackSize = 2048
-const MaxFrames = 1024
+const MaxStackSize = 1 << 20
+
+// How deeply calls can nest before the VM reports a stack overflow, the same
+// as in the evaluator. The frames that takes include main's.
+const MaxCallDepth = 10000
+const MaxFrames = MaxCallDepth + 1
+
+var errStackOverflow = errors.New("stack overflow")
 
 var True = &object.Boolean{Value: true}
 var False = &object.Boolean{Value: false}
@@ -24,36 +38,132 @@ type VM struct {
 
 	frames      []*Frame
 	framesIndex int
+
+	handlers []handler // Innermost last
+
+	builtinNames []string          // The layout the code was compiled against
+	builtins     []*object.Builtin // Indexed like OpGetBuiltin's operand; nil where the registry has no such builtin
+
+	limiter *object.Limiter // Nil unless running with limits
+
+	// Called before each instruction while a Debugger is attached. Returning
+	// true pauses the VM there.
+	hook func() bool
+}
+
+// An active try: where its catch block starts, and the frame and stack to
+// unwind to before running it
+type handler struct {
+	catchPos    int
+	framesIndex int
+	sp          int
 }
 
 func NewVm(bytecode *compiler.Bytecode) *VM {
-	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions}
+	mainFn := &object.CompiledFunction{
+		Instructions: bytecode.Instructions,
+		SourceMap:    bytecode.SourceMap,
+	}
 	mainClosure := &object.Closure{Fn: mainFn}
 	mainFrame := NewFrame(mainClosure, 0)
 
-	frames := make([]*Frame, MaxFrames)
+	frames := make([]*Frame, 1, 64)
 	frames[0] = mainFrame
 
-	return &VM{
+	globalsSize := GlobalsSize
+	if len(bytecode.GlobalNames) > globalsSize {
+		globalsSize = len(bytecode.GlobalNames)
+	}
+
+	vm := &VM{
 		constants: bytecode.Constants,
-		globals:   make([]object.Object, GlobalsSize),
+		globals:   make([]object.Object, globalsSize),
 
 		stack: make([]object.Object, StackSize),
 		sp:    0,
 
 		frames:      frames,
 		framesIndex: 1,
+
+		builtinNames: bytecode.Builtins,
 	}
+
+	vm.SetBuiltins(object.DefaultBuiltins())
+
+	return vm
 }
 
+// A VM keeping its globals in s, so they outlive it. The store must have
+// room for every global the program defines, see GrowGlobals, or the VM
+// keeps them in a copy instead.
 func NewVmWithGlobalsStore(bytecode *compiler.Bytecode, s []object.Object) *VM {
 	vm := NewVm(bytecode)
 
-	vm.globals = s
+	vm.globals = GrowGlobals(s, len(bytecode.GlobalNames))
 
 	return vm
 }
 
+// A globals store with room for at least n globals, holding the values in
+// globals. It's globals itself if that's big enough already.
+func GrowGlobals(globals []object.Object, n int) []object.Object {
+	if n <= len(globals) {
+		return globals
+	}
+
+	size := 2 * len(globals)
+	if size < n {
+		size = n
+	}
+
+	grown := make([]object.Object, size)
+	copy(grown, globals)
+
+	return grown
+}
+
+// A VM that calls fn, a closure or builtin, with args, sharing the constants
+// and globals of a program that has already run. That's how a host calls
+// back into a program. After Run, LastPoppedStackElem is the result.
+func NewVmForCall(constants []object.Object, globals []object.Object, fn object.Object, args ...object.Object) (*VM, error) {
+	call, err := code.MakeChecked(code.OpCall, len(args))
+	if err != nil {
+		return nil, fmt.Errorf("too many arguments: %d", len(args))
+	}
+
+	instructions := append(call, code.Make(code.OpPop)...)
+	vm := NewVmWithGlobalsStore(&compiler.Bytecode{Instructions: instructions, Constants: constants}, globals)
+
+	if err := vm.growStack(1 + len(args)); err != nil {
+		return nil, err
+	}
+
+	vm.stack[0] = fn
+	copy(vm.stack[1:], args)
+	vm.sp = 1 + len(args)
+
+	return vm, nil
+}
+
+// Give the code the functions in registry as its builtins. Each is found by
+// the name the code was compiled against, so code compiled with a different
+// layout still calls the right ones. Calling a builtin the registry lacks is
+// a runtime error.
+func (vm *VM) SetBuiltins(registry *object.BuiltinRegistry) {
+	if vm.builtinNames == nil {
+		vm.builtins = make([]*object.Builtin, registry.Len())
+		for i := range vm.builtins {
+			vm.builtins[i] = registry.At(i)
+		}
+		return
+	}
+
+	vm.builtins = make([]*object.Builtin, len(vm.builtinNames))
+	for i, name := range vm.builtinNames {
+		vm.builtins[i], _ = registry.Lookup(name)
+	}
+}
+
 type Frame struct {
 	cl          *object.Closure
 	ip          int
@@ -74,13 +184,43 @@ func (f *Frame) Instructions() code.Instructions {
 	return f.cl.Fn.Instructions
 }
 
+// Read the current instruction's next operand, width bytes wide or wider
+// after an OpWide, and move past it
+func (f *Frame) readOperand(width int, wide bool) int {
+	if wide && width < 4 {
+		width *= 2
+	}
+
+	ins := f.Instructions()[f.ip+1:]
+	f.ip += width
+
+	switch width {
+	case 1:
+		return int(code.ReadUint8(ins))
+	case 2:
+		return int(code.ReadUint16(ins))
+	default:
+		return int(code.ReadUint32(ins))
+	}
+}
+
 func (vm *VM) currentFrame() *Frame {
 	return vm.frames[vm.framesIndex-1]
 }
 
-func (vm *VM) pushFrame(f *Frame) {
-	vm.frames[vm.framesIndex] = f
+func (vm *VM) pushFrame(f *Frame) error {
+	if vm.framesIndex >= MaxFrames {
+		return errStackOverflow
+	}
+
+	if vm.framesIndex == len(vm.frames) {
+		vm.frames = append(vm.frames, f)
+	} else {
+		vm.frames[vm.framesIndex] = f
+	}
 	vm.framesIndex++
+
+	return nil
 }
 
 func (vm *VM) popFrame() *Frame {
@@ -88,25 +228,142 @@ func (vm *VM) popFrame() *Frame {
 	return vm.frames[vm.framesIndex]
 }
 
+// Runtime errors come back as *object.Error, pointing at the failing instruction's source,
+// unless a try catches them
 func (vm *VM) Run() error {
-	var ip int
+	for {
+		err := vm.run()
+		if err == nil || err == errPaused {
+			return err
+		}
+
+		errObj := vm.runtimeError(err)
+		if !errObj.Catchable() || !vm.catch(errObj) {
+			return errObj
+		}
+	}
+}
+
+/*
+RunWithLimits runs the program like Run, but stops it once ctx is done or it
+goes past limits, failing with an *object.Error of the matching kind. Those
+errors unwrap to ctx's error or one of object.ErrStepLimit,
+ErrAllocationLimit and ErrRecursionLimit.
+*/
+func (vm *VM) RunWithLimits(ctx context.Context, limits object.Limits) error {
+	vm.limiter = object.NewLimiter(ctx, limits)
+	defer func() { vm.limiter = nil }()
+
+	if err := vm.limiter.Canceled(); err != nil {
+		return err
+	}
+
+	return vm.Run()
+}
+
+// Fill in where err was raised. A rethrown error keeps where it was first raised.
+func (vm *VM) runtimeError(err error) *object.Error {
+	errObj, ok := err.(*object.Error)
+	if !ok {
+		errObj = &object.Error{Message: err.Error()}
+	}
+
+	if errObj.Kind == "" {
+		errObj.Kind = object.RuntimeError
+	}
+
+	if !errObj.Pos.IsValid() {
+		frame := vm.currentFrame()
+		errObj.Pos = frame.cl.Fn.SourceMap.Lookup(frame.ip)
+	}
+
+	if errObj.Stack == nil {
+		errObj.Stack = vm.stackTrace()
+	}
+
+	return errObj
+}
+
+// The frames on the call stack, innermost first
+func (vm *VM) stackTrace() []object.StackFrame {
+	stack := make([]object.StackFrame, 0, vm.framesIndex)
+
+	for i := vm.framesIndex - 1; i >= 0; i-- {
+		frame := vm.frames[i]
+
+		name := frame.cl.Fn.Name
+		switch {
+		case i == 0:
+			name = "<main>"
+		case name == "":
+			name = "<anonymous>"
+		}
+
+		pos := frame.cl.Fn.SourceMap.Lookup(frame.ip)
+		stack = append(stack, object.StackFrame{Function: name, Pos: pos})
+	}
+
+	return stack
+}
+
+// Unwind to the innermost handler and continue at its catch block, with err
+// on the stack. Returns false if there's no handler.
+func (vm *VM) catch(err *object.Error) bool {
+	if len(vm.handlers) == 0 {
+		return false
+	}
+
+	h := vm.handlers[len(vm.handlers)-1]
+	vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+	vm.framesIndex = h.framesIndex
+	vm.sp = h.sp
+	vm.currentFrame().ip = h.catchPos - 1
+
+	vm.stack[vm.sp] = err
+	vm.sp++
+
+	return true
+}
+
+// Drop the handlers installed by frames that have returned
+func (vm *VM) dropHandlers() {
+	for len(vm.handlers) > 0 && vm.handlers[len(vm.handlers)-1].framesIndex > vm.framesIndex {
+		vm.handlers = vm.handlers[:len(vm.handlers)-1]
+	}
+}
+
+func (vm *VM) run() error {
+	var frame *Frame
 	var ins code.Instructions
 	var op code.Opcode
 
 	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+		if vm.hook != nil && vm.hook() {
+			return errPaused
+		}
+
 		vm.currentFrame().ip++
 
-		ip = vm.currentFrame().ip
-		ins = vm.currentFrame().Instructions()
-		op = code.Opcode(ins[ip])
+		frame = vm.currentFrame()
+		ins = frame.Instructions()
+		op = code.Opcode(ins[frame.ip])
+
+		wide := op == code.OpWide
+		if wide {
+			frame.ip++
+			op = code.Opcode(ins[frame.ip])
+		}
+
+		if vm.limiter != nil {
+			if err := vm.limiter.Step(); err != nil {
+				return err
+			}
+		}
 
 		switch op {
 		case code.OpConstant:
-			// I still don't quite understand how this is working
-			// before the code was just code.ReadUint16(vm.instructions[ip+1:])
-			// If we already know the size, then why not get subslice of exactly that size?
-			constIndex := code.ReadUint16(ins[ip+1 : ip+3])
-			vm.currentFrame().ip += 2
+			constIndex := frame.readOperand(2, wide)
 
 			err := vm.push(vm.constants[constIndex])
 
@@ -147,31 +404,67 @@ func (vm *VM) Run() error {
 				return err
 			}
 
-		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan:
+		case code.OpSetIndex:
+			value := vm.pop()
+			index := vm.pop()
+			left := vm.pop()
+
+			err := vm.executeSetIndex(left, index, value)
+			if err != nil {
+				return err
+			}
+
+		case code.OpDupTwo:
+			err := vm.push(vm.stack[vm.sp-2])
+			if err != nil {
+				return err
+			}
+
+			err = vm.push(vm.stack[vm.sp-2])
+			if err != nil {
+				return err
+			}
+
+		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpGreaterThanOrEqual,
+			code.OpLessThan, code.OpLessThanOrEqual:
 			err := vm.executeComparison(op)
 			if err != nil {
 				return err
 			}
 
-		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv:
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod:
 			err := vm.executeBinaryOperation(op)
 			if err != nil {
 				return err
 			}
 
 		case code.OpJump:
-			pos := int(code.ReadUint16(ins[ip+1:]))
-			vm.currentFrame().ip = pos - 1
+			pos := frame.readOperand(2, wide)
+			frame.ip = pos - 1
 
 		case code.OpJumpNotTruthy:
-			pos := int(code.ReadUint16(ins[ip+1:]))
-			vm.currentFrame().ip += 2
+			pos := frame.readOperand(2, wide)
 
 			condition := vm.pop()
 			if !isTruthy(condition) {
-				vm.currentFrame().ip = pos - 1
+				frame.ip = pos - 1
 			}
 
+		case code.OpTry:
+			catchPos := frame.readOperand(2, wide)
+
+			vm.handlers = append(vm.handlers, handler{
+				catchPos:    catchPos,
+				framesIndex: vm.framesIndex,
+				sp:          vm.sp,
+			})
+
+		case code.OpEndTry:
+			vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+		case code.OpThrow:
+			return object.ThrownValue(vm.pop())
+
 		case code.OpNull:
 			err := vm.push(Null)
 			if err != nil {
@@ -179,8 +472,11 @@ func (vm *VM) Run() error {
 			}
 
 		case code.OpHash:
-			numElements := int(code.ReadUint16(ins[ip+1:]))
-			vm.currentFrame().ip += 2
+			numElements := frame.readOperand(2, wide)
+
+			if err := vm.limiter.Allocate(numElements / 2); err != nil {
+				return err
+			}
 
 			hash, err := vm.buildHash(vm.sp-numElements, vm.sp)
 			if err != nil {
@@ -194,14 +490,12 @@ func (vm *VM) Run() error {
 			}
 
 		case code.OpSetGlobal:
-			globalIndex := code.ReadUint16(ins[ip+1:])
-			vm.currentFrame().ip += 2
+			globalIndex := frame.readOperand(2, wide)
 
 			vm.globals[globalIndex] = vm.pop()
 
 		case code.OpGetGlobal:
-			globalIndex := code.ReadUint16(ins[ip+1:])
-			vm.currentFrame().ip += 2
+			globalIndex := frame.readOperand(2, wide)
 
 			err := vm.push(vm.globals[globalIndex])
 			if err != nil {
@@ -209,8 +503,26 @@ func (vm *VM) Run() error {
 			}
 
 		case code.OpGetFree:
-			freeIndex := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
+			freeIndex := frame.readOperand(1, wide)
+
+			currentClosure := vm.currentFrame().cl
+			err := vm.push(deref(currentClosure.Free[freeIndex]))
+			if err != nil {
+				return err
+			}
+
+		case code.OpSetFree:
+			freeIndex := frame.readOperand(1, wide)
+
+			free := vm.currentFrame().cl.Free
+			if cell, ok := free[freeIndex].(*object.Cell); ok {
+				cell.Value = vm.pop()
+			} else {
+				free[freeIndex] = vm.pop()
+			}
+
+		case code.OpGetFreeCell:
+			freeIndex := frame.readOperand(1, wide)
 
 			currentClosure := vm.currentFrame().cl
 			err := vm.push(currentClosure.Free[freeIndex])
@@ -219,8 +531,11 @@ func (vm *VM) Run() error {
 			}
 
 		case code.OpArray:
-			numElements := int(code.ReadUint16(ins[ip+1:]))
-			vm.currentFrame().ip += 2
+			numElements := frame.readOperand(2, wide)
+
+			if err := vm.limiter.Allocate(numElements); err != nil {
+				return err
+			}
 
 			array := vm.buildArray(vm.sp-numElements, vm.sp)
 			vm.sp = vm.sp - numElements
@@ -241,19 +556,27 @@ func (vm *VM) Run() error {
 			}
 
 		case code.OpCall:
-			numArgs := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
+			numArgs := frame.readOperand(1, wide)
 
 			err := vm.executeCall(int(numArgs))
 			if err != nil {
 				return err
 			}
 
+		case code.OpTailCall:
+			numArgs := frame.readOperand(1, wide)
+
+			err := vm.executeTailCall(int(numArgs))
+			if err != nil {
+				return err
+			}
+
 		case code.OpReturnValue:
 			returnValue := vm.pop()
 
 			frame := vm.popFrame()
 			vm.sp = frame.basePointer - 1
+			vm.dropHandlers()
 
 			err := vm.push(returnValue)
 			if err != nil {
@@ -263,6 +586,7 @@ func (vm *VM) Run() error {
 		case code.OpReturn:
 			frame := vm.popFrame()
 			vm.sp = frame.basePointer - 1
+			vm.dropHandlers()
 
 			err := vm.push(Null)
 			if err != nil {
@@ -270,45 +594,83 @@ func (vm *VM) Run() error {
 			}
 
 		case code.OpSetLocal:
-			localIndex := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
+			localIndex := frame.readOperand(1, wide)
 
 			frame := vm.currentFrame()
+			slot := &vm.stack[frame.basePointer+int(localIndex)]
 
-			vm.stack[frame.basePointer+int(localIndex)] = vm.pop()
+			// Once a closure has captured the local, write through its cell
+			if cell, ok := (*slot).(*object.Cell); ok {
+				cell.Value = vm.pop()
+			} else {
+				*slot = vm.pop()
+			}
 
 		case code.OpGetLocal:
-			localIndex := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
+			localIndex := frame.readOperand(1, wide)
 
 			frame := vm.currentFrame()
 
-			err := vm.push(vm.stack[frame.basePointer+int(localIndex)])
+			err := vm.push(deref(vm.stack[frame.basePointer+int(localIndex)]))
 			if err != nil {
 				return err
 			}
 
-		case code.OpGetBuiltin:
-			builtinIndex := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
+		case code.OpGetLocalCell:
+			localIndex := frame.readOperand(1, wide)
+
+			frame := vm.currentFrame()
+			slot := &vm.stack[frame.basePointer+int(localIndex)]
 
-			definition := object.Builtins[builtinIndex]
+			cell, ok := (*slot).(*object.Cell)
+			if !ok {
+				cell = &object.Cell{Value: *slot}
+				*slot = cell
+			}
 
-			err := vm.push(definition.Builtin)
+			err := vm.push(cell)
+			if err != nil {
+				return err
+			}
+
+		case code.OpGetBuiltin:
+			builtinIndex := frame.readOperand(1, wide)
+
+			err := vm.pushBuiltin(int(builtinIndex))
 			if err != nil {
 				return err
 			}
 
 		case code.OpClosure:
-			constIndex := code.ReadUint16(ins[ip+1:])
-			numFree := code.ReadUint8(ins[ip+3:])
-			vm.currentFrame().ip += 3
+			constIndex := frame.readOperand(2, wide)
+			numFree := frame.readOperand(1, wide)
 
 			err := vm.pushClosure(int(constIndex), int(numFree))
 			if err != nil {
 				return err
 			}
 
+		case code.OpImport:
+			globalIndex := frame.readOperand(2, wide)
+			constIndex := frame.readOperand(2, wide)
+
+			err := vm.executeImport(int(globalIndex), int(constIndex))
+			if err != nil {
+				return err
+			}
+
+		case code.OpModule:
+			constIndex := frame.readOperand(2, wide)
+			numExports := frame.readOperand(2, wide)
+
+			module := vm.buildModule(int(constIndex), vm.sp-2*numExports, vm.sp)
+			vm.sp = vm.sp - 2*numExports
+
+			err := vm.push(module)
+			if err != nil {
+				return err
+			}
+
 		}
 	}
 
@@ -328,8 +690,10 @@ func (vm *VM) LastPoppedStackElem() object.Object {
 }
 
 func (vm *VM) push(o object.Object) error {
-	if vm.sp >= StackSize {
-		return fmt.Errorf("stack overflow")
+	if vm.sp >= len(vm.stack) {
+		if err := vm.growStack(vm.sp + 1); err != nil {
+			return err
+		}
 	}
 
 	vm.stack[vm.sp] = o
@@ -338,6 +702,31 @@ func (vm *VM) push(o object.Object) error {
 	return nil
 }
 
+// Make room for size values on the stack
+func (vm *VM) growStack(size int) error {
+	if size <= len(vm.stack) {
+		return nil
+	}
+
+	if size > MaxStackSize {
+		return errStackOverflow
+	}
+
+	newSize := len(vm.stack) * 2
+	for newSize < size {
+		newSize *= 2
+	}
+	if newSize > MaxStackSize {
+		newSize = MaxStackSize
+	}
+
+	stack := make([]object.Object, newSize)
+	copy(stack, vm.stack)
+	vm.stack = stack
+
+	return nil
+}
+
 func (vm *VM) pop() object.Object {
 	o := vm.stack[vm.sp-1]
 
@@ -358,15 +747,43 @@ func (vm *VM) executeBinaryOperation(op code.Opcode) error {
 	case leftType == object.INTEGER_OBJ && rightType == object.INTEGER_OBJ:
 		return vm.executeBinaryIntegerOperation(op, left, right)
 
+	// Mixing an integer with a float promotes the integer
+	case isNumber(left) && isNumber(right):
+		return vm.executeBinaryFloatOperation(op, left, right)
+
 	case leftType == object.STRING_OBJ && rightType == object.STRING_OBJ:
 		return vm.executeBinaryStringOperation(op, left, right)
 
 	default:
-		return fmt.Errorf("unsupported types for binary operation: %s %s",
-			leftType, rightType)
+		return operatorError(op, left, right)
 	}
 }
 
+// The operator each binary instruction is compiled from
+var operators = map[code.Opcode]string{
+	code.OpAdd:                "+",
+	code.OpSub:                "-",
+	code.OpMul:                "*",
+	code.OpDiv:                "/",
+	code.OpMod:                "%",
+	code.OpEqual:              "==",
+	code.OpNotEqual:           "!=",
+	code.OpGreaterThan:        ">",
+	code.OpGreaterThanOrEqual: ">=",
+	code.OpLessThan:           "<",
+	code.OpLessThanOrEqual:    "<=",
+}
+
+// The error for operands a binary instruction can't work with, worded as the
+// evaluator words it
+func operatorError(op code.Opcode, left, right object.Object) error {
+	if left.Type() != right.Type() {
+		return fmt.Errorf("type mismatch: %s %s %s", left.Type(), operators[op], right.Type())
+	}
+
+	return fmt.Errorf("unknown operator: %s %s %s", left.Type(), operators[op], right.Type())
+}
+
 func (vm *VM) executeBinaryIntegerOperation(
 	op code.Opcode,
 	left, right object.Object,
@@ -388,8 +805,17 @@ func (vm *VM) executeBinaryIntegerOperation(
 		result = leftValue * rightValue
 
 	case code.OpDiv:
+		if rightValue == 0 {
+			return fmt.Errorf("division by zero")
+		}
 		result = leftValue / rightValue
 
+	case code.OpMod:
+		if rightValue == 0 {
+			return fmt.Errorf("division by zero")
+		}
+		result = leftValue % rightValue
+
 	default:
 		return fmt.Errorf("unknown integer operator: %d", op)
 	}
@@ -397,17 +823,73 @@ func (vm *VM) executeBinaryIntegerOperation(
 	return vm.push(&object.Integer{Value: result})
 }
 
+func (vm *VM) executeBinaryFloatOperation(
+	op code.Opcode,
+	left, right object.Object,
+) error {
+	leftValue := toFloat(left)
+	rightValue := toFloat(right)
+
+	var result float64
+
+	switch op {
+
+	case code.OpAdd:
+		result = leftValue + rightValue
+
+	case code.OpSub:
+		result = leftValue - rightValue
+
+	case code.OpMul:
+		result = leftValue * rightValue
+
+	case code.OpDiv:
+		if rightValue == 0 {
+			return fmt.Errorf("division by zero")
+		}
+		result = leftValue / rightValue
+
+	case code.OpMod:
+		if rightValue == 0 {
+			return fmt.Errorf("division by zero")
+		}
+		result = math.Mod(leftValue, rightValue)
+
+	default:
+		return fmt.Errorf("unknown float operator: %d", op)
+	}
+
+	return vm.push(&object.Float{Value: result})
+}
+
+func isNumber(obj object.Object) bool {
+	return obj.Type() == object.INTEGER_OBJ || obj.Type() == object.FLOAT_OBJ
+}
+
+// The value of an integer or float as a float64
+func toFloat(obj object.Object) float64 {
+	if i, ok := obj.(*object.Integer); ok {
+		return float64(i.Value)
+	}
+
+	return obj.(*object.Float).Value
+}
+
 func (vm *VM) executeBinaryStringOperation(
 	op code.Opcode,
 	left, right object.Object,
 ) error {
 	if op != code.OpAdd {
-		return fmt.Errorf("unknown string operator: %d", op)
+		return operatorError(op, left, right)
 	}
 
 	leftValue := left.(*object.String).Value
 	rightValue := right.(*object.String).Value
 
+	if err := vm.limiter.Allocate(len(leftValue) + len(rightValue)); err != nil {
+		return err
+	}
+
 	return vm.push(&object.String{Value: leftValue + rightValue})
 }
 
@@ -419,6 +901,10 @@ func (vm *VM) executeComparison(op code.Opcode) error {
 		return vm.executeIntegerComparison(op, left, right)
 	}
 
+	if isNumber(left) && isNumber(right) {
+		return vm.executeFloatComparison(op, left, right)
+	}
+
 	switch op {
 
 	case code.OpEqual:
@@ -428,8 +914,7 @@ func (vm *VM) executeComparison(op code.Opcode) error {
 		return vm.push(nativeBoolToBooleanObject(right != left))
 
 	default:
-		return fmt.Errorf("unknown operator: %d (%s %s)",
-			op, left.Type(), right.Type())
+		return operatorError(op, left, right)
 	}
 }
 
@@ -451,6 +936,47 @@ func (vm *VM) executeIntegerComparison(
 	case code.OpGreaterThan:
 		return vm.push(nativeBoolToBooleanObject(leftValue > rightValue))
 
+	case code.OpGreaterThanOrEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue >= rightValue))
+
+	case code.OpLessThan:
+		return vm.push(nativeBoolToBooleanObject(leftValue < rightValue))
+
+	case code.OpLessThanOrEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue <= rightValue))
+
+	default:
+		return fmt.Errorf("unknown operator: %d", op)
+	}
+}
+
+func (vm *VM) executeFloatComparison(
+	op code.Opcode,
+	left, right object.Object,
+) error {
+	leftValue := toFloat(left)
+	rightValue := toFloat(right)
+
+	switch op {
+
+	case code.OpEqual:
+		return vm.push(nativeBoolToBooleanObject(rightValue == leftValue))
+
+	case code.OpNotEqual:
+		return vm.push(nativeBoolToBooleanObject(rightValue != leftValue))
+
+	case code.OpGreaterThan:
+		return vm.push(nativeBoolToBooleanObject(leftValue > rightValue))
+
+	case code.OpGreaterThanOrEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue >= rightValue))
+
+	case code.OpLessThan:
+		return vm.push(nativeBoolToBooleanObject(leftValue < rightValue))
+
+	case code.OpLessThanOrEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue <= rightValue))
+
 	default:
 		return fmt.Errorf("unknown operator: %d", op)
 	}
@@ -485,12 +1011,23 @@ func (vm *VM) executeBangOperator() error {
 func (vm *VM) executeMinusOperator() error {
 	operand := vm.pop()
 
-	if operand.Type() != object.INTEGER_OBJ {
+	switch operand := operand.(type) {
+	case *object.Integer:
+		return vm.push(&object.Integer{Value: -operand.Value})
+	case *object.Float:
+		return vm.push(&object.Float{Value: -operand.Value})
+	default:
 		return fmt.Errorf("unsupported type for negation: %s", operand.Type())
 	}
+}
+
+// The value a local or free slot holds, looking through the cell if it's been captured
+func deref(obj object.Object) object.Object {
+	if cell, ok := obj.(*object.Cell); ok {
+		return cell.Value
+	}
 
-	value := operand.(*object.Integer).Value
-	return vm.push(&object.Integer{Value: -value})
+	return obj
 }
 
 func isTruthy(obj object.Object) bool {
@@ -537,6 +1074,33 @@ func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, error) {
 	return &object.Hash{Pairs: hashedPairs}, nil
 }
 
+// Push the module an import refers to, first running its function when this
+// is the first import to be reached
+func (vm *VM) executeImport(globalIndex, constIndex int) error {
+	if module := vm.globals[globalIndex]; module != nil {
+		return vm.push(module)
+	}
+
+	err := vm.pushClosure(constIndex, 0)
+	if err != nil {
+		return err
+	}
+
+	return vm.callClosure(vm.stack[vm.sp-1].(*object.Closure), 0)
+}
+
+// A module from the name and value pairs between startIndex and endIndex
+func (vm *VM) buildModule(constIndex, startIndex, endIndex int) object.Object {
+	name := vm.constants[constIndex].(*object.String).Value
+	exports := make(map[string]object.Object, (endIndex-startIndex)/2)
+
+	for i := startIndex; i < endIndex; i += 2 {
+		exports[vm.stack[i].(*object.String).Value] = vm.stack[i+1]
+	}
+
+	return &object.Module{Name: name, Exports: exports}
+}
+
 func (vm *VM) executeIndexExpression(left, index object.Object) error {
 	switch {
 
@@ -546,11 +1110,60 @@ func (vm *VM) executeIndexExpression(left, index object.Object) error {
 	case left.Type() == object.HASH_OBJ:
 		return vm.executeHashIndex(left, index)
 
+	case left.Type() == object.MODULE_OBJ && index.Type() == object.STRING_OBJ:
+		module, name := left.(*object.Module), index.(*object.String).Value
+		if value, ok := module.Exports[name]; ok {
+			return vm.push(value)
+		}
+		return fmt.Errorf("module %s has no binding %s", module.Name, name)
+
+	case left.Type() == object.ERROR_OBJ && index.Type() == object.STRING_OBJ:
+		if field := left.(*object.Error).Field(index.(*object.String).Value); field != nil {
+			return vm.push(field)
+		}
+		return vm.push(Null)
+
 	default:
 		return fmt.Errorf("index operator not supported: %s", left.Type())
 	}
 }
 
+func (vm *VM) executeSetIndex(left, index, value object.Object) error {
+	switch {
+
+	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		arrayObject := left.(*object.Array)
+		i := index.(*object.Integer).Value
+
+		if i < 0 || i >= int64(len(arrayObject.Elements)) {
+			return fmt.Errorf("index out of range: %d", i)
+		}
+
+		arrayObject.Elements[i] = value
+
+	case left.Type() == object.HASH_OBJ:
+		hashObject := left.(*object.Hash)
+
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return fmt.Errorf("unusable as hash key: %s", index.Type())
+		}
+
+		if _, ok := hashObject.Pairs[key.HashKey()]; !ok {
+			if err := vm.limiter.Allocate(len(hashObject.Pairs) + 1); err != nil {
+				return err
+			}
+		}
+
+		hashObject.Pairs[key.HashKey()] = object.HashPair{Key: index, Value: value}
+
+	default:
+		return fmt.Errorf("index assignment not supported: %s", left.Type())
+	}
+
+	return vm.push(value)
+}
+
 func (vm *VM) executeArrayIndex(array, index object.Object) error {
 	arrayObject := array.(*object.Array)
 	i := index.(*object.Integer).Value
@@ -594,17 +1207,52 @@ func (vm *VM) executeCall(numArgs int) error {
 	}
 }
 
+// A call whose result the calling function returns as it is. A closure
+// takes over the caller's frame, so recursion in tail position runs in
+// constant stack space; builtins are called as usual.
+func (vm *VM) executeTailCall(numArgs int) error {
+	cl, ok := vm.stack[vm.sp-1-numArgs].(*object.Closure)
+	if !ok || vm.framesIndex == 1 || numArgs != cl.Fn.NumParameters {
+		return vm.executeCall(numArgs)
+	}
+
+	// Move the callee and its arguments down over the caller's
+	basePointer := vm.currentFrame().basePointer
+	copy(vm.stack[basePointer-1:], vm.stack[vm.sp-1-numArgs:vm.sp])
+	vm.sp = basePointer + numArgs
+
+	vm.popFrame()
+	vm.dropHandlers()
+
+	return vm.callClosure(cl, numArgs)
+}
+
 func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
 	if numArgs != cl.Fn.NumParameters {
 		return fmt.Errorf("wrong number of arguments: want=%d, got=%d",
 			cl.Fn.NumParameters, numArgs)
 	}
 
+	if err := vm.limiter.Enter(vm.framesIndex); err != nil {
+		return err
+	}
+
 	frame := NewFrame(cl, vm.sp-numArgs)
-	vm.pushFrame(frame)
+	if err := vm.growStack(frame.basePointer + cl.Fn.NumLocals); err != nil {
+		return err
+	}
+	if err := vm.pushFrame(frame); err != nil {
+		return err
+	}
 
 	vm.sp = frame.basePointer + cl.Fn.NumLocals
 
+	// Clear what an earlier frame left in the local slots, so a stale cell
+	// isn't mistaken for one of ours
+	for i := frame.basePointer + numArgs; i < vm.sp; i++ {
+		vm.stack[i] = nil
+	}
+
 	return nil
 }
 
@@ -612,6 +1260,14 @@ func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
 	args := vm.stack[vm.sp-numArgs : vm.sp]
 
 	result := builtin.Fn(args...)
+	if err, ok := result.(*object.Error); ok {
+		return err
+	}
+
+	if err := vm.limiter.Allocated(result); err != nil {
+		return err
+	}
+
 	vm.sp = vm.sp - numArgs - 1
 
 	if result != nil {
@@ -623,6 +1279,18 @@ func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
 	return nil
 }
 
+func (vm *VM) pushBuiltin(index int) error {
+	if index < len(vm.builtins) && vm.builtins[index] != nil {
+		return vm.push(vm.builtins[index])
+	}
+
+	if index < len(vm.builtinNames) {
+		return fmt.Errorf("builtin %s is not available", vm.builtinNames[index])
+	}
+
+	return fmt.Errorf("builtin %d is not available", index)
+}
+
 func (vm *VM) pushClosure(constIndex, numFree int) error {
 	constant := vm.constants[constIndex]
 	function, ok := constant.(*object.CompiledFunction)
@@ -1,19 +1,33 @@
 package vm
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 	"rafiki/ast"
 	"rafiki/compiler"
 	"rafiki/lexer"
 	"rafiki/object"
 	"rafiki/parser"
+	"strings"
 	"testing"
+	"time"
 )
 
-func parse(input string) *ast.Program {
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+
 	l := lexer.NewLexer(input)
 	p := parser.NewParser(l)
-	return p.ParseProgram()
+	program := p.ParseProgram()
+
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+
+	return program
 }
 
 func testIntegerObject(expected int64, actual object.Object) error {
@@ -40,7 +54,7 @@ func runVmTests(t *testing.T, tests []vmTestCase) {
 	t.Helper()
 
 	for _, tt := range tests {
-		program := parse(tt.input)
+		program := parse(t, tt.input)
 
 		comp := compiler.NewCompiler()
 		err := comp.Compile(program)
@@ -86,6 +100,12 @@ func testExpectedObject(
 			t.Errorf("testIntegerObject failed: %s", err)
 		}
 
+	case float64:
+		err := testFloatObject(expected, actual)
+		if err != nil {
+			t.Errorf("testFloatObject failed: %s", err)
+		}
+
 	case string:
 		err := testStringObject(expected, actual)
 		if err != nil {
@@ -184,6 +204,89 @@ func TestIntegerArithmetic(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestFloatArithmetic(t *testing.T) {
+	tests := []vmTestCase{
+		{"3.14", 3.14},
+		{"-2.5", -2.5},
+		{"1.5 + 1.5", 3.0},
+		{"1 + 0.5", 1.5},
+		{"0.5 * 4", 2.0},
+		{"7 / 2.0", 3.5},
+		{"10 - 2.5", 7.5},
+		{"let x = 1.0; x += 1; x /= 4; x", 0.5},
+		{"float(3)", 3.0},
+		{"float(\"2.25\")", 2.25},
+		{"round(3.14159, 2)", 3.14},
+		{"round(1250.0, -2)", 1300.0},
+		{"round(1.5, 400)", 1.5},
+		{"round(1e300, 100)", 1e300},
+		{"round(1.5, -400)", 0.0},
+		{"7 / 2", 3},
+		{"int(3.9)", 3},
+		{"int(-3.9)", -3},
+		{"int(\"42\")", 42},
+		{"round(2.5)", 3},
+		{"round(-2.5)", -3},
+		{"round(7)", 7},
+		{"floor(-1.5)", -2},
+		{"floor(2.9)", 2},
+		{"1 == 1.0", true},
+		{"1.5 != 1.5", false},
+		{"2 > 1.5", true},
+		{"1.5 < 1", false},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestDivisionByZero(t *testing.T) {
+	tests := []string{"1 / 0", "1.5 / 0", "let x = 1; x /= 0.0", "1 % 0"}
+
+	for _, input := range tests {
+		comp := compiler.NewCompiler()
+		err := comp.Compile(parse(t, input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := NewVm(comp.Bytecode())
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("expected VM error for %q but resulted in none.", input)
+		}
+
+		if err.(*object.Error).Message != "division by zero" {
+			t.Errorf("wrong VM error for %q. want=%q, got=%q", input, "division by zero", err)
+		}
+	}
+}
+
+func testFloatObject(expected float64, actual object.Object) error {
+	result, ok := actual.(*object.Float)
+	if !ok {
+		return fmt.Errorf("object is not Float. got=%T (%+v)",
+			actual, actual)
+	}
+
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. got=%g, want=%g",
+			result.Value, expected)
+	}
+
+	return nil
+}
+
+func TestModulo(t *testing.T) {
+	tests := []vmTestCase{
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"10 % 5 + 1", 1},
+		{"7.5 % 2", 1.5},
+	}
+
+	runVmTests(t, tests)
+}
+
 func TestBooleanExpressions(t *testing.T) {
 	tests := []vmTestCase{
 		{"true", true},
@@ -212,6 +315,24 @@ func TestBooleanExpressions(t *testing.T) {
 		{"(1 > 2) == true", false},
 		{"(1 > 2) == false", true},
 		{"!(if (false) { 5; })", true},
+		{"1 <= 2", true},
+		{"2 <= 2", true},
+		{"3 <= 2", false},
+		{"1 >= 2", false},
+		{"2 >= 2", true},
+		{"1.5 <= 1", false},
+		{"2 >= 1.5", true},
+		{"true && true", true},
+		{"true && false", false},
+		{"false || true", true},
+		{"false || false", false},
+		{"1 && \"a\"", true},
+		{"0 || false", true},
+		{"!(if (false) { 5; }) && 1 < 2", true},
+		{"false && 1 / 0", false},
+		{"true || 1 / 0", true},
+		{"1 < 2 && 2 < 3 || false", true},
+		{"let x = 0; let f = fn() { x = 1; true }; false && f(); x == 0", true},
 	}
 
 	runVmTests(t, tests)
@@ -249,6 +370,45 @@ func TestConditionals(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestLoops(t *testing.T) {
+	tests := []vmTestCase{
+		{"let i = 0; while (i < 5) { let i = i + 1; } i", 5},
+		{"let i = 0; while (false) { let i = i + 1; } i", 0},
+		{"let s = 0; for (let i = 0; i < 5; let i = i + 1) { let s = s + i; } s", 10},
+		{"let s = 0; for (let i = 0; i < 10; let i = i + 1) { if (i == 2) { continue; } if (i == 5) { break; } let s = s + i; } s", 8},
+		{"let i = 0; for (;;) { let i = i + 1; if (i > 3) { break; } } i", 4},
+		{"let f = fn(n) { let i = 0; while (true) { if (i == n) { return i * 10; } let i = i + 1; } }; f(4)", 40},
+		{"let n = 0; for (let i = 0; i < 3; let i = i + 1) { for (let j = 0; j < 3; let j = j + 1) { if (j == 1) { break; } let n = n + 1; } } n", 3},
+		{"let s = 0; let xs = [1, 2, 3, 4]; let i = 0; while (i < len(xs)) { let s = s + xs[i]; let i = i + 1; } s", 10},
+		{"if (true) { let x = 1; }", Null},
+		{"if (false) { 1 } else {}", Null},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestAssignment(t *testing.T) {
+	tests := []vmTestCase{
+		{"let x = 1; x = 2; x", 2},
+		{"let x = 1; x = x + 1", 2},
+		{"let x = 10; x += 5; x -= 3; x *= 2; x /= 4; x", 6},
+		{"let a = 1; let b = 2; a = b = 7; a + b", 14},
+		{"let f = fn() { let y = 1; y += 2; y }; f()", 3},
+		{"let x = 1; let f = fn() { x = 5 }; f(); x", 5},
+		{"let f = fn(n) { n *= 10; n }; f(4)", 40},
+		{"let counter = fn() { let n = 0; fn() { n += 1 } }; let c = counter(); c(); c(); c()", 3},
+		{"let pair = fn() { let n = 0; [fn() { n += 1 }, fn() { n }] }; let p = pair(); p[0](); p[0](); p[1]()", 2},
+		{"let f = fn() { let v = 1; let g = fn() { fn() { v = v * 3 } }; g()(); g()(); v }; f()", 9},
+		{"let f = fn() { let v = 1; let get = fn() { v }; v = 2; get() }; f()", 2},
+		{"let xs = [1, 2, 3]; xs[1] = 20; xs[1]", 20},
+		{"let xs = [1, 2, 3]; xs[2] += 10; xs[2]", 13},
+		{"let h = {}; h[\"a\"] = 1; h[\"a\"] += 4; h[\"a\"]", 5},
+		{"let xs = [1, 2, 3]; let i = 0; let s = 0; while (i < len(xs)) { s += xs[i]; i += 1; } s", 6},
+	}
+
+	runVmTests(t, tests)
+}
+
 func TestGlobalLetStatements(t *testing.T) {
 	tests := []vmTestCase{
 		{"let one = 1; one", 1},
@@ -542,7 +702,7 @@ func TestCallingFunctionsWithWrongArguments(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		program := parse(tt.input)
+		program := parse(t, tt.input)
 
 		comp := compiler.NewCompiler()
 		err := comp.Compile(program)
@@ -556,8 +716,54 @@ func TestCallingFunctionsWithWrongArguments(t *testing.T) {
 			t.Fatalf("expected VM error but resulted in none.")
 		}
 
+		errObj, ok := err.(*object.Error)
+		if !ok {
+			t.Fatalf("VM error is not *object.Error. got=%T (%+v)", err, err)
+		}
+
+		if errObj.Message != tt.expected {
+			t.Fatalf("wrong VM error: want=%q, got=%q", tt.expected, errObj.Message)
+		}
+	}
+}
+
+func TestRuntimeErrorPositions(t *testing.T) {
+	tests := []struct {
+		input    string
+		line     int
+		column   int
+		expected string
+	}{
+		{"1 + true", 1, 3, "1:3: type mismatch: INTEGER + BOOLEAN"},
+		{"let f = fn(a) {\n  a + \"x\"\n};\nf(1)", 2, 5, "2:5: type mismatch: INTEGER + STRING"},
+		{"let f = fn(a) { a };\nf()", 2, 2, "2:2: wrong number of arguments: want=1, got=0"},
+		{"let xs = [1];\nxs[3] = 1", 2, 7, "2:7: index out of range: 3"},
+		{"let s = \"a\";\ns[0] = 1", 2, 6, "2:6: index assignment not supported: STRING"},
+	}
+
+	for _, tt := range tests {
+		program := parse(t, tt.input)
+
+		comp := compiler.NewCompiler()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := NewVm(comp.Bytecode())
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("expected VM error but resulted in none.")
+		}
+
+		errObj := err.(*object.Error)
+		if errObj.Pos.Line != tt.line || errObj.Pos.Column != tt.column {
+			t.Errorf("wrong error position for %q. want=%d:%d, got=%d:%d",
+				tt.input, tt.line, tt.column, errObj.Pos.Line, errObj.Pos.Column)
+		}
+
 		if err.Error() != tt.expected {
-			t.Fatalf("wrong VM error: want=%q, got=%q", tt.expected, err)
+			t.Errorf("wrong error string. want=%q, got=%q", tt.expected, err.Error())
 		}
 	}
 }
@@ -567,47 +773,120 @@ func TestBuiltinFunctions(t *testing.T) {
 		{`len("")`, 0},
 		{`len("four")`, 4},
 		{`len("hello world")`, 11},
-		{
-			`len(1)`,
-			&object.Error{
-				Message: "argument to `len` not supported, got INTEGER",
-			},
-		},
-		{`len("one", "two")`,
-			&object.Error{
-				Message: "wrong number of arguments. got=2, want=1",
-			},
-		},
 		{`len([1, 2, 3])`, 3},
 		{`len([])`, 0},
 		{`puts("hello", "world!")`, Null},
 		{`first([1, 2, 3])`, 1},
 		{`first([])`, Null},
-		{`first(1)`,
-			&object.Error{
-				Message: "argument to `first` must be ARRAY, got INTEGER",
-			},
-		},
 		{`last([1, 2, 3])`, 3},
 		{`last([])`, Null},
-		{`last(1)`,
-			&object.Error{
-				Message: "argument to `last` must be ARRAY, got INTEGER",
-			},
-		},
 		{`rest([1, 2, 3])`, []int{2, 3}},
 		{`rest([])`, Null},
 		{`push([], 1)`, []int{1}},
-		{`push(1, 1)`,
-			&object.Error{
-				Message: "argument to `push` must be ARRAY, got INTEGER",
-			},
-		},
 	}
 
 	runVmTests(t, tests)
 }
 
+func TestTryCatch(t *testing.T) {
+	tests := []vmTestCase{
+		{`try { 1 } catch (e) { 2 }`, 1},
+		{`try { 1 / 0 } catch (e) { 2 }`, 2},
+		{`try { 1 / 0 } catch (e) { e["message"] }`, "division by zero"},
+		{`try { 1 / 0 } catch (e) { e["type"] }`, "RuntimeError"},
+		{`try { throw "oops" } catch (e) { e["message"] }`, "oops"},
+		{`try { throw "oops" } catch (e) { e["type"] }`, "Error"},
+		{`try { throw 42 } catch (e) { e["message"] }`, "42"},
+		{`try { len(1) } catch (e) { e["message"] }`, "argument to `len` not supported, got INTEGER"},
+		{`try { fn(a) { a }() } catch (e) { e["message"] }`, "wrong number of arguments: want=1, got=0"},
+		{`let f = fn() { throw "deep" }; let g = fn() { 1 + f() }; try { g() } catch (e) { e["message"] }`, "deep"},
+		{`let f = fn() { throw "x" }; try { f() } catch (e) { len(e["stack"]) }`, 2},
+		{`try { try { throw "inner" } catch (e) { throw e } } catch (e) { e["message"] }`, "inner"},
+		{`try { try { throw "inner" } catch (e) { 1 } } catch (e) { 2 }`, 1},
+		{`let f = fn() { try { return 1; } catch (e) { 2 }; 3 }; f(); try { throw "x" } catch (e) { 4 }`, 4},
+		{`let n = 0; while (n < 5) { try { n += 1; if (n == 3) { break; } } catch (e) {} } try { throw "x" } catch (e) { n }`, 3},
+		{`let r = try { 1 / 0; 10 } catch (e) { 20 }; r + 1`, 21},
+		{`let x = 1; try { x = 2; throw "x"; x = 3 } catch (e) {}; x`, 2},
+		{`[1, try { throw "x" } catch (e) { 2 }, 3][1]`, 2},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestUncaughtThrow(t *testing.T) {
+	// g's call to f isn't in tail position, so g keeps its frame
+	input := "let f = fn() {\n  throw \"boom\"\n};\nlet g = fn() { let x = f(); x };\ng()"
+
+	program := parse(t, input)
+
+	comp := compiler.NewCompiler()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := NewVm(comp.Bytecode())
+	err = vm.Run()
+	if err == nil {
+		t.Fatalf("expected VM error but resulted in none.")
+	}
+
+	errObj := err.(*object.Error)
+	if errObj.Message != "boom" || errObj.Kind != object.ThrownError {
+		t.Errorf("wrong error. got=%s %q", errObj.Kind, errObj.Message)
+	}
+
+	expected := []string{"f (2:3)", "g (4:25)", "<main> (5:2)"}
+	if len(errObj.Stack) != len(expected) {
+		t.Fatalf("wrong stack length. want=%d, got=%d (%v)", len(expected), len(errObj.Stack), errObj.Stack)
+	}
+
+	for i, frame := range expected {
+		if errObj.Stack[i].String() != frame {
+			t.Errorf("wrong frame %d. want=%q, got=%q", i, frame, errObj.Stack[i].String())
+		}
+	}
+}
+
+// Builtins report misuse by throwing, just like the evaluator
+func TestBuiltinErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`len(1)`, "argument to `len` not supported, got INTEGER"},
+		{`len("one", "two")`, "wrong number of arguments. got=2, want=1"},
+		{`first(1)`, "argument to `first` must be ARRAY, got INTEGER"},
+		{`last(1)`, "argument to `last` must be ARRAY, got INTEGER"},
+		{`push(1, 1)`, "argument to `push` must be ARRAY, got INTEGER"},
+	}
+
+	for _, tt := range tests {
+		program := parse(t, tt.input)
+
+		comp := compiler.NewCompiler()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := NewVm(comp.Bytecode())
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("expected VM error for %q but resulted in none.", tt.input)
+		}
+
+		errObj := err.(*object.Error)
+		if errObj.Message != tt.expected {
+			t.Errorf("wrong VM error: want=%q, got=%q", tt.expected, errObj.Message)
+		}
+
+		if errObj.Kind != object.RuntimeError {
+			t.Errorf("wrong error kind: want=%q, got=%q", object.RuntimeError, errObj.Kind)
+		}
+	}
+}
+
 func TestClosures(t *testing.T) {
 	tests := []vmTestCase{
 		{
@@ -762,3 +1041,487 @@ func TestRecursiveFibonacci(t *testing.T) {
 
 	runVmTests(t, tests)
 }
+
+// Write the files into a fresh directory, then compile and run main.rk from it
+func runModules(t *testing.T, files map[string]string) (object.Object, error) {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, src := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	l := lexer.NewLexerWithFilename(files["main.rk"], filepath.Join(dir, "main.rk"))
+	program := parser.NewParser(l).ParseProgram()
+
+	comp := compiler.NewCompiler()
+	if err := comp.Compile(program); err != nil {
+		return nil, err
+	}
+
+	vm := NewVm(comp.Bytecode())
+	if err := vm.Run(); err != nil {
+		return nil, err
+	}
+
+	return vm.LastPoppedStackElem(), nil
+}
+
+func TestImports(t *testing.T) {
+	lib := `
+let count = 0;
+let square = fn(x) { x * x };
+let bump = fn() { count += 1; count };
+`
+
+	tests := []vmTestCase{
+		{`let m = import "lib/math.rk"; m.square(4)`, 16},
+		{`let m = import "lib/math.rk"; m["square"](5)`, 25},
+		// The file runs once, so both imports share its state
+		{`let a = import "lib/math.rk"; let b = import "lib/math.rk"; a.bump(); b.bump()`, 2},
+		{`let f = fn() { import "lib/math.rk" }; f().bump() + f().bump()`, 3},
+		// Modules see their own globals, not the importer's
+		{`let count = 10; let m = import "lib/math.rk"; m.bump()`, 1},
+		{`let m = import "lib/uses.rk"; m.twice(3)`, 18},
+	}
+
+	for _, tt := range tests {
+		result, err := runModules(t, map[string]string{
+			"main.rk":     tt.input,
+			"lib/math.rk": lib,
+			"lib/uses.rk": `let math = import "math.rk"; let twice = fn(x) { 2 * math.square(x) };`,
+		})
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %s", tt.input, err)
+		}
+
+		testExpectedObject(t, tt.expected, result)
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	tests := []struct {
+		files    map[string]string
+		expected string
+	}{
+		{map[string]string{"main.rk": `let m = import "lib.rk"; m.nope`, "lib.rk": "let x = 1;"}, "module lib has no binding nope"},
+		{map[string]string{"main.rk": `import "missing.rk"`}, `cannot import "missing.rk": no such file or directory`},
+		{map[string]string{"main.rk": `import "lib.rk"`, "lib.rk": "let = 1;"}, "expected next token to be IDENT, got = instead"},
+		{map[string]string{"main.rk": `import "lib.rk"`, "lib.rk": "1 + true"}, "type mismatch: INTEGER + BOOLEAN"},
+		{map[string]string{"main.rk": `import "lib.rk"`, "lib.rk": "return 1;"}, "cannot return from the top level of a module"},
+		{map[string]string{"main.rk": `import "a.rk"`, "a.rk": `import "b.rk"`, "b.rk": `import "a.rk"`}, "import cycle: a.rk -> b.rk -> a.rk"},
+		{map[string]string{"main.rk": `import "a.rk"`, "a.rk": `import "main.rk"`}, "import cycle: main.rk -> a.rk -> main.rk"},
+		{map[string]string{"main.rk": `let m = import "lib.rk"; m.x = 2`, "lib.rk": "let x = 1;"}, "index assignment not supported: MODULE"},
+	}
+
+	for _, tt := range tests {
+		_, err := runModules(t, tt.files)
+		if err == nil || !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("%q: wrong error. want=%q, got=%v", tt.files["main.rk"], tt.expected, err)
+		}
+	}
+}
+
+func TestBuiltinRegistries(t *testing.T) {
+	double := func(args ...object.Object) object.Object {
+		return &object.Integer{Value: args[0].(*object.Integer).Value * 2}
+	}
+
+	compiled := object.NewBuiltinRegistry()
+	compiled.Register("len", object.DefaultBuiltins().At(0).Fn)
+	compiled.Register("double", double)
+
+	comp := compiler.NewCompilerWithBuiltins(compiled)
+	if err := comp.Compile(parse(t, `double(len("abc"))`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	bytecode := comp.Bytecode()
+
+	// The same builtins in another order are still found by name
+	running := object.NewBuiltinRegistry()
+	running.Register("double", double)
+	running.Register("len", object.DefaultBuiltins().At(0).Fn)
+
+	vm := NewVm(bytecode)
+	vm.SetBuiltins(running)
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	if err := testIntegerObject(6, vm.LastPoppedStackElem()); err != nil {
+		t.Errorf("testIntegerObject failed: %s", err)
+	}
+
+	// Without double, calling it fails
+	running.Remove("double")
+
+	vm = NewVm(bytecode)
+	vm.SetBuiltins(running)
+	err := vm.Run()
+	if err == nil {
+		t.Fatalf("expected an error calling a missing builtin")
+	}
+
+	if msg := err.(*object.Error).Message; msg != "builtin double is not available" {
+		t.Errorf("wrong error. got=%q", msg)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		input    string
+		limits   object.Limits
+		kind     string
+		cause    error
+		expected string
+	}{
+		{
+			`while (true) {}`,
+			object.Limits{MaxSteps: 1000},
+			object.StepLimitError, object.ErrStepLimit,
+			"step limit of 1000 exceeded",
+		},
+		{
+			// A try can't catch running out of steps
+			`while (true) { try { while (true) {} } catch (e) {} }`,
+			object.Limits{MaxSteps: 1000},
+			object.StepLimitError, object.ErrStepLimit,
+			"step limit of 1000 exceeded",
+		},
+		{
+			`let s = "ab"; while (true) { s = s + s; }`,
+			object.Limits{MaxAllocation: 100},
+			object.AllocationLimitError, object.ErrAllocationLimit,
+			"allocation of 128 exceeds the limit of 100",
+		},
+		{
+			`let a = []; while (true) { a = push(a, 1); }`,
+			object.Limits{MaxAllocation: 10},
+			object.AllocationLimitError, object.ErrAllocationLimit,
+			"allocation of 11 exceeds the limit of 10",
+		},
+		{
+			`let h = {}; let i = 0; while (true) { h[i] = i; i += 1; }`,
+			object.Limits{MaxAllocation: 10},
+			object.AllocationLimitError, object.ErrAllocationLimit,
+			"allocation of 11 exceeds the limit of 10",
+		},
+		{
+			`[1, 2, 3]`,
+			object.Limits{MaxAllocation: 2},
+			object.AllocationLimitError, object.ErrAllocationLimit,
+			"allocation of 3 exceeds the limit of 2",
+		},
+		{
+			`let f = fn(n) { 1 + f(n + 1) }; f(0)`,
+			object.Limits{MaxDepth: 50},
+			object.RecursionLimitError, object.ErrRecursionLimit,
+			"recursion depth limit of 50 exceeded",
+		},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.NewCompiler()
+		if err := comp.Compile(parse(t, tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := NewVm(comp.Bytecode())
+		err := vm.RunWithLimits(context.Background(), tt.limits)
+
+		var errObj *object.Error
+		if !errors.As(err, &errObj) {
+			t.Errorf("%s: expected an *object.Error. got=%v", tt.input, err)
+			continue
+		}
+
+		if errObj.Kind != tt.kind || errObj.Message != tt.expected {
+			t.Errorf("%s: wrong error. want=%s %q, got=%s %q",
+				tt.input, tt.kind, tt.expected, errObj.Kind, errObj.Message)
+		}
+
+		if !errors.Is(err, tt.cause) {
+			t.Errorf("%s: error doesn't unwrap to %v", tt.input, tt.cause)
+		}
+	}
+}
+
+func TestCatchingLimits(t *testing.T) {
+	input := `
+	let f = fn(n) { 1 + f(n + 1) };
+	let kind = try { f(0) } catch (e) { e["type"] };
+	let size = try { [1, 2, 3, 4] } catch (e) { e["type"] };
+	[kind, size]`
+
+	comp := compiler.NewCompiler()
+	if err := comp.Compile(parse(t, input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := NewVm(comp.Bytecode())
+	err := vm.RunWithLimits(context.Background(), object.Limits{MaxDepth: 20, MaxAllocation: 3})
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	expected := "[RecursionLimitError, AllocationLimitError]"
+	if result := vm.LastPoppedStackElem().Inspect(); result != expected {
+		t.Errorf("wrong result. want=%s, got=%s", expected, result)
+	}
+}
+
+func TestCancellation(t *testing.T) {
+	comp := compiler.NewCompiler()
+	if err := comp.Compile(parse(t, `while (true) {}`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	err := NewVm(comp.Bytecode()).RunWithLimits(ctx, object.Limits{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deadline to stop the program. got=%v", err)
+	}
+
+	if kind := err.(*object.Error).Kind; kind != object.CanceledError {
+		t.Errorf("wrong kind. want=%s, got=%s", object.CanceledError, kind)
+	}
+}
+
+func TestStackOverflow(t *testing.T) {
+	input := `
+	let f = fn(n) { 1 + f(n + 1) };
+	let g = fn() { let n = f(0); n };
+	g();`
+
+	comp := compiler.NewCompiler()
+	if err := comp.Compile(parse(t, input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	err := NewVm(comp.Bytecode()).Run()
+
+	errObj, ok := err.(*object.Error)
+	if !ok || errObj.Message != "stack overflow" || errObj.Kind != object.RuntimeError {
+		t.Fatalf("expected a stack overflow. got=%v", err)
+	}
+
+	if len(errObj.Stack) != MaxCallDepth+1 {
+		t.Errorf("wrong stack depth. want=%d, got=%d", MaxCallDepth+1, len(errObj.Stack))
+	}
+
+	bottom := errObj.Stack[len(errObj.Stack)-2:]
+	if bottom[0].Function != "g" || bottom[1].Function != "<main>" {
+		t.Errorf("wrong frames at the bottom of the stack: %v", bottom)
+	}
+}
+
+func TestGrowingStack(t *testing.T) {
+	tests := []vmTestCase{
+		// Deeper than the stack's starting size
+		{`let sum = fn(n) { if (n == 0) { 0 } else { n + sum(n - 1) } }; sum(5000)`, 12502500},
+		{`let f = fn(n) { 1 + f(n + 1) }; try { f(0) } catch (e) { e["message"] }`, "stack overflow"},
+	}
+
+	runVmTests(t, tests)
+}
+
+// Programs past the limits of the usual operand widths, whose instructions
+// need OpWide
+func TestWideOperands(t *testing.T) {
+	// Identifiers can't hold digits, so number them in letters: ga, gb, ...
+	names := func(prefix string, n int) []string {
+		list := make([]string, n)
+		for i := range list {
+			name := ""
+			for j := i; ; j /= 26 {
+				name = string(rune('a'+j%26)) + name
+				if j < 26 {
+					break
+				}
+			}
+			list[i] = prefix + name
+		}
+		return list
+	}
+
+	var globals strings.Builder
+	for i, name := range names("g", 70000) {
+		fmt.Fprintf(&globals, "let %s = %d;\n", name, i)
+	}
+
+	var elements []string
+	for i := 0; i < 70000; i++ {
+		elements = append(elements, fmt.Sprint(i))
+	}
+
+	// 80,000 bytes of instructions to jump over
+	padding := strings.Repeat("1;\n", 20000)
+
+	params := names("p", 300)
+	locals := names("v", 300)
+	var body strings.Builder
+	for i := range locals {
+		fmt.Fprintf(&body, "let %s = %s;\n", locals[i], params[i])
+	}
+
+	tests := []vmTestCase{
+		// More globals, and constants, than 16 bit operands can number
+		{globals.String() + "ga + " + names("g", 70000)[69999], 69999},
+		{"let a = [" + strings.Join(elements, ", ") + "]; len(a) + a[69999]", 139999},
+		// More parameters, arguments and locals than 8 bit ones can
+		{"let f = fn(" + strings.Join(params, ", ") + ") {" + body.String() + "va + " + locals[299] + " };" +
+			"f(" + strings.Join(elements[:300], ", ") + ")", 299},
+		// And more free variables
+		{"let f = fn(" + strings.Join(params, ", ") + ") { fn() { " + strings.Join(params, " + ") + " } };" +
+			"f(" + strings.Join(elements[:300], ", ") + ")()", 44850},
+		// Jumps further than 16 bit offsets reach
+		{"if (false) {" + padding + "1 } else { 2 }", 2},
+		{"if (true) {" + padding + "1 } else { 2 }", 1},
+		{"let i = 0; let n = 0; while (true) { i += 1; if (i == 4) { break; } if (i == 2) { continue; }" + padding + "n += 1; } n", 2},
+		{"try {" + padding + "throw 1; } catch (e) { 3 }", 3},
+		{"let f = fn(x) { if (x) {" + padding + "} x && 4 }; f(true)", true},
+	}
+
+	for _, tt := range tests {
+		p := parser.NewParser(lexer.NewLexer(tt.input))
+		program := p.ParseProgram()
+		if len(p.Errors()) > 0 {
+			t.Fatalf("parser errors: %v", p.Errors()[0])
+		}
+
+		comp := compiler.NewCompiler()
+		if err := comp.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := NewVm(comp.Bytecode())
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+
+		testExpectedObject(t, tt.expected, vm.LastPoppedStackElem())
+	}
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []vmTestCase{
+		// Far deeper than MaxCallDepth, since each call takes over its caller's frame
+		{`let loop = fn(n, acc) { if (n == 0) { acc } else { loop(n - 1, acc + 1) } }; loop(100000, 0)`, 100000},
+		{`let loop = fn(n) { if (n == 0) { return "done"; } return loop(n - 1); }; loop(100000)`, "done"},
+		{`
+		let odd = 0;
+		let even = fn(n) { if (n == 0) { true } else { odd(n - 1) } };
+		odd = fn(n) { if (n == 0) { false } else { even(n - 1) } };
+		even(100001)`, false},
+		// Locals of the callee don't see the caller's
+		{`let g = fn(x) { let y = x * 2; y }; let f = fn(a, b) { let c = a + b; g(c) }; f(1, 2)`, 6},
+		// Builtins and other values called in tail position
+		{`let f = fn(a) { len(a) }; f([1, 2, 3])`, 3},
+		{`let f = fn() { let g = fn(x) { x }; g(1) }; f()`, 1},
+		{`let g = fn(n) { throw n }; let f = fn(n) { try { g(n) } catch (e) { e["message"] } }; f(7)`, "7"},
+		{`let g = fn(n) { n + 1 }; let f = fn(n) { while (true) { return g(n); } }; f(1)`, 2},
+		{`let h = fn(n) { throw n }; let g = fn(n) { h(n) }; let f = fn(n) { try { g(n) } catch (e) { e["message"] } }; f(7)`, "7"},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestOptimizationPreservesResults(t *testing.T) {
+	dir := t.TempDir()
+	lib := filepath.Join(dir, "lib.rk")
+	if err := os.WriteFile(lib, []byte("let k = 2 * 3; let f = fn(x) { if (true) { return x + k; } x };"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	inputs := []string{
+		"1 + 2 * 3 - 4 / 2",
+		"-(5 % 3) + 2.5 * 2",
+		"7 / 2.0 - 1",
+		"1 / 0",
+		"5.5 % 0",
+		"1 + true",
+		"-true",
+		`"a" + "b"`,
+		`"a" == "a"`,
+		"1 == 1.0",
+		"1 > 2 == false",
+		"!5",
+		"!!true",
+		"true == !false",
+		"if (true) { 10 } else { 20 }",
+		"if (1 > 2) { 10 }",
+		"if (0) { 1 } else { 2 }",
+		"if (!true) { 1 } else { if (2 >= 2) { 3 } else { 4 } }",
+		"let x = 3; if (x > 1) { if (x > 2) { 1 } else { 2 } } else { 3 }",
+		"let i = 0; while (true) { i += 1; if (i == 5) { break; } } i",
+		"let i = 0; while (false) { i += 1; } i",
+		"let total = 0; for (let i = 0; i < 10; i += 1) { if (i % 2 == 0) { continue; } total += i; } total",
+		"true && 1 > 2 || 3 >= 3",
+		"false || !true",
+		"let f = fn() { return 1; 2 }; f()",
+		"let f = fn(n) { if (n > 0) { return n; } return -n; 99 }; f(-4) + f(3)",
+		"let f = fn() { throw 1 + 1; 3 }; try { f() } catch (e) { e[\"message\"] }",
+		"let f = fn() { 1 / (2 - 2) }; f()",
+		"let g = fn(a) { a + \"x\" }; let f = fn() { g(1 + 1) }; f()",
+		"let add = fn(a) { fn(b) { a + b * (2 + 2) } }; add(1)(2)",
+		"let loop = fn(n, acc) { if (n == 0) { acc } else { loop(n - 1, acc + 2 * 3) } }; loop(1000, 0)",
+		"let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } }; fib(15)",
+		"[1 + 1, 2 * 2.5, !false][1]",
+		`{"a": 1 + 1, "b": -(3)}["b"]`,
+		"try { throw -1 * 10; } catch (e) { e[\"message\"] }",
+		`let m = import "` + lib + `"; m.f(1) + m.k`,
+	}
+
+	run := func(input string, level int) (string, error) {
+		program := parser.NewParser(lexer.NewLexerWithFilename(input, filepath.Join(dir, "main.rk"))).ParseProgram()
+
+		comp := compiler.NewCompiler()
+		comp.SetOptimization(level)
+		if err := comp.Compile(program); err != nil {
+			t.Fatalf("%s: compiler error: %s", input, err)
+		}
+
+		vm := NewVm(comp.Bytecode())
+		if err := vm.Run(); err != nil {
+			return "", err
+		}
+
+		return vm.LastPoppedStackElem().Inspect(), nil
+	}
+
+	for _, input := range inputs {
+		unoptimized, unoptimizedErr := run(input, compiler.O0)
+		optimized, optimizedErr := run(input, compiler.O1)
+
+		if optimized != unoptimized {
+			t.Errorf("%s: optimized result %q, unoptimized %q", input, optimized, unoptimized)
+		}
+
+		if (optimizedErr == nil) != (unoptimizedErr == nil) {
+			t.Errorf("%s: optimized error %v, unoptimized %v", input, optimizedErr, unoptimizedErr)
+			continue
+		}
+
+		if optimizedErr == nil {
+			continue
+		}
+
+		// Errors point at the same source, with the same stack
+		if optimizedErr.Error() != unoptimizedErr.Error() {
+			t.Errorf("%s: optimized error %q, unoptimized %q", input, optimizedErr, unoptimizedErr)
+		}
+
+		optimizedTrace := optimizedErr.(*object.Error).StackTrace()
+		unoptimizedTrace := unoptimizedErr.(*object.Error).StackTrace()
+		if optimizedTrace != unoptimizedTrace {
+			t.Errorf("%s: optimized stack\n%s\nunoptimized\n%s", input, optimizedTrace, unoptimizedTrace)
+		}
+	}
+}